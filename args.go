@@ -0,0 +1,215 @@
+package stickgen
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/tyler-sommer/stick/parse"
+)
+
+// namedArgPrefix is the name of the reserved function used to mark a named
+// argument, followed by the argument's name.
+const namedArgPrefix = directivePrefix + "arg_"
+
+var namedArgPattern = regexp.MustCompile(`^(\s*)(\w+)\s*=`)
+
+// signatures lists the parameters of Twig's builtin functions and filters,
+// allowing named arguments to be passed positionally. Filter parameters
+// exclude the value being filtered.
+var signatures = map[string]map[string][]string{
+	"Functions": {
+		"attribute":            {"object", "attribute", "arguments"},
+		"block":                {"name", "template"},
+		"constant":             {"constant", "object"},
+		"cycle":                {"values", "position"},
+		"date":                 {"date", "timezone"},
+		"include":              {"template", "variables", "with_context", "ignore_missing", "sandboxed"},
+		"random":               {"values", "max"},
+		"range":                {"low", "high", "step"},
+		"source":               {"name", "ignore_missing"},
+		"template_from_string": {"template", "name"},
+	},
+	"Filters": {
+		"date":          {"format", "timezone"},
+		"date_modify":   {"modifier"},
+		"default":       {"default"},
+		"join":          {"glue", "and"},
+		"number_format": {"decimal", "decimal_point", "thousand_sep"},
+		"replace":       {"from"},
+		"round":         {"precision", "method"},
+		"slice":         {"start", "length", "preserve_keys"},
+		"split":         {"delimiter", "limit"},
+		"trim":          {"character_mask", "side"},
+	},
+}
+
+// rewriteNamedArgs rewrites any named arguments in the source of a tag.
+// Stick does not support named arguments, so each name=value is wrapped in a
+// call to a reserved function instead.
+func rewriteNamedArgs(src string) string {
+	out := &strings.Builder{}
+	var stack []byte
+	var quote byte
+	start := false
+	for i := 0; i < len(src); i++ {
+		if start {
+			start = false
+			m := namedArgPattern.FindStringSubmatchIndex(src[i:])
+			if m != nil && !strings.HasPrefix(src[i+m[1]:], "=") {
+				end := i + m[1] + argEnd(src[i+m[1]:])
+				fmt.Fprintf(out, "%s%s%s(%s)", src[i+m[2]:i+m[3]], namedArgPrefix, src[i+m[4]:i+m[5]], rewriteNamedArgs(src[i+m[1]:end]))
+				i = end - 1
+				continue
+			}
+		}
+		c := src[i]
+		out.WriteByte(c)
+		switch {
+		case quote != 0:
+			if c == '\\' && i+1 < len(src) {
+				i++
+				out.WriteByte(src[i])
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '(' || c == '[' || c == '{':
+			stack = append(stack, c)
+			start = c == '('
+		case c == ')' || c == ']' || c == '}':
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		case c == ',':
+			start = len(stack) > 0 && stack[len(stack)-1] == '('
+		}
+	}
+	return out.String()
+}
+
+// argEnd returns the offset of the end of the argument at the start of src.
+func argEnd(src string) int {
+	var quote byte
+	depth := 0
+	for i := 0; i < len(src); i++ {
+		c := src[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '(' || c == '[' || c == '{':
+			depth++
+		case c == ')' || c == ']' || c == '}':
+			if depth == 0 {
+				return i
+			}
+			depth--
+		case c == ',' && depth == 0:
+			return i
+		}
+	}
+	return len(src)
+}
+
+// namedArg returns the name and value of a named argument, or an empty name
+// if the argument is positional.
+func namedArg(e parse.Expr) (string, parse.Expr) {
+	if fn, ok := e.(*parse.FuncExpr); ok && strings.HasPrefix(fn.Name, namedArgPrefix) && len(fn.Args) == 1 {
+		return strings.TrimPrefix(fn.Name, namedArgPrefix), fn.Args[0]
+	}
+	return "", e
+}
+
+// resolveArgs places any named arguments according to params. Arguments
+// that are skipped are returned as nil.
+func resolveArgs(args []parse.Expr, params []string) ([]parse.Expr, error) {
+	var res []parse.Expr
+	set := make(map[int]bool)
+	named := false
+	for _, arg := range args {
+		name, arg := namedArg(arg)
+		if name == "" {
+			if named {
+				return nil, fmt.Errorf("stickgen: positional argument follows named argument")
+			}
+			set[len(res)] = true
+			res = append(res, arg)
+			continue
+		}
+		named = true
+		i := indexOf(params, name)
+		if i < 0 {
+			return nil, fmt.Errorf("stickgen: unknown argument %s", name)
+		}
+		if set[i] {
+			return nil, fmt.Errorf("stickgen: argument %s given more than once", name)
+		}
+		for len(res) <= i {
+			res = append(res, nil)
+		}
+		set[i] = true
+		res[i] = arg
+	}
+	return res, nil
+}
+
+// walkArgs evaluates each argument, returning any statements that must
+// precede their use and the resulting Go expression for each argument.
+//
+// Named arguments are placed according to params, with any skipped
+// arguments passed as nil. If params is nil, named arguments are instead
+// collected into a map passed as the final argument.
+func (g *Generator) walkArgs(args []parse.Expr, params []string) (string, []string, error) {
+	if params != nil {
+		var err error
+		if args, err = resolveArgs(args, params); err != nil {
+			return "", nil, err
+		}
+	}
+	var names []string
+	var named []string
+	var body []byte
+	for _, arg := range args {
+		if arg == nil {
+			names = append(names, "nil")
+			continue
+		}
+		name, arg := namedArg(arg)
+		if name == "" && len(named) > 0 {
+			return "", nil, fmt.Errorf("stickgen: positional argument follows named argument")
+		}
+		val, err := g.walkExpr(arg)
+		if err != nil {
+			return "", nil, err
+		}
+		if val.isFunction {
+			body = append(body, g.handleErr(val)+"\n"...)
+		}
+		if name == "" {
+			names = append(names, val.resultantName)
+		} else {
+			named = append(named, strconv.Quote(name)+": "+val.resultantName)
+		}
+	}
+	if len(named) > 0 {
+		names = append(names, "map[string]stick.Value{"+strings.Join(named, ", ")+"}")
+	}
+	return string(body), names, nil
+}
+
+func indexOf(list []string, s string) int {
+	for i, v := range list {
+		if v == s {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,92 @@
+package stickgen_test
+
+import "testing"
+
+func TestNamedArgs(t *testing.T) {
+	dump := `env.Functions["dump_args"] = func(ctx stick.Context, args ...stick.Value) stick.Value {
+		var s []string
+		for _, arg := range args {
+			s = append(s, fmt.Sprint(arg))
+		}
+		return strings.Join(s, " ")
+	}
+	env.Filters["default"] = func(ctx stick.Context, val stick.Value, args ...stick.Value) stick.Value {
+		if val == nil || val == "" {
+			return args[0]
+		}
+		return val
+	}`
+	testRender(t, []renderTest{
+		{
+			name:      "named",
+			templates: map[string]string{"test.twig": `{% for i in range(low=1, high=5, step=2) %}{{ i }}{% endfor %}`},
+			want:      "135",
+		},
+		{
+			name:      "any order",
+			templates: map[string]string{"test.twig": `{% for i in range(high=3, low=1) %}{{ i }}{% endfor %}`},
+			want:      "123",
+		},
+		{
+			name:      "after positional",
+			templates: map[string]string{"test.twig": `{% for i in range(1, 7, step=3) %}{{ i }}{% endfor %}`},
+			want:      "147",
+		},
+		{
+			name:      "in set tag",
+			templates: map[string]string{"test.twig": `{% set r = range(low=1, high=2) %}{% for i in r %}{{ i }}{% endfor %}`},
+			want:      "12",
+		},
+		{
+			name:      "filter",
+			templates: map[string]string{"test.twig": `{{ x|default(default='d') }}`},
+			ctx:       `map[string]stick.Value{"x": ""}`,
+			setup:     dump,
+			imports:   []string{"strings"},
+			want:      "d",
+		},
+		{
+			name:      "unknown signature",
+			templates: map[string]string{"test.twig": `{{ dump_args(1, b=2, c=x) }}`},
+			ctx:       `map[string]stick.Value{"x": 3}`,
+			setup:     dump,
+			imports:   []string{"strings"},
+			want:      "1 map[b:2 c:3]",
+		},
+		{
+			name:      "nested",
+			templates: map[string]string{"test.twig": `{{ dump_args(a=dump_args(b=1)) }}`},
+			setup:     dump,
+			imports:   []string{"strings"},
+			want:      "map[a:map[b:1]]",
+		},
+		{
+			name:      "not named",
+			templates: map[string]string{"test.twig": `{{ dump_args('a=1', x == 1, [1]) }}`},
+			ctx:       `map[string]stick.Value{"x": 1}`,
+			setup:     dump,
+			imports:   []string{"strings"},
+			want:      "a=1 true [1]",
+		},
+		{
+			name:      "positional follows named",
+			templates: map[string]string{"test.twig": `{{ range(low=1, 3) }}`},
+			err:       "positional argument follows named argument",
+		},
+		{
+			name:      "positional follows named without signature",
+			templates: map[string]string{"test.twig": `{{ dump_args(a=1, 3) }}`},
+			err:       "positional argument follows named argument",
+		},
+		{
+			name:      "unknown argument",
+			templates: map[string]string{"test.twig": `{{ range(low=1, top=3) }}`},
+			err:       "unknown argument top",
+		},
+		{
+			name:      "given twice",
+			templates: map[string]string{"test.twig": `{{ range(1, 3, low=2) }}`},
+			err:       "argument low given more than once",
+		},
+	})
+}
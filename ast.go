@@ -0,0 +1,31 @@
+package stickgen
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strings"
+)
+
+// GenerateAST parses each of the given templates and returns the generated
+// code as a syntax tree, as with GenerateAll. This allows the code to be
+// modified before it is printed, for example using go/printer. Positions in
+// the tree are recorded in the returned FileSet, under the name of the file
+// GenerateDir would write for the first template.
+func (g *Generator) GenerateAST(names ...string) (*token.FileSet, *ast.File, error) {
+	if len(names) == 0 {
+		return nil, nil, errors.New("stickgen: no templates given")
+	}
+	src := &strings.Builder{}
+	if err := g.clone().generateAll(src, names); err != nil {
+		return nil, nil, err
+	}
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, g.fileName(names[0]), src.String(), parser.ParseComments)
+	if err != nil {
+		return nil, nil, fmt.Errorf("stickgen: unable to parse generated code: %s", err)
+	}
+	return fset, f, nil
+}
@@ -0,0 +1,118 @@
+package stickgen_test
+
+import (
+	"bytes"
+	"go/ast"
+	"go/format"
+	"reflect"
+	"testing"
+
+	"github.com/tyler-sommer/stick"
+	"github.com/veonik/go-stickgen"
+)
+
+func TestGenerateAST(t *testing.T) {
+	templates := map[string]string{
+		"layout.twig":        `[{% block content %}{% endblock %}]`,
+		"index.twig":         `{% extends 'layout.twig' %}{% block content %}{{ name }}{% endblock %}`,
+		"partials/card.twig": `card`,
+	}
+	tests := []struct {
+		name    string
+		names   []string
+		options func(g *stickgen.Generator)
+		file    string
+		funcs   []string
+		err     string
+	}{
+		{
+			name:  "template",
+			names: []string{"index.twig"},
+			file:  "index_twig.go",
+			funcs: []string{"blockIndexTwigContent", "TemplateIndexTwig"},
+		},
+		{
+			name:  "templates",
+			names: []string{"partials/card.twig", "index.twig"},
+			file:  "partials_card_twig.go",
+			funcs: []string{"blockIndexTwigContent", "TemplatePartialsCardTwig", "TemplateIndexTwig"},
+		},
+		{
+			name:    "unformatted",
+			names:   []string{"index.twig"},
+			options: func(g *stickgen.Generator) { g.NoFormat = true },
+			file:    "index_twig.go",
+			funcs:   []string{"blockIndexTwigContent", "TemplateIndexTwig"},
+		},
+		{
+			name:  "no templates",
+			err:   "no templates given",
+			names: nil,
+		},
+		{
+			name:  "missing",
+			names: []string{"missing.twig"},
+			err:   "template not found: missing.twig",
+		},
+		{
+			name:  "invalid code",
+			names: []string{"index.twig"},
+			options: func(g *stickgen.Generator) {
+				g.AllowUnformatted = true
+				g.Naming = brokenNaming{}
+			},
+			err: "unable to parse generated code",
+		},
+	}
+	for _, tt := range tests {
+		g := stickgen.NewGenerator("views", &stick.MemoryLoader{Templates: templates})
+		if tt.options != nil {
+			tt.options(g)
+		}
+		fset, f, err := g.GenerateAST(tt.names...)
+		checkErr(t, tt.name, "generating", err, tt.err)
+		if err != nil {
+			continue
+		}
+		if got := fset.Position(f.Pos()).Filename; got != tt.file {
+			t.Errorf("%s: expected file %q, got %q", tt.name, tt.file, got)
+		}
+		if f.Name.Name != "views" {
+			t.Errorf("%s: expected package views, got %s", tt.name, f.Name.Name)
+		}
+		var funcs []string
+		for _, decl := range f.Decls {
+			if fn, ok := decl.(*ast.FuncDecl); ok {
+				funcs = append(funcs, fn.Name.Name)
+			}
+		}
+		if !reflect.DeepEqual(funcs, tt.funcs) {
+			t.Errorf("%s: expected functions %v, got %v", tt.name, tt.funcs, funcs)
+		}
+		// Printing the tree gives the same code as GenerateAll.
+		c := stickgen.NewGenerator("views", &stick.MemoryLoader{Templates: templates})
+		if tt.options != nil {
+			tt.options(c)
+		}
+		want, err := c.GenerateAll(tt.names...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var b bytes.Buffer
+		if err := format.Node(&b, fset, f); err != nil {
+			t.Fatal(err)
+		}
+		if b.String() != want {
+			t.Errorf("%s: expected printed code:\n%s\ngot:\n%s", tt.name, want, b.String())
+		}
+	}
+}
+
+// brokenNaming names templates with invalid identifiers.
+type brokenNaming struct {
+	stickgen.DefaultNaming
+}
+
+func (brokenNaming) Template(name string) string {
+	return "Template-" + name
+}
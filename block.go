@@ -0,0 +1,550 @@
+package stickgen
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tyler-sommer/stick/parse"
+)
+
+// A block is a block definition registered in a block scope.
+type block struct {
+	name    string
+	scope   string
+	tplName string
+	// escape is the escaping strategy in effect where the block is defined.
+	escape string
+	node   *parse.BlockNode
+	// level is the depth of the defining template in the inheritance chain.
+	// Blocks defined in more derived templates take precedence.
+	level int
+	// parent is the name of the generated function for the block this block
+	// overrides, if any.
+	parent string
+}
+
+// addBlock registers the given block under the given name, returning the
+// name of its generated function. Blocks defined in more derived templates
+// override those defined in their parents, which remain available to the
+// overriding block using parent(). Any blocks nested in the given block are
+// registered as well.
+func (g *Generator) addBlock(name string, node *parse.BlockNode) string {
+	fnName := g.blockName(name)
+	b := block{
+		name:    name,
+		scope:   g.blockScope(),
+		tplName: g.name,
+		escape:  g.escape,
+		node:    node,
+		level:   g.level,
+	}
+	g.register(fnName)
+	prev, ok := g.blocks[fnName]
+	switch {
+	case !ok || prev.tplName == b.tplName && prev.level == b.level:
+		g.blocks[fnName] = b
+	case g.hasBlock(fnName, node):
+		return fnName
+	case b.level <= prev.level:
+		b.parent = fnName + g.templateIdent(prev.tplName)
+		g.register(b.parent)
+		g.blocks[b.parent] = prev
+		g.blocks[fnName] = b
+	default:
+		// The block is overridden, so it is inserted into the chain of
+		// overrides below the last block that is more derived.
+		cur := fnName
+		for {
+			c := g.blocks[cur]
+			if c.parent == "" || g.blocks[c.parent].level >= b.level {
+				b.parent = c.parent
+				c.parent = fnName + g.templateIdent(b.tplName)
+				g.blocks[cur] = c
+				g.register(c.parent)
+				g.blocks[c.parent] = b
+				break
+			}
+			cur = c.parent
+		}
+	}
+	g.addNestedBlocks(node.Body)
+	return fnName
+}
+
+// hasBlock reports whether the given block is registered under the given
+// name or is overridden by the block registered under it.
+func (g *Generator) hasBlock(fnName string, node *parse.BlockNode) bool {
+	for fnName != "" {
+		b := g.blocks[fnName]
+		if b.node == node {
+			return true
+		}
+		fnName = b.parent
+	}
+	return false
+}
+
+// takeBlocks removes the blocks registered in the given scope, returning
+// them in the order they were registered.
+func (g *Generator) takeBlocks(scope string) []block {
+	var names []string
+	for fnName, b := range g.blocks {
+		if b.scope == scope {
+			names = append(names, fnName)
+		}
+	}
+	var blocks []block
+	for _, fnName := range g.inOrder(names) {
+		blocks = append(blocks, g.blocks[fnName])
+		delete(g.blocks, fnName)
+	}
+	return blocks
+}
+
+// redefineBlocks registers the given blocks in the current scope as they
+// were registered by the templates defining them.
+func (g *Generator) redefineBlocks(blocks []block) {
+	prevName, prevEscape, prevLevel := g.name, g.escape, g.level
+	defer func() {
+		g.name, g.escape, g.level = prevName, prevEscape, prevLevel
+	}()
+	for _, b := range blocks {
+		g.name, g.escape, g.level = b.tplName, b.escape, b.level
+		g.addBlock(b.name, b.node)
+	}
+}
+
+// addNestedBlocks registers the blocks nested within the given node so that
+// overrides are resolved before any block is generated.
+func (g *Generator) addNestedBlocks(n parse.Node) {
+	switch node := n.(type) {
+	case *parse.BlockNode:
+		g.addBlock(node.Name, node)
+		return
+	case *parse.EmbedNode:
+		// Blocks in an embed tag belong to the embedded template's scope.
+		return
+	case *parse.BodyNode:
+		if node == nil {
+			return
+		}
+	case *parse.FilterNode:
+		if d, ok := g.directive(node); ok && d.tag == "autoescape" {
+			if strategy, err := g.autoescapeStrategy(d.args); err == nil {
+				prevEscape := g.escape
+				g.escape = strategy
+				defer func() {
+					g.escape = prevEscape
+				}()
+			}
+		}
+	}
+	for _, child := range n.All() {
+		if child != nil {
+			g.addNestedBlocks(child)
+		}
+	}
+}
+
+// walkChild walks a template that extends another. As in Twig, the child
+// may only define blocks and contain tags that produce no output, which are
+// walked before the parent template is generated.
+//
+// When the parent is chosen by a condition, each possible parent is
+// generated in its own block scope and the choice is made at runtime.
+func (g *Generator) walkChild(node *parse.ModuleNode) error {
+	names, cond, err := g.extendsRef(node.Parent.Tpl)
+	if err != nil {
+		return err
+	}
+	var defs []parse.Node
+	for _, n := range node.BodyNode.All() {
+		switch child := n.(type) {
+		case *parse.TextNode:
+			if strings.TrimSpace(child.Data) == "" {
+				continue
+			}
+		case *parse.BlockNode, *parse.UseNode:
+			defs = append(defs, child)
+			continue
+		case *parse.MacroNode:
+			continue
+		case *parse.SetNode, *parse.DoNode, *parse.ImportNode, *parse.FromNode:
+			if err := g.walk(child); err != nil {
+				return err
+			}
+			continue
+		case *parse.FilterNode:
+			if d, ok := g.directive(child); ok && d.tag == "set" {
+				if err := g.walk(child); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		return fmt.Errorf("stickgen: %s extends another template and cannot contain content outside of blocks", g.name)
+	}
+	extend := func(name string) error {
+		for _, n := range defs {
+			switch def := n.(type) {
+			case *parse.BlockNode:
+				g.addBlock(def.Name, def)
+			case *parse.UseNode:
+				if err := g.walkUse(def); err != nil {
+					return err
+				}
+			}
+		}
+		g.level++
+		defer func() {
+			g.level--
+		}()
+		return g.generate(name)
+	}
+	if cond == nil {
+		return extend(names[0])
+	}
+	c, err := g.walkExpr(cond)
+	if err != nil {
+		return err
+	}
+	g.out.WriteString(g.comment(node.Parent.Pos))
+	errCheck := ""
+	if c.isFunction {
+		g.out.WriteString(fmt.Sprintf(`%s{
+%s	%s
+`, g.indent(), g.indent(), c.body))
+		g.tabs++
+		if c.hasError && g.IgnoreErrors {
+			errCheck = "err == nil && "
+		} else if c.hasError {
+			g.out.WriteString(fmt.Sprintf(`%s%s
+`, g.indent(), g.checkErr(c)))
+		}
+	}
+	prevScope := g.scope
+	scope := g.blockScope()
+	defer func() {
+		g.scope = prevScope
+	}()
+	// Blocks defined by more derived templates override those of each
+	// possible parent, so they are moved into each parent's scope.
+	derived := g.takeBlocks(scope)
+	for i, name := range names {
+		if i == 0 {
+			g.out.WriteString(fmt.Sprintf(`%sif %sstick.CoerceBool(%s) {
+`, g.indent(), errCheck, c.resultantName))
+		} else {
+			g.out.WriteString(fmt.Sprintf(`%s} else {
+`, g.indent()))
+		}
+		g.scope = fmt.Sprintf("%sExtends%d", scope, i+1)
+		g.redefineBlocks(derived)
+		g.tabs++
+		if err := extend(name); err != nil {
+			return err
+		}
+		g.tabs--
+	}
+	g.out.WriteString(fmt.Sprintf(`%s}
+`, g.indent()))
+	if c.isFunction {
+		g.tabs--
+		g.out.WriteString(fmt.Sprintf(`%s}
+`, g.indent()))
+	}
+	return nil
+}
+
+// extendsRef returns the possible parents of a template given the expression
+// in its extends tag. If there is more than one, the returned condition
+// chooses the first when true and the second otherwise.
+//
+// Supported expressions are those accepted by templateName, as well as
+// conditionals whose branches are string literals.
+func (g *Generator) extendsRef(e parse.Expr) ([]string, parse.Expr, error) {
+	if name, ok := g.templateName(e); ok {
+		return []string{name}, nil, nil
+	}
+	switch expr := e.(type) {
+	case *parse.GroupExpr:
+		return g.extendsRef(expr.X)
+	case *parse.TernaryIfExpr:
+		t, ok := g.evaluate(expr.TrueX)
+		f, ok2 := g.evaluate(expr.FalseX)
+		if ok && ok2 {
+			return []string{t, f}, expr.Cond, nil
+		}
+	}
+	return nil, nil, errors.New("Unable to evaluate extends reference")
+}
+
+// renderBlock generates the function for a block. Variables set where the
+// block is called, such as loop variables, are passed to it in its context,
+// so the block starts with no local variables of its own.
+func (g *Generator) renderBlock(fnName string, b block) error {
+	prevName, prevScope, prevEscape, prevParent, prevLevel, prevTemps := g.name, g.scope, g.escape, g.parent, g.level, g.temps
+	prevArgs, prevLoop := g.args, g.loop
+	g.name, g.scope, g.escape, g.parent, g.level, g.temps = b.tplName, b.scope, b.escape, b.parent, b.level, 0
+	g.args, g.loop = make(map[string]string), ""
+	defer func() {
+		g.name, g.scope, g.escape, g.parent, g.level, g.temps = prevName, prevScope, prevEscape, prevParent, prevLevel, prevTemps
+		g.args, g.loop = prevArgs, prevLoop
+	}()
+	g.out.WriteString(fmt.Sprintf(`func %s(%senv *stick.Env, output io.Writer, ctx map[string]stick.Value)%s {
+`, fnName, g.params(), g.result()))
+	if err := g.walk(b.node.Body); err != nil {
+		return err
+	}
+	g.out.WriteString(g.returnNil() + `}`)
+	return nil
+}
+
+// walkParentFunc generates a call to the parent() function, which renders
+// the block overridden by the current block.
+func (g *Generator) walkParentFunc() (evaluatedExpr, error) {
+	if g.parent == "" {
+		return emptyExpr, fmt.Errorf("stickgen: parent called outside of an overriding block in %s", g.name)
+	}
+	g.parents[g.parent] = true
+	g.addImport("bytes")
+	parentval := g.temp("parentval")
+	return evaluatedExpr{
+		body: fmt.Sprintf(`%s := &bytes.Buffer{}
+%s	%s`, parentval, g.indent(), g.checked(fmt.Sprintf("%s(%senv, %s, %s)", g.parent, g.callArgs(), parentval, g.contextVars()), "err")),
+		resultantName: parentval + ".String()",
+		isFunction:    true,
+		hasError:      false,
+		safe:          true,
+		isString:      true,
+	}, nil
+}
+
+// blockName returns the name of the generated function for a block in the
+// current scope.
+func (g *Generator) blockName(name string) string {
+	return g.blockFuncName(g.blockScope(), name)
+}
+
+// blockFuncName returns the name of the generated function for a block in
+// the given scope.
+func (g *Generator) blockFuncName(scope, name string) string {
+	return g.Naming.Block(scope, name)
+}
+
+// blockScope returns the current block scope, which defaults to the name of
+// the root template.
+func (g *Generator) blockScope() string {
+	if g.scope == "" {
+		return g.templateIdent(g.stack[0])
+	}
+	return g.scope
+}
+
+// dispatcherName returns the name of the generated function that renders a
+// block in the given scope by name.
+func (g *Generator) dispatcherName(scope string) string {
+	return "blocks" + g.Naming.Identifier(scope)
+}
+
+// walkBlockFunc generates a call to the block() function. The block is
+// looked up by name at runtime. If a template is given, the block is
+// rendered as defined by that template.
+func (g *Generator) walkBlockFunc(expr *parse.FuncExpr) (evaluatedExpr, error) {
+	args, err := resolveArgs(expr.Args, signatures["Functions"]["block"])
+	if err != nil {
+		return emptyExpr, err
+	}
+	if len(args) == 0 || len(args) > 2 || args[0] == nil {
+		return emptyExpr, errors.New("stickgen: block expects a block name")
+	}
+	scope := g.blockScope()
+	if len(args) == 2 && args[1] != nil {
+		tplName, ok := g.evaluate(args[1])
+		if !ok {
+			return emptyExpr, errors.New("Unable to evaluate block template reference")
+		}
+		if scope, err = g.templateBlocks(tplName); err != nil {
+			return emptyExpr, err
+		}
+	}
+	name, err := g.walkExpr(args[0])
+	if err != nil {
+		return emptyExpr, err
+	}
+	body := ""
+	if name.isFunction {
+		body = g.handleErr(name) + "\n"
+	}
+	g.register(g.dispatcherName(scope))
+	g.dispatchers[scope] = true
+	val := g.temp("val")
+	return evaluatedExpr{
+		body:          fmt.Sprintf(`%s%s, err := %s(%senv, %s, stick.CoerceString(%s))`, body, val, g.dispatcherName(scope), g.callArgs(), g.contextVars(), name.resultantName),
+		resultantName: val,
+		isFunction:    true,
+		hasError:      true,
+		safe:          true,
+		isString:      true,
+	}, nil
+}
+
+// templateBlocks registers the blocks of the named template, including any
+// it inherits, in a scope of their own. The scope is returned.
+func (g *Generator) templateBlocks(name string) (string, error) {
+	scope := "Template" + g.templateIdent(name)
+	if g.dispatchers[scope] {
+		return scope, nil
+	}
+	prevScope, prevName, prevLevel := g.scope, g.name, g.level
+	defer func() {
+		g.scope, g.name, g.level = prevScope, prevName, prevLevel
+	}()
+	g.scope = scope
+	for g.level = 0; name != ""; g.level++ {
+		tree, err := g.parse(name)
+		if err != nil {
+			return "", err
+		}
+		g.name = name
+		for _, n := range tree.Root().All() {
+			switch child := n.(type) {
+			case *parse.UseNode:
+				if err := g.walkUse(child); err != nil {
+					return "", err
+				}
+			case *parse.BlockNode:
+				g.addBlock(child.Name, child)
+			}
+		}
+		name = ""
+		if tree.Root().Parent != nil {
+			names, cond, err := g.extendsRef(tree.Root().Parent.Tpl)
+			if err != nil {
+				return "", err
+			}
+			if cond != nil {
+				return "", fmt.Errorf("stickgen: unable to render blocks of %s, which conditionally extends another template", g.name)
+			}
+			name = names[0]
+		}
+	}
+	return scope, nil
+}
+
+// blockNames returns the sorted names of the blocks in the given scope,
+// excluding any that are overridden.
+func (g *Generator) blockNames(scope string) []string {
+	var names []string
+	for fnName, b := range g.blocks {
+		if b.scope == scope && fnName == g.blockFuncName(scope, b.name) {
+			names = append(names, b.name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// renderDispatcher generates a function that renders the blocks in the given
+// scope by name.
+func (g *Generator) renderDispatcher(scope string) {
+	names := g.blockNames(scope)
+	g.addImport("bytes")
+	g.addImport("fmt")
+	g.out.WriteString(fmt.Sprintf(`func %s(%senv *stick.Env, ctx map[string]stick.Value, name string) (string, error) {
+	output := &bytes.Buffer{}
+	switch name {
+`, g.dispatcherName(scope), g.params()))
+	for _, name := range names {
+		call := fmt.Sprintf("%s(%senv, output, ctx)", g.blockFuncName(scope, name), g.callArgs())
+		if !g.IgnoreErrors {
+			call = fmt.Sprintf(`if err := %s; err != nil {
+			return "", err
+		}`, call)
+		}
+		g.out.WriteString(fmt.Sprintf(`	case %s:
+		%s
+`, strconv.Quote(name), call))
+	}
+	g.out.WriteString(`	default:
+		return "", fmt.Errorf("block %s does not exist", name)
+	}
+	return output.String(), nil
+}`)
+}
+
+// walkEmbed generates an embedded template inline. The embedded template's
+// blocks are generated in a new scope so that the blocks overridden by the
+// embed tag do not conflict with blocks in the embedding template. As with
+// include, the embedded template is rendered with any variables given by
+// the with and only options.
+func (g *Generator) walkEmbed(node *parse.EmbedNode) error {
+	name, ok := g.evaluate(node.Tpl)
+	if !ok {
+		return errors.New("Unable to evaluate embed reference")
+	}
+	prevScope := g.scope
+	g.embeds++
+	g.scope = fmt.Sprintf("%sEmbed%d", g.blockScope(), g.embeds)
+	defer func() {
+		g.scope = prevScope
+	}()
+	g.out.WriteString(g.comment(node.Pos))
+	if node.With != nil || node.Only {
+		if err := g.withContext(node.With, node.Only, func() error {
+			return g.generate(name)
+		}); err != nil {
+			return err
+		}
+	} else {
+		g.out.WriteString(fmt.Sprintf(`%s{
+`, g.indent()))
+		g.tabs++
+		if err := g.generate(name); err != nil {
+			return err
+		}
+		g.tabs--
+		g.out.WriteString(fmt.Sprintf(`%s}
+`, g.indent()))
+	}
+	for _, block := range node.Blocks {
+		g.addBlock(block.Name, block)
+	}
+	return nil
+}
+
+// walkUse registers the blocks defined in another template without
+// generating the rest of its body. Blocks may be registered under an alias.
+func (g *Generator) walkUse(node *parse.UseNode) error {
+	name, ok := g.evaluate(node.Tpl)
+	if !ok {
+		return errors.New("Unable to evaluate use reference")
+	}
+	tree, err := g.parse(name)
+	if err != nil {
+		return err
+	}
+	prevName := g.name
+	g.name = name
+	defer func() {
+		g.name = prevName
+	}()
+	for _, n := range tree.Root().All() {
+		switch child := n.(type) {
+		case *parse.UseNode:
+			if err := g.walkUse(child); err != nil {
+				return err
+			}
+		case *parse.BlockNode:
+			blockName := child.Name
+			if alias, ok := node.Aliases[child.Name]; ok {
+				blockName = alias
+			}
+			g.addBlock(blockName, child)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,570 @@
+package stickgen_test
+
+import "testing"
+
+func TestBlockScope(t *testing.T) {
+	items := `map[string]stick.Value{"items": []stick.Value{1, 2, 3}}`
+	testRender(t, []renderTest{
+		{
+			name:      "loop variable",
+			templates: map[string]string{"test.twig": `{% for i in items %}{% block row %}{{ i }}{% endblock %}{% endfor %}`},
+			ctx:       items,
+			want:      "123",
+		},
+		{
+			name:      "loop",
+			templates: map[string]string{"test.twig": `{% for i in items %}{% block row %}{{ loop.index0 }}{% endblock %}{% endfor %}`},
+			ctx:       items,
+			want:      "012",
+		},
+		{
+			name:      "set variable",
+			templates: map[string]string{"test.twig": `{% set x = 'a' %}{% block b %}{{ x }}{% endblock %}`},
+			want:      "a",
+		},
+		{
+			name:      "block function",
+			templates: map[string]string{"test.twig": `{% for i in items %}{{ block('row') }}{% endfor %}{% block row %}{{ i }}{% endblock %}`},
+			ctx:       `map[string]stick.Value{"items": []stick.Value{1, 2, 3}, "i": "-"}`,
+			want:      "123-",
+		},
+		{
+			name: "parent",
+			templates: map[string]string{
+				"layout.twig": `{% for i in items %}{% block row %}{{ i }}{% endblock %}{% endfor %}`,
+				"test.twig":   `{% extends 'layout.twig' %}{% block row %}[{{ parent() }}]{% endblock %}`,
+			},
+			ctx:  items,
+			want: "[1][2][3]",
+		},
+		{
+			name:      "local to block",
+			templates: map[string]string{"test.twig": `{% block b %}{% set x = 'in' %}{{ x }}{% endblock %}{{ x }}`},
+			ctx:       `map[string]stick.Value{"x": "out"}`,
+			want:      "inout",
+		},
+	})
+}
+
+func TestEmbed(t *testing.T) {
+	card := `<div>{% block title %}Title{% endblock %}|{% block body %}Body{% endblock %}</div>`
+	testRender(t, []renderTest{
+		{
+			name: "override",
+			templates: map[string]string{
+				"card.twig": card,
+				"test.twig": `{% embed 'card.twig' %}{% block body %}Custom{% endblock %}{% endembed %}`,
+			},
+			want: "<div>Title|Custom</div>",
+		},
+		{
+			name: "with only",
+			templates: map[string]string{
+				"card.twig": `<div>{% block title %}{{ title }}{% endblock %}|{% if name is defined %}{{ name }}{% endif %}</div>`,
+				"test.twig": `{% embed 'card.twig' with {'title': t} only %}{% endembed %}`,
+			},
+			ctx:  `map[string]stick.Value{"t": "T", "name": "N"}`,
+			want: "<div>T|</div>",
+		},
+		{
+			name: "with",
+			templates: map[string]string{
+				"card.twig": `<div>{% block title %}{% endblock %}|{{ name }}</div>`,
+				"test.twig": `{% embed 'card.twig' with {'name': 'M'} %}{% block title %}{{ name }}{% endblock %}{% endembed %}`,
+			},
+			ctx:  `map[string]stick.Value{"name": "N"}`,
+			want: "<div>M|M</div>",
+		},
+		{
+			name: "no overrides",
+			templates: map[string]string{
+				"card.twig": card,
+				"test.twig": `{% embed 'card.twig' %}{% endembed %}`,
+			},
+			want: "<div>Title|Body</div>",
+		},
+		{
+			name: "embedded twice",
+			templates: map[string]string{
+				"card.twig": card,
+				"test.twig": `{% embed 'card.twig' %}{% block title %}A{% endblock %}{% endembed %}{% embed 'card.twig' %}{% block title %}B{% endblock %}{% endembed %}`,
+			},
+			want: "<div>A|Body</div><div>B|Body</div>",
+		},
+		{
+			name: "does not affect own blocks",
+			templates: map[string]string{
+				"card.twig": card,
+				"test.twig": `{% block title %}Page{% endblock %}{% embed 'card.twig' %}{% block title %}Card{% endblock %}{% endembed %}`,
+			},
+			want: "Page<div>Card|Body</div>",
+		},
+		{
+			name: "parent",
+			templates: map[string]string{
+				"card.twig": card,
+				"test.twig": `{% embed 'card.twig' %}{% block body %}[{{ parent() }}]{% endblock %}{% endembed %}`,
+			},
+			want: "<div>Title|[Body]</div>",
+		},
+		{
+			name: "context",
+			templates: map[string]string{
+				"card.twig": card,
+				"test.twig": `{% for i in [1, 2] %}{% embed 'card.twig' %}{% block body %}{{ i }}{{ name }}{% endblock %}{% endembed %}{% endfor %}`,
+			},
+			ctx:  `map[string]stick.Value{"name": "!"}`,
+			want: "<div>Title|1!</div><div>Title|2!</div>",
+		},
+		{
+			name:      "missing template",
+			templates: map[string]string{"test.twig": `{% embed 'card.twig' %}{% endembed %}`},
+			err:       "card.twig",
+		},
+		{
+			name:      "dynamic template",
+			templates: map[string]string{"test.twig": `{% embed name %}{% endembed %}`},
+			err:       "Unable to evaluate embed reference",
+		},
+	})
+}
+
+func TestUse(t *testing.T) {
+	blocks := `{% block title %}Title{% endblock %}{% block footer %}Footer{% endblock %}body`
+	testRender(t, []renderTest{
+		{
+			name: "use",
+			templates: map[string]string{
+				"blocks.twig": blocks,
+				"test.twig":   `{% use 'blocks.twig' %}{{ block('title') }}/{{ block('footer') }}`,
+			},
+			want: "Title/Footer",
+		},
+		{
+			name: "own block wins",
+			templates: map[string]string{
+				"blocks.twig": blocks,
+				"test.twig":   `{% use 'blocks.twig' %}{% block title %}Own{% endblock %}/{{ block('footer') }}`,
+			},
+			want: "Own/Footer",
+		},
+		{
+			name: "alias",
+			templates: map[string]string{
+				"blocks.twig": blocks,
+				"test.twig":   `{% use 'blocks.twig' with title as base_title %}{% block title %}[{{ block('base_title') }}]{% endblock %}`,
+			},
+			want: "[Title]",
+		},
+		{
+			name: "nested use",
+			templates: map[string]string{
+				"base.twig":   `{% block base %}Base{% endblock %}`,
+				"blocks.twig": `{% use 'base.twig' %}` + blocks,
+				"test.twig":   `{% use 'blocks.twig' %}{{ block('base') }}`,
+			},
+			want: "Base",
+		},
+		{
+			name: "in layout",
+			templates: map[string]string{
+				"blocks.twig": blocks,
+				"layout.twig": `{% use 'blocks.twig' %}{{ block('title') }}|{% block content %}{% endblock %}`,
+				"test.twig":   `{% extends 'layout.twig' %}{% block title %}Child{% endblock %}{% block content %}Content{% endblock %}`,
+			},
+			want: "Child|Content",
+		},
+		{
+			name:      "missing template",
+			templates: map[string]string{"test.twig": `{% use 'blocks.twig' %}`},
+			err:       "blocks.twig",
+		},
+		{
+			name:      "dynamic template",
+			templates: map[string]string{"test.twig": `{% use name %}`},
+			err:       "Unable to evaluate use reference",
+		},
+	})
+}
+
+func TestShorthandBlock(t *testing.T) {
+	testRender(t, []renderTest{
+		{
+			name:      "shorthand",
+			templates: map[string]string{"test.twig": `<title>{% block title page_title %}</title>`},
+			ctx:       `map[string]stick.Value{"page_title": "Home"}`,
+			want:      "<title>Home</title>",
+		},
+		{
+			name:      "expression",
+			templates: map[string]string{"test.twig": `{% block title 'Site: ' ~ name %}`},
+			ctx:       `map[string]stick.Value{"name": "Home"}`,
+			want:      "Site: Home",
+		},
+		{
+			name: "overridden",
+			templates: map[string]string{
+				"layout.twig": `[{% block title 'Default' %}]`,
+				"test.twig":   `{% extends 'layout.twig' %}{% block title page_title|upper %}`,
+			},
+			ctx: `map[string]stick.Value{"page_title": "home"}`,
+			setup: `env.Filters["upper"] = func(ctx stick.Context, val stick.Value, args ...stick.Value) stick.Value {
+		return strings.ToUpper(stick.CoerceString(val))
+	}`,
+			imports: []string{"strings"},
+			want:    "[HOME]",
+		},
+		{
+			name: "overrides long form",
+			templates: map[string]string{
+				"layout.twig": `[{% block title %}Default{% endblock %}]`,
+				"test.twig":   `{% extends 'layout.twig' %}{% block title 'Child' %}`,
+			},
+			want: "[Child]",
+		},
+		{
+			name:      "escaped",
+			templates: map[string]string{"test.twig": `{% autoescape 'html' %}{% block title s %}{% endautoescape %}`},
+			ctx:       `map[string]stick.Value{"s": "<b>"}`,
+			want:      "&lt;b&gt;",
+		},
+		{
+			name:      "block function",
+			templates: map[string]string{"test.twig": `{% block title 'a' %}{{ block('title') }}`},
+			want:      "aa",
+		},
+	})
+}
+
+func TestBlockFunc(t *testing.T) {
+	testRender(t, []renderTest{
+		{
+			name:      "literal name",
+			templates: map[string]string{"test.twig": `{% block sidebar %}side{% endblock %}|{{ block('sidebar') }}`},
+			want:      "side|side",
+		},
+		{
+			name:      "dynamic name",
+			templates: map[string]string{"test.twig": `{% block page_footer %}page{% endblock %}{% block post_footer %}post{% endblock %}|{{ block(kind ~ '_footer') }}`},
+			ctx:       `map[string]stick.Value{"kind": "post"}`,
+			want:      "pagepost|post",
+		},
+		{
+			name: "overridden",
+			templates: map[string]string{
+				"layout.twig": `{% block title %}Layout{% endblock %}|{{ block('title') }}`,
+				"test.twig":   `{% extends 'layout.twig' %}{% block title %}Child{% endblock %}`,
+			},
+			want: "Child|Child",
+		},
+		{
+			name: "inherited",
+			templates: map[string]string{
+				"layout.twig": `{% block nav %}Nav{% endblock %}{% block content %}{% endblock %}`,
+				"test.twig":   `{% extends 'layout.twig' %}{% block content %}[{{ block('nav') }}]{% endblock %}`,
+			},
+			want: "Nav[Nav]",
+		},
+		{
+			name:      "not escaped again",
+			templates: map[string]string{"test.twig": `{% autoescape 'html' %}{% block b %}<i>{{ s }}</i>{% endblock %}{{ block('b') }}{% endautoescape %}`},
+			ctx:       `map[string]stick.Value{"s": "<b>"}`,
+			want:      "<i>&lt;b&gt;</i><i>&lt;b&gt;</i>",
+		},
+		{
+			name:      "unknown block",
+			templates: map[string]string{"test.twig": `{% block a %}{% endblock %}{{ block(name) }}`},
+			ctx:       `map[string]stick.Value{"name": "nope"}`,
+			runErr:    "block nope does not exist",
+		},
+		{
+			name:      "no name",
+			templates: map[string]string{"test.twig": `{{ block() }}`},
+			err:       "block expects a block name",
+		},
+	})
+}
+
+func TestParentFunc(t *testing.T) {
+	layout := `<{% block title %}Layout{% endblock %}>`
+	testRender(t, []renderTest{
+		{
+			name: "parent",
+			templates: map[string]string{
+				"layout.twig": layout,
+				"test.twig":   `{% extends 'layout.twig' %}{% block title %}Child - {{ parent() }}{% endblock %}`,
+			},
+			want: "<Child - Layout>",
+		},
+		{
+			name: "called twice",
+			templates: map[string]string{
+				"layout.twig": layout,
+				"test.twig":   `{% extends 'layout.twig' %}{% block title %}{{ parent() }}{{ parent() }}{% endblock %}`,
+			},
+			want: "<LayoutLayout>",
+		},
+		{
+			name: "chain",
+			templates: map[string]string{
+				"layout.twig": layout,
+				"page.twig":   `{% extends 'layout.twig' %}{% block title %}Page/{{ parent() }}{% endblock %}`,
+				"test.twig":   `{% extends 'page.twig' %}{% block title %}Child/{{ parent() }}{% endblock %}`,
+			},
+			want: "<Child/Page/Layout>",
+		},
+		{
+			name: "skips level",
+			templates: map[string]string{
+				"layout.twig": layout,
+				"page.twig":   `{% extends 'layout.twig' %}`,
+				"test.twig":   `{% extends 'page.twig' %}{% block title %}Child/{{ parent() }}{% endblock %}`,
+			},
+			want: "<Child/Layout>",
+		},
+		{
+			name: "context",
+			templates: map[string]string{
+				"layout.twig": `{% block title %}{{ name }}{% endblock %}`,
+				"test.twig":   `{% extends 'layout.twig' %}{% block title %}{% set name = 'local' %}{{ parent() }}{% endblock %}`,
+			},
+			ctx:  `map[string]stick.Value{"name": "ctx"}`,
+			want: "local",
+		},
+		{
+			name:      "outside block",
+			templates: map[string]string{"test.twig": `{{ parent() }}`},
+			err:       "parent called outside of an overriding block in test.twig",
+		},
+		{
+			name:      "not overriding",
+			templates: map[string]string{"test.twig": `{% block title %}{{ parent() }}{% endblock %}`},
+			err:       "parent called outside of an overriding block in test.twig",
+		},
+	})
+}
+
+func TestBlockOverride(t *testing.T) {
+	layout := `{% block head %}H{% endblock %}|{% block content %}[{% block inner %}i{% endblock %}]{% endblock %}|{% block foot %}F{% endblock %}`
+	testRender(t, []renderTest{
+		{
+			name: "most derived wins",
+			templates: map[string]string{
+				"layout.twig": layout,
+				"page.twig":   `{% extends 'layout.twig' %}{% block head %}page{% endblock %}{% block foot %}page{% endblock %}`,
+				"test.twig":   `{% extends 'page.twig' %}{% block head %}child{% endblock %}`,
+			},
+			want: "child|[i]|page",
+		},
+		{
+			name: "nested block",
+			templates: map[string]string{
+				"layout.twig": layout,
+				"test.twig":   `{% extends 'layout.twig' %}{% block inner %}child{% endblock %}`,
+			},
+			want: "H|[child]|F",
+		},
+		{
+			name: "outer block replaces nested block",
+			templates: map[string]string{
+				"layout.twig": layout,
+				"test.twig":   `{% extends 'layout.twig' %}{% block content %}content{% endblock %}`,
+			},
+			want: "H|content|F",
+		},
+		{
+			name: "nested block defined by child",
+			templates: map[string]string{
+				"layout.twig": layout,
+				"page.twig":   `{% extends 'layout.twig' %}{% block content %}<{% block inner %}page{% endblock %}>{% endblock %}`,
+				"test.twig":   `{% extends 'page.twig' %}{% block inner %}child{% endblock %}`,
+			},
+			want: "H|<child>|F",
+		},
+		{
+			name: "defined in child order",
+			templates: map[string]string{
+				"layout.twig": layout,
+				"page.twig":   `{% extends 'layout.twig' %}{% block inner %}page{% endblock %}`,
+				"test.twig":   `{% extends 'page.twig' %}{% block content %}{{ parent() }}{% endblock %}{% block inner %}child-{{ parent() }}{% endblock %}`,
+			},
+			want: "H|[child-page]|F",
+		},
+		{
+			name: "unused block",
+			templates: map[string]string{
+				"layout.twig": layout,
+				"test.twig":   `{% extends 'layout.twig' %}{% block other %}other{% endblock %}`,
+			},
+			want: "H|[i]|F",
+		},
+		{
+			name: "content outside blocks",
+			templates: map[string]string{
+				"layout.twig": layout,
+				"test.twig":   `{% extends 'layout.twig' %}content`,
+			},
+			err: "cannot contain content outside of blocks",
+		},
+		{
+			name: "missing parent",
+			templates: map[string]string{
+				"test.twig": `{% extends 'layout.twig' %}`,
+			},
+			err: "layout.twig",
+		},
+	})
+}
+
+func TestDynamicExtends(t *testing.T) {
+	templates := map[string]string{
+		"base.twig":      `base[{% block content %}{% endblock %}]`,
+		"base_ajax.twig": `ajax[{% block content %}{% endblock %}]`,
+		"test.twig":      `{% extends ajax ? 'base_ajax.twig' : 'base.twig' %}{% block content %}{{ name }}{% endblock %}`,
+	}
+	testRender(t, []renderTest{
+		{name: "conditional true", templates: templates, ctx: `map[string]stick.Value{"ajax": true, "name": "a"}`, want: "ajax[a]"},
+		{name: "conditional false", templates: templates, ctx: `map[string]stick.Value{"ajax": false, "name": "a"}`, want: "base[a]"},
+		{
+			name: "conditional function",
+			templates: map[string]string{
+				"base.twig":      templates["base.twig"],
+				"base_ajax.twig": templates["base_ajax.twig"],
+				"test.twig":      `{% extends (is_ajax() ? 'base_ajax.twig' : 'base.twig') %}{% block content %}c{% endblock %}`,
+			},
+			setup: `env.Functions["is_ajax"] = func(ctx stick.Context, args ...stick.Value) stick.Value {
+		return true
+	}`,
+			want: "ajax[c]",
+		},
+		{
+			name: "conditional parent",
+			templates: map[string]string{
+				"base.twig":      templates["base.twig"],
+				"base_ajax.twig": templates["base_ajax.twig"],
+				"test.twig":      `{% extends ajax ? 'base_ajax.twig' : 'base.twig' %}{% block content %}<{{ parent() }}>{% endblock %}`,
+				"page.twig":      `{% extends 'test.twig' %}{% block content %}page{{ parent() }}{% endblock %}`,
+			},
+			tpl:  "page.twig",
+			ctx:  `map[string]stick.Value{"ajax": false}`,
+			call: `err := TemplatePageTwig(env, output, ctx)`,
+			want: "base[page<>]",
+		},
+		{
+			name: "conditional grandparent",
+			templates: map[string]string{
+				"base.twig":      `base[{% block content %}{% endblock %}|{% block foot %}{% endblock %}]`,
+				"base_ajax.twig": `ajax[{% block content %}{% endblock %}]`,
+				"test.twig":      `{% extends ajax ? 'base_ajax.twig' : 'base.twig' %}{% block content %}test{% endblock %}`,
+				"page.twig":      `{% extends 'test.twig' %}{% block foot %}page{% endblock %}`,
+			},
+			tpl:  "page.twig",
+			ctx:  `map[string]stick.Value{"ajax": false}`,
+			call: `err := TemplatePageTwig(env, output, ctx)`,
+			want: "base[test|page]",
+		},
+		{
+			name: "array",
+			templates: map[string]string{
+				"base.twig": templates["base.twig"],
+				"test.twig": `{% extends ['custom.twig', 'base.twig'] %}{% block content %}c{% endblock %}`,
+			},
+			want: "base[c]",
+		},
+		{
+			name: "array first exists",
+			templates: map[string]string{
+				"base.twig":   templates["base.twig"],
+				"custom.twig": `custom[{% block content %}{% endblock %}]`,
+				"test.twig":   `{% extends ['custom.twig', 'base.twig'] %}{% block content %}c{% endblock %}`,
+			},
+			want: "custom[c]",
+		},
+		{
+			name:      "variable",
+			templates: map[string]string{"test.twig": `{% extends layout %}`},
+			err:       "Unable to evaluate extends reference",
+		},
+		{
+			name:      "conditional variable",
+			templates: map[string]string{"test.twig": `{% extends ajax ? layout : 'base.twig' %}`},
+			err:       "Unable to evaluate extends reference",
+		},
+	})
+}
+
+func TestBlockFuncTemplate(t *testing.T) {
+	common := `{% block footer %}Footer {{ year }}{% endblock %}{% block header %}Header{% endblock %}`
+	testRender(t, []renderTest{
+		{
+			name: "block",
+			templates: map[string]string{
+				"common.twig": common,
+				"test.twig":   `{{ block('footer', 'common.twig') }}`,
+			},
+			ctx:  `map[string]stick.Value{"year": 2020}`,
+			want: "Footer 2020",
+		},
+		{
+			name: "named",
+			templates: map[string]string{
+				"common.twig": common,
+				"test.twig":   `{{ block(template='common.twig', name='header') }}`,
+			},
+			want: "Header",
+		},
+		{
+			name: "own block of same name",
+			templates: map[string]string{
+				"common.twig": common,
+				"test.twig":   `{% block header %}Own{% endblock %}|{{ block('header', 'common.twig') }}|{{ block('header') }}`,
+			},
+			want: "Own|Header|Own",
+		},
+		{
+			name: "inherited",
+			templates: map[string]string{
+				"common.twig": common,
+				"page.twig":   `{% extends 'common.twig' %}{% block header %}Page {{ parent() }}{% endblock %}`,
+				"test.twig":   `{{ block('header', 'page.twig') }}|{{ block('footer', 'page.twig') }}`,
+			},
+			ctx:  `map[string]stick.Value{"year": 2020}`,
+			want: "Page Header|Footer 2020",
+		},
+		{
+			name: "local variables",
+			templates: map[string]string{
+				"common.twig": common,
+				"test.twig":   `{% for year in [1, 2] %}{{ block('footer', 'common.twig') }};{% endfor %}`,
+			},
+			want: "Footer 1;Footer 2;",
+		},
+		{
+			name: "unknown block",
+			templates: map[string]string{
+				"common.twig": common,
+				"test.twig":   `{{ block('nope', 'common.twig') }}`,
+			},
+			runErr: "block nope does not exist",
+		},
+		{
+			name:      "missing template",
+			templates: map[string]string{"test.twig": `{{ block('footer', 'common.twig') }}`},
+			err:       "common.twig",
+		},
+		{
+			name:      "dynamic template",
+			templates: map[string]string{"test.twig": `{{ block('footer', tpl) }}`},
+			err:       "Unable to evaluate block template reference",
+		},
+		{
+			name: "conditional extends",
+			templates: map[string]string{
+				"common.twig": common,
+				"page.twig":   `{% extends x ? 'common.twig' : 'common.twig' %}`,
+				"test.twig":   `{{ block('footer', 'page.twig') }}`,
+			},
+			err: "unable to render blocks of page.twig, which conditionally extends another template",
+		},
+	})
+}
@@ -0,0 +1,201 @@
+package stickgen
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/tyler-sommer/stick"
+)
+
+// A BuildCache records the templates that each generated file was generated
+// from, including any templates they depend on, along with the hashes of
+// their sources. It is used to skip regenerating files whose sources have
+// not changed. A BuildCache is safe for concurrent use.
+type BuildCache struct {
+	mu sync.Mutex
+	// Files contains an entry for each generated file, keyed by path.
+	Files map[string]BuildCacheEntry `json:"files"`
+	// Dirs contains the files generated by GenerateDir or GenerateGlob,
+	// keyed by the directory they were generated in.
+	Dirs map[string][]string `json:"dirs,omitempty"`
+}
+
+// A BuildCacheEntry describes how a file was generated.
+type BuildCacheEntry struct {
+	// Options is the Fingerprint of the Generator used.
+	Options string `json:"options"`
+	// Sources contains the hex-encoded SHA-256 hash of each template the
+	// file was generated from, keyed by name.
+	Sources map[string]string `json:"sources"`
+}
+
+// NewBuildCache creates an empty BuildCache.
+func NewBuildCache() *BuildCache {
+	return &BuildCache{Files: make(map[string]BuildCacheEntry), Dirs: make(map[string][]string)}
+}
+
+// LoadBuildCache reads a BuildCache saved at the given path. If there is no
+// file at the path, an empty BuildCache is returned.
+func LoadBuildCache(path string) (*BuildCache, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return NewBuildCache(), nil
+	} else if err != nil {
+		return nil, err
+	}
+	c := NewBuildCache()
+	if err := json.Unmarshal(b, c); err != nil {
+		return nil, fmt.Errorf("stickgen: invalid cache %s: %s", path, err)
+	}
+	if c.Files == nil {
+		c.Files = make(map[string]BuildCacheEntry)
+	}
+	if c.Dirs == nil {
+		c.Dirs = make(map[string][]string)
+	}
+	return c, nil
+}
+
+// Save writes the BuildCache to the given path.
+func (c *BuildCache) Save(path string) error {
+	c.mu.Lock()
+	b, err := json.MarshalIndent(c, "", "  ")
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(b, '\n'), 0644)
+}
+
+// Fresh reports whether the file at the given path exists and was generated
+// using the given options from templates that have not changed since, as
+// loaded by the given Loader.
+func (c *BuildCache) Fresh(file, options string, loader stick.Loader) bool {
+	c.mu.Lock()
+	e, ok := c.Files[file]
+	c.mu.Unlock()
+	if !ok || e.Options != options || len(e.Sources) == 0 {
+		return false
+	}
+	if _, err := os.Stat(file); err != nil {
+		return false
+	}
+	for name, hash := range e.Sources {
+		tpl, err := loader.Load(name)
+		if err != nil {
+			return false
+		}
+		body, err := ioutil.ReadAll(tpl.Contents())
+		if err != nil {
+			return false
+		}
+		sum := sha256.Sum256(body)
+		if hex.EncodeToString(sum[:]) != hash {
+			return false
+		}
+	}
+	return true
+}
+
+// Add records that the file at the given path was generated using the given
+// options. The templates it was generated from are read from the file, and
+// their sources are loaded using the given Loader.
+func (c *BuildCache) Add(file, options string, loader stick.Loader) error {
+	names, err := Sources(file)
+	if err != nil {
+		return err
+	}
+	digests := make(map[string][sha256.Size]byte)
+	for _, name := range names {
+		tpl, err := loader.Load(name)
+		if err != nil {
+			return err
+		}
+		body, err := ioutil.ReadAll(tpl.Contents())
+		if err != nil {
+			return err
+		}
+		digests[name] = sha256.Sum256(body)
+	}
+	c.add(file, options, digests)
+	return nil
+}
+
+// add records that a file was generated from sources with the given
+// digests.
+func (c *BuildCache) add(file, options string, digests map[string][sha256.Size]byte) {
+	e := BuildCacheEntry{Options: options, Sources: make(map[string]string, len(digests))}
+	for name, sum := range digests {
+		e.Sources[name] = hex.EncodeToString(sum[:])
+	}
+	c.mu.Lock()
+	c.Files[file] = e
+	c.mu.Unlock()
+}
+
+// Remove discards the record of the file at the given path, such as when
+// the file is removed.
+func (c *BuildCache) Remove(file string) {
+	c.mu.Lock()
+	delete(c.Files, file)
+	c.mu.Unlock()
+}
+
+// freshDir reports whether exactly the given files were generated in dir
+// and each of them is fresh.
+func (c *BuildCache) freshDir(dir string, files []string, options string, loader stick.Loader) bool {
+	c.mu.Lock()
+	prev := c.Dirs[dir]
+	c.mu.Unlock()
+	if len(prev) != len(files) {
+		return false
+	}
+	for i, file := range files {
+		if prev[i] != file || !c.Fresh(file, options, loader) {
+			return false
+		}
+	}
+	return true
+}
+
+// setDir records the files generated in dir.
+func (c *BuildCache) setDir(dir string, files []string) {
+	c.mu.Lock()
+	c.Dirs[dir] = files
+	c.mu.Unlock()
+}
+
+// Fingerprint returns a hash of the Generator's options, which changes
+// whenever the options change in a way that may change the generated code.
+func (g *Generator) Fingerprint() string {
+	var filters, tests, constants []string
+	for name := range g.Filters {
+		filters = append(filters, name)
+	}
+	for name := range g.Tests {
+		tests = append(tests, name)
+	}
+	for name, v := range g.Constants {
+		constants = append(constants, fmt.Sprintf("%s=%#v", name, v))
+	}
+	sort.Strings(filters)
+	sort.Strings(tests)
+	sort.Strings(constants)
+	opts := fmt.Sprintf("%#v", []interface{}{
+		Version, g.pkgName, filters, tests, constants,
+		g.DynamicIncludes, g.StringTemplates, g.RuntimeSources, g.Debug, g.Autoescape,
+		g.Registry, g.StringFuncs, g.Types, g.Header, g.BuildConstraint, g.GeneratedBy,
+		g.Command, g.Reproducible, g.StickImport, g.RuntimeImport, g.Unexported, g.Receiver,
+		fmt.Sprintf("%T", g.Naming), g.Extensions, g.StripExtensions, g.Comments, g.LineDirectives, g.NoFormat,
+		g.AllowUnformatted, g.Context, g.ErrorPolicy, g.ErrorPlaceholder, g.IgnoreErrors,
+		g.Packages, fmt.Sprintf("%T%+v", g.FileNaming, g.FileNaming),
+	})
+	sum := sha256.Sum256([]byte(opts))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,265 @@
+package stickgen_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tyler-sommer/stick"
+	"github.com/veonik/go-stickgen"
+)
+
+func TestBuildCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stickgen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	root := filepath.Join(dir, "templates")
+	templates := map[string]string{
+		"layout.twig": `[{% block content %}{% endblock %}]`,
+		"index.twig":  `{% extends 'layout.twig' %}{% block content %}index{% endblock %}`,
+	}
+	tests := []struct {
+		name string
+		// change is applied after the file is generated and cached.
+		change func(t *testing.T, file string, g *stickgen.Generator)
+		fresh  bool
+	}{
+		{
+			name:   "unchanged",
+			change: func(*testing.T, string, *stickgen.Generator) {},
+			fresh:  true,
+		},
+		{
+			name: "template changed",
+			change: func(t *testing.T, _ string, _ *stickgen.Generator) {
+				writeTree(t, root, map[string]string{"index.twig": `{% extends 'layout.twig' %}{% block content %}changed{% endblock %}`})
+			},
+		},
+		{
+			name: "parent changed",
+			change: func(t *testing.T, _ string, _ *stickgen.Generator) {
+				writeTree(t, root, map[string]string{"layout.twig": `({% block content %}{% endblock %})`})
+			},
+		},
+		{
+			name: "parent removed",
+			change: func(t *testing.T, _ string, _ *stickgen.Generator) {
+				os.Remove(filepath.Join(root, "layout.twig"))
+			},
+		},
+		{
+			name: "options changed",
+			change: func(_ *testing.T, _ string, g *stickgen.Generator) {
+				g.Autoescape = "html"
+			},
+		},
+		{
+			name: "file removed",
+			change: func(_ *testing.T, file string, _ *stickgen.Generator) {
+				os.Remove(file)
+			},
+		},
+	}
+	for _, tt := range tests {
+		writeTree(t, root, templates)
+		g := stickgen.NewGenerator("views", stick.NewFilesystemLoader(root))
+		src, err := g.Generate("index.twig")
+		if err != nil {
+			t.Fatal(err)
+		}
+		file := filepath.Join(dir, "index_twig.go")
+		if err := ioutil.WriteFile(file, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+		c := stickgen.NewBuildCache()
+		if c.Fresh(file, g.Fingerprint(), stick.NewFilesystemLoader(root)) {
+			t.Errorf("%s: expected a file not in the cache not to be fresh", tt.name)
+		}
+		if err := c.Add(file, g.Fingerprint(), stick.NewFilesystemLoader(root)); err != nil {
+			t.Fatal(err)
+		}
+		// The cache is saved and loaded again, so that the file records
+		// everything needed.
+		cacheFile := filepath.Join(dir, "cache.json")
+		if err := c.Save(cacheFile); err != nil {
+			t.Fatal(err)
+		}
+		if c, err = stickgen.LoadBuildCache(cacheFile); err != nil {
+			t.Fatal(err)
+		}
+		tt.change(t, file, g)
+		if got := c.Fresh(file, g.Fingerprint(), stick.NewFilesystemLoader(root)); got != tt.fresh {
+			t.Errorf("%s: expected fresh %v, got %v", tt.name, tt.fresh, got)
+		}
+	}
+}
+
+func TestLoadBuildCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stickgen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeTree(t, dir, map[string]string{
+		"empty.json":   `{}`,
+		"invalid.json": `{"files": [`,
+		"cache.json":   `{"files": {"views/a.go": {"options": "x", "sources": {"a.twig": "00"}}}}`,
+	})
+	tests := []struct {
+		file  string
+		files map[string]stickgen.BuildCacheEntry
+		err   string
+	}{
+		{file: "missing.json", files: map[string]stickgen.BuildCacheEntry{}},
+		{file: "empty.json", files: map[string]stickgen.BuildCacheEntry{}},
+		{
+			file:  "cache.json",
+			files: map[string]stickgen.BuildCacheEntry{"views/a.go": {Options: "x", Sources: map[string]string{"a.twig": "00"}}},
+		},
+		{file: "invalid.json", err: "stickgen: invalid cache"},
+	}
+	for _, tt := range tests {
+		c, err := stickgen.LoadBuildCache(filepath.Join(dir, tt.file))
+		checkErr(t, tt.file, "loading", err, tt.err)
+		if err != nil {
+			continue
+		}
+		if !reflect.DeepEqual(c.Files, tt.files) {
+			t.Errorf("%s: expected files %v, got %v", tt.file, tt.files, c.Files)
+		}
+		if c.Dirs == nil {
+			t.Errorf("%s: expected dirs to be initialized", tt.file)
+		}
+	}
+	if err := stickgen.NewBuildCache().Add(filepath.Join(dir, "empty.json"), "", nil); err == nil {
+		t.Errorf("expected an error adding a file that was not generated")
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	fingerprint := func(options func(g *stickgen.Generator)) string {
+		g := stickgen.NewGenerator("views", nil)
+		if options != nil {
+			options(g)
+		}
+		return g.Fingerprint()
+	}
+	def := fingerprint(nil)
+	if fingerprint(nil) != def {
+		t.Fatalf("expected the fingerprint to be deterministic")
+	}
+	// Options that do not change the generated code do not change the
+	// fingerprint.
+	if fingerprint(func(g *stickgen.Generator) { g.Jobs = 8 }) != def {
+		t.Errorf("expected Jobs not to change the fingerprint")
+	}
+	tests := []struct {
+		name    string
+		options func(g *stickgen.Generator)
+	}{
+		{"autoescape", func(g *stickgen.Generator) { g.Autoescape = "html" }},
+		{"context", func(g *stickgen.Generator) { g.Context = true }},
+		{"header", func(g *stickgen.Generator) { g.Header = "Copyright." }},
+		{"command", func(g *stickgen.Generator) { g.Command = "stickgen -out views" }},
+		{"error policy", func(g *stickgen.Generator) { g.ErrorPolicy = stickgen.ErrorsPanic }},
+		{"comments", func(g *stickgen.Generator) { g.Comments = stickgen.CommentsOff }},
+		{"naming", func(g *stickgen.Generator) { g.Naming = prefixNaming{} }},
+		{"filters", func(g *stickgen.Generator) { g.Filters = map[string]stickgen.Filter{"upper": {}} }},
+		{"constants", func(g *stickgen.Generator) { g.Constants = map[string]stick.Value{"debug": true} }},
+	}
+	seen := map[string]string{def: "default"}
+	for _, tt := range tests {
+		fp := fingerprint(tt.options)
+		if prev, ok := seen[fp]; ok {
+			t.Errorf("%s: expected a fingerprint different from %s", tt.name, prev)
+		}
+		seen[fp] = tt.name
+	}
+}
+
+func TestGenerateGlobCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stickgen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	root := filepath.Join(dir, "templates")
+	out := filepath.Join(dir, "views")
+	writeTree(t, root, map[string]string{
+		"_nav.twig":  `<nav>`,
+		"index.twig": `{% include '_nav.twig' %}index`,
+		"about.twig": `about`,
+	})
+	c := stickgen.NewBuildCache()
+	tests := []struct {
+		name    string
+		change  func(t *testing.T, g *stickgen.Generator)
+		written []string
+	}{
+		{
+			name:    "first run",
+			written: []string{"about_twig.go", "index_twig.go"},
+		},
+		{
+			name:    "unchanged",
+			written: []string{},
+		},
+		{
+			name: "included template changed",
+			change: func(t *testing.T, _ *stickgen.Generator) {
+				writeTree(t, root, map[string]string{"_nav.twig": `<nav class="main">`})
+			},
+			written: []string{"about_twig.go", "index_twig.go"},
+		},
+		{
+			name: "options changed",
+			change: func(_ *testing.T, g *stickgen.Generator) {
+				g.Comments = stickgen.CommentsOff
+			},
+			written: []string{"about_twig.go", "index_twig.go"},
+		},
+		{
+			name: "generated file removed",
+			change: func(*testing.T, *stickgen.Generator) {
+				os.Remove(filepath.Join(out, "about_twig.go"))
+			},
+			written: []string{"about_twig.go", "index_twig.go"},
+		},
+		{
+			name: "template added",
+			change: func(t *testing.T, _ *stickgen.Generator) {
+				writeTree(t, root, map[string]string{"contact.twig": `contact`})
+			},
+			written: []string{"about_twig.go", "contact_twig.go", "index_twig.go"},
+		},
+	}
+	comments := stickgen.CommentsFull
+	for _, tt := range tests {
+		g := stickgen.NewGenerator("views", stick.NewFilesystemLoader(root))
+		g.BuildCache = c
+		g.Comments = comments
+		if tt.change != nil {
+			tt.change(t, g)
+		}
+		comments = g.Comments
+		written, err := g.GenerateGlob(root, out, nil, []string{"_*.twig"})
+		if err != nil {
+			t.Fatalf("%s: %s", tt.name, err)
+		}
+		if got := relPaths(t, out, written); !reflect.DeepEqual(got, tt.written) {
+			t.Errorf("%s: expected files %v written, got %v", tt.name, tt.written, got)
+		}
+	}
+	b, err := ioutil.ReadFile(filepath.Join(out, "index_twig.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `<nav class=\"main\">index`; !strings.Contains(string(b), want) {
+		t.Errorf("expected index_twig.go containing %s, got:\n%s", want, b)
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// drift is true if, in check mode, any generated file is out of date.
+var drift bool
+
+// writeFile writes a generated file, creating its directory if needed. In
+// check mode, the file is left untouched and any difference from the
+// generated code is printed as a unified diff instead. If file is "-", the
+// code is written to standard output.
+func writeFile(file, src string) error {
+	if file == "-" {
+		_, err := io.WriteString(os.Stdout, src)
+		return err
+	}
+	if !*check {
+		dir := filepath.Dir(file)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("output path is not a directory: %s", dir)
+		}
+		if err := ioutil.WriteFile(file, []byte(src), 0644); err != nil {
+			return fmt.Errorf("unable to write output: %s", err)
+		}
+		return nil
+	}
+	old, err := ioutil.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if string(old) == src {
+		return nil
+	}
+	drift = true
+	fmt.Print(unifiedDiff(string(old), src, file, file))
+	return nil
+}
+
+// removeFile removes a generated file that is no longer needed. In check
+// mode, the file is left untouched and its removal is printed as a unified
+// diff instead.
+func removeFile(file string) error {
+	if !*check {
+		return os.Remove(file)
+	}
+	old, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	drift = true
+	fmt.Print(unifiedDiff(string(old), "", file, "/dev/null"))
+	return nil
+}
+
+// diffContext is the number of unchanged lines shown around each change.
+const diffContext = 3
+
+// A diffLine is a line of a diff, prefixed by ' ', '-' or '+'.
+type diffLine struct {
+	op   byte
+	text string
+}
+
+// unifiedDiff returns the differences between the lines of a and b as a
+// unified diff, or an empty string if they are the same.
+func unifiedDiff(a, b, fromFile, toFile string) string {
+	lines := diffLines(splitLines(a), splitLines(b))
+	var buf strings.Builder
+	for i := 0; i < len(lines); {
+		if lines[i].op == ' ' {
+			i++
+			continue
+		}
+		// A hunk extends until a change is followed by more unchanged lines
+		// than the context on both sides of a gap.
+		start := i - diffContext
+		if start < 0 {
+			start = 0
+		}
+		end, same := i, 0
+		for ; end < len(lines) && same <= 2*diffContext; end++ {
+			if lines[end].op == ' ' {
+				same++
+			} else {
+				same = 0
+			}
+		}
+		end -= same
+		if end += diffContext; end > len(lines) {
+			end = len(lines)
+		}
+		if buf.Len() == 0 {
+			fmt.Fprintf(&buf, "--- %s\n+++ %s\n", fromFile, toFile)
+		}
+		writeHunk(&buf, lines, start, end)
+		i = end
+	}
+	return buf.String()
+}
+
+// writeHunk writes the lines of a diff from start to end as a hunk.
+func writeHunk(buf *strings.Builder, lines []diffLine, start, end int) {
+	// Line numbers begin after the lines of each file preceding the hunk.
+	aStart, bStart := 1, 1
+	for _, l := range lines[:start] {
+		if l.op != '+' {
+			aStart++
+		}
+		if l.op != '-' {
+			bStart++
+		}
+	}
+	aLen, bLen := 0, 0
+	for _, l := range lines[start:end] {
+		if l.op != '+' {
+			aLen++
+		}
+		if l.op != '-' {
+			bLen++
+		}
+	}
+	// An empty range is numbered after the line that precedes it.
+	if aLen == 0 {
+		aStart--
+	}
+	if bLen == 0 {
+		bStart--
+	}
+	fmt.Fprintf(buf, "@@ -%d,%d +%d,%d @@\n", aStart, aLen, bStart, bLen)
+	for _, l := range lines[start:end] {
+		buf.WriteByte(l.op)
+		buf.WriteString(l.text)
+		if !strings.HasSuffix(l.text, "\n") {
+			buf.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
+
+// diffLines returns the lines of a diff turning a into b, using the longest
+// common subsequence of the lines that differ between them.
+func diffLines(a, b []string) []diffLine {
+	var res []diffLine
+	// Lines common to the start and end of both are trimmed first, since
+	// generated files usually change in few places.
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		res = append(res, diffLine{' ', a[pre]})
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+	x, y := a[pre:len(a)-suf], b[pre:len(b)-suf]
+	// lcs[i][j] is the length of the longest common subsequence of x[i:]
+	// and y[j:].
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			res = append(res, diffLine{' ', x[i]})
+			i++
+			j++
+		case j == len(y) || (i < len(x) && lcs[i+1][j] >= lcs[i][j+1]):
+			res = append(res, diffLine{'-', x[i]})
+			i++
+		default:
+			res = append(res, diffLine{'+', y[j]})
+			j++
+		}
+	}
+	for _, l := range a[len(a)-suf:] {
+		res = append(res, diffLine{' ', l})
+	}
+	return res
+}
+
+// splitLines splits s into lines, each including its trailing newline.
+func splitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want string
+	}{
+		{"same", "a\nb\n", "a\nb\n", ""},
+		{"changed", "a\nb\nc\n", "a\nx\nc\n", "--- old\n+++ new\n@@ -1,3 +1,3 @@\n a\n-b\n+x\n c\n"},
+		{"added", "", "a\n", "--- old\n+++ new\n@@ -0,0 +1,1 @@\n+a\n"},
+		{"removed", "a\n", "", "--- old\n+++ new\n@@ -1,1 +0,0 @@\n-a\n"},
+		{"no newline", "a", "b\n", "--- old\n+++ new\n@@ -1,1 +1,1 @@\n-a\n\\ No newline at end of file\n+b\n"},
+		{
+			"hunks",
+			"1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n",
+			"x\n2\n3\n4\n5\n6\n7\n8\n9\ny\n",
+			"--- old\n+++ new\n@@ -1,4 +1,4 @@\n-1\n+x\n 2\n 3\n 4\n@@ -7,4 +7,4 @@\n 7\n 8\n 9\n-10\n+y\n",
+		},
+	}
+	for _, tt := range tests {
+		if got := unifiedDiff(tt.a, tt.b, "old", "new"); got != tt.want {
+			t.Errorf("%s: expected diff %q, got %q", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	files := map[string]string{
+		"templates/layout.twig": `[{% block content %}{% endblock %}]`,
+		"templates/index.twig":  `{% extends 'layout.twig' %}{% block content %}Hello{% endblock %}`,
+	}
+	index := filepath.Join("views", "index_twig.go")
+	testCLI(t, []cliTest{
+		{
+			name:    "up to date",
+			files:   files,
+			before:  [][]string{{"-path", "templates", "-out", "views"}},
+			args:    []string{"-path", "templates", "-out", "views", "-check"},
+			written: []string{"views/index_twig.go", "views/layout_twig.go"},
+			pkgs:    map[string]string{"views": "views"},
+			render:  `err = views.TemplateIndexTwig(env, w, nil)`,
+			output:  "[Hello]",
+		},
+		{
+			name:    "nothing generated",
+			files:   files,
+			args:    []string{"-path", "templates", "-out", "views", "-check"},
+			written: []string{},
+			stdout:  []string{"--- " + index + "\n+++ " + index + "\n", "+package views\n"},
+			stderr:  []string{"stickgen: generated code is out of date"},
+			fail:    true,
+		},
+		{
+			name:   "template changed",
+			files:  files,
+			before: [][]string{{"-path", "templates", "-out", "views"}},
+			edit: map[string]string{
+				"templates/index.twig": `{% extends 'layout.twig' %}{% block content %}Goodbye{% endblock %}`,
+			},
+			args:    []string{"-path", "templates", "-out", "views", "-check"},
+			written: []string{"views/index_twig.go", "views/layout_twig.go"},
+			contains: map[string][]string{
+				"views/index_twig.go": {"Hello"},
+			},
+			stdout: []string{"--- " + index + "\n+++ " + index + "\n", "-", "+", "Goodbye"},
+			stderr: []string{"stickgen: generated code is out of date"},
+			fail:   true,
+		},
+		{
+			name:    "split mode",
+			files:   files,
+			before:  [][]string{{"-path", "templates", "-out", "views", "index.twig"}},
+			edit:    map[string]string{"templates/layout.twig": `({% block content %}{% endblock %})`},
+			args:    []string{"-path", "templates", "-out", "views", "-check", "index.twig"},
+			written: []string{"views/index.twig.go"},
+			stdout:  []string{"--- " + filepath.Join("views", "index.twig.go") + "\n"},
+			stderr:  []string{"stickgen: generated code is out of date"},
+			fail:    true,
+		},
+		{
+			name:    "single template",
+			files:   files,
+			before:  [][]string{{"-path", "templates", "-template", "index.twig", "-o", "views/index.go", "-pkg", "views"}},
+			args:    []string{"-path", "templates", "-template", "index.twig", "-o", "views/index.go", "-pkg", "views", "-check"},
+			written: []string{"views/index.go"},
+		},
+		{
+			name:   "watch",
+			files:  files,
+			args:   []string{"-check", "-watch"},
+			stderr: []string{"stickgen: -check cannot be used with -watch"},
+			fail:   true,
+		},
+	})
+}
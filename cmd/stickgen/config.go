@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/tyler-sommer/stick"
+	"github.com/veonik/go-stickgen"
+)
+
+// configFiles are the config files used, in order of preference, if no
+// config file or other options are given.
+var configFiles = []string{"stickgen.json", ".stickgen.json"}
+
+// A config describes the templates to generate.
+type config struct {
+	Targets []*target `json:"targets"`
+	// Cache is the path of the file recording the templates generated, if
+	// any.
+	Cache string `json:"cache"`
+
+	buildCache *stickgen.BuildCache
+}
+
+// A target describes a set of templates generated into a package.
+type target struct {
+	// Path is the directory that templates are loaded from.
+	Path string `json:"path"`
+	// Out is the directory that code is generated into.
+	Out string `json:"out"`
+	// Package is the name of the generated package. By default, it is
+	// named after the output directory.
+	Package string `json:"package"`
+	// Include and Exclude are globs selecting the templates to generate.
+	Include []string `json:"include"`
+	Exclude []string `json:"exclude"`
+	// Packages maps directories of templates to packages of their own.
+	Packages []packageMapping `json:"packages"`
+
+	Options options `json:"options"`
+
+	// split generates each template into a package of its own, named
+	// after the directory containing the template.
+	split bool
+	// template is the name of the target's single template, if any, which
+	// is generated into file.
+	template string
+	file     string
+	// stdin contains the single template read from standard input, if
+	// any, in which case the generated code is written to standard output.
+	stdin  []byte
+	loader stick.Loader
+	// cache loads templates for a single run, so that templates changed
+	// between runs in watch mode are reloaded.
+	cache      stick.Loader
+	buildCache *stickgen.BuildCache
+}
+
+// A packageMapping maps the templates in a directory, relative to the
+// target's path, to a package generated into another output path.
+type packageMapping struct {
+	Dir     string `json:"dir"`
+	Out     string `json:"out"`
+	Package string `json:"package"`
+}
+
+// options contains the Generator options that may be set in a config file.
+type options struct {
+	Autoescape       string   `json:"autoescape"`
+	Unexported       bool     `json:"unexported"`
+	Receiver         string   `json:"receiver"`
+	Context          bool     `json:"context"`
+	ErrorPolicy      string   `json:"error_policy"`
+	ErrorPlaceholder string   `json:"error_placeholder"`
+	IgnoreErrors     bool     `json:"ignore_errors"`
+	Registry         bool     `json:"registry"`
+	StringFuncs      bool     `json:"string_funcs"`
+	Types            bool     `json:"types"`
+	DynamicIncludes  bool     `json:"dynamic_includes"`
+	StringTemplates  bool     `json:"string_templates"`
+	RuntimeSources   bool     `json:"runtime_sources"`
+	Debug            bool     `json:"debug"`
+	Comments         string   `json:"comments"`
+	LineDirectives   bool     `json:"line_directives"`
+	Header           string   `json:"header"`
+	BuildConstraint  string   `json:"build_constraint"`
+	StickImport      string   `json:"stick_import"`
+	RuntimeImport    string   `json:"runtime_import"`
+	FilePattern      string   `json:"file_pattern"`
+	Flatten          bool     `json:"flatten"`
+	Lowercase        bool     `json:"lowercase"`
+	Extensions       []string `json:"extensions"`
+	StripExtensions  bool     `json:"strip_extensions"`
+}
+
+var errorPolicies = map[string]stickgen.ErrorPolicy{
+	"":            stickgen.ErrorsReturn,
+	"return":      stickgen.ErrorsReturn,
+	"panic":       stickgen.ErrorsPanic,
+	"placeholder": stickgen.ErrorsPlaceholder,
+	"handler":     stickgen.ErrorsHandler,
+}
+
+var commentLevels = map[string]stickgen.CommentLevel{
+	"":        stickgen.CommentsFull,
+	"full":    stickgen.CommentsFull,
+	"minimal": stickgen.CommentsMinimal,
+	"off":     stickgen.CommentsOff,
+}
+
+// findConfig returns the first of configFiles that exists in the current
+// directory, or an empty string if there is none.
+func findConfig() string {
+	for _, file := range configFiles {
+		if _, err := os.Stat(file); err == nil {
+			return file
+		}
+	}
+	return ""
+}
+
+// loadConfig reads the targets described by a JSON config file.
+// Paths in the file are relative to the directory containing it.
+func loadConfig(file string) (*config, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if ext := filepath.Ext(file); ext != ".json" {
+		return nil, fmt.Errorf("unsupported config file format: %s", ext)
+	}
+	var c config
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.DisallowUnknownFields()
+	if err := d.Decode(&c); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %s", file, err)
+	}
+	if len(c.Targets) == 0 {
+		return nil, fmt.Errorf("invalid config file %s: no targets", file)
+	}
+	dir := filepath.Dir(file)
+	for _, t := range c.Targets {
+		if t.Path == "" {
+			t.Path = "."
+		}
+		if t.Out == "" {
+			return nil, fmt.Errorf("invalid config file %s: target for %s has no output path", file, t.Path)
+		}
+		t.Path = filepath.Join(dir, t.Path)
+		t.Out = filepath.Join(dir, t.Out)
+		for i, p := range t.Packages {
+			if p.Dir == "" || p.Out == "" {
+				return nil, fmt.Errorf("invalid config file %s: package for %s has no directory or output path", file, t.Path)
+			}
+			t.Packages[i].Out = filepath.Join(dir, p.Out)
+		}
+	}
+	if c.Cache != "" {
+		c.Cache = filepath.Join(dir, c.Cache)
+	}
+	return &c, nil
+}
+
+// validate reports any invalid options of the target.
+func (t *target) validate() error {
+	if t.Package != "" && !token.IsIdentifier(t.Package) {
+		return fmt.Errorf("invalid package name: %s", t.Package)
+	}
+	for _, p := range t.Packages {
+		if p.Package != "" && !token.IsIdentifier(p.Package) {
+			return fmt.Errorf("invalid package name: %s", p.Package)
+		}
+	}
+	if _, ok := errorPolicies[t.Options.ErrorPolicy]; !ok {
+		return fmt.Errorf("invalid error policy: %s", t.Options.ErrorPolicy)
+	}
+	if _, ok := commentLevels[t.Options.Comments]; !ok {
+		return fmt.Errorf("invalid comment level: %s", t.Options.Comments)
+	}
+	return nil
+}
+
+// generator returns a Generator for the target that generates the named
+// package.
+func (t *target) generator(pkgName string) *stickgen.Generator {
+	g := stickgen.NewGenerator(pkgName, t.cache)
+	g.Command = command()
+	g.Reproducible = *trimpath
+	g.Jobs = *jobs
+	g.BuildCache = t.buildCache
+	g.Logger = logger
+	for _, p := range t.Packages {
+		g.Packages = append(g.Packages, stickgen.Package{Dir: p.Dir, Out: p.Out, Name: p.Package})
+	}
+	o := t.Options
+	g.Autoescape = o.Autoescape
+	g.Unexported = o.Unexported
+	g.Receiver = o.Receiver
+	g.Context = o.Context
+	g.ErrorPolicy = errorPolicies[o.ErrorPolicy]
+	g.ErrorPlaceholder = o.ErrorPlaceholder
+	g.IgnoreErrors = o.IgnoreErrors
+	g.Registry = o.Registry
+	g.StringFuncs = o.StringFuncs
+	g.Types = o.Types
+	g.DynamicIncludes = o.DynamicIncludes
+	g.StringTemplates = o.StringTemplates
+	g.RuntimeSources = o.RuntimeSources
+	g.Debug = o.Debug
+	g.Comments = commentLevels[o.Comments]
+	g.LineDirectives = o.LineDirectives
+	g.Header = strings.TrimRight(o.Header, "\n")
+	g.BuildConstraint = o.BuildConstraint
+	g.StickImport = o.StickImport
+	g.RuntimeImport = o.RuntimeImport
+	g.Extensions = o.Extensions
+	g.StripExtensions = o.StripExtensions
+	if n := t.fileNaming(); n != nil {
+		g.FileNaming = n
+	}
+	return g
+}
+
+// findTemplates returns the names of the templates in the target's input
+// path matching its include and exclude globs, or with one of its
+// extensions if it has no include globs.
+func (t *target) findTemplates() ([]string, error) {
+	return t.generator(t.Package).FindTemplates(t.Path, t.Include, t.Exclude)
+}
+
+// fileNaming returns the FileNaming described by the target's options, or
+// nil if the default naming is used.
+func (t *target) fileNaming() stickgen.FileNaming {
+	o := t.Options
+	if o.FilePattern == "" && !o.Flatten && !o.Lowercase {
+		return nil
+	}
+	p := stickgen.FilePattern{Pattern: o.FilePattern, Flatten: o.Flatten, Lowercase: o.Lowercase}
+	if p.Pattern == "" {
+		p.Pattern = "{{name}}.go"
+	}
+	return p
+}
+
+// splitFile returns the path of the file that the named template is
+// generated into when each template is generated into a package named
+// after its directory. Unless the target's options name files, the file is
+// named after the template with the ".go" extension added.
+func (t *target) splitFile(name string) string {
+	n := t.fileNaming()
+	if n == nil {
+		return filepath.Join(t.Out, filepath.FromSlash(name)) + ".go"
+	}
+	dir, base := "", name
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		dir, base = name[:i], name[i+1:]
+	}
+	return filepath.Join(t.Out, filepath.FromSlash(dir), n.File(base))
+}
+
+// pkgName returns the name of the package generated into the given
+// directory.
+func (t *target) pkgName(dir string) string {
+	if t.Package != "" {
+		return t.Package
+	}
+	return filepath.Base(dir)
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/veonik/go-stickgen"
+)
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		contents string
+		want     *config
+		err      string
+	}{
+		{
+			name: "json",
+			file: "stickgen.json",
+			contents: `{
+	"cache": ".cache.json",
+	"targets": [
+		{
+			"path": "templates",
+			"out": "views",
+			"include": ["*.twig"],
+			"exclude": ["_*.twig"],
+			"options": {"autoescape": "html", "context": true, "comments": "minimal"}
+		},
+		{
+			"out": "admin/views",
+			"package": "admin",
+			"packages": [{"dir": "users", "out": "admin/views/users", "package": "users"}]
+		}
+	]
+}`,
+			want: &config{
+				Cache: "conf/.cache.json",
+				Targets: []*target{
+					{
+						Path:    "conf/templates",
+						Out:     "conf/views",
+						Include: []string{"*.twig"},
+						Exclude: []string{"_*.twig"},
+						Options: options{Autoescape: "html", Context: true, Comments: "minimal"},
+					},
+					{
+						Path:    "conf",
+						Out:     "conf/admin/views",
+						Package: "admin",
+						Packages: []packageMapping{
+							{Dir: "users", Out: "conf/admin/views/users", Package: "users"},
+						},
+					},
+				},
+			},
+		},
+		{
+			name:     "hidden",
+			file:     ".stickgen.json",
+			contents: `{"targets": [{"out": "views", "options": {"error_policy": "placeholder", "error_placeholder": "?"}}]}`,
+			want: &config{
+				Targets: []*target{{
+					Path:    "conf",
+					Out:     "conf/views",
+					Options: options{ErrorPolicy: "placeholder", ErrorPlaceholder: "?"},
+				}},
+			},
+		},
+		{
+			name:     "unknown key",
+			file:     "stickgen.json",
+			contents: `{"targets": [{"out": "views", "output": "views"}]}`,
+			err:      `invalid config file conf/stickgen.json: json: unknown field "output"`,
+		},
+		{
+			name:     "invalid json",
+			file:     "stickgen.json",
+			contents: `{"targets": [`,
+			err:      "invalid config file conf/stickgen.json:",
+		},
+		{
+			name:     "no targets",
+			file:     "stickgen.json",
+			contents: `{"cache": "cache.json"}`,
+			err:      "invalid config file conf/stickgen.json: no targets",
+		},
+		{
+			name:     "no output path",
+			file:     "stickgen.json",
+			contents: `{"targets": [{"path": "templates"}]}`,
+			err:      "invalid config file conf/stickgen.json: target for templates has no output path",
+		},
+		{
+			name:     "package without output path",
+			file:     "stickgen.json",
+			contents: `{"targets": [{"path": "templates", "out": "views", "packages": [{"dir": "admin"}]}]}`,
+			err:      "invalid config file conf/stickgen.json: package for conf/templates has no directory or output path",
+		},
+		{
+			name:     "unsupported format",
+			file:     "stickgen.yaml",
+			contents: "targets: []",
+			err:      "unsupported config file format: .yaml",
+		},
+	}
+	dir, err := ioutil.TempDir("", "stickgen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	for _, tt := range tests {
+		if err := os.RemoveAll("conf"); err != nil {
+			t.Fatal(err)
+		}
+		writeTree(t, "conf", map[string]string{tt.file: tt.contents})
+		c, err := loadConfig(filepath.Join("conf", tt.file))
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: expected error containing %q, got %v", tt.name, tt.err, err)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(c, tt.want) {
+			t.Errorf("%s: expected %+v, got %+v", tt.name, tt.want, c)
+			for i := 0; i < len(c.Targets) && i < len(tt.want.Targets); i++ {
+				t.Logf("%s: target %d: expected %+v, got %+v", tt.name, i, tt.want.Targets[i], c.Targets[i])
+			}
+		}
+	}
+	if _, err := loadConfig("missing.json"); err == nil || !os.IsNotExist(err) {
+		t.Errorf("expected a missing config file to not exist, got %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		target target
+		err    string
+	}{
+		{"defaults", target{}, ""},
+		{"options", target{Package: "views", Options: options{ErrorPolicy: "handler", Comments: "off"}}, ""},
+		{"invalid package", target{Package: "my-views"}, "invalid package name: my-views"},
+		{"invalid mapped package", target{Packages: []packageMapping{{Dir: "admin", Out: "admin", Package: "my-admin"}}}, "invalid package name: my-admin"},
+		{"invalid error policy", target{Options: options{ErrorPolicy: "ignore"}}, "invalid error policy: ignore"},
+		{"invalid comment level", target{Options: options{Comments: "some"}}, "invalid comment level: some"},
+	}
+	for _, tt := range tests {
+		err := tt.target.validate()
+		if tt.err == "" && err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.name, err)
+		} else if tt.err != "" && (err == nil || err.Error() != tt.err) {
+			t.Errorf("%s: expected error %q, got %v", tt.name, tt.err, err)
+		}
+	}
+}
+
+func TestTargetGenerator(t *testing.T) {
+	tg := &target{
+		Options: options{
+			Autoescape:  "html",
+			Context:     true,
+			ErrorPolicy: "panic",
+			Comments:    "minimal",
+			Header:      "Copyright.\n",
+		},
+	}
+	g := tg.generator("views")
+	if g.Autoescape != "html" || !g.Context || g.ErrorPolicy != stickgen.ErrorsPanic || g.Comments != stickgen.CommentsMinimal {
+		t.Errorf("expected the target's options, got %+v", g)
+	}
+	if g.Header != "Copyright." {
+		t.Errorf("expected the header without its trailing newline, got %q", g.Header)
+	}
+}
+
+func TestSplitFile(t *testing.T) {
+	tests := []struct {
+		options options
+		want    string
+	}{
+		{options{}, filepath.Join("views", "admin", "Users.twig.go")},
+		{options{FilePattern: "{{name}}_stickgen.go"}, filepath.Join("views", "admin", "Users_stickgen.go")},
+		{options{Lowercase: true}, filepath.Join("views", "admin", "users.go")},
+	}
+	for _, tt := range tests {
+		tg := &target{Out: "views", Options: tt.options}
+		if got := tg.splitFile("admin/Users.twig"); got != tt.want {
+			t.Errorf("%+v: expected %s, got %s", tt.options, tt.want, got)
+		}
+	}
+}
+
+func TestConfig(t *testing.T) {
+	templates := map[string]string{
+		"templates/index.twig":       `{% include '_nav.twig' %}<p>{{ name }}</p>`,
+		"templates/_nav.twig":        `<nav>`,
+		"admin/templates/users.twig": `Users`,
+	}
+	config := `{
+	"targets": [
+		{
+			"path": "templates",
+			"out": "views",
+			"exclude": ["_*.twig"],
+			"options": {"autoescape": "html", "comments": "off"}
+		},
+		{"path": "admin/templates", "out": "admin/views", "package": "adminviews"}
+	]
+}`
+	withFiles := func(files map[string]string) map[string]string {
+		res := make(map[string]string)
+		for k, v := range templates {
+			res[k] = v
+		}
+		for k, v := range files {
+			res[k] = v
+		}
+		return res
+	}
+	written := []string{"admin/views/users_twig.go", "views/index_twig.go"}
+	testCLI(t, []cliTest{
+		{
+			name:    "default config file",
+			files:   withFiles(map[string]string{"stickgen.json": config}),
+			written: written,
+			contains: map[string][]string{
+				"views/index_twig.go":       {"package views\n", "//stickgen:command stickgen\n"},
+				"admin/views/users_twig.go": {"package adminviews\n"},
+			},
+			pkgs: map[string]string{"views": "views", "adminviews": "admin/views"},
+			render: `err = views.TemplateIndexTwig(env, w, map[string]stick.Value{"name": "<b>"})
+	if err == nil {
+		err = adminviews.TemplateUsersTwig(env, w, nil)
+	}`,
+			output: "<nav><p>&lt;b&gt;</p>Users",
+		},
+		{
+			name:    "hidden default config file",
+			files:   withFiles(map[string]string{".stickgen.json": `{"targets": [{"path": "admin/templates", "out": "admin/views"}]}`}),
+			written: []string{"admin/views/users_twig.go"},
+		},
+		{
+			name:    "config file in another directory",
+			files:   withFiles(map[string]string{"build/gen.json": `{"targets": [{"path": "../templates", "out": "../out"}]}`}),
+			args:    []string{"-config", "build/gen.json"},
+			written: []string{"out/index_twig.go", "out/nav_twig.go"},
+			contains: map[string][]string{
+				"out/index_twig.go": {"package out\n", "//stickgen:command stickgen -config=build/gen.json\n"},
+			},
+		},
+		{
+			name:    "options ignore default config file",
+			files:   withFiles(map[string]string{"stickgen.json": config}),
+			args:    []string{"-path", "admin/templates"},
+			written: []string{"generated/users_twig.go"},
+		},
+		{
+			name:   "config file with options",
+			files:  withFiles(map[string]string{"stickgen.json": config}),
+			args:   []string{"-config", "stickgen.json", "-pkg", "views"},
+			stderr: []string{"stickgen: -config cannot be used with other options or globs"},
+			fail:   true,
+		},
+		{
+			name:   "config file with globs",
+			files:  withFiles(map[string]string{"stickgen.json": config}),
+			args:   []string{"-config", "stickgen.json", "*.twig"},
+			stderr: []string{"stickgen: -config cannot be used with other options or globs"},
+			fail:   true,
+		},
+		{
+			name:   "missing config file",
+			files:  templates,
+			args:   []string{"-config", "missing.json"},
+			stderr: []string{"stickgen: open missing.json: no such file or directory"},
+			fail:   true,
+		},
+		{
+			name:   "invalid option",
+			files:  withFiles(map[string]string{"stickgen.json": `{"targets": [{"out": "views", "options": {"error_policy": "ignore"}}]}`}),
+			stderr: []string{"stickgen: invalid error policy: ignore"},
+			fail:   true,
+		},
+	})
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/veonik/go-stickgen"
+)
+
+// deps runs the deps command with the given arguments, printing the path
+// of each template that the named templates depend on.
+func deps(args []string) {
+	fs := flag.NewFlagSet("deps", flag.ExitOnError)
+	root := fs.String("path", ".", "Path to templates")
+	asJSON := fs.Bool("json", false, "Print an object mapping each template to the paths of its dependencies")
+	fs.Usage = func() {
+		fmt.Println("Usage: stickgen deps [-path <templates>] [-json] <template>...")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	t := &target{Path: *root}
+	g := stickgen.NewGenerator("deps", stickgen.NewCachingLoader(stickgen.NewFilesystemLoader(*root)))
+	g.Logger = logger
+	res := make(map[string][]string)
+	all := make(map[string]bool)
+	var errs []error
+	for _, name := range fs.Args() {
+		names, err := g.Dependencies(filepath.ToSlash(name))
+		if err != nil {
+			errs = append(errs, t.errors(err)...)
+			continue
+		}
+		files := make([]string, len(names))
+		for i, name := range names {
+			files[i] = filepath.Join(*root, filepath.FromSlash(name))
+			all[files[i]] = true
+		}
+		res[name] = files
+	}
+	if len(errs) > 0 {
+		reportErrors(errs)
+		os.Exit(1)
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(res); err != nil {
+			fatalf("%s", err)
+		}
+		return
+	}
+	var files []string
+	for file := range all {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		fmt.Println(file)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestDeps(t *testing.T) {
+	files := map[string]string{
+		"templates/layout.twig":   `[{% block content %}{% endblock %}]`,
+		"templates/header.twig":   `Header`,
+		"templates/page.twig":     `{% extends 'layout.twig' %}{% block content %}{% include 'header.twig' %}{% endblock %}`,
+		"templates/other.twig":    `Other`,
+		"templates/broken.twig":   `{{ x`,
+		"templates/admin/ui.twig": `{% include 'header.twig' %}`,
+	}
+	path := func(name string) string {
+		return filepath.Join("templates", filepath.FromSlash(name))
+	}
+	testCLI(t, []cliTest{
+		{
+			name:   "single template",
+			files:  files,
+			args:   []string{"deps", "-path", "templates", "page.twig"},
+			stdout: []string{path("header.twig") + "\n" + path("layout.twig") + "\n" + path("page.twig") + "\n"},
+		},
+		{
+			name:   "several templates",
+			files:  files,
+			args:   []string{"deps", "-path", "templates", "other.twig", "admin/ui.twig"},
+			stdout: []string{path("admin/ui.twig") + "\n" + path("header.twig") + "\n" + path("other.twig") + "\n"},
+		},
+		{
+			name:  "json",
+			files: files,
+			args:  []string{"deps", "-path", "templates", "-json", "page.twig", "other.twig"},
+			stdout: []string{
+				"{\n  \"other.twig\": [\n    \"templates",
+				"\"page.twig\": [",
+				"layout.twig\"",
+			},
+		},
+		{
+			name:   "error",
+			files:  files,
+			args:   []string{"deps", "-path", "templates", "page.twig", "broken.twig"},
+			stderr: []string{"stickgen: " + path("broken.twig") + ":1:"},
+			fail:   true,
+		},
+		{
+			name:   "no templates",
+			files:  files,
+			args:   []string{"deps", "-path", "templates"},
+			stdout: []string{"Usage: stickgen deps"},
+			fail:   true,
+		},
+	})
+}
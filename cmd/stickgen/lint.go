@@ -0,0 +1,52 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/veonik/go-stickgen"
+)
+
+// lint runs the lint command with the given arguments, reporting every
+// construct in the templates found that stickgen cannot generate.
+func lint(args []string) {
+	fs := flag.NewFlagSet("lint", flag.ExitOnError)
+	root := fs.String("path", ".", "Path to templates")
+	var exclude, exts stringsFlag
+	fs.Var(&exclude, "exclude", "Glob of templates not to check (may be repeated)")
+	fs.Var(&exts, "ext", "Extension of templates found when no globs are given (may be repeated, default .twig)")
+	fs.Usage = func() {
+		fmt.Println("Usage: stickgen lint [-path <templates>] [-exclude <glob>]... [<glob>...]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	t := &target{Path: *root, Include: fs.Args(), Exclude: exclude}
+	g := stickgen.NewGenerator("lint", stickgen.NewCachingLoader(stickgen.NewFilesystemLoader(t.Path)))
+	g.Logger = logger
+	g.Extensions = exts
+	names, err := g.FindTemplates(t.Path, t.Include, t.Exclude)
+	if err != nil {
+		fatalf("unable to glob inputs: %s", err)
+	}
+	// An empty stickgen.Errors is not a nil error, so it is only converted
+	// if there are problems.
+	var errs []error
+	if problems := g.Lint(names...); len(problems) > 0 {
+		errs = t.errors(problems)
+	}
+	printErrors(errs)
+	if len(errs) > 0 {
+		fatalf("%s found in %s", count(len(errs), "problem"), count(len(names), "template"))
+	}
+	fmt.Fprintf(os.Stderr, "stickgen: no problems found in %s\n", count(len(names), "template"))
+}
+
+// count returns n followed by the given noun, which is made plural unless n
+// is 1.
+func count(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
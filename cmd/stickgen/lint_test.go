@@ -0,0 +1,35 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	files := map[string]string{
+		"templates/index.twig": `Hello, {{ name }}`,
+		"templates/page.twig":  "{{ constant('FOO') }}\n{% include name %}",
+	}
+	page := filepath.Join("templates", "page.twig")
+	testCLI(t, []cliTest{
+		{
+			name:   "problems",
+			files:  files,
+			args:   []string{"lint", "-path", "templates"},
+			stderr: []string{"stickgen: " + page + ":1:", "undefined constant FOO", "stickgen: " + page + ":2:", "stickgen: 2 problems found in 2 templates"},
+			fail:   true,
+		},
+		{
+			name:   "no problems",
+			files:  files,
+			args:   []string{"lint", "-path", "templates", "-exclude", "page.twig"},
+			stderr: []string{"stickgen: no problems found in 1 template"},
+		},
+		{
+			name:   "globs",
+			files:  files,
+			args:   []string{"lint", "-path", "templates", "index.twig"},
+			stderr: []string{"stickgen: no problems found in 1 template"},
+		},
+	})
+}
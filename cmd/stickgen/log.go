@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// logger records the progress of stickgen, along with warnings and
+// constructs skipped while generating code. It is replaced by main
+// according to the verbosity flags.
+var logger = newLogger(os.Stderr, 0)
+
+// Levels of the messages logged, in order of increasing importance.
+const (
+	levelDebug = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var levelNames = []string{"DEBUG", "INFO", "WARN", "ERROR"}
+
+// A levelLogger is a stickgen.Logger that writes each message at or above
+// its level as a line of key=value pairs.
+type levelLogger struct {
+	out   *log.Logger
+	level int
+}
+
+// newLogger returns a logger writing to w. By default, only warnings are
+// logged. A verbosity of 1 adds progress, such as the files generated, and
+// 2 adds details, such as files that are up to date and each template
+// generated.
+func newLogger(w io.Writer, verbosity int) *levelLogger {
+	level := levelWarn
+	switch {
+	case verbosity >= 2:
+		level = levelDebug
+	case verbosity == 1:
+		level = levelInfo
+	}
+	return &levelLogger{out: log.New(w, "", 0), level: level}
+}
+
+func (l *levelLogger) Debug(msg string, args ...interface{}) { l.log(levelDebug, msg, args) }
+func (l *levelLogger) Info(msg string, args ...interface{})  { l.log(levelInfo, msg, args) }
+func (l *levelLogger) Warn(msg string, args ...interface{})  { l.log(levelWarn, msg, args) }
+func (l *levelLogger) Error(msg string, args ...interface{}) { l.log(levelError, msg, args) }
+
+// log writes the message and its alternating keys and values, if the level
+// is logged. A value without a key is given the key "!BADKEY".
+func (l *levelLogger) log(level int, msg string, args []interface{}) {
+	if level < l.level {
+		return
+	}
+	b := &bytes.Buffer{}
+	fmt.Fprintf(b, "level=%s msg=%s", levelNames[level], logValue(msg))
+	for i := 0; i < len(args); i += 2 {
+		if i+1 == len(args) {
+			fmt.Fprintf(b, " !BADKEY=%s", logValue(fmt.Sprint(args[i])))
+			break
+		}
+		fmt.Fprintf(b, " %s=%s", fmt.Sprint(args[i]), logValue(fmt.Sprint(args[i+1])))
+	}
+	l.out.Println(b.String())
+}
+
+// logValue returns s, quoted if it is empty or contains spaces, quotes,
+// equals signs or unprintable characters.
+func logValue(s string) string {
+	if s == "" || strings.IndexFunc(s, func(r rune) bool {
+		return unicode.IsSpace(r) || r == '"' || r == '=' || !unicode.IsPrint(r)
+	}) >= 0 {
+		return strconv.Quote(s)
+	}
+	return s
+}
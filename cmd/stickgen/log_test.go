@@ -0,0 +1,27 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestNewLogger(t *testing.T) {
+	tests := []struct {
+		verbosity int
+		expected  string
+	}{
+		{0, "level=WARN msg=warning\n"},
+		{1, "level=INFO msg=progress file=a.go\nlevel=WARN msg=warning\n"},
+		{2, "level=DEBUG msg=detail\nlevel=INFO msg=progress file=a.go\nlevel=WARN msg=warning\n"},
+	}
+	for _, tt := range tests {
+		b := &bytes.Buffer{}
+		l := newLogger(b, tt.verbosity)
+		l.Debug("detail")
+		l.Info("progress", "file", "a.go")
+		l.Warn("warning")
+		if b.String() != tt.expected {
+			t.Errorf("verbosity %d: expected %q, got %q", tt.verbosity, tt.expected, b.String())
+		}
+	}
+}
@@ -6,76 +6,643 @@ Command stickgen generates Go code from a Stick/Twig template.
 	$ go get -u github.com/veonik/go-stickgen/...
 
 Stickgen takes an input path where views are stored, an output path for
-generated files, and a glob for matching templates.
+generated files, a package name, and globs for matching templates.
 
-	Usage: stickgen [-path <templates>] [-out <generated>] <glob>
+If globs are given, each template matching one of them is generated into a
+package of its own, named after the template's directory. Otherwise, every
+template in the input path is generated into a single package in the output
+path. The package is named after the output directory unless a package name
+is given.
+
+Globs are matched against template names relative to the input path, and
+may contain a "**" element to match any number of directories. Templates
+matching an exclude glob, such as partials, are not generated but may still
+be included by other templates.
+
+Instead of options, stickgen may be given a JSON config file describing one
+or more targets, each generating the templates in a path into a package. If
+no options are given, stickgen.json or .stickgen.json in the current
+directory is used, if present. Paths are relative to the directory
+containing the config file.
+
+	{
+		"cache": ".stickgen-cache.json",
+		"targets": [
+			{
+				"path": "templates",
+				"out": "views",
+				"include": ["*.twig"],
+				"options": {"autoescape": "html", "context": true, "comments": "minimal"}
+			},
+			{
+				"path": "admin/templates",
+				"out": "admin/views",
+				"package": "views",
+				"packages": [{"dir": "users", "out": "admin/views/users"}]
+			}
+		]
+	}
+
+The options of each target are autoescape, unexported, receiver, context,
+error_policy (return, panic, placeholder or handler), error_placeholder,
+ignore_errors, registry, string_funcs, types, dynamic_includes,
+string_templates, runtime_sources, debug, comments (full, minimal or off),
+line_directives, header, build_constraint, stick_import, runtime_import,
+extensions and strip_extensions, which correspond to the fields of
+stickgen.Generator.
+
+Unless globs are given, the templates found are those with the ".twig"
+extension, or with one of the extensions given by -ext or the extensions
+option, such as ".html.twig" or ".tpl". Generated functions are named after
+the whole name of the template, including its extension, unless -strip-ext
+or the strip_extensions option is given, in which case "page.html.twig" is
+rendered by TemplatePage rather than TemplatePageHtmlTwig.
+
+Generated files are named after their templates, with any characters other
+than letters and digits replaced by underscores. Instead, the file_pattern
+option names them using a pattern such as "{{name}}_stickgen.go", where
+{{name}} is the name of the template without its extension. The flatten
+option replaces slashes in the names of templates in subdirectories with
+underscores, and the lowercase option converts names to lower case. Unless
+names are flattened, templates in subdirectories must be mapped to packages
+of their own.
+
+The packages of a target map directories of its templates to packages of
+their own, each with a dir relative to the target's path, an out path and
+an optional package name. Templates shared by more than one package, such
+as layouts, are generated into each package that uses them, so generated
+packages never import one another.
+
+A single template may be generated into a given file, which is useful with
+go generate. Unless a package name is given, the package is named after
+the package already in the output file's directory:
+
+	//go:generate stickgen -template page.twig -o page_gen.go
+
+Each generated file records the templates it was generated from, the
+version of stickgen, any options that differ from their defaults and the
+command that generated it. With -trimpath, absolute paths within the
+current directory are recorded relative to it, and nothing that depends on
+when the code is generated is recorded, so that generated code is the same
+on every machine.
+
+If a cache file is given, stickgen records the templates each generated
+file depends on, along with the hashes of their sources and the options
+used, and only regenerates files whose sources or options have changed
+since.
+
+If the only glob is "-", a single template is read from standard input and
+the generated code is written to standard output. Templates it includes are
+loaded from the path to templates.
+
+	cat page.twig | stickgen -name page.twig -pkg views - > page.go
+
+With -prune, generated files in the output path that no longer correspond
+to a template, such as files generated for templates that have since been
+deleted or renamed, are removed. Only files with the header stickgen writes
+are removed, so other files in the output path are left alone.
+
+In check mode, nothing is written. Instead, a unified diff is printed for
+each generated file that is missing, out of date or would be pruned, and
+stickgen exits with a non-zero status if there are any, so that generated
+code committed to a repository can be verified to be up to date.
+
+Stickgen does not stop at the first template that cannot be generated.
+Each error is reported with the path, line and column of the template it
+occurred in, the remaining templates are generated, and stickgen exits
+with a non-zero status once done. Since templates generated into a single
+package share functions, no files are written for such a package if any of
+its templates fail.
+
+The deps command prints the path of each template that the given templates
+depend on, including the templates themselves and any templates they
+extend, include, embed or import, directly or indirectly, so that build
+rules can declare them as inputs. With -json, an object mapping each given
+template to the paths of its dependencies is printed instead.
+
+	$ stickgen deps -path templates page.twig
+	templates/layout.twig
+	templates/page.twig
+
+The lint command parses and walks the templates matching the given globs,
+or every template in the input path, without generating any code, and
+reports every construct that stickgen cannot generate along with its
+position, rather than stopping at the first in each template. This is
+useful for assessing how much of an existing set of templates can be
+generated.
+
+	$ stickgen lint -path templates
+	stickgen: templates/page.twig:3:5: undefined constant FOO
+	stickgen: 1 problem found in 12 templates
+
+Warnings are logged to standard error. With -v, stickgen also logs its
+progress, and with -vv, details such as files that are up to date, each
+template generated and constructs that are skipped, such as missing
+templates that are ignored.
+
+In watch mode, stickgen keeps running after generating the templates and
+regenerates them whenever a template, or a template one of them includes,
+extends or imports, changes.
+
+	Usage: stickgen [-config <file>] [-cache <file>] [-path <templates>] [-out <generated>] [-pkg <name>] [-exclude <glob>]... [-ext <extension>]... [-strip-ext] [-template <name> [-o <file>]] [-name <name>] [-jobs <n>] [-check | -watch] [-prune] [-trimpath] [-v | -vv] [<glob>... | -]
+	       stickgen deps [-path <templates>] [-json] <template>...
+	       stickgen lint [-path <templates>] [-exclude <glob>]... [-ext <extension>]... [<glob>...]
+	  -cache string
+	    	Cache file recording generated templates, so that only templates whose sources changed are regenerated
+	  -check
+	    	Report generated files that are out of date, with a diff, without writing them
+	  -config string
+	    	Config file (default stickgen.json or .stickgen.json, if no other options are given)
+	  -exclude value
+	    	Glob of templates not to generate (may be repeated)
+	  -ext value
+	    	Extension of templates found when no globs are given (may be repeated, default .twig)
+	  -jobs int
+	    	Number of templates to generate concurrently (default is the number of CPUs)
+	  -name string
+	    	Name of the template read from standard input (default "stdin.twig")
+	  -o string
+	    	Output file when generating a single template (default <template>_gen.go)
 	  -out string
 	    	Output path (default "./generated")
 	  -path string
 	    	Path to templates (default ".")
+	  -pkg string
+	    	Package name (default is the name of the output directory)
+	  -prune
+	    	Remove generated files that no longer correspond to a template
+	  -strip-ext
+	    	Remove template extensions from the names of generated functions
+	  -template string
+	    	Name of a single template to generate, relative to the path to templates
+	  -trimpath
+	    	Record paths relative to the current directory and omit timestamps, so that generated code is reproducible
+	  -v	Log progress, such as the files generated
+	  -vv
+	    	Log progress and details, such as files that are up to date and constructs that are skipped
+	  -watch
+	    	Watch the input path and regenerate templates when they change
 */
 package main
 
 import (
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
 
-	"github.com/tyler-sommer/stick"
 	"github.com/veonik/go-stickgen"
+	"github.com/veonik/go-stickgen/internal/parallel"
 )
 
 var path = flag.String("path", ".", "Path to templates")
 var out = flag.String("out", "./generated", "Output path")
+var pkg = flag.String("pkg", "", "Package name (default is the name of the output directory)")
+var watch = flag.Bool("watch", false, "Watch the input path and regenerate templates when they change")
+var tplName = flag.String("template", "", "Name of a single template to generate, relative to the path to templates")
+var outFile = flag.String("o", "", "Output file when generating a single template (default <template>_gen.go)")
+var jobs = flag.Int("jobs", runtime.NumCPU(), "Number of templates to generate concurrently")
+var cacheFile = flag.String("cache", "", "Cache file recording generated templates, so that only templates whose sources changed are regenerated")
+var prune = flag.Bool("prune", false, "Remove generated files that no longer correspond to a template")
+var check = flag.Bool("check", false, "Report generated files that are out of date, with a diff, without writing them")
+var stdinName = flag.String("name", "stdin.twig", "Name of the template read from standard input")
+var verbose = flag.Bool("v", false, "Log progress, such as the files generated")
+var veryVerbose = flag.Bool("vv", false, "Log progress and details, such as files that are up to date and constructs that are skipped")
+var trimpath = flag.Bool("trimpath", false, "Record paths relative to the current directory and omit timestamps, so that generated code is reproducible")
+var configFile = flag.String("config", "", "Config file (default stickgen.json or .stickgen.json, if no other options are given)")
+var stripExt = flag.Bool("strip-ext", false, "Remove template extensions from the names of generated functions")
+var exclude, exts stringsFlag
+
+func init() {
+	flag.Var(&exclude, "exclude", "Glob of templates not to generate (may be repeated)")
+	flag.Var(&exts, "ext", "Extension of templates found when no globs are given (may be repeated, default .twig)")
+}
+
+// stringsFlag is a flag that may be given more than once.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(v string) error {
+	*f = append(*f, v)
+	return nil
+}
 
 func main() {
 	flag.Usage = func() {
-		fmt.Println("Usage: stickgen [-path <templates>] [-out <generated>] <glob>")
+		fmt.Println("Usage: stickgen [-config <file>] [-cache <file>] [-path <templates>] [-out <generated>] [-pkg <name>] [-exclude <glob>]... [-ext <extension>]... [-strip-ext] [-template <name> [-o <file>]] [-name <name>] [-jobs <n>] [-check | -watch] [-prune] [-trimpath] [-v | -vv] [<glob>... | -]")
+		fmt.Println("       stickgen deps [-path <templates>] [-json] <template>...")
+		fmt.Println("       stickgen lint [-path <templates>] [-exclude <glob>]... [-ext <extension>]... [<glob>...]")
 		flag.PrintDefaults()
 	}
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "deps":
+			deps(os.Args[2:])
+			return
+		case "lint":
+			lint(os.Args[2:])
+			return
+		}
+	}
 	flag.Parse()
-	loader := stick.NewFilesystemLoader(*path)
+	logger = newLogger(os.Stderr, verbosity())
+	if *jobs < 1 {
+		fatalf("invalid number of jobs: %d", *jobs)
+	}
+	if *check && *watch {
+		fatalf("-check cannot be used with -watch")
+	}
+	c, err := loadTargets()
+	if err != nil {
+		fatalf("%s", err)
+	}
+	if *cacheFile != "" {
+		c.Cache = *cacheFile
+	}
+	if c.Cache != "" && !*check {
+		c.buildCache, err = stickgen.LoadBuildCache(c.Cache)
+		if err != nil {
+			fatalf("%s", err)
+		}
+	}
+	for _, t := range c.Targets {
+		if err := t.validate(); err != nil {
+			fatalf("%s", err)
+		}
+		t.loader = stickgen.NewFilesystemLoader(t.Path)
+		if t.stdin != nil {
+			t.loader = &stdinLoader{Loader: t.loader, name: t.template, contents: t.stdin}
+		} else {
+			t.buildCache = c.buildCache
+		}
+	}
 
-	if flag.NArg() == 0 {
-		fmt.Println("stickgen: expects one arg, glob to generate")
+	errs := generate(c, nil)
+	reportErrors(errs)
+	if *watch {
+		watchTemplates(c)
 		return
 	}
-	err := os.MkdirAll(*out, 0755)
+	if len(errs) > 0 {
+		os.Exit(1)
+	}
+	if drift {
+		fatalf("generated code is out of date")
+	}
+}
+
+// verbosity returns the level of detail logged, according to the -v and
+// -vv flags.
+func verbosity() int {
+	switch {
+	case *veryVerbose:
+		return 2
+	case *verbose:
+		return 1
+	}
+	return 0
+}
+
+// loadTargets returns the config described by the config file, if one is
+// given or found, or by the command line otherwise.
+func loadTargets() (*config, error) {
+	explicit := false
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "config", "cache", "check", "jobs", "prune", "trimpath", "watch", "v", "vv":
+		default:
+			explicit = true
+		}
+	})
+	explicit = explicit || flag.NArg() > 0
+	file := *configFile
+	if file != "" && explicit {
+		return nil, fmt.Errorf("-config cannot be used with other options or globs")
+	}
+	if file == "" && !explicit {
+		file = findConfig()
+	}
+	if file != "" {
+		return loadConfig(file)
+	}
+	t, err := flagTarget()
 	if err != nil {
-		fmt.Printf("stickgen: output path is not a directory: %s\n", *out)
+		return nil, err
 	}
-	files, err := filepath.Glob(filepath.Join(*path, flag.Arg(0)))
+	t.Options = options{Extensions: exts, StripExtensions: *stripExt}
+	return &config{Targets: []*target{t}}, nil
+}
+
+// flagTarget returns the target described by the command line.
+func flagTarget() (*target, error) {
+	if flag.NArg() == 1 && flag.Arg(0) == "-" {
+		if *check || *watch {
+			return nil, fmt.Errorf("-check and -watch cannot be used with standard input")
+		}
+		return stdinTarget(*stdinName)
+	}
+	if *tplName != "" {
+		if flag.NArg() > 0 {
+			return nil, fmt.Errorf("-template cannot be used with globs")
+		}
+		return singleTarget(*tplName, *outFile), nil
+	}
+	return &target{
+		Path:    *path,
+		Out:     *out,
+		Package: *pkg,
+		Include: flag.Args(),
+		Exclude: exclude,
+		split:   flag.NArg() > 0,
+	}, nil
+}
+
+// generate generates the templates of each target, and saves the cache if
+// there is one. If changed is not nil, only templates affected by a change
+// to one of the given files are generated. Every target is generated, even
+// if some fail, and the errors of each are returned.
+func generate(c *config, changed map[string]bool) []error {
+	if c.buildCache != nil {
+		defer func() {
+			if err := c.buildCache.Save(c.Cache); err != nil {
+				logger.Warn("unable to save cache", "file", c.Cache, "error", err)
+			}
+		}()
+	}
+	var errs []error
+	for _, t := range c.Targets {
+		var names map[string]bool
+		if changed != nil {
+			names = t.templateNames(changed)
+		}
+		t.cache = stickgen.NewCachingLoader(t.loader)
+		var err error
+		if t.template != "" {
+			err = t.generateTemplate(names)
+		} else if t.split {
+			err = t.generateSplit(names)
+		} else {
+			err = t.generatePackage(names)
+		}
+		errs = append(errs, t.errors(err)...)
+		if *prune {
+			if err := t.prune(); err != nil {
+				errs = append(errs, fmt.Errorf("unable to prune %s: %s", t.Out, err))
+			}
+		}
+	}
+	return errs
+}
+
+// errors returns each of the errors in err, which may be a stickgen.Errors.
+// Templates in errors are named by their path, so that editors can locate
+// them.
+func (t *target) errors(err error) []error {
+	switch err := err.(type) {
+	case nil:
+		return nil
+	case stickgen.Errors:
+		var errs []error
+		for _, err := range err {
+			errs = append(errs, t.errors(err)...)
+		}
+		return errs
+	case *stickgen.TemplateError:
+		if err.Name == "" || t.stdin != nil && err.Name == t.template {
+			return []error{err}
+		}
+		e := *err
+		e.Name = filepath.Join(t.Path, filepath.FromSlash(e.Name))
+		return []error{&e}
+	}
+	return []error{err}
+}
+
+// reportErrors prints each of the given errors, followed by a summary if
+// there are any.
+func reportErrors(errs []error) {
+	printErrors(errs)
+	switch n := len(errs); {
+	case n == 1:
+		fmt.Fprintln(os.Stderr, "stickgen: unable to generate code: 1 error")
+	case n > 1:
+		fmt.Fprintf(os.Stderr, "stickgen: unable to generate code: %d errors\n", n)
+	}
+}
+
+// printErrors prints each of the given errors to standard error.
+func printErrors(errs []error) {
+	for _, err := range errs {
+		fmt.Fprintf(os.Stderr, "stickgen: %s\n", strings.TrimPrefix(err.Error(), "stickgen: "))
+	}
+}
+
+// generateSplit generates each template of the target into a file of its
+// own, in a package named after the directory containing the template. If
+// changed is not nil, only templates affected by a change to one of the
+// named templates are generated. Templates that cannot be generated do not
+// prevent the rest from being written, and their errors are returned as a
+// stickgen.Errors.
+func (t *target) generateSplit(changed map[string]bool) error {
+	tpls, err := t.findTemplates()
+	if err != nil {
+		return fmt.Errorf("unable to glob inputs: %s", err)
+	}
+	var files, outfiles, outputs []string
+	for _, tpl := range tpls {
+		outfile := t.splitFile(tpl)
+		if changed != nil && !affected(changed, outfile) {
+			continue
+		}
+		files = append(files, tpl)
+		outfiles = append(outfiles, outfile)
+	}
+	outputs = make([]string, len(files))
+	errs := make([]error, len(files))
+	fresh := make([]bool, len(files))
+	parallel.For(len(files), *jobs, func(i int) {
+		g := t.generator(t.pkgName(filepath.Dir(outfiles[i])))
+		if t.fresh(g, outfiles[i]) {
+			fresh[i] = true
+			return
+		}
+		outputs[i], errs[i] = g.Generate(files[i])
+	})
+	var failed stickgen.Errors
+	for i, outfile := range outfiles {
+		if fresh[i] {
+			logger.Debug("up to date", "file", outfile)
+			continue
+		}
+		if !*check {
+			logger.Info("generating", "template", filepath.Join(t.Path, filepath.FromSlash(files[i])), "file", outfile)
+		}
+		if errs[i] != nil {
+			failed = append(failed, errs[i])
+			continue
+		}
+		if err := writeFile(outfile, outputs[i]); err != nil {
+			failed = append(failed, err)
+			continue
+		}
+		t.cached(outfile)
+	}
+	if len(failed) > 0 {
+		return failed
+	}
+	return nil
+}
+
+// generateTemplate generates the target's single template into its output
+// file. If changed is not nil, the template is only generated if it is
+// affected by a change to one of the named templates.
+func (t *target) generateTemplate(changed map[string]bool) error {
+	if changed != nil && !affected(changed, t.file) {
+		return nil
+	}
+	g := t.generator(t.Package)
+	if t.fresh(g, t.file) {
+		logger.Debug("up to date", "file", t.file)
+		return nil
+	}
+	if !*check && t.stdin == nil {
+		logger.Info("generating", "template", filepath.Join(t.Path, filepath.FromSlash(t.template)), "file", t.file)
+	}
+	output, err := g.Generate(t.template)
 	if err != nil {
-		fmt.Printf("stickgen: unable to glob inputs: %s\n", err.Error())
+		return err
+	}
+	if err := writeFile(t.file, output); err != nil {
+		return err
+	}
+	t.cached(t.file)
+	return nil
+}
+
+// fresh reports whether the given file, generated using g, is up to date
+// according to the cache.
+func (t *target) fresh(g *stickgen.Generator, file string) bool {
+	return t.buildCache != nil && t.buildCache.Fresh(file, g.Fingerprint(), t.cache)
+}
+
+// cached records the given file, which was just generated, in the cache.
+func (t *target) cached(file string) {
+	if t.buildCache == nil {
 		return
 	}
-	outfiles := make([]string, len(files))
-	for i, file := range files {
-		tpl, err := filepath.Rel(*path, file)
+	g := t.generator(t.pkgName(filepath.Dir(file)))
+	if err := t.buildCache.Add(file, g.Fingerprint(), t.cache); err != nil {
+		logger.Warn("unable to cache", "file", file, "error", err)
+	}
+}
+
+// generatePackage generates the templates of the target into a single
+// package in the output path. Since functions shared by templates are
+// generated only once per package, the whole package is regenerated if any
+// template in it is affected by a change.
+func (t *target) generatePackage(changed map[string]bool) error {
+	dir, err := filepath.Abs(t.Out)
+	if err != nil {
+		return fmt.Errorf("unable to locate output path: %s", err)
+	}
+	if changed != nil && !t.affectedPackages(changed, dir) {
+		return nil
+	}
+	g := t.generator(t.pkgName(dir))
+	if *check {
+		files, err := g.GenerateFiles(t.Path, t.Out, t.Include, t.Exclude)
 		if err != nil {
-			fmt.Printf("stickgen: unable to locate input file: %s\n", err)
+			return err
+		}
+		var paths []string
+		for file := range files {
+			paths = append(paths, file)
+		}
+		sort.Strings(paths)
+		for _, file := range paths {
+			if err := writeFile(file, files[file]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	files, err := g.GenerateGlob(t.Path, t.Out, t.Include, t.Exclude)
+	for _, file := range files {
+		logger.Info("generated", "file", file)
+	}
+	return err
+}
+
+// fatalf prints the given error message and exits with a non-zero status.
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "stickgen: "+format+"\n", args...)
+	os.Exit(1)
+}
+
+// runFlags are the flags that control how stickgen runs rather than what it
+// generates, which are omitted from the command recorded in generated files.
+var runFlags = map[string]bool{"cache": true, "check": true, "jobs": true, "prune": true, "watch": true, "v": true, "vv": true}
+
+// command returns the command line that generates the same code as stickgen
+// was run to generate, which is recorded in generated files.
+func command() string {
+	args := []string{"stickgen"}
+	flag.Visit(func(f *flag.Flag) {
+		if runFlags[f.Name] {
 			return
 		}
-		outfile := filepath.Join(*out, tpl) + ".go"
-		dirName := filepath.Dir(outfile)
-		err = os.MkdirAll(dirName, 0755)
-		if err != nil {
-			fmt.Printf("stickgen: output path is not a directory: %s\n", *out)
+		if v, ok := f.Value.(*stringsFlag); ok {
+			for _, s := range *v {
+				args = append(args, "-"+f.Name+"="+shellQuote(s))
+			}
+			return
 		}
-		fmt.Printf("Generating %s as %s\n", file, outfile)
-		outfiles[i] = outfile
-		g := stickgen.NewGenerator(filepath.Base(dirName), loader)
-		output, err := g.Generate(tpl)
-		if err != nil {
-			fmt.Printf("stickgen: unable to generate code: %s\n", err)
+		if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && b.IsBoolFlag() && f.Value.String() == "true" {
+			args = append(args, "-"+f.Name)
 			return
 		}
-		err = ioutil.WriteFile(outfile, []byte(output), 0644)
-		if err != nil {
-			fmt.Printf("stickgen: unable to write output: %s\n", err)
+		v := f.Value.String()
+		if *trimpath && pathFlags[f.Name] {
+			v = trimPath(v)
 		}
+		args = append(args, "-"+f.Name+"="+shellQuote(v))
+	})
+	for _, arg := range flag.Args() {
+		args = append(args, shellQuote(arg))
+	}
+	return strings.Join(args, " ")
+}
+
+// pathFlags are the flags whose values are paths.
+var pathFlags = map[string]bool{"config": true, "o": true, "out": true, "path": true}
+
+// trimPath returns the given path relative to the current directory, if it
+// is an absolute path within it.
+func trimPath(p string) string {
+	if !filepath.IsAbs(p) {
+		return p
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return p
+	}
+	rel, err := filepath.Rel(wd, p)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return p
 	}
+	return filepath.ToSlash(rel)
+}
 
+// shellQuote quotes s for use as an argument in a shell command, if needed.
+func shellQuote(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\n\"'\\$`*?[]{}()<>|&;#~!") {
+		return s
+	}
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
 }
@@ -0,0 +1,741 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// importPath is the import path of this package, within which the code
+// generated by the tests is built.
+const importPath = "github.com/veonik/go-stickgen/cmd/stickgen"
+
+// A cliTest describes a run of stickgen in a directory of its own.
+type cliTest struct {
+	name string
+	// files contains the files in the working directory, such as templates
+	// and config files, keyed by slash-separated path.
+	files map[string]string
+	args  []string
+	stdin string
+	// env contains environment variables set for stickgen, such as those
+	// set by go generate.
+	env []string
+	// before contains the arguments of earlier runs of stickgen, which must
+	// succeed, after which the files in edit are written, or removed if
+	// empty.
+	before [][]string
+	edit   map[string]string
+	// written contains the files expected in the working directory
+	// afterwards, other than those in files and edit, and contains the
+	// strings expected in some of them.
+	written  []string
+	contains map[string][]string
+	// stdout and stderr contain the strings expected in the output of
+	// stickgen, and fail is true if it is expected to exit with a non-zero
+	// status.
+	stdout []string
+	stderr []string
+	fail   bool
+	// redirect is the file standard output is saved to, as if redirected by
+	// a shell, so that code written to it is built.
+	redirect string
+	// pkgs maps the names that render uses to the directories of the
+	// packages generated. If render is given, it is run as the body of a
+	// program, with env and w in scope, that renders templates to w and
+	// assigns err, and output is the output expected.
+	pkgs   map[string]string
+	render string
+	output string
+}
+
+var (
+	buildOnce sync.Once
+	binDir    string
+	binErr    error
+)
+
+// stickgenBin returns the path of the stickgen command, which is built the
+// first time it is needed.
+func stickgenBin(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("go"); err != nil || testing.Short() {
+		t.Skip("the go command is needed to build stickgen")
+	}
+	buildOnce.Do(func() {
+		binDir, binErr = ioutil.TempDir("", "stickgen")
+		if binErr != nil {
+			return
+		}
+		var out string
+		if out, binErr = goBuild(".", binDir+string(filepath.Separator), "."); binErr != nil {
+			binErr = fmt.Errorf("%s\n%s", binErr, out)
+		}
+	})
+	if binErr != nil {
+		t.Fatalf("unable to build stickgen: %s", binErr)
+	}
+	return filepath.Join(binDir, "stickgen")
+}
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if binDir != "" {
+		os.RemoveAll(binDir)
+	}
+	os.Exit(code)
+}
+
+// goBuild builds the given packages in dir, writing the programs to bin.
+func goBuild(dir, bin string, pkgs ...string) (string, error) {
+	cmd := exec.Command("go", append([]string{"build", "-o", bin}, pkgs...)...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	return string(out), err
+}
+
+// writeTree writes each of the files, keyed by slash-separated path, into
+// dir.
+func writeTree(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, contents := range files {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// listTree returns the slash-separated paths of the files in dir.
+func listTree(t *testing.T, dir string) map[string]bool {
+	t.Helper()
+	files := make(map[string]bool)
+	err := filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, file)
+		files[filepath.ToSlash(rel)] = true
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+// runStickgen runs stickgen in dir with the given arguments, environment
+// variables and standard input, returning its output.
+func runStickgen(t *testing.T, dir string, env []string, stdin string, args ...string) (string, string, error) {
+	t.Helper()
+	cmd := exec.Command(stickgenBin(t), args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdin = strings.NewReader(stdin)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	return stdout.String(), stderr.String(), err
+}
+
+// testCLI runs stickgen for each test and checks its output and the files
+// it writes. The packages generated by tests that succeed are then built at
+// once, along with the programs rendering them.
+func testCLI(t *testing.T, tests []cliTest) {
+	stickgenBin(t)
+	// The generated packages import go-stickgen, so they are built in a
+	// directory within it.
+	top, err := ioutil.TempDir(".", "_stickgen_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(top)
+	var pkgs []string
+	progs := make(map[string]cliTest)
+	for i, tt := range tests {
+		dir := filepath.Join(top, fmt.Sprintf("case%d", i))
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		writeTree(t, dir, tt.files)
+		for _, args := range tt.before {
+			if _, stderr, err := runStickgen(t, dir, tt.env, "", args...); err != nil {
+				t.Fatalf("%s: unable to run stickgen %v: %s\n%s", tt.name, args, err, stderr)
+			}
+		}
+		for name, contents := range tt.edit {
+			file := filepath.Join(dir, filepath.FromSlash(name))
+			if contents == "" {
+				if err := os.Remove(file); err != nil {
+					t.Fatal(err)
+				}
+				continue
+			}
+			writeTree(t, dir, map[string]string{name: contents})
+		}
+		stdout, stderr, err := runStickgen(t, dir, tt.env, tt.stdin, tt.args...)
+		if (err != nil) != tt.fail {
+			t.Errorf("%s: expected failure %v, got %v:\n%s", tt.name, tt.fail, err, stderr)
+			continue
+		}
+		for _, s := range tt.stdout {
+			if !strings.Contains(stdout, s) {
+				t.Errorf("%s: expected standard output containing %q, got:\n%s", tt.name, s, stdout)
+			}
+		}
+		for _, s := range tt.stderr {
+			if !strings.Contains(stderr, s) {
+				t.Errorf("%s: expected standard error containing %q, got:\n%s", tt.name, s, stderr)
+			}
+		}
+		if tt.redirect != "" {
+			writeTree(t, dir, map[string]string{tt.redirect: stdout})
+		}
+		written := []string{}
+		dirs := make(map[string]bool)
+		for file := range listTree(t, dir) {
+			_, input := tt.files[file]
+			if _, ok := tt.edit[file]; !ok && !input {
+				written = append(written, file)
+			}
+			if strings.HasSuffix(file, ".go") {
+				dirs[filepath.Dir(filepath.Join(dir, filepath.FromSlash(file)))] = true
+			}
+		}
+		sort.Strings(written)
+		want := tt.written
+		if want == nil {
+			want = []string{}
+		}
+		if !reflect.DeepEqual(written, want) {
+			t.Errorf("%s: expected files %v written, got %v", tt.name, want, written)
+		}
+		for file, strs := range tt.contains {
+			b, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(file)))
+			if err != nil {
+				t.Errorf("%s: %s", tt.name, err)
+				continue
+			}
+			for _, s := range strs {
+				if !strings.Contains(string(b), s) {
+					t.Errorf("%s: expected %s containing %q, got:\n%s", tt.name, file, s, b)
+				}
+			}
+		}
+		if tt.fail {
+			continue
+		}
+		for d := range dirs {
+			rel, _ := filepath.Rel(top, d)
+			pkgs = append(pkgs, "./"+filepath.ToSlash(rel))
+		}
+		if tt.render != "" {
+			prog := fmt.Sprintf("prog%d", i)
+			writeTree(t, filepath.Join(top, prog), map[string]string{"main.go": renderProgram(dir, tt)})
+			pkgs = append(pkgs, "./"+prog)
+			progs[prog] = tt
+		}
+	}
+	if len(pkgs) == 0 {
+		return
+	}
+	sort.Strings(pkgs)
+	bin, err := filepath.Abs(filepath.Join(top, "bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, err := goBuild(top, bin+string(filepath.Separator), pkgs...); err != nil {
+		t.Fatalf("unable to build generated code: %s\n%s", err, out)
+	}
+	for prog, tt := range progs {
+		out, err := exec.Command(filepath.Join(bin, prog)).CombinedOutput()
+		if err != nil {
+			t.Errorf("%s: unable to render: %s\n%s", tt.name, err, out)
+		} else if string(out) != tt.output {
+			t.Errorf("%s: expected output %q, got %q", tt.name, tt.output, out)
+		}
+	}
+}
+
+// renderProgram returns the source of the program rendering the templates
+// generated for the test into dir, which is relative to this package.
+func renderProgram(dir string, tt cliTest) string {
+	var names []string
+	for name := range tt.pkgs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	b := &strings.Builder{}
+	b.WriteString("package main\n\nimport (\n\t\"fmt\"\n\t\"os\"\n\n\t\"github.com/tyler-sommer/stick\"\n")
+	for _, name := range names {
+		pkg := filepath.Join(dir, filepath.FromSlash(tt.pkgs[name]))
+		fmt.Fprintf(b, "\t%s %q\n", name, importPath+"/"+filepath.ToSlash(pkg))
+	}
+	fmt.Fprintf(b, `)
+
+func main() {
+	env := stick.New(nil)
+	w := os.Stdout
+	var err error
+	%s
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+`, tt.render)
+	return b.String()
+}
+
+func TestCommand(t *testing.T) {
+	templates := map[string]string{
+		"templates/layout.twig": `<{% block content %}{% endblock %}>`,
+		"templates/index.twig":  `{% extends 'layout.twig' %}{% block content %}Hello, {{ name }}{% endblock %}`,
+	}
+	testCLI(t, []cliTest{
+		{
+			name:    "defaults",
+			files:   map[string]string{"index.twig": `Hello, {{ name }}`},
+			written: []string{"generated/index_twig.go"},
+			contains: map[string][]string{
+				"generated/index_twig.go": {"package generated", "func TemplateIndexTwig(", "//stickgen:command stickgen\n"},
+			},
+			pkgs:   map[string]string{"generated": "generated"},
+			render: `err = generated.TemplateIndexTwig(env, w, map[string]stick.Value{"name": "World"})`,
+			output: "Hello, World",
+		},
+		{
+			name:    "paths and package",
+			files:   templates,
+			args:    []string{"-path", "templates", "-out", "views", "-pkg", "pages"},
+			written: []string{"views/index_twig.go", "views/layout_twig.go"},
+			contains: map[string][]string{
+				"views/index_twig.go": {"package pages", "//stickgen:command stickgen -out=views -path=templates -pkg=pages\n"},
+			},
+			pkgs:   map[string]string{"pages": "views"},
+			render: `err = pages.TemplateIndexTwig(env, w, map[string]stick.Value{"name": "World"})`,
+			output: "<Hello, World>",
+		},
+		{
+			name:    "quoted command",
+			files:   map[string]string{"my templates/index.twig": `index`},
+			args:    []string{"-path", "my templates"},
+			written: []string{"generated/index_twig.go"},
+			contains: map[string][]string{
+				"generated/index_twig.go": {"//stickgen:command stickgen -path='my templates'\n"},
+			},
+		},
+		{
+			name:    "run flags omitted from command",
+			files:   map[string]string{"index.twig": `index`},
+			args:    []string{"-jobs", "2"},
+			written: []string{"generated/index_twig.go"},
+			contains: map[string][]string{
+				"generated/index_twig.go": {"//stickgen:command stickgen\n"},
+			},
+		},
+		{
+			name:    "trimpath",
+			files:   map[string]string{"index.twig": `index`},
+			args:    []string{"-trimpath"},
+			written: []string{"generated/index_twig.go"},
+			contains: map[string][]string{
+				"generated/index_twig.go": {"//stickgen:version ", "//stickgen:command stickgen -trimpath\n"},
+			},
+		},
+		{
+			name:   "invalid jobs",
+			files:  map[string]string{"index.twig": `index`},
+			args:   []string{"-jobs", "0"},
+			stderr: []string{"stickgen: invalid number of jobs: 0"},
+			fail:   true,
+		},
+		{
+			name:   "check and watch",
+			files:  map[string]string{"index.twig": `index`},
+			args:   []string{"-check", "-watch"},
+			stderr: []string{"stickgen: -check cannot be used with -watch"},
+			fail:   true,
+		},
+		{
+			name:   "invalid package name",
+			files:  map[string]string{"index.twig": `index`},
+			args:   []string{"-pkg", "my-views"},
+			stderr: []string{"stickgen: invalid package name: my-views"},
+			fail:   true,
+		},
+		{
+			name:   "unknown flag",
+			args:   []string{"-nope"},
+			stdout: []string{"Usage: stickgen"},
+			stderr: []string{"flag provided but not defined: -nope"},
+			fail:   true,
+		},
+	})
+}
+
+func TestShellQuote(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"views", "views"},
+		{"./views/*.twig", "'./views/*.twig'"},
+		{"my views", "'my views'"},
+		{"it's", `'it'\''s'`},
+		{"", "''"},
+	}
+	for _, tt := range tests {
+		if got := shellQuote(tt.in); got != tt.want {
+			t.Errorf("shellQuote(%q): expected %s, got %s", tt.in, tt.want, got)
+		}
+	}
+}
+
+func TestTrimPath(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	outside := filepath.Join(filepath.Dir(wd), "elsewhere")
+	tests := []struct {
+		in, want string
+	}{
+		{"views", "views"},
+		{wd, "."},
+		{filepath.Join(wd, "templates", "admin"), "templates/admin"},
+		{outside, outside},
+	}
+	for _, tt := range tests {
+		if got := trimPath(tt.in); got != tt.want {
+			t.Errorf("trimPath(%q): expected %s, got %s", tt.in, tt.want, got)
+		}
+	}
+}
+
+func TestGlobs(t *testing.T) {
+	templates := map[string]string{
+		"templates/layout.twig":             `[{% block content %}{% endblock %}]`,
+		"templates/pages/index.twig":        `{% extends 'layout.twig' %}{% block content %}{% include 'pages/_nav.twig' %}Home{% endblock %}`,
+		"templates/pages/_nav.twig":         `<nav>`,
+		"templates/admin/users/list.twig":   `{% extends 'layout.twig' %}{% block content %}Users{% endblock %}`,
+		"templates/admin/users/_row.twig":   `row`,
+		"templates/admin/users/notes.txt":   `notes`,
+		"templates/admin/users/legacy.twig": `{% if %}`,
+	}
+	testCLI(t, []cliTest{
+		{
+			name:    "include and exclude",
+			files:   templates,
+			args:    []string{"-path", "templates", "-out", "views", "-exclude", "**/_*.twig", "-exclude", "**/legacy.twig", "**/*.twig"},
+			written: []string{"views/admin/users/list.twig.go", "views/layout.twig.go", "views/pages/index.twig.go"},
+			contains: map[string][]string{
+				"views/pages/index.twig.go":      {"package pages\n", "func TemplatePagesIndexTwig("},
+				"views/admin/users/list.twig.go": {"package users\n", "func TemplateAdminUsersListTwig("},
+			},
+			pkgs: map[string]string{"pages": "views/pages", "users": "views/admin/users"},
+			render: `err = pages.TemplatePagesIndexTwig(env, w, nil)
+	if err == nil {
+		err = users.TemplateAdminUsersListTwig(env, w, nil)
+	}`,
+			output: "[<nav>Home][Users]",
+		},
+		{
+			name:    "single directory",
+			files:   templates,
+			args:    []string{"-path", "templates", "-out", "views", "pages/*"},
+			written: []string{"views/pages/_nav.twig.go", "views/pages/index.twig.go"},
+		},
+		{
+			name:    "package name",
+			files:   templates,
+			args:    []string{"-path", "templates", "-out", "views", "-pkg", "views", "pages/index.twig"},
+			written: []string{"views/pages/index.twig.go"},
+			contains: map[string][]string{
+				"views/pages/index.twig.go": {"package views\n"},
+			},
+		},
+		{
+			name:    "no templates matched",
+			files:   templates,
+			args:    []string{"-path", "templates", "-out", "views", "*.html"},
+			written: []string{},
+		},
+		{
+			name:   "invalid glob",
+			files:  templates,
+			args:   []string{"-path", "templates", "-out", "views", "[a-"},
+			stderr: []string{"stickgen: unable to glob inputs: syntax error in pattern"},
+			fail:   true,
+		},
+	})
+}
+
+func TestJobs(t *testing.T) {
+	files := map[string]string{"templates/layout.twig": `[{% block content %}{% endblock %}]`}
+	var render []string
+	for i := 0; i < 10; i++ {
+		files[fmt.Sprintf("templates/pages/page%d.twig", i)] = fmt.Sprintf(`{%% extends 'layout.twig' %%}{%% block content %%}%d{%% endblock %%}`, i)
+		render = append(render, fmt.Sprintf("if err == nil {\n\t\terr = pages.TemplatePagesPage%dTwig(env, w, nil)\n\t}", i))
+	}
+	written := []string{}
+	pkgWritten := []string{"views/layout_twig.go"}
+	for i := 0; i < 10; i++ {
+		written = append(written, fmt.Sprintf("views/pages/page%d.twig.go", i))
+		pkgWritten = append(pkgWritten, fmt.Sprintf("views/pages_page%d_twig.go", i))
+	}
+	testCLI(t, []cliTest{
+		{
+			name:    "split",
+			files:   files,
+			args:    []string{"-path", "templates", "-out", "views", "-jobs", "4", "pages/*.twig"},
+			written: written,
+			pkgs:    map[string]string{"pages": "views/pages"},
+			render:  strings.Join(render, "\n\t"),
+			output:  "[0][1][2][3][4][5][6][7][8][9]",
+		},
+		{
+			name:    "package",
+			files:   files,
+			args:    []string{"-path", "templates", "-out", "views", "-jobs", "4"},
+			written: pkgWritten,
+			pkgs:    map[string]string{"pages": "views"},
+			render:  strings.Join(render, "\n\t"),
+			output:  "[0][1][2][3][4][5][6][7][8][9]",
+		},
+	})
+}
+
+func TestCache(t *testing.T) {
+	files := map[string]string{
+		"templates/layout.twig": `[{% block content %}{% endblock %}]`,
+		"templates/index.twig":  `{% extends 'layout.twig' %}{% block content %}Hello{% endblock %}`,
+	}
+	split := []string{"-cache", "cache.json", "-path", "templates", "-out", "views", "index.twig"}
+	single := []string{"-cache", "cache.json", "-path", "templates", "-template", "index.twig", "-o", "views/index.go", "-pkg", "views"}
+	testCLI(t, []cliTest{
+		{
+			name:    "package",
+			files:   files,
+			args:    []string{"-cache", "cache.json", "-path", "templates", "-out", "views"},
+			written: []string{"cache.json", "views/index_twig.go", "views/layout_twig.go"},
+			contains: map[string][]string{
+				"cache.json": {`"views"`},
+			},
+			pkgs:   map[string]string{"views": "views"},
+			render: `err = views.TemplateIndexTwig(env, w, nil)`,
+			output: "[Hello]",
+		},
+		{
+			name:    "package up to date",
+			files:   files,
+			before:  [][]string{{"-cache", "cache.json", "-path", "templates", "-out", "views"}},
+			edit:    map[string]string{"views/index_twig.go": "// Edited.\npackage views\n"},
+			args:    []string{"-cache", "cache.json", "-path", "templates", "-out", "views"},
+			written: []string{"cache.json", "views/layout_twig.go"},
+			contains: map[string][]string{
+				"views/index_twig.go": {"// Edited.\n"},
+			},
+		},
+		{
+			name:    "package template changed",
+			files:   files,
+			before:  [][]string{{"-cache", "cache.json", "-path", "templates", "-out", "views"}},
+			edit:    map[string]string{"templates/layout.twig": `({% block content %}{% endblock %})`},
+			args:    []string{"-cache", "cache.json", "-path", "templates", "-out", "views"},
+			written: []string{"cache.json", "views/index_twig.go", "views/layout_twig.go"},
+			pkgs:    map[string]string{"views": "views"},
+			render:  `err = views.TemplateIndexTwig(env, w, nil)`,
+			output:  "(Hello)",
+		},
+		{
+			name:    "split up to date",
+			files:   files,
+			before:  [][]string{split},
+			edit:    map[string]string{"views/index.twig.go": "// Edited.\npackage views\n"},
+			args:    split,
+			written: []string{"cache.json"},
+			contains: map[string][]string{
+				"views/index.twig.go": {"// Edited.\n"},
+			},
+		},
+		{
+			name:    "split generated file removed",
+			files:   files,
+			before:  [][]string{split},
+			edit:    map[string]string{"views/index.twig.go": ""},
+			args:    split,
+			written: []string{"cache.json"},
+			contains: map[string][]string{
+				"views/index.twig.go": {"func TemplateIndexTwig("},
+			},
+			pkgs:   map[string]string{"views": "views"},
+			render: `err = views.TemplateIndexTwig(env, w, nil)`,
+			output: "[Hello]",
+		},
+		{
+			name:    "single template up to date",
+			files:   files,
+			before:  [][]string{single},
+			edit:    map[string]string{"views/index.go": "// Edited.\npackage views\n"},
+			args:    single,
+			written: []string{"cache.json"},
+			contains: map[string][]string{
+				"views/index.go": {"// Edited.\n"},
+			},
+		},
+		{
+			name:    "single template options changed",
+			files:   files,
+			before:  [][]string{single},
+			args:    []string{"-cache", "cache.json", "-path", "templates", "-template", "index.twig", "-o", "views/index.go", "-pkg", "pages"},
+			written: []string{"cache.json", "views/index.go"},
+			contains: map[string][]string{
+				"views/index.go": {"package pages\n"},
+			},
+		},
+		{
+			name:    "config file",
+			files:   map[string]string{"templates/index.twig": `Hello`, "stickgen.json": `{"cache": ".stickgen-cache.json", "targets": [{"path": "templates", "out": "views"}]}`},
+			before:  [][]string{{}},
+			edit:    map[string]string{"views/index_twig.go": "// Edited.\npackage views\n"},
+			written: []string{".stickgen-cache.json"},
+			contains: map[string][]string{
+				"views/index_twig.go": {"// Edited.\n"},
+			},
+		},
+		{
+			name:   "invalid cache file",
+			files:  map[string]string{"templates/index.twig": `Hello`, "cache.json": `{"files": [`},
+			args:   []string{"-cache", "cache.json", "-path", "templates"},
+			stderr: []string{"stickgen: invalid cache"},
+			fail:   true,
+		},
+		{
+			name:    "check ignores cache",
+			files:   files,
+			before:  [][]string{{"-cache", "cache.json", "-path", "templates", "-out", "views"}},
+			edit:    map[string]string{"templates/index.twig": `{% extends 'layout.twig' %}{% block content %}Goodbye{% endblock %}`},
+			args:    []string{"-cache", "cache.json", "-path", "templates", "-out", "views", "-check"},
+			written: []string{"cache.json", "views/index_twig.go", "views/layout_twig.go"},
+			stderr:  []string{"generated code is out of date"},
+			fail:    true,
+		},
+	})
+}
+
+func TestErrors(t *testing.T) {
+	files := map[string]string{
+		"templates/index.twig":  `index`,
+		"templates/broken.twig": `{% if %}`,
+		"templates/users.twig":  "Users\n{{ x }}{% include 'missing.twig' %}",
+	}
+	testCLI(t, []cliTest{
+		{
+			name:  "package",
+			files: files,
+			args:  []string{"-path", "templates", "-out", "views"},
+			stderr: []string{
+				"stickgen: " + filepath.Join("templates", "broken.twig") + ": ",
+				"stickgen: " + filepath.Join("templates", "users.twig") + ":2:",
+				"stickgen: unable to generate code: 2 errors\n",
+			},
+			fail: true,
+		},
+		{
+			name:    "split",
+			files:   files,
+			args:    []string{"-path", "templates", "-out", "views", "*.twig"},
+			written: []string{"views/index.twig.go"},
+			stderr: []string{
+				"stickgen: " + filepath.Join("templates", "broken.twig") + ": ",
+				"stickgen: " + filepath.Join("templates", "users.twig") + ":2:",
+				"stickgen: unable to generate code: 2 errors\n",
+			},
+			fail: true,
+		},
+		{
+			name: "config targets",
+			files: map[string]string{
+				"a/index.twig":  `{% if %}`,
+				"b/index.twig":  `b`,
+				"stickgen.json": `{"targets": [{"path": "a", "out": "views/a"}, {"path": "b", "out": "views/b"}]}`,
+			},
+			written: []string{"views/b/index_twig.go"},
+			stderr: []string{
+				"stickgen: " + filepath.Join("a", "index.twig") + ": ",
+				"stickgen: unable to generate code: 1 error\n",
+			},
+			fail: true,
+		},
+	})
+}
+
+func TestExtensions(t *testing.T) {
+	files := map[string]string{
+		"templates/page.html.twig": `{% include 'nav.tpl' %}page`,
+		"templates/nav.tpl":        `<nav>`,
+		"templates/README.md":      `readme`,
+	}
+	testCLI(t, []cliTest{
+		{
+			name:    "default",
+			files:   files,
+			args:    []string{"-path", "templates", "-out", "views"},
+			written: []string{"views/page_html_twig.go"},
+			pkgs:    map[string]string{"views": "views"},
+			render:  `err = views.TemplatePageHtmlTwig(env, w, nil)`,
+			output:  "<nav>page",
+		},
+		{
+			name:    "extensions",
+			files:   files,
+			args:    []string{"-path", "templates", "-out", "views", "-ext", ".html.twig", "-ext", ".tpl", "-strip-ext"},
+			written: []string{"views/nav_tpl.go", "views/page_html_twig.go"},
+			contains: map[string][]string{
+				"views/page_html_twig.go": {"//stickgen:options Extensions=.html.twig,.tpl StripExtensions\n"},
+			},
+			pkgs: map[string]string{"views": "views"},
+			render: `err = views.TemplatePage(env, w, nil)
+	if err == nil {
+		err = views.TemplateNav(env, w, nil)
+	}`,
+			output: "<nav>page<nav>",
+		},
+		{
+			name:    "globs",
+			files:   files,
+			args:    []string{"-path", "templates", "-out", "views", "-ext", ".html.twig", "-strip-ext", "*.twig"},
+			written: []string{"views/page.html.twig.go"},
+			contains: map[string][]string{
+				"views/page.html.twig.go": {"func TemplatePage("},
+			},
+		},
+		{
+			name:    "config",
+			files:   map[string]string{"templates/page.tpl": `page`, "stickgen.json": `{"targets": [{"path": "templates", "out": "views", "options": {"extensions": [".tpl"], "strip_extensions": true}}]}`},
+			written: []string{"views/page_tpl.go"},
+			contains: map[string][]string{
+				"views/page_tpl.go": {"func TemplatePage("},
+			},
+		},
+		{
+			name:   "invalid extension",
+			files:  files,
+			args:   []string{"-path", "templates", "-ext", "tpl"},
+			stderr: []string{`stickgen: invalid template extension: "tpl"`},
+			fail:   true,
+		},
+	})
+}
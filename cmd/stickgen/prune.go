@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/veonik/go-stickgen"
+)
+
+// prune removes the files generated for the target that no longer
+// correspond to any of its templates, such as files generated for
+// templates that have since been deleted or renamed.
+func (t *target) prune() error {
+	var orphans []string
+	var err error
+	switch {
+	case t.template != "":
+		return nil
+	case t.split:
+		orphans, err = t.splitOrphans()
+	default:
+		var dir string
+		if dir, err = filepath.Abs(t.Out); err == nil {
+			orphans, err = t.generator(t.pkgName(dir)).Orphans(t.Path, t.Out, t.Include, t.Exclude)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	for _, file := range orphans {
+		if !*check {
+			logger.Info("removing", "file", file)
+		}
+		if err := removeFile(file); err != nil {
+			return err
+		}
+		if t.buildCache != nil && !*check {
+			t.buildCache.Remove(file)
+		}
+	}
+	return nil
+}
+
+// splitOrphans returns the files generated by stickgen in the target's
+// output path, or its subdirectories, that generateSplit would not
+// generate.
+func (t *target) splitOrphans() ([]string, error) {
+	tpls, err := t.findTemplates()
+	if err != nil {
+		return nil, err
+	}
+	keep := make(map[string]bool)
+	for _, tpl := range tpls {
+		keep[t.splitFile(tpl)] = true
+	}
+	var orphans []string
+	err = filepath.Walk(t.Out, func(file string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil || info.IsDir() || filepath.Ext(file) != ".go" || keep[file] {
+			return err
+		}
+		if _, err := stickgen.Sources(file); err == nil {
+			orphans = append(orphans, file)
+		}
+		return nil
+	})
+	return orphans, err
+}
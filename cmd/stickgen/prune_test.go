@@ -0,0 +1,56 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestPrune(t *testing.T) {
+	files := map[string]string{
+		"templates/index.twig": `Hello`,
+		"templates/about.twig": `About`,
+		"views/helpers.go":     "package views\n",
+	}
+	about := filepath.Join("views", "about_twig.go")
+	testCLI(t, []cliTest{
+		{
+			name:    "package",
+			files:   files,
+			before:  [][]string{{"-path", "templates", "-out", "views"}},
+			edit:    map[string]string{"templates/about.twig": ""},
+			args:    []string{"-path", "templates", "-out", "views", "-prune", "-v"},
+			written: []string{"views/index_twig.go"},
+			stderr:  []string{"msg=removing file=" + about},
+			pkgs:    map[string]string{"views": "views"},
+			render:  `err = views.TemplateIndexTwig(env, w, nil)`,
+			output:  "Hello",
+		},
+		{
+			name:    "split mode",
+			files:   files,
+			before:  [][]string{{"-path", "templates", "-out", "views", "*.twig"}},
+			edit:    map[string]string{"templates/about.twig": ""},
+			args:    []string{"-path", "templates", "-out", "views", "-prune", "*.twig"},
+			written: []string{"views/index.twig.go"},
+		},
+		{
+			name:    "without prune",
+			files:   files,
+			before:  [][]string{{"-path", "templates", "-out", "views"}},
+			edit:    map[string]string{"templates/about.twig": ""},
+			args:    []string{"-path", "templates", "-out", "views"},
+			written: []string{"views/about_twig.go", "views/index_twig.go"},
+		},
+		{
+			name:    "check",
+			files:   files,
+			before:  [][]string{{"-path", "templates", "-out", "views"}},
+			edit:    map[string]string{"templates/about.twig": ""},
+			args:    []string{"-path", "templates", "-out", "views", "-prune", "-check"},
+			written: []string{"views/about_twig.go", "views/index_twig.go"},
+			stdout:  []string{"--- " + about + "\n+++ /dev/null\n", "-package views\n"},
+			stderr:  []string{"stickgen: generated code is out of date"},
+			fail:    true,
+		},
+	})
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/tyler-sommer/stick"
+)
+
+// singleTarget returns a target that generates the named template into the
+// given file, or into a file named after the template if file is empty.
+func singleTarget(name, file string) *target {
+	name = filepath.ToSlash(name)
+	if file == "" {
+		base := filepath.Base(filepath.FromSlash(name))
+		file = strings.TrimSuffix(base, filepath.Ext(base)) + "_gen.go"
+	}
+	t := &target{
+		Path:     *path,
+		Out:      filepath.Dir(file),
+		Package:  *pkg,
+		template: name,
+		file:     file,
+	}
+	if t.Package == "" {
+		t.Package = dirPackage(t.Out, file)
+	}
+	return t
+}
+
+// dirPackage returns the name of the package in dir, ignoring the given
+// file and any tests. If dir contains no package, the package named by go
+// generate is used if dir is the current directory, and otherwise dir's
+// name.
+func dirPackage(dir, file string) string {
+	files, _ := filepath.Glob(filepath.Join(dir, "*.go"))
+	for _, f := range files {
+		if strings.HasSuffix(f, "_test.go") || sameFile(f, file) {
+			continue
+		}
+		ast, err := parser.ParseFile(token.NewFileSet(), f, nil, parser.PackageClauseOnly)
+		if err == nil {
+			return ast.Name.Name
+		}
+	}
+	if pkg := os.Getenv("GOPACKAGE"); pkg != "" && sameFile(dir, ".") {
+		return strings.TrimSuffix(pkg, "_test")
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return filepath.Base(dir)
+	}
+	return filepath.Base(abs)
+}
+
+// sameFile reports whether the paths a and b refer to the same file.
+func sameFile(a, b string) bool {
+	ai, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	bi, err := os.Stat(b)
+	return err == nil && os.SameFile(ai, bi)
+}
+
+// stdinTarget returns a target that generates the template read from
+// standard input, giving it the given name, and writes it to standard
+// output.
+func stdinTarget(name string) (*target, error) {
+	b, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read standard input: %s", err)
+	}
+	t := &target{
+		Path:     *path,
+		Package:  *pkg,
+		template: filepath.ToSlash(name),
+		file:     "-",
+		stdin:    b,
+	}
+	if t.Package == "" {
+		t.Package = dirPackage(".", "")
+	}
+	return t, nil
+}
+
+// A stdinLoader loads the template read from standard input by name, and
+// any other template using another Loader.
+type stdinLoader struct {
+	stick.Loader
+	name     string
+	contents []byte
+}
+
+func (l *stdinLoader) Load(name string) (stick.Template, error) {
+	if name != l.name {
+		return l.Loader.Load(name)
+	}
+	return l, nil
+}
+
+func (l *stdinLoader) Name() string {
+	return l.name
+}
+
+func (l *stdinLoader) Contents() io.Reader {
+	return bytes.NewReader(l.contents)
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tyler-sommer/stick"
+)
+
+func TestDirPackage(t *testing.T) {
+	tests := []struct {
+		name      string
+		files     map[string]string
+		dir       string
+		file      string
+		gopackage string
+		want      string
+	}{
+		{
+			name:  "package in directory",
+			files: map[string]string{"views/views.go": "package pages\n"},
+			dir:   "views",
+			file:  filepath.Join("views", "page_gen.go"),
+			want:  "pages",
+		},
+		{
+			name: "output file and tests ignored",
+			files: map[string]string{
+				"views/page_gen.go":   "package old\n",
+				"views/views_test.go": "package views_test\n",
+				"views/broken.go":     "not go",
+			},
+			dir:  "views",
+			file: filepath.Join("views", "page_gen.go"),
+			want: "views",
+		},
+		{
+			name:      "go generate",
+			dir:       ".",
+			file:      "page_gen.go",
+			gopackage: "pages_test",
+			want:      "pages",
+		},
+		{
+			name:      "go generate in another directory",
+			dir:       "views",
+			file:      filepath.Join("views", "page_gen.go"),
+			gopackage: "pages",
+			want:      "views",
+		},
+		{
+			name: "current directory",
+			dir:  ".",
+			file: "page_gen.go",
+			want: "work",
+		},
+	}
+	tmp, err := ioutil.TempDir("", "stickgen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	defer os.Setenv("GOPACKAGE", os.Getenv("GOPACKAGE"))
+	for _, tt := range tests {
+		dir := filepath.Join(tmp, tt.name, "work")
+		if err := os.MkdirAll(filepath.Join(dir, "views"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		writeTree(t, dir, tt.files)
+		if err := os.Chdir(dir); err != nil {
+			t.Fatal(err)
+		}
+		os.Setenv("GOPACKAGE", tt.gopackage)
+		if got := dirPackage(tt.dir, tt.file); got != tt.want {
+			t.Errorf("%s: expected package %s, got %s", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestSingleTemplate(t *testing.T) {
+	files := map[string]string{
+		"views.go":                   "package views\n",
+		"templates/page.html.twig":   `{% extends 'layout.twig' %}{% block body %}Hello, {{ name }}{% endblock %}`,
+		"templates/layout.twig":      `<{% block body %}{% endblock %}>`,
+		"templates/admin/users.twig": `Users`,
+	}
+	testCLI(t, []cliTest{
+		{
+			name:    "package of directory",
+			files:   files,
+			args:    []string{"-path", "templates", "-template", "page.html.twig"},
+			written: []string{"page.html_gen.go"},
+			contains: map[string][]string{
+				"page.html_gen.go": {"package views\n", "//stickgen:sources \"layout.twig\" \"page.html.twig\"\n"},
+			},
+			pkgs:   map[string]string{"views": "."},
+			render: `err = views.TemplatePageHtmlTwig(env, w, map[string]stick.Value{"name": "World"})`,
+			output: "<Hello, World>",
+		},
+		{
+			name:    "output file",
+			files:   files,
+			args:    []string{"-path", "templates", "-template", "admin/users.twig", "-o", "admin/users.go"},
+			written: []string{"admin/users.go"},
+			contains: map[string][]string{
+				"admin/users.go": {"package admin\n", "func TemplateAdminUsersTwig("},
+			},
+		},
+		{
+			name:    "package name",
+			files:   map[string]string{"templates/layout.twig": `layout`},
+			args:    []string{"-path", "templates", "-template", "layout.twig", "-o", "gen/layout.go", "-pkg", "layouts"},
+			written: []string{"gen/layout.go"},
+			contains: map[string][]string{
+				"gen/layout.go": {"package layouts\n"},
+			},
+		},
+		{
+			name:    "go generate",
+			files:   map[string]string{"templates/layout.twig": `layout`},
+			args:    []string{"-path", "templates", "-template", "layout.twig"},
+			env:     []string{"GOPACKAGE=pages"},
+			written: []string{"layout_gen.go"},
+			contains: map[string][]string{
+				"layout_gen.go": {"package pages\n"},
+			},
+		},
+		{
+			name:   "globs",
+			files:  files,
+			args:   []string{"-path", "templates", "-template", "layout.twig", "*.twig"},
+			stderr: []string{"stickgen: -template cannot be used with globs"},
+			fail:   true,
+		},
+		{
+			name:   "missing template",
+			files:  files,
+			args:   []string{"-path", "templates", "-template", "missing.twig"},
+			stderr: []string{"stickgen: " + filepath.Join("templates", "missing.twig") + ": open ", "stickgen: unable to generate code: 1 error"},
+			fail:   true,
+		},
+	})
+}
+
+func TestStdinLoader(t *testing.T) {
+	l := &stdinLoader{
+		Loader:   &stick.MemoryLoader{Templates: map[string]string{"layout.twig": "layout"}},
+		name:     "page.twig",
+		contents: []byte("page"),
+	}
+	tests := []struct {
+		name string
+		want string
+		err  string
+	}{
+		{name: "page.twig", want: "page"},
+		{name: "layout.twig", want: "layout"},
+		{name: "missing.twig", err: "template not found: missing.twig"},
+	}
+	for _, tt := range tests {
+		tpl, err := l.Load(tt.name)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%s: expected error %q, got %v", tt.name, tt.err, err)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.name, err)
+			continue
+		}
+		b, err := ioutil.ReadAll(tpl.Contents())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tpl.Name() != tt.name || string(b) != tt.want {
+			t.Errorf("%s: expected %s with contents %q, got %s with %q", tt.name, tt.name, tt.want, tpl.Name(), b)
+		}
+	}
+}
+
+func TestStdin(t *testing.T) {
+	files := map[string]string{"templates/_nav.twig": `<nav>`}
+	page := `{% include '_nav.twig' %}Hello, {{ name }}`
+	testCLI(t, []cliTest{
+		{
+			name:     "named template",
+			files:    files,
+			args:     []string{"-path", "templates", "-name", "page.twig", "-pkg", "views", "-"},
+			stdin:    page,
+			stdout:   []string{"package views\n", "func TemplatePageTwig("},
+			redirect: "views/page.go",
+			written:  []string{"views/page.go"},
+			pkgs:     map[string]string{"views": "views"},
+			render:   `err = views.TemplatePageTwig(env, w, map[string]stick.Value{"name": "World"})`,
+			output:   "<nav>Hello, World",
+		},
+		{
+			name:     "go generate",
+			args:     []string{"-"},
+			stdin:    `Hello`,
+			env:      []string{"GOPACKAGE=pages"},
+			stdout:   []string{"package pages\n", "func TemplateStdinTwig("},
+			redirect: "pages/stdin.go",
+			written:  []string{"pages/stdin.go"},
+		},
+		{
+			name:   "template error",
+			files:  files,
+			args:   []string{"-path", "templates", "-name", "page.twig", "-pkg", "views", "-"},
+			stdin:  `Hello{% include 'missing.twig' %}`,
+			stderr: []string{"stickgen: page.twig:1:"},
+			fail:   true,
+		},
+		{
+			name:   "check",
+			args:   []string{"-check", "-"},
+			stdin:  `Hello`,
+			stderr: []string{"stickgen: -check and -watch cannot be used with standard input"},
+			fail:   true,
+		},
+		{
+			name:   "watch",
+			args:   []string{"-watch", "-"},
+			stdin:  `Hello`,
+			stderr: []string{"stickgen: -check and -watch cannot be used with standard input"},
+			fail:   true,
+		},
+	})
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/veonik/go-stickgen"
+)
+
+// pollInterval is how often the input paths are checked for changes. A burst
+// of changes, such as an editor saving a file, is usually handled at once.
+const pollInterval = 250 * time.Millisecond
+
+// A fileState records what is known about a file to tell when it changes.
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// watchTemplates regenerates the templates of each target whenever the
+// files in its input path change. It polls the modification times of the
+// files rather than relying on platform notifications, and never returns.
+func watchTemplates(c *config) {
+	files := make(map[string]fileState)
+	for _, t := range c.Targets {
+		scanFiles(t.Path, files)
+		logger.Info("watching for changes", "path", t.Path)
+	}
+	for range time.Tick(pollInterval) {
+		next := make(map[string]fileState, len(files))
+		for _, t := range c.Targets {
+			scanFiles(t.Path, next)
+		}
+		changed := make(map[string]bool)
+		for path, st := range next {
+			if prev, ok := files[path]; !ok || prev != st {
+				changed[path] = true
+			}
+		}
+		for path := range files {
+			if _, ok := next[path]; !ok {
+				changed[path] = true
+			}
+		}
+		files = next
+		if len(changed) > 0 {
+			reportErrors(generate(c, changed))
+		}
+	}
+}
+
+// scanFiles records the state of every file in root. Files that cannot be
+// read are skipped, and are picked up by a later scan once they can.
+func scanFiles(root string, files map[string]fileState) {
+	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			logger.Warn("unable to watch", "path", path, "error", err)
+			return nil
+		}
+		if !info.IsDir() {
+			files[filepath.Clean(path)] = fileState{info.ModTime(), info.Size()}
+		}
+		return nil
+	})
+}
+
+// templateNames returns the names of the templates in the target's input
+// path among the given files.
+func (t *target) templateNames(files map[string]bool) map[string]bool {
+	names := make(map[string]bool)
+	for file := range files {
+		rel, err := filepath.Rel(t.Path, file)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		names[filepath.ToSlash(rel)] = true
+	}
+	return names
+}
+
+// affected reports whether the generated file is affected by a change to
+// any of the named templates. A file that does not exist, or that does not
+// record its sources, is always affected.
+func affected(changed map[string]bool, generatedFile string) bool {
+	names, err := stickgen.Sources(generatedFile)
+	if err != nil {
+		return true
+	}
+	for _, name := range names {
+		if changed[name] {
+			return true
+		}
+	}
+	return false
+}
+
+// affectedPackages reports whether any package generated by the target,
+// whose output path is dir, is affected by a change to any of the named
+// templates.
+func (t *target) affectedPackages(changed map[string]bool, dir string) bool {
+	if t.affectedDir(changed, dir) {
+		return true
+	}
+	for _, p := range t.Packages {
+		if t.affectedDir(changed, p.Out) {
+			return true
+		}
+	}
+	return false
+}
+
+// affectedDir reports whether any file generated in dir is affected by a
+// change to any of the named templates. A change to any file with one of the
+// target's template extensions affects the directory, since it may be a new
+// template.
+func (t *target) affectedDir(changed map[string]bool, dir string) bool {
+	g := t.generator(t.Package)
+	for name := range changed {
+		if g.IsTemplate(name) {
+			return true
+		}
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return true
+	}
+	for _, file := range files {
+		if names, err := stickgen.Sources(file); err == nil {
+			for _, name := range names {
+				if changed[name] {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// generatedFile is the header of a file generated from index.twig, which
+// includes partials/card.twig.
+const generatedFile = `// Code generated by stickgen. DO NOT EDIT.
+//stickgen:sources "index.twig" "partials/card.twig"
+//stickgen:hash 0123
+package views
+`
+
+func TestAffected(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stickgen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeTree(t, dir, map[string]string{
+		"views/index_twig.go": generatedFile,
+		"views/helpers.go":    "package views\n",
+	})
+	gen := filepath.Join(dir, "views", "index_twig.go")
+	tests := []struct {
+		name    string
+		changed []string
+		file    string
+		want    bool
+	}{
+		{"template", []string{"index.twig"}, gen, true},
+		{"included template", []string{"partials/card.twig"}, gen, true},
+		{"other template", []string{"about.twig"}, gen, false},
+		{"missing file", []string{"about.twig"}, filepath.Join(dir, "views", "about_twig.go"), true},
+		{"file without sources", []string{"about.twig"}, filepath.Join(dir, "views", "helpers.go"), true},
+	}
+	for _, tt := range tests {
+		changed := make(map[string]bool)
+		for _, name := range tt.changed {
+			changed[name] = true
+		}
+		if got := affected(changed, tt.file); got != tt.want {
+			t.Errorf("%s: expected affected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestAffectedDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stickgen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeTree(t, dir, map[string]string{
+		"views/index_twig.go": strings.Replace(generatedFile, "partials/card.twig", "partials/card.html", 1),
+	})
+	tests := []struct {
+		name    string
+		exts    []string
+		changed []string
+		want    bool
+	}{
+		{"template", nil, []string{"index.twig"}, true},
+		{"new template", nil, []string{"about.twig"}, true},
+		{"included file", nil, []string{"partials/card.html"}, true},
+		{"other file", nil, []string{"notes.txt"}, false},
+		{"new template with extension", []string{".tpl", ".html.twig"}, []string{"about.tpl"}, true},
+		{"file without extension", []string{".tpl", ".html.twig"}, []string{"about.twig"}, false},
+	}
+	for _, tt := range tests {
+		changed := make(map[string]bool)
+		for _, name := range tt.changed {
+			changed[name] = true
+		}
+		tgt := &target{Options: options{Extensions: tt.exts}}
+		if got := tgt.affectedDir(changed, filepath.Join(dir, "views")); got != tt.want {
+			t.Errorf("%s: expected affected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestWatch(t *testing.T) {
+	bin := stickgenBin(t)
+	dir, err := ioutil.TempDir("", "stickgen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeTree(t, dir, map[string]string{
+		"templates/index.twig":         `[{% include 'partials/card.twig' %}]`,
+		"templates/about.twig":         `About`,
+		"templates/partials/card.twig": `Hello`,
+	})
+	cmd := exec.Command(bin, "-watch", "-v", "-path", "templates", "-out", "views")
+	cmd.Dir = dir
+	stdout, stderr := &syncBuffer{}, &syncBuffer{}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+		if t.Failed() {
+			t.Logf("stickgen output:\n%s%s", stdout.String(), stderr.String())
+		}
+	}()
+
+	// waitFor waits until the generated file contains s. Templates whose
+	// text is static are generated as string constants.
+	waitFor := func(file, s string) {
+		t.Helper()
+		var b []byte
+		for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+			b, _ = ioutil.ReadFile(filepath.Join(dir, "views", file))
+			if strings.Contains(string(b), s) {
+				return
+			}
+		}
+		t.Fatalf("expected %s containing %q, got:\n%s", file, s, b)
+	}
+	waitFor("index_twig.go", "[Hello]")
+	waitFor("about_twig.go", `"About"`)
+	// Leave time for the watcher to start before changing templates.
+	time.Sleep(200 * time.Millisecond)
+
+	writeTree(t, filepath.Join(dir, "templates"), map[string]string{"partials/card.twig": `Goodbye`})
+	waitFor("index_twig.go", "[Goodbye]")
+
+	writeTree(t, filepath.Join(dir, "templates"), map[string]string{"contact.twig": `Contact`})
+	waitFor("contact_twig.go", `"Contact"`)
+
+	// Errors are reported, and stickgen keeps watching.
+	writeTree(t, filepath.Join(dir, "templates"), map[string]string{"partials/card.twig": `{% if %}`})
+	for deadline := time.Now().Add(10 * time.Second); !strings.Contains(stderr.String(), "stickgen: unable to generate "); time.Sleep(20 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the error to be reported")
+		}
+	}
+	writeTree(t, filepath.Join(dir, "templates"), map[string]string{"partials/card.twig": `Hello again`})
+	waitFor("index_twig.go", "[Hello again]")
+	if s := stderr.String(); !strings.Contains(s, `msg="watching for changes" path=templates`) {
+		t.Errorf("expected progress to be logged, got:\n%s", s)
+	}
+}
+
+// A syncBuffer is a bytes.Buffer that may be written while it is read.
+type syncBuffer struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.String()
+}
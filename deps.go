@@ -0,0 +1,25 @@
+package stickgen
+
+import "sort"
+
+// Dependencies returns the names of the templates that the named template
+// depends on: the template itself and any templates it extends, includes,
+// embeds or imports, directly or indirectly, or whose source it inlines.
+// These are the templates loaded while generating it, so templates that are
+// only loaded at runtime, such as dynamic includes, are not listed. Names
+// are returned in sorted order.
+func (g *Generator) Dependencies(name string) ([]string, error) {
+	c := g.clone()
+	if err := c.generateTemplates([]string{name}); err != nil {
+		return nil, err
+	}
+	if _, err := c.output(); err != nil {
+		return nil, err
+	}
+	var names []string
+	for name := range c.digests {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
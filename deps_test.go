@@ -0,0 +1,40 @@
+package stickgen_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/tyler-sommer/stick"
+	"github.com/veonik/go-stickgen"
+)
+
+func TestDependencies(t *testing.T) {
+	templates := map[string]string{
+		"layout.twig":  `[{% block content %}{% endblock %}]`,
+		"macros.twig":  `{% macro hello(name) %}{% include 'name.twig' %}{% endmacro %}`,
+		"name.twig":    `Hello, {{ name }}`,
+		"footer.txt":   `footer`,
+		"page.twig":    `{% extends 'layout.twig' %}{% import 'macros.twig' as m %}{% block content %}{{ m.hello('world') }}{{ source('footer.txt') }}{% endblock %}`,
+		"other.twig":   `other`,
+		"dynamic.twig": `{% include name %}`,
+		"broken.twig":  `{% include 'missing.twig' %}`,
+	}
+	g := stickgen.NewGenerator("views", &stick.MemoryLoader{Templates: templates})
+	g.DynamicIncludes = true
+	for _, tt := range []struct {
+		name string
+		want []string
+		err  string
+	}{
+		{"page.twig", []string{"footer.txt", "layout.twig", "macros.twig", "name.twig", "page.twig"}, ""},
+		{"other.twig", []string{"other.twig"}, ""},
+		{"dynamic.twig", []string{"dynamic.twig"}, ""},
+		{"broken.twig", nil, "missing.twig"},
+	} {
+		got, err := g.Dependencies(tt.name)
+		checkErr(t, tt.name, "listing dependencies", err, tt.err)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: expected dependencies %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,302 @@
+package stickgen
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/tyler-sommer/stick"
+	"github.com/veonik/go-stickgen/internal/parallel"
+)
+
+// templateExt is the extension of the templates found by default.
+const templateExt = ".twig"
+
+// GenerateDir generates a file in outDir for each template found in root,
+// which must be the directory that templates are loaded from. Templates are
+// named by their slash-separated path relative to root. The files written
+// are returned.
+//
+// Each file is named by FileNaming, after its template. By default, any
+// characters other than letters and digits are replaced by underscores, so
+// that "partials/user-card.twig" is generated as
+// "partials_user_card_twig.go". File names must have the ".go" extension,
+// but not "_test.go", and may not contain slashes.
+// Functions shared by more than one template, such as macros, are generated
+// only in the first file that uses them. If Registry is enabled, the
+// Templates map is declared in a file of its own, named templates.go.
+//
+// Templates in a directory mapped by Packages are generated into the
+// Package's output directory instead, with their files named after their
+// path relative to the mapped directory, so that "admin/users.twig" mapped
+// from "admin" is generated as "users_twig.go". Each package is generated
+// as if by a separate call to GenerateDir, so templates they share, such as
+// layouts, are generated into each package that uses them.
+//
+// If BuildCache is set, nothing is generated in a package if the same files
+// were last generated with the same options from the same sources.
+func (g *Generator) GenerateDir(root, outDir string) ([]string, error) {
+	return g.GenerateGlob(root, outDir, nil, nil)
+}
+
+// GenerateGlob is like GenerateDir, but only generates the templates found
+// in root whose names match at least one of the include patterns and none
+// of the exclude patterns, as described by the FindTemplates method. If no
+// include patterns are given, every template with one of the Extensions is
+// generated. Templates that are not generated, such as partials, may still
+// be included by those that are.
+//
+// If any of the templates cannot be generated, the rest are still generated
+// so that the error, an Errors, reports each of them, but no files are
+// written for the package containing them, since it would be incomplete.
+func (g *Generator) GenerateGlob(root, outDir string, include, exclude []string) ([]string, error) {
+	pkgs, err := g.findPackages(root, outDir, include, exclude)
+	if err != nil {
+		return nil, err
+	}
+	loader := NewCachingLoader(g.loader)
+	var written []string
+	var failed Errors
+	for _, pkg := range pkgs {
+		files, err := pkg.gen.writePackage(pkg, loader)
+		written = append(written, files...)
+		if errs, ok := err.(Errors); ok {
+			failed = append(failed, errs...)
+		} else if err != nil {
+			return written, err
+		}
+	}
+	if len(failed) > 0 {
+		return written, failed
+	}
+	return written, nil
+}
+
+// GenerateFiles is like GenerateGlob, but returns the contents of each file
+// that would be written, keyed by path, rather than writing them.
+func (g *Generator) GenerateFiles(root, outDir string, include, exclude []string) (map[string]string, error) {
+	pkgs, err := g.findPackages(root, outDir, include, exclude)
+	if err != nil {
+		return nil, err
+	}
+	loader := NewCachingLoader(g.loader)
+	res := make(map[string]string)
+	var failed Errors
+	for _, pkg := range pkgs {
+		files, err := pkg.gen.generateFiles(pkg.dir, pkg.names, pkg.files, loader)
+		if errs, ok := err.(Errors); ok {
+			failed = append(failed, errs...)
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			res[f.path] = f.src
+		}
+	}
+	if len(failed) > 0 {
+		return nil, failed
+	}
+	return res, nil
+}
+
+// A Package maps the templates in a directory to a package other than the
+// one that GenerateDir or GenerateGlob generates into.
+type Package struct {
+	// Dir is the slash-separated directory containing the templates,
+	// relative to the directory that templates are loaded from. Templates
+	// in its subdirectories are included, unless they are mapped to
+	// another Package.
+	Dir string
+
+	// Out is the directory that the package is generated into.
+	Out string
+
+	// Name is the name of the package. By default, the package is named
+	// after Out.
+	Name string
+}
+
+// A genPackage is a package generated by GenerateGlob.
+type genPackage struct {
+	// gen generates the package, with its name.
+	gen *Generator
+	dir string
+	// prefix is the directory of the package's templates, if the package
+	// is mapped by a Package.
+	prefix string
+	// names contains the names of the package's templates, and files the
+	// path of the file generated for each.
+	names []string
+	files []string
+}
+
+// writePackage generates the templates of a package and writes them to its
+// directory, unless the BuildCache shows they are up to date. If any of the
+// templates cannot be generated, nothing is written.
+func (g *Generator) writePackage(pkg *genPackage, loader stick.Loader) ([]string, error) {
+	options := g.Fingerprint()
+	if g.BuildCache != nil && g.BuildCache.freshDir(pkg.dir, pkg.files, options, loader) {
+		g.log().Debug("up to date", "dir", pkg.dir)
+		return nil, nil
+	}
+	files, err := g.generateFiles(pkg.dir, pkg.names, pkg.files, loader)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(pkg.dir, 0755); err != nil {
+		return nil, err
+	}
+	var written []string
+	for _, f := range files {
+		if err := ioutil.WriteFile(f.path, []byte(f.src), 0644); err != nil {
+			return written, err
+		}
+		written = append(written, f.path)
+		if g.BuildCache != nil && f.digests != nil {
+			g.BuildCache.add(f.path, options, f.digests)
+		}
+	}
+	if g.BuildCache != nil {
+		g.BuildCache.setDir(pkg.dir, pkg.files)
+	}
+	return written, nil
+}
+
+// A generatedFile is a file generated by GenerateGlob.
+type generatedFile struct {
+	path string
+	src  string
+	// digests contains the hash of the source of each template the file
+	// was generated from.
+	digests map[string][sha256.Size]byte
+}
+
+// findPackages returns the packages generated for the templates selected
+// by the given patterns: the package in outDir, followed by those mapped by
+// Packages that contain any templates. Each template belongs to the Package
+// with the longest matching Dir.
+func (g *Generator) findPackages(root, outDir string, include, exclude []string) ([]*genPackage, error) {
+	names, err := g.FindTemplates(root, include, exclude)
+	if err != nil {
+		return nil, err
+	}
+	pkgs := []*genPackage{{gen: g, dir: outDir}}
+	dirs := map[string]string{filepath.Clean(outDir): "."}
+	for _, p := range g.Packages {
+		prefix := path.Clean(p.Dir)
+		if p.Dir == "" || prefix == "." || prefix == ".." || strings.HasPrefix(prefix, "../") || path.IsAbs(prefix) {
+			return nil, fmt.Errorf("stickgen: invalid package directory: %q", p.Dir)
+		}
+		if p.Out == "" {
+			return nil, fmt.Errorf("stickgen: package for %s has no output directory", p.Dir)
+		}
+		if prev, ok := dirs[filepath.Clean(p.Out)]; ok {
+			return nil, fmt.Errorf("stickgen: %s and %s would both be generated in %s", prev, prefix, p.Out)
+		}
+		dirs[filepath.Clean(p.Out)] = prefix
+		name := p.Name
+		if name == "" {
+			name = filepath.Base(p.Out)
+		}
+		if !token.IsIdentifier(name) {
+			return nil, fmt.Errorf("stickgen: invalid package name: %s", name)
+		}
+		c := *g
+		c.pkgName = name
+		pkgs = append(pkgs, &genPackage{gen: &c, dir: p.Out, prefix: prefix})
+	}
+	for _, name := range names {
+		pkg := pkgs[0]
+		for _, p := range pkgs[1:] {
+			if strings.HasPrefix(name, p.prefix+"/") && len(p.prefix) > len(pkg.prefix) {
+				pkg = p
+			}
+		}
+		pkg.names = append(pkg.names, name)
+	}
+	res := []*genPackage{pkgs[0]}
+	for _, pkg := range pkgs[1:] {
+		if len(pkg.names) > 0 {
+			res = append(res, pkg)
+		}
+	}
+	for _, pkg := range res {
+		files := make(map[string]string)
+		for _, name := range pkg.names {
+			file := g.fileName(strings.TrimPrefix(name, pkg.prefix+"/"))
+			if path.Ext(file) != ".go" || strings.HasSuffix(file, "_test.go") || strings.Contains(file, "/") || file == registryFile && g.Registry {
+				return nil, fmt.Errorf("stickgen: invalid file name for %s: %s", name, file)
+			}
+			if prev, ok := files[file]; ok {
+				return nil, fmt.Errorf("stickgen: %s and %s would both be generated as %s", prev, name, file)
+			}
+			files[file] = name
+			pkg.files = append(pkg.files, filepath.Join(pkg.dir, file))
+		}
+	}
+	return res, nil
+}
+
+// generateFiles generates each of the named templates into the
+// corresponding file, preceded by the registry file if Registry is enabled.
+func (g *Generator) generateFiles(outDir string, names, outFiles []string, loader stick.Loader) ([]generatedFile, error) {
+	if err := g.validate(); err != nil {
+		return nil, err
+	}
+	var files []generatedFile
+	var failed Errors
+	if g.Registry {
+		src, err := g.clone().writeRegistry()
+		if err != nil {
+			failed = append(failed, err)
+		}
+		files = append(files, generatedFile{path: filepath.Join(outDir, registryFile), src: src})
+	}
+
+	// Templates are generated concurrently, but the functions they share
+	// are assigned to files in order, so the output does not depend on the
+	// number of jobs.
+	gens := make([]*Generator, len(names))
+	srcs := make([]string, len(names))
+	errs := make([]error, len(names))
+	parallel.For(len(names), g.Jobs, func(i int) {
+		c := g.clone()
+		c.loader = loader
+		c.sharedRegistry = true
+		gens[i], errs[i] = c, c.generateTemplates([]string{names[i]})
+	})
+	emitted := make(map[string]bool)
+	for i, c := range gens {
+		if errs[i] != nil {
+			continue
+		}
+		c.emitted = emitted
+		srcs[i], errs[i] = c.output()
+	}
+	parallel.For(len(names), g.Jobs, func(i int) {
+		if errs[i] == nil {
+			srcs[i], errs[i] = gens[i].format(srcs[i])
+		}
+	})
+	for i, name := range names {
+		if _, ok := errs[i].(*TemplateError); errs[i] != nil && !ok {
+			// Errors formatting the code are not located at a node.
+			errs[i] = &TemplateError{Name: name, Err: errs[i]}
+		}
+		if errs[i] != nil {
+			failed = append(failed, errs[i])
+			continue
+		}
+		files = append(files, generatedFile{path: outFiles[i], src: srcs[i], digests: gens[i].digests})
+	}
+	if len(failed) > 0 {
+		return nil, failed
+	}
+	return files, nil
+}
@@ -0,0 +1,295 @@
+package stickgen_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/tyler-sommer/stick"
+	"github.com/veonik/go-stickgen"
+)
+
+// writeTree writes each of the files, keyed by slash-separated path, into
+// dir.
+func writeTree(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, contents := range files {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// relPaths returns the paths of files relative to dir, in slash-separated
+// form and sorted.
+func relPaths(t *testing.T, dir string, files []string) []string {
+	t.Helper()
+	res := []string{}
+	for _, file := range files {
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res = append(res, filepath.ToSlash(rel))
+	}
+	sort.Strings(res)
+	return res
+}
+
+func TestGenerateDir(t *testing.T) {
+	templates := map[string]string{
+		"layout.twig":             `[{% block content %}{% endblock %}]`,
+		"macros.twig":             `{% macro hello(name) %}Hello, {{ name }}{% endmacro %}`,
+		"index.twig":              `{% extends 'layout.twig' %}{% import 'macros.twig' as m %}{% block content %}{{ m.hello('index') }}{% endblock %}`,
+		"partials/user-card.twig": `{% import 'macros.twig' as m %}{{ m.hello(name) }}`,
+		"README.md":               `not a template`,
+	}
+	tests := []struct {
+		name      string
+		templates map[string]string
+		options   func(g *stickgen.Generator)
+		files     []string
+		err       string
+	}{
+		{
+			name:      "templates",
+			templates: templates,
+			files:     []string{"index_twig.go", "layout_twig.go", "macros_twig.go", "partials_user_card_twig.go"},
+		},
+		{
+			name:      "registry",
+			templates: templates,
+			options:   func(g *stickgen.Generator) { g.Registry = true },
+			files:     []string{"index_twig.go", "layout_twig.go", "macros_twig.go", "partials_user_card_twig.go", "templates.go"},
+		},
+		{
+			name:      "parallel",
+			templates: templates,
+			options:   func(g *stickgen.Generator) { g.Jobs = 4 },
+			files:     []string{"index_twig.go", "layout_twig.go", "macros_twig.go", "partials_user_card_twig.go"},
+		},
+		{
+			name:      "file pattern",
+			templates: templates,
+			options: func(g *stickgen.Generator) {
+				g.FileNaming = stickgen.FilePattern{Pattern: "{{name}}_stickgen.go", Flatten: true, Lowercase: true}
+			},
+			files: []string{"index_stickgen.go", "layout_stickgen.go", "macros_stickgen.go", "partials_user-card_stickgen.go"},
+		},
+		{
+			name:      "subdirectory file names",
+			templates: templates,
+			options:   func(g *stickgen.Generator) { g.FileNaming = stickgen.FilePattern{Pattern: "{{name}}.go"} },
+			err:       "invalid file name for partials/user-card.twig: partials/user-card.go",
+		},
+		{
+			name:      "test file names",
+			templates: map[string]string{"a.twig": `a`},
+			options:   func(g *stickgen.Generator) { g.FileNaming = stickgen.FilePattern{Pattern: "{{name}}_test.go"} },
+			err:       "invalid file name for a.twig: a_test.go",
+		},
+		{
+			name:      "colliding file names",
+			templates: map[string]string{"a-b.twig": `a`, "a_b.twig": `b`},
+			err:       "a-b.twig and a_b.twig would both be generated as a_b_twig.go",
+		},
+	}
+	// The generated packages import this package, so they are built in a
+	// directory within it.
+	dir, err := ioutil.TempDir(".", "_stickgen_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var pkgs []string
+	for i, tt := range tests {
+		root := filepath.Join(dir, "templates", tt.name)
+		pkg := "views" + string(rune('a'+i))
+		out := filepath.Join(dir, pkg)
+		writeTree(t, root, tt.templates)
+		g := stickgen.NewGenerator(pkg, stick.NewFilesystemLoader(root))
+		if tt.options != nil {
+			tt.options(g)
+		}
+		written, err := g.GenerateDir(root, out)
+		checkErr(t, tt.name, "generating", err, tt.err)
+		if err != nil {
+			if len(written) > 0 {
+				t.Errorf("%s: expected no files written, got %v", tt.name, written)
+			}
+			continue
+		}
+		if got := relPaths(t, out, written); !reflect.DeepEqual(got, tt.files) {
+			t.Errorf("%s: expected files %v, got %v", tt.name, tt.files, got)
+		}
+		pkgs = append(pkgs, "./"+pkg)
+	}
+	// Functions shared by the templates, such as macros, are generated only
+	// in the first file that uses them, or the packages would not build.
+	if _, err := exec.LookPath("go"); err != nil || testing.Short() {
+		return
+	}
+	cmd := exec.Command("go", append([]string{"build"}, pkgs...)...)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Errorf("unable to build generated packages:\n%s", out)
+	}
+	for file, want := range map[string]bool{"index_twig.go": true, "partials_user_card_twig.go": false} {
+		b, err := ioutil.ReadFile(filepath.Join(dir, "viewsa", file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(b), "func macroMacrosTwigHello(") != want {
+			t.Errorf("expected the macro to be generated only in the first file using it, got in %s:\n%s", file, b)
+		}
+	}
+}
+
+func TestPackages(t *testing.T) {
+	templates := map[string]string{
+		"layout.twig":             `[{% block content %}{% endblock %}]`,
+		"macros.twig":             `{% macro hello(name) %}Hello, {{ name }}{% endmacro %}`,
+		"index.twig":              `{% extends 'layout.twig' %}{% block content %}index{% endblock %}`,
+		"admin/users.twig":        `{% extends 'layout.twig' %}{% import 'macros.twig' as m %}{% block content %}{{ m.hello(name) }}{% endblock %}`,
+		"admin/reports/list.twig": `{% extends 'layout.twig' %}{% import 'macros.twig' as m %}{% block content %}{{ m.hello('list') }}{% endblock %}`,
+	}
+	dir, err := ioutil.TempDir(".", "_stickgen_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	root := filepath.Join(dir, "templates")
+	writeTree(t, root, templates)
+	g := stickgen.NewGenerator("views", stick.NewFilesystemLoader(root))
+	g.Packages = []stickgen.Package{
+		{Dir: "admin", Out: filepath.Join(dir, "views", "admin")},
+		{Dir: "admin/reports/", Out: filepath.Join(dir, "reports"), Name: "adminreports"},
+	}
+	written, err := g.GenerateDir(root, filepath.Join(dir, "views"))
+	if err != nil {
+		t.Fatalf("unexpected error generating: %s", err)
+	}
+	want := []string{"reports/list_twig.go", "views/admin/users_twig.go", "views/index_twig.go", "views/layout_twig.go", "views/macros_twig.go"}
+	if got := relPaths(t, dir, written); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected files %v, got %v", want, got)
+	}
+	for file, pkg := range map[string]string{"views/index_twig.go": "views", "views/admin/users_twig.go": "admin", "reports/list_twig.go": "adminreports"} {
+		b, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(file)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(b), "\npackage "+pkg+"\n") {
+			t.Errorf("expected %s in package %s, got:\n%s", file, pkg, b)
+		}
+	}
+
+	for _, tt := range []struct {
+		name     string
+		packages []stickgen.Package
+		err      string
+	}{
+		{"no directory", []stickgen.Package{{Out: "x"}}, `invalid package directory: ""`},
+		{"outside root", []stickgen.Package{{Dir: "../admin", Out: "x"}}, `invalid package directory: "../admin"`},
+		{"no output", []stickgen.Package{{Dir: "admin"}}, "package for admin has no output directory"},
+		{"same output", []stickgen.Package{{Dir: "admin", Out: "x"}, {Dir: "admin/reports", Out: "x"}}, "admin and admin/reports would both be generated in x"},
+		{"invalid name", []stickgen.Package{{Dir: "admin", Out: "x", Name: "my-admin"}}, "invalid package name: my-admin"},
+	} {
+		g.Packages = tt.packages
+		_, err := g.GenerateFiles(root, filepath.Join(dir, "other"), nil, nil)
+		checkErr(t, tt.name, "generating", err, tt.err)
+	}
+
+	// Templates shared by the packages, such as the layout and macros, are
+	// generated into each package that uses them, so every package builds.
+	if _, err := exec.LookPath("go"); err != nil || testing.Short() {
+		return
+	}
+	cmd := exec.Command("go", "build", "./views/...", "./reports")
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Errorf("unable to build generated packages:\n%s", out)
+	}
+}
+
+func TestJobs(t *testing.T) {
+	templates := map[string]string{
+		"layout.twig": `[{% block content %}{% endblock %}]`,
+		"macros.twig": `{% macro hello(name) %}Hello, {{ name }}{% endmacro %}`,
+	}
+	var names []string
+	for i := 0; i < 20; i++ {
+		name := fmt.Sprintf("page%02d.twig", i)
+		templates[name] = fmt.Sprintf(`{%% extends 'layout.twig' %%}{%% import 'macros.twig' as m %%}{%% block content %%}{{ m.hello('%d') }}{%% endblock %%}`, i)
+		names = append(names, name)
+	}
+	templates["broken.twig"] = `{% include 'missing.twig' %}`
+	dir, err := ioutil.TempDir("", "stickgen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeTree(t, dir, templates)
+	tests := []struct {
+		name    string
+		jobs    int
+		exclude []string
+		err     string
+	}{
+		{name: "sequential", jobs: 1, exclude: []string{"broken.twig"}},
+		{name: "default", jobs: 0, exclude: []string{"broken.twig"}},
+		{name: "parallel", jobs: 8, exclude: []string{"broken.twig"}},
+		{name: "more jobs than templates", jobs: 64, exclude: []string{"broken.twig"}},
+		{name: "error", jobs: 8, err: "stickgen: broken.twig:1:"},
+	}
+	var want map[string]string
+	for _, tt := range tests {
+		l := &countingLoader{Loader: stick.NewFilesystemLoader(dir), loads: make(map[string]int)}
+		g := stickgen.NewGenerator("views", l)
+		g.Jobs = tt.jobs
+		out := filepath.Join(dir, "views", strconv.Itoa(tt.jobs))
+		if err := os.MkdirAll(out, 0755); err != nil {
+			t.Fatal(err)
+		}
+		written, err := g.GenerateGlob(dir, out, nil, tt.exclude)
+		if l.concurrent {
+			t.Errorf("%s: expected the loader not to be used concurrently", tt.name)
+		}
+		checkErr(t, tt.name, "generating", err, tt.err)
+		if err != nil {
+			continue
+		}
+		files := make(map[string]string)
+		for _, file := range written {
+			b, err := ioutil.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			files[filepath.Base(file)] = string(b)
+		}
+		for name, n := range l.loads {
+			if n != 1 {
+				t.Errorf("%s: expected %s loaded once, got %d", tt.name, name, n)
+			}
+		}
+		// The output does not depend on the number of jobs.
+		if want == nil {
+			want = files
+		} else if !reflect.DeepEqual(files, want) {
+			t.Errorf("%s: expected the same files as %s", tt.name, tests[0].name)
+		}
+	}
+	if len(want) != len(names)+2 {
+		t.Errorf("expected %d files, got %d", len(names)+2, len(want))
+	}
+}
@@ -0,0 +1,601 @@
+package stickgen
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/tyler-sommer/stick/parse"
+)
+
+// Stick's parser does not support every tag Twig provides. Before a template
+// is parsed, tags it does not understand are rewritten into filter tags with
+// a reserved name. When the walker encounters one of these filter tags, it
+// looks up the original tag in the Generator's list of directives.
+
+const directivePrefix = "_stickgen_"
+
+// A directive is a tag that was rewritten by the preprocessor.
+type directive struct {
+	tag  string
+	args string
+}
+
+// A blockTag describes a tag with a body that must be preprocessed.
+type blockTag struct {
+	name string
+	// accept reports whether the tag's arguments are in a form that should
+	// be rewritten.
+	accept func(args string) bool
+}
+
+var blockTags = []blockTag{
+	{"set", isIdentifier},
+	{"with", always},
+	{"spaceless", always},
+	{"autoescape", always},
+	{"apply", always},
+	{"trans", always},
+	{"cache", always},
+}
+
+func always(string) bool {
+	return true
+}
+
+var identifier = regexp.MustCompile(`^[A-Za-z_]\w*$`)
+
+func isIdentifier(s string) bool {
+	return identifier.MatchString(s)
+}
+
+var (
+	tagPattern           = regexp.MustCompile(`(?s)\{%(-?)(\s*)(\w+)(.*?)(-?)%\}`)
+	printPattern         = regexp.MustCompile(`(?s)\{\{(.*?)\}\}`)
+	endVerbatimPattern   = regexp.MustCompile(`\{%(-?)\s*endverbatim\s*(-?)%\}`)
+	shortBlockPattern    = regexp.MustCompile(`(?s)^(\w+)\s+(.+)$`)
+	ignoreMissingPattern = regexp.MustCompile(`(?s)^(.+?)\s+ignore\s+missing\b(.*)$`)
+	cachePattern         = regexp.MustCompile(`(?s)^(.+?)(?:\s+ttl\((.*)\))?$`)
+)
+
+// An edit records a change in length made by the preprocessor.
+type edit struct {
+	end   int // the end of the rewritten tag in the preprocessed source
+	delta int // the number of bytes added by the rewrite
+}
+
+// A sourceMap maps offsets in preprocessed source to the original source.
+type sourceMap []edit
+
+func (m sourceMap) offset(off int) int {
+	res := off
+	for _, e := range m {
+		if e.end > off {
+			break
+		}
+		res -= e.delta
+	}
+	return res
+}
+
+// preprocess rewrites any tags in src that Stick's parser does not support.
+func (g *Generator) preprocess(src string) (string, sourceMap) {
+	var sm sourceMap
+	var open []string
+	out := &strings.Builder{}
+	last := 0
+	replace := func(start, end int, repl string) {
+		orig := src[start:end]
+		// Keep line numbers intact by preserving any newlines in the tag.
+		if n := strings.Count(orig, "\n") - strings.Count(repl, "\n"); n > 0 {
+			i := strings.LastIndex(repl, "%}")
+			for i > 0 && repl[i-1] == '-' {
+				i--
+			}
+			repl = repl[:i] + strings.Repeat("\n", n) + repl[i:]
+		}
+		out.WriteString(src[last:start])
+		out.WriteString(repl)
+		last = end
+		if d := len(repl) - len(orig); d != 0 {
+			sm = append(sm, edit{end: out.Len(), delta: d})
+		}
+	}
+	for pos := 0; pos < len(src); {
+		m := tagPattern.FindStringSubmatchIndex(src[pos:])
+		if p := printPattern.FindStringSubmatchIndex(src[pos:]); p != nil && (m == nil || p[0] < m[0]) {
+			pos += p[1]
+			// Print tags are only rewritten to support named arguments.
+			expr := src[pos-p[1]+p[2] : pos-p[1]+p[3]]
+			if repl := rewriteNamedArgs(expr); repl != expr {
+				replace(pos-p[1]+p[0], pos, "{{"+repl+"}}")
+			}
+			continue
+		}
+		if m == nil {
+			break
+		}
+		for i := range m {
+			m[i] += pos
+		}
+		pos = m[1]
+		start, head, rest, tail := m[0], src[m[0]:m[8]], src[m[8]:m[9]], src[m[9]:m[1]]
+		named := rewriteNamedArgs(rest)
+		lead, name, args := src[m[2]:m[3]], src[m[6]:m[7]], strings.TrimSpace(named)
+		ws, trail := src[m[4]:m[5]], src[m[10]:m[11]]
+		repl := ""
+		if name == "verbatim" {
+			// The contents of a verbatim tag are kept as the directive's
+			// arguments and never parsed.
+			e := endVerbatimPattern.FindStringSubmatchIndex(src[pos:])
+			if e == nil {
+				continue
+			}
+			content := src[pos : pos+e[0]]
+			if trail == "-" {
+				content = strings.TrimLeftFunc(content, unicode.IsSpace)
+			}
+			if src[pos+e[2]:pos+e[3]] == "-" {
+				content = strings.TrimRightFunc(content, unicode.IsSpace)
+			}
+			repl = fmt.Sprintf("{%%%s%sfilter %s%d %s%%}{%%%s endfilter %s%%}", lead, ws, directivePrefix, len(g.directives), trail, src[pos+e[2]:pos+e[3]], src[pos+e[4]:pos+e[5]])
+			g.directives = append(g.directives, directive{tag: name, args: content})
+			pos += e[1]
+		} else if m := shortBlockPattern.FindStringSubmatch(args); m != nil && name == "block" {
+			// The shorthand form is expanded into a block with a body.
+			repl = fmt.Sprintf("{%%%s%sblock %s %%}{{ %s }}{%% endblock %s%%}", lead, ws, m[1], m[2], trail)
+		} else if m := ignoreMissingPattern.FindStringSubmatch(args); m != nil && name == "include" {
+			// Stick does not support ignore missing, so it is attached to
+			// the template name using a reserved filter.
+			repl = fmt.Sprintf("{%%%s%sinclude (%s)|%s%d%s %s%%}", lead, ws, m[1], directivePrefix, len(g.directives), m[2], trail)
+			g.directives = append(g.directives, directive{tag: name, args: "ignore missing"})
+		} else if loop, expr, cond, ok := splitForCondition(args); ok && name == "for" {
+			// The condition is attached to the iterated expression using a
+			// reserved filter.
+			repl = fmt.Sprintf("{%%%s%sfor %s (%s)|%s%d %s%%}", lead, ws, loop, expr, directivePrefix, len(g.directives), trail)
+			g.directives = append(g.directives, directive{tag: name, args: cond})
+		} else if tag, ok := findBlockTag(name); ok && tag.accept(args) {
+			repl = fmt.Sprintf("{%%%s%sfilter %s%d %s%%}", lead, ws, directivePrefix, len(g.directives), trail)
+			g.directives = append(g.directives, directive{tag: name, args: args})
+			open = append(open, name)
+		} else if len(open) > 0 && name == "end"+open[len(open)-1] {
+			repl = fmt.Sprintf("{%%%s%sendfilter %s%%}", lead, ws, trail)
+			open = open[:len(open)-1]
+		} else if named != rest {
+			repl = head + named + tail
+		} else {
+			continue
+		}
+		replace(start, pos, repl)
+	}
+	out.WriteString(src[last:])
+	return out.String(), sm
+}
+
+func findBlockTag(name string) (blockTag, bool) {
+	for _, tag := range blockTags {
+		if tag.name == name {
+			return tag, true
+		}
+	}
+	return blockTag{}, false
+}
+
+// parseExpr parses a single Twig expression.
+func parseExpr(src string) (parse.Expr, error) {
+	tree, err := parse.Parse("{{ " + src + " }}")
+	if err != nil {
+		return nil, err
+	}
+	nodes := tree.Root().All()
+	if len(nodes) != 1 {
+		return nil, fmt.Errorf("stickgen: invalid expression: %s", src)
+	}
+	node, ok := nodes[0].(*parse.PrintNode)
+	if !ok {
+		return nil, fmt.Errorf("stickgen: invalid expression: %s", src)
+	}
+	return node.X, nil
+}
+
+// directive returns the directive a filter tag was rewritten from, if any.
+func (g *Generator) directive(node *parse.FilterNode) (directive, bool) {
+	if len(node.Filters) != 1 {
+		return directive{}, false
+	}
+	return g.lookupDirective(node.Filters[0])
+}
+
+// lookupDirective returns the directive with the given reserved name.
+func (g *Generator) lookupDirective(name string) (directive, bool) {
+	if !strings.HasPrefix(name, directivePrefix) {
+		return directive{}, false
+	}
+	i, err := strconv.Atoi(strings.TrimPrefix(name, directivePrefix))
+	if err != nil || i < 0 || i >= len(g.directives) {
+		return directive{}, false
+	}
+	return g.directives[i], true
+}
+
+func (g *Generator) walkDirective(d directive, node *parse.FilterNode) error {
+	switch d.tag {
+	case "set":
+		return g.walkCapture(d.args, node)
+	case "with":
+		return g.walkWith(d.args, node)
+	case "spaceless":
+		return g.walkSpaceless(node)
+	case "autoescape":
+		return g.walkAutoescape(d.args, node)
+	case "apply":
+		return g.walkApply(d.args, node)
+	case "trans":
+		return g.walkTrans(d.args, node)
+	case "cache":
+		return g.walkCache(d.args, node)
+	case "verbatim":
+		g.out.WriteString(g.comment(node.Pos))
+		g.writeString(strconv.Quote(d.args))
+		return nil
+	}
+	return fmt.Errorf("stickgen: unsupported tag: %s", d.tag)
+}
+
+// walkCapture renders the body of a set tag into a buffer, assigning the
+// result to the named local variable. The body was escaped as it was
+// rendered, so the result is Markup.
+func (g *Generator) walkCapture(name string, node *parse.FilterNode) error {
+	g.addImport("bytes")
+	g.addImport(runtimeImport)
+	g.out.WriteString(g.comment(node.Pos))
+	ident := g.declare(name)
+	g.out.WriteString(fmt.Sprintf(`%s{
+%s	output := &bytes.Buffer{}
+`, g.indent(), g.indent()))
+	g.tabs++
+	if err := g.walkScope(node.Body); err != nil {
+		return err
+	}
+	g.out.WriteString(fmt.Sprintf(`%s%s = stickgen.Markup(output.String())
+`, g.indent(), ident))
+	g.tabs--
+	g.out.WriteString(fmt.Sprintf(`%s}
+`, g.indent()))
+	return nil
+}
+
+// applyName is the name of the local variable holding the captured body of
+// an apply tag.
+const applyName = directivePrefix + "apply"
+
+// walkApply captures the body of an apply or filter tag and prints the
+// result of applying the given filters to it.
+func (g *Generator) walkApply(filters string, node *parse.FilterNode) error {
+	e, err := parseExpr(applyName + "|" + filters)
+	if err != nil {
+		return err
+	}
+	g.out.WriteString(fmt.Sprintf(`%s{
+`, g.indent()))
+	g.tabs++
+	prevArgs, prevEscape := g.args, g.escape
+	defer func() {
+		g.args, g.escape = prevArgs, prevEscape
+	}()
+	g.args = make(map[string]string, len(prevArgs))
+	for k, v := range prevArgs {
+		g.args[k] = v
+	}
+	if err := g.walkCapture(applyName, node); err != nil {
+		return err
+	}
+	// The body has already been escaped.
+	g.escape = ""
+	if err := g.walk(&parse.PrintNode{Pos: node.Pos, X: e}); err != nil {
+		return err
+	}
+	g.tabs--
+	g.out.WriteString(fmt.Sprintf(`%s}
+`, g.indent()))
+	return nil
+}
+
+// walkWith walks the body of a with tag using a new context. The context
+// inherits the current context unless the only keyword is given.
+func (g *Generator) walkWith(args string, node *parse.FilterNode) error {
+	only := false
+	if rest := strings.TrimSuffix(args, "only"); rest != args && (rest == "" || strings.HasSuffix(rest, " ")) {
+		only = true
+		args = strings.TrimSpace(rest)
+	}
+	var vars parse.Expr
+	if args != "" {
+		e, err := parseExpr(args)
+		if err != nil {
+			return err
+		}
+		vars = e
+	}
+	g.out.WriteString(g.comment(node.Pos))
+	return g.withContext(vars, only, func() error {
+		return g.walkScope(node.Body)
+	})
+}
+
+// withContext calls walk to generate code using a new context containing
+// the given variables. The context inherits the current context unless only
+// is true.
+func (g *Generator) withContext(vars parse.Expr, only bool, walk func() error) error {
+	parent := "ctx"
+	if only {
+		parent = "nil"
+	}
+	v := newLiteral("nil")
+	if vars != nil {
+		var err error
+		v, err = g.walkExpr(vars)
+		if err != nil {
+			return err
+		}
+	}
+	g.addImport(runtimeImport)
+	g.out.WriteString(fmt.Sprintf(`%s{
+`, g.indent()))
+	g.tabs++
+	if v.isFunction {
+		g.out.WriteString(fmt.Sprintf(`%s%s
+`, g.indent(), g.handleErr(v)))
+	}
+	g.out.WriteString(fmt.Sprintf(`%sctx := stickgen.NewContext(%s, %s)
+%s_ = ctx
+`, g.indent(), parent, v.resultantName, g.indent()))
+	prevArgs, prevLoop := g.args, g.loop
+	g.args = make(map[string]string, len(prevArgs))
+	if only {
+		g.loop = ""
+	} else {
+		for k, v := range prevArgs {
+			g.args[k] = v
+		}
+		// Local variables are shadowed by variables of the same name.
+		if hash, ok := vars.(*parse.HashExpr); ok {
+			for _, el := range hash.Elements {
+				switch k := el.Key.(type) {
+				case *parse.NameExpr:
+					delete(g.args, k.Name)
+				case *parse.StringExpr:
+					delete(g.args, k.Text)
+				}
+			}
+		}
+	}
+	err := walk()
+	g.args, g.loop = prevArgs, prevLoop
+	if err != nil {
+		return err
+	}
+	g.tabs--
+	g.out.WriteString(fmt.Sprintf(`%s}
+`, g.indent()))
+	return nil
+}
+
+// contextVars returns a Go expression for the current context, including any
+// local variables and the loop variable.
+func (g *Generator) contextVars() string {
+	if len(g.args) == 0 && g.loop == "" {
+		return "ctx"
+	}
+	var names []string
+	for name := range g.args {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	vars := ""
+	for _, name := range names {
+		vars += fmt.Sprintf("%s: %s, ", strconv.Quote(name), g.args[name])
+	}
+	if g.loop != "" {
+		vars += fmt.Sprintf(`"loop": %s, `, g.loop)
+	}
+	g.addImport(runtimeImport)
+	return fmt.Sprintf("stickgen.NewContext(ctx, map[string]stick.Value{%s})", strings.TrimSuffix(vars, ", "))
+}
+
+// walkSpaceless removes whitespace between HTML tags in the body of a
+// spaceless tag. Bodies containing only text are handled at generation time,
+// otherwise output is filtered as it is written.
+func (g *Generator) walkSpaceless(node *parse.FilterNode) error {
+	text := ""
+	for _, child := range node.Body.All() {
+		t, ok := child.(*parse.TextNode)
+		if !ok {
+			text = ""
+			break
+		}
+		text += t.Data
+	}
+	if text != "" {
+		g.out.WriteString(g.comment(node.Pos))
+		g.writeString(strconv.Quote(Spaceless(text)))
+		return nil
+	}
+	if len(node.Body.All()) == 0 {
+		return nil
+	}
+	g.addImport(runtimeImport)
+	g.out.WriteString(g.comment(node.Pos))
+	g.out.WriteString(fmt.Sprintf(`%s{
+%s	output := stickgen.NewSpacelessWriter(output)
+`, g.indent(), g.indent()))
+	g.tabs++
+	if err := g.walkScope(node.Body); err != nil {
+		return err
+	}
+	g.tabs--
+	g.out.WriteString(fmt.Sprintf(`%s}
+`, g.indent()))
+	return nil
+}
+
+// autoescapeStrategy returns the escaping strategy given as the arguments to
+// an autoescape tag. An empty strategy disables escaping.
+func (g *Generator) autoescapeStrategy(args string) (string, error) {
+	strategy := "html"
+	if args != "" {
+		e, err := parseExpr(args)
+		if err != nil {
+			return "", err
+		}
+		switch v, _ := g.constant(e); v := v.(type) {
+		case string:
+			strategy = v
+		case bool:
+			if !v {
+				strategy = ""
+			}
+		default:
+			return "", fmt.Errorf("stickgen: invalid autoescape strategy: %s", args)
+		}
+	}
+	if strategy != "" && !isEscapeStrategy(strategy) {
+		return "", fmt.Errorf("stickgen: unsupported autoescape strategy: %s", strategy)
+	}
+	return strategy, nil
+}
+
+// walkAutoescape walks the body of an autoescape tag using the given
+// escaping strategy.
+func (g *Generator) walkAutoescape(args string, node *parse.FilterNode) error {
+	strategy, err := g.autoescapeStrategy(args)
+	if err != nil {
+		return err
+	}
+	prevEscape := g.escape
+	g.escape = strategy
+	defer func() {
+		g.escape = prevEscape
+	}()
+	return g.walkScope(node.Body)
+}
+
+// walkTrans generates a call to Translate for the body of a trans tag. Any
+// variables printed in the body are replaced with %name% placeholders.
+func (g *Generator) walkTrans(args string, node *parse.FilterNode) error {
+	if args != "" {
+		return fmt.Errorf("stickgen: unsupported trans arguments: %s", args)
+	}
+	message := ""
+	for _, child := range node.Body.All() {
+		switch n := child.(type) {
+		case *parse.TextNode:
+			message += n.Data
+			continue
+		case *parse.PrintNode:
+			if name, ok := n.X.(*parse.NameExpr); ok {
+				message += "%" + name.Name + "%"
+				continue
+			}
+		}
+		return fmt.Errorf("stickgen: trans tags may only contain text and variables")
+	}
+	var names []string
+	seen := make(map[string]bool)
+	for _, p := range placeholderPattern.FindAllString(message, -1) {
+		if name := p[1 : len(p)-1]; !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	vars := ""
+	for _, name := range names {
+		v, err := g.walkExpr(&parse.NameExpr{Name: name})
+		if err != nil {
+			return err
+		}
+		val := v.resultantName
+		if g.escape != "" {
+			val = fmt.Sprintf("stickgen.Escape(%s, %s)", val, strconv.Quote(g.escape))
+		}
+		vars += fmt.Sprintf("%s: %s, ", strconv.Quote(name), val)
+	}
+	g.addImport(runtimeImport)
+	g.out.WriteString(g.comment(node.Pos))
+	g.writeString(fmt.Sprintf("stickgen.Translate(%s, %s, map[string]stick.Value{%s})", g.funcContext(), strconv.Quote(message), strings.TrimSuffix(vars, ", ")))
+	return nil
+}
+
+// walkCache generates the body of a cache tag as a function that is only
+// called when the fragment is not in the Cache registered with the Env.
+func (g *Generator) walkCache(args string, node *parse.FilterNode) error {
+	m := cachePattern.FindStringSubmatch(args)
+	if m == nil {
+		return fmt.Errorf("stickgen: cache expects a key")
+	}
+	e, err := parseExpr(m[1])
+	if err != nil {
+		return err
+	}
+	key, err := g.walkExpr(e)
+	if err != nil {
+		return err
+	}
+	ttl := newLiteral("0")
+	if m[2] != "" {
+		e, err := parseExpr(m[2])
+		if err != nil {
+			return err
+		}
+		if ttl, err = g.walkExpr(e); err != nil {
+			return err
+		}
+		if ttl.isFunction {
+			return fmt.Errorf("stickgen: unsupported cache ttl: %s", m[2])
+		}
+		ttl.resultantName = "stick.CoerceNumber(" + ttl.resultantName + ")"
+	}
+	g.addImport(runtimeImport)
+	g.out.WriteString(g.comment(node.Pos))
+	if key.isFunction {
+		g.out.WriteString(fmt.Sprintf(`%s{
+%s	%s
+`, g.indent(), g.indent(), g.handleErr(key)))
+		g.tabs++
+	}
+	cached := "stickgen.Cached("
+	if !g.IgnoreErrors {
+		cached = "if err := " + cached
+	}
+	g.out.WriteString(fmt.Sprintf(`%s%s%s, output, stick.CoerceString(%s), %s, func(output io.Writer) error {
+`, g.indent(), cached, g.funcContext(), key.resultantName, ttl.resultantName))
+	g.tabs++
+	prevRet := g.ret
+	g.ret = "return err"
+	err = g.walkScope(node.Body)
+	g.ret = prevRet
+	if err != nil {
+		return err
+	}
+	g.out.WriteString(fmt.Sprintf(`%sreturn nil
+`, g.indent()))
+	g.tabs--
+	if g.IgnoreErrors {
+		g.out.WriteString(fmt.Sprintf(`%s})
+`, g.indent()))
+	} else {
+		g.out.WriteString(fmt.Sprintf(`%s}); err != nil {
+%s	%s
+%s}
+`, g.indent(), g.indent(), g.ret, g.indent()))
+	}
+	if key.isFunction {
+		g.tabs--
+		g.out.WriteString(fmt.Sprintf(`%s}
+`, g.indent()))
+	}
+	return nil
+}
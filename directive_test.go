@@ -0,0 +1,467 @@
+package stickgen_test
+
+import (
+	"testing"
+
+	"github.com/veonik/go-stickgen"
+)
+
+func TestCache(t *testing.T) {
+	count := `n := 0
+	env.Functions["count"] = func(ctx stick.Context, args ...stick.Value) stick.Value {
+		n++
+		return n
+	}`
+	cache := count + `
+	env.Functions["cache"] = stickgen.NewCacheFunc(stickgen.NewMemoryCache())`
+	twice := `err := TemplateTestTwig(env, output, ctx)
+	if err == nil {
+		err = TemplateTestTwig(env, output, ctx)
+	}`
+	imports := []string{"github.com/veonik/go-stickgen"}
+	testRender(t, []renderTest{
+		{
+			name:      "hit",
+			templates: map[string]string{"test.twig": `{% cache "k" %}{{ count() }}{% endcache %}`},
+			setup:     cache,
+			imports:   imports,
+			call:      twice,
+			want:      "11",
+		},
+		{
+			name:      "ttl",
+			templates: map[string]string{"test.twig": `{% cache "k" ttl(60) %}{{ count() }}{% endcache %}`},
+			setup:     cache,
+			imports:   imports,
+			call:      twice,
+			want:      "11",
+		},
+		{
+			name:      "ttl in key",
+			templates: map[string]string{"test.twig": `{% cache "k" ttl(60) %}{{ count() }}{% endcache %}{% cache "k" ttl(300) %}{{ count() }}{% endcache %}`},
+			setup:     cache,
+			imports:   imports,
+			call:      twice,
+			want:      "1212",
+		},
+		{
+			name:      "dynamic key",
+			templates: map[string]string{"test.twig": `{% for k in keys %}{% cache k %}{{ count() }}{% endcache %}{% endfor %}`},
+			ctx:       `map[string]stick.Value{"keys": []stick.Value{"a", "b", "a"}}`,
+			setup:     cache,
+			imports:   imports,
+			want:      "121",
+		},
+		{
+			name:      "no cache",
+			templates: map[string]string{"test.twig": `{% cache "k" %}{{ count() }}{% endcache %}`},
+			setup:     count,
+			call:      twice,
+			want:      "12",
+		},
+		{
+			name:      "no key",
+			templates: map[string]string{"test.twig": `{% cache %}x{% endcache %}`},
+			err:       "cache expects a key",
+		},
+		{
+			name:      "dynamic ttl",
+			templates: map[string]string{"test.twig": `{% cache "k" ttl(count()) %}x{% endcache %}`},
+			err:       "unsupported cache ttl",
+		},
+	})
+}
+
+func TestTrans(t *testing.T) {
+	french := `env.Filters["trans"] = func(ctx stick.Context, val stick.Value, args ...stick.Value) stick.Value {
+		name, _ := ctx.Scope().Get("name")
+		return strings.Replace(stick.CoerceString(val), "Hello", "Bonjour", 1) + fmt.Sprintf(" (%s, %s)", ctx.Name(), name)
+	}`
+	testRender(t, []renderTest{
+		{
+			name:      "untranslated",
+			templates: map[string]string{"test.twig": `{% trans %}Hello {{ name }}{% endtrans %}`},
+			ctx:       `map[string]stick.Value{"name": "Bob"}`,
+			want:      "Hello Bob",
+		},
+		{
+			name:      "translated",
+			templates: map[string]string{"test.twig": `{% trans %}Hello {{ name }}{% endtrans %}`},
+			ctx:       `map[string]stick.Value{"name": "Bob"}`,
+			setup:     french,
+			imports:   []string{"strings"},
+			want:      "Bonjour Bob (test.twig, Bob)",
+		},
+		{
+			name:      "local variable",
+			templates: map[string]string{"test.twig": `{% set name = 'Ann' %}{% trans %}Hello {{ name }}{% endtrans %}`},
+			setup:     french,
+			imports:   []string{"strings"},
+			want:      "Bonjour Ann (test.twig, Ann)",
+		},
+		{
+			name:      "context",
+			templates: map[string]string{"test.twig": `{% trans %}Hello{% endtrans %}`},
+			options: func(g *stickgen.Generator) {
+				g.Context = true
+			},
+			setup: `goctx := context.WithValue(context.Background(), "lang", "fr")
+	env.Filters["trans"] = func(ctx stick.Context, val stick.Value, args ...stick.Value) stick.Value {
+		return stickgen.ContextOf(ctx).Value("lang")
+	}`,
+			imports: []string{"context", "github.com/veonik/go-stickgen"},
+			call:    "err := TemplateTestTwig(goctx, env, output, ctx)",
+			want:    "fr",
+		},
+		{
+			name:      "escaped",
+			templates: map[string]string{"test.twig": `{% autoescape 'html' %}{% trans %}Hello {{ name }}{% endtrans %}{% endautoescape %}`},
+			ctx:       `map[string]stick.Value{"name": "<b>"}`,
+			want:      "Hello &lt;b&gt;",
+		},
+		{
+			name:      "placeholders",
+			templates: map[string]string{"test.twig": `{% trans %}%greeting% {{ name }}, %greeting%{% endtrans %}`},
+			ctx:       `map[string]stick.Value{"name": "Bob", "greeting": "Hi"}`,
+			want:      "Hi Bob, Hi",
+		},
+		{
+			name:      "translator",
+			templates: map[string]string{"test.twig": `{% for name in ['Ann', 'Bob'] %}{% trans %}Hello {{ name }}{% endtrans %};{% endfor %}`},
+			setup:     `env.Filters["trans"] = stickgen.NewTransFilter(translator{"Hello %name%": "%name%, bonjour"})`,
+			imports:   []string{"github.com/veonik/go-stickgen"},
+			decls: `type translator map[string]string
+
+func (t translator) Translate(message string) string {
+	if s, ok := t[message]; ok {
+		return s
+	}
+	return message
+}`,
+			want: "Ann, bonjour;Bob, bonjour;",
+		},
+		{
+			name:      "expression",
+			templates: map[string]string{"test.twig": `{% trans %}Hello {{ user.name }}{% endtrans %}`},
+			err:       "trans tags may only contain text and variables",
+		},
+		{
+			name:      "arguments",
+			templates: map[string]string{"test.twig": `{% trans with vars %}Hello{% endtrans %}`},
+			err:       "unsupported trans arguments",
+		},
+	})
+}
+
+func TestCapture(t *testing.T) {
+	testRender(t, []renderTest{
+		{
+			name:      "capture",
+			templates: map[string]string{"test.twig": `{% set x %}Hello {{ name }}{% endset %}[{{ x }}]`},
+			ctx:       `map[string]stick.Value{"name": "Bob"}`,
+			want:      "[Hello Bob]",
+		},
+		{
+			name:      "loop",
+			templates: map[string]string{"test.twig": `{% set x %}{% for i in items %}{{ i }},{% endfor %}{% endset %}{{ x }}{{ x }}`},
+			ctx:       `map[string]stick.Value{"items": []stick.Value{1, 2, 3}}`,
+			want:      "1,2,3,1,2,3,",
+		},
+		{
+			name:      "nested",
+			templates: map[string]string{"test.twig": `{% set x %}a{% set y %}b{% endset %}{{ y }}{% endset %}{{ x }}{{ y }}`},
+			ctx:       `map[string]stick.Value{"y": "-"}`,
+			want:      "ab-",
+		},
+		{
+			name:      "escaped once",
+			templates: map[string]string{"test.twig": `{% autoescape 'html' %}{% set x %}<i>{{ html }}</i>{% endset %}{{ x }}{% endautoescape %}`},
+			ctx:       `map[string]stick.Value{"html": "<b>"}`,
+			want:      "<i>&lt;b&gt;</i>",
+		},
+		{
+			name:      "concatenated escapes",
+			templates: map[string]string{"test.twig": `{% autoescape 'html' %}{% set x %}<i>{% endset %}{{ x ~ '' }}{% endautoescape %}`},
+			want:      "&lt;i&gt;",
+		},
+		{
+			name:      "empty is falsy",
+			templates: map[string]string{"test.twig": `{% set x %}{% endset %}{% set y %}y{% endset %}{% if x %}x{% endif %}{% if y %}y{% endif %}`},
+			want:      "y",
+		},
+		{
+			name:      "named like generated variables",
+			templates: map[string]string{"test.twig": `{% set output %}a{% endset %}{% set type %}b{% endset %}{{ output }}{{ type }}`},
+			want:      "ab",
+		},
+		{
+			name:      "unclosed",
+			templates: map[string]string{"test.twig": `{% set x %}a`},
+			err:       "endfilter",
+		},
+	})
+}
+
+func TestWith(t *testing.T) {
+	testRender(t, []renderTest{
+		{
+			name:      "with",
+			templates: map[string]string{"test.twig": `{% with {'a': 1, b: 'x'} %}{{ a }}{{ b }}{{ name }}{% endwith %}`},
+			ctx:       `map[string]stick.Value{"name": "!"}`,
+			want:      "1x!",
+		},
+		{
+			name:      "only",
+			templates: map[string]string{"test.twig": `{% with {'a': 1} only %}{{ a }}{% if name is defined %}!{% endif %}{% endwith %}`},
+			ctx:       `map[string]stick.Value{"name": "!"}`,
+			want:      "1",
+		},
+		{
+			name:      "no variables",
+			templates: map[string]string{"test.twig": `{% with %}{{ name }}{% endwith %}`},
+			ctx:       `map[string]stick.Value{"name": "!"}`,
+			want:      "!",
+		},
+		{
+			name:      "expression",
+			templates: map[string]string{"test.twig": `{% with vars %}{{ a }}{% endwith %}`},
+			ctx:       `map[string]stick.Value{"vars": map[string]stick.Value{"a": "x"}}`,
+			want:      "x",
+		},
+		{
+			name:      "shadows local",
+			templates: map[string]string{"test.twig": `{% set a = 'out' %}{% with {'a': 'in'} %}{{ a }}{% endwith %}{{ a }}`},
+			want:      "inout",
+		},
+		{
+			name:      "set is scoped",
+			templates: map[string]string{"test.twig": `{% with %}{% set a = 'in' %}{{ a }}{% endwith %}{{ a }}`},
+			ctx:       `map[string]stick.Value{"a": "out"}`,
+			want:      "inout",
+		},
+		{
+			name:      "loop",
+			templates: map[string]string{"test.twig": `{% for i in [1, 2] %}{% with {'a': i * 10} %}{{ a }}{{ i }}{{ loop.index }},{% endwith %}{% endfor %}`},
+			want:      "1011,2022,",
+		},
+		{
+			name:      "only hides loop",
+			templates: map[string]string{"test.twig": `{% for i in [1] %}{% with {'a': 1} only %}{% if i is defined %}!{% endif %}{{ a }}{% endwith %}{% endfor %}`},
+			want:      "1",
+		},
+		{
+			name:      "invalid",
+			templates: map[string]string{"test.twig": `{% with {'a': } %}{% endwith %}`},
+			err:       "unexpected token",
+		},
+	})
+}
+
+func TestVerbatim(t *testing.T) {
+	testRender(t, []renderTest{
+		{
+			name:      "verbatim",
+			templates: map[string]string{"test.twig": `{% verbatim %}{{ name }}{% if x %}{% endverbatim %}`},
+			want:      "{{ name }}{% if x %}",
+		},
+		{
+			name:      "surrounded",
+			templates: map[string]string{"test.twig": `{{ a }}{% verbatim %}<{{ a }}>{% endverbatim %}{{ a }}`},
+			ctx:       `map[string]stick.Value{"a": "!"}`,
+			want:      "!<{{ a }}>!",
+		},
+		{
+			name:      "whitespace control",
+			templates: map[string]string{"test.twig": "{% verbatim -%}\n  {{ a }}\n{%- endverbatim %}"},
+			want:      "{{ a }}",
+		},
+		{
+			name:      "quotes",
+			templates: map[string]string{"test.twig": "{% verbatim %}\"`\\{% endverbatim %}"},
+			want:      "\"`\\",
+		},
+		{
+			name:      "multiline",
+			templates: map[string]string{"test.twig": "{% verbatim %}\n{{ a }}\n{% endverbatim %}{{ a }}"},
+			ctx:       `map[string]stick.Value{"a": "!"}`,
+			want:      "\n{{ a }}\n!",
+		},
+		{
+			name:      "not escaped",
+			templates: map[string]string{"test.twig": `{% autoescape 'html' %}{% verbatim %}<b>{% endverbatim %}{% endautoescape %}`},
+			want:      "<b>",
+		},
+		{
+			name:      "unclosed",
+			templates: map[string]string{"test.twig": `{% verbatim %}{{ a }}`},
+			err:       "verbatim",
+		},
+	})
+}
+
+func TestSpacelessTag(t *testing.T) {
+	testRender(t, []renderTest{
+		{
+			name:      "static",
+			templates: map[string]string{"test.twig": "{% spaceless %}\n<div>\n  <b>a  b</b>\n</div>\n{% endspaceless %}"},
+			want:      "<div><b>a  b</b></div>",
+		},
+		{
+			name:      "dynamic",
+			templates: map[string]string{"test.twig": "{% spaceless %}\n<ul>\n{% for i in [1, 2] %}  <li>{{ i }}</li>\n{% endfor %}</ul>\n{% endspaceless %}"},
+			want:      "<ul><li>1</li><li>2</li></ul>",
+		},
+		{
+			name:      "whitespace in values",
+			templates: map[string]string{"test.twig": `{% spaceless %}<p>{{ a }}</p> {{ b }} <p></p>{% endspaceless %}`},
+			ctx:       `map[string]stick.Value{"a": "x  y", "b": "<i> </i>"}`,
+			want:      "<p>x  y</p><i></i><p></p>",
+		},
+		{
+			name:      "text between tags",
+			templates: map[string]string{"test.twig": `{% spaceless %}<b>{{ a }}</b> and <b>{{ a }}</b>{% endspaceless %}`},
+			ctx:       `map[string]stick.Value{"a": "!"}`,
+			want:      "<b>!</b> and <b>!</b>",
+		},
+		{
+			name:      "surrounding output",
+			templates: map[string]string{"test.twig": "<p>\n{% spaceless %} <b>{{ a }}</b> {% endspaceless %}\n</p>"},
+			ctx:       `map[string]stick.Value{"a": "!"}`,
+			want:      "<p>\n<b>!</b>\n</p>",
+		},
+		{
+			name:      "empty",
+			templates: map[string]string{"test.twig": `a{% spaceless %}{% endspaceless %}b`},
+			want:      "ab",
+		},
+		{
+			name:      "unclosed",
+			templates: map[string]string{"test.twig": `{% spaceless %}<b></b>`},
+			err:       "endfilter",
+		},
+	})
+}
+
+func TestAutoescape(t *testing.T) {
+	ctx := `map[string]stick.Value{"s": "<a b>"}`
+	testRender(t, []renderTest{
+		{
+			name:      "default strategy",
+			templates: map[string]string{"test.twig": `{% autoescape %}{{ s }}{% endautoescape %}{{ s }}`},
+			ctx:       ctx,
+			want:      "&lt;a b&gt;<a b>",
+		},
+		{
+			name:      "html",
+			templates: map[string]string{"test.twig": `{% autoescape 'html' %}<p>{{ s }}</p>{% endautoescape %}`},
+			ctx:       ctx,
+			want:      "<p>&lt;a b&gt;</p>",
+		},
+		{
+			name:      "js",
+			templates: map[string]string{"test.twig": `{% autoescape 'js' %}{{ s }}{% endautoescape %}`},
+			ctx:       ctx,
+			want:      `\u003Ca\u0020b\u003E`,
+		},
+		{
+			name:      "url",
+			templates: map[string]string{"test.twig": `{% autoescape 'url' %}{{ s }}{% endautoescape %}`},
+			ctx:       ctx,
+			want:      "%3Ca%20b%3E",
+		},
+		{
+			name:      "url invalid utf-8",
+			templates: map[string]string{"test.twig": `{% autoescape 'url' %}{{ s }}|{{ t }}{% endautoescape %}`},
+			ctx:       `map[string]stick.Value{"s": "a\xff", "t": "a\xffb\xc3"}`,
+			want:      "a%FF|a%FFb%C3",
+		},
+		{
+			name:      "false",
+			templates: map[string]string{"test.twig": `{% autoescape 'html' %}{% autoescape false %}{{ s }}{% endautoescape %}{{ s }}{% endautoescape %}`},
+			ctx:       ctx,
+			want:      "<a b>&lt;a b&gt;",
+		},
+		{
+			name:      "nested",
+			templates: map[string]string{"test.twig": `{% autoescape 'js' %}{% autoescape 'html' %}{{ s }}{% endautoescape %}|{{ s }}{% endautoescape %}`},
+			ctx:       ctx,
+			want:      `&lt;a b&gt;|\u003Ca\u0020b\u003E`,
+		},
+		{
+			name:      "raw",
+			templates: map[string]string{"test.twig": `{% autoescape %}{{ s|raw }}{% endautoescape %}`},
+			ctx:       ctx,
+			want:      "<a b>",
+		},
+		{
+			name:      "loop",
+			templates: map[string]string{"test.twig": `{% autoescape %}{% for i in ['<', '>'] %}{{ i }}{% endfor %}{% endautoescape %}`},
+			want:      "&lt;&gt;",
+		},
+		{
+			name:      "unsupported strategy",
+			templates: map[string]string{"test.twig": `{% autoescape 'xml' %}{% endautoescape %}`},
+			err:       "unsupported autoescape strategy: xml",
+		},
+		{
+			name:      "invalid strategy",
+			templates: map[string]string{"test.twig": `{% autoescape strategy %}{% endautoescape %}`},
+			err:       "invalid autoescape strategy: strategy",
+		},
+	})
+}
+
+func TestApply(t *testing.T) {
+	filters := `env.Filters["upper"] = func(ctx stick.Context, val stick.Value, args ...stick.Value) stick.Value {
+		return strings.ToUpper(stick.CoerceString(val))
+	}
+	env.Filters["wrap"] = func(ctx stick.Context, val stick.Value, args ...stick.Value) stick.Value {
+		return stick.CoerceString(args[0]) + stick.CoerceString(val) + stick.CoerceString(args[1])
+	}`
+	imports := []string{"strings"}
+	testRender(t, []renderTest{
+		{
+			name:      "apply",
+			templates: map[string]string{"test.twig": `{% apply upper %}Hello {{ name }}{% endapply %}!`},
+			ctx:       `map[string]stick.Value{"name": "bob"}`,
+			setup:     filters,
+			imports:   imports,
+			want:      "HELLO BOB!",
+		},
+		{
+			name:      "chain",
+			templates: map[string]string{"test.twig": "{% apply spaceless|upper %}\n<b> a </b> <i></i>\n{% endapply %}"},
+			setup:     filters,
+			imports:   imports,
+			want:      "<B> A </B><I></I>",
+		},
+		{
+			name:      "arguments",
+			templates: map[string]string{"test.twig": `{% apply wrap('[', end) %}{{ name }}{% endapply %}`},
+			ctx:       `map[string]stick.Value{"name": "a", "end": "]"}`,
+			setup:     filters,
+			imports:   imports,
+			want:      "[a]",
+		},
+		{
+			name:      "loop",
+			templates: map[string]string{"test.twig": `{% for i in ['a', 'b'] %}{% apply upper %}{{ i }}{{ loop.index }}{% endapply %}{% endfor %}`},
+			setup:     filters,
+			imports:   imports,
+			want:      "A1B2",
+		},
+		{
+			name:      "escaped once",
+			templates: map[string]string{"test.twig": `{% autoescape 'html' %}{% apply wrap('<i>', '</i>') %}{{ s }}{% endapply %}{% endautoescape %}`},
+			ctx:       `map[string]stick.Value{"s": "<b>"}`,
+			setup:     filters,
+			imports:   imports,
+			want:      "<i>&lt;b&gt;</i>",
+		},
+		{
+			name:      "invalid filter",
+			templates: map[string]string{"test.twig": `{% apply upper( %}a{% endapply %}`},
+			err:       "unexpected token",
+		},
+	})
+}
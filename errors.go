@@ -0,0 +1,93 @@
+package stickgen
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/tyler-sommer/stick/parse"
+)
+
+// A TemplateError is an error generating a template. It is located at the
+// node being generated when the error occurred, which may be in a template
+// included, extended or imported by the one being generated.
+type TemplateError struct {
+	// Name is the name of the template containing the node.
+	Name string
+
+	// Line and Column are the 1-based position of the node in its template.
+	// They are zero if the error did not occur at a node, such as when the
+	// template cannot be loaded or parsed.
+	Line   int
+	Column int
+
+	// Err is the underlying error.
+	Err error
+}
+
+func (e *TemplateError) Error() string {
+	msg := strings.TrimPrefix(e.Err.Error(), "stickgen: ")
+	if e.Name == "" {
+		return "stickgen: " + msg
+	}
+	loc := e.Name
+	if e.Line > 0 {
+		loc += fmt.Sprintf(":%d:%d", e.Line, e.Column)
+	}
+	return fmt.Sprintf("stickgen: %s: %s", loc, msg)
+}
+
+// Unwrap returns the underlying error.
+func (e *TemplateError) Unwrap() error {
+	return e.Err
+}
+
+// Errors contains an error for each template that could not be generated
+// when generating more than one. The rest of the templates are still
+// generated after one fails, so that every error is reported at once.
+type Errors []error
+
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Unwrap returns the errors, for use with errors.Is and errors.As.
+func (e Errors) Unwrap() []error {
+	return e
+}
+
+// A location is the position of a node in a template.
+type location struct {
+	name string
+	pos  parse.Pos
+}
+
+// templateError returns err as a TemplateError located at the node being
+// generated, unless it already is one. If no node is being generated, the
+// error is attributed to the named template.
+func (g *Generator) templateError(name string, err error) error {
+	if _, ok := err.(*TemplateError); ok {
+		return err
+	}
+	if g.at.name == "" {
+		return &TemplateError{Name: name, Err: err}
+	}
+	line, col := g.position(g.at)
+	return &TemplateError{Name: g.at.name, Line: line, Column: col, Err: err}
+}
+
+// position returns the line and column of a location in the original
+// source of its template.
+func (g *Generator) position(at location) (int, int) {
+	src := g.texts[at.name]
+	off := g.sources[at.name].offset(at.pos.Offset)
+	if off < 0 || off > len(src) {
+		return at.pos.Line, 0
+	}
+	start := strings.LastIndex(src[:off], "\n") + 1
+	return at.pos.Line, utf8.RuneCountInString(src[start:off]) + 1
+}
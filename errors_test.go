@@ -0,0 +1,122 @@
+package stickgen_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tyler-sommer/stick"
+	"github.com/veonik/go-stickgen"
+)
+
+var brokenTemplates = map[string]string{
+	"index.twig":  `index`,
+	"broken.twig": `{% if %}`,
+	"users.twig":  "Users\n  {{ x }}{% include 'missing.twig' %}",
+	"layout.twig": "{% block content %}{% endblock %}",
+	"page.twig":   "{% extends 'layout.twig' %}\n{% block content %}\n{% include name %}{% endblock %}",
+}
+
+func TestTemplateError(t *testing.T) {
+	tests := []struct {
+		name     string
+		tplName  string
+		line     int
+		contains string
+	}{
+		{"syntax error", "broken.twig", 0, "stickgen: broken.twig: "},
+		{"missing include", "users.twig", 2, "stickgen: users.twig:2:"},
+	}
+	g := stickgen.NewGenerator("views", &stick.MemoryLoader{Templates: brokenTemplates})
+	for _, tt := range tests {
+		_, err := g.Generate(tt.tplName)
+		var terr *stickgen.TemplateError
+		if !errors.As(err, &terr) {
+			t.Errorf("%s: expected a TemplateError, got %v", tt.name, err)
+			continue
+		}
+		if terr.Name != tt.tplName || terr.Line != tt.line || (terr.Line > 0) != (terr.Column > 0) {
+			t.Errorf("%s: expected an error in %s on line %d, got %s:%d:%d", tt.name, tt.tplName, tt.line, terr.Name, terr.Line, terr.Column)
+		}
+		if !strings.Contains(err.Error(), tt.contains) {
+			t.Errorf("%s: expected an error containing %q, got %q", tt.name, tt.contains, err)
+		}
+	}
+}
+
+func TestTemplateErrorColumn(t *testing.T) {
+	g := stickgen.NewGenerator("views", &stick.MemoryLoader{Templates: map[string]string{
+		"test.twig": "a\nbé{% include 'missing.twig' %}",
+	}})
+	_, err := g.Generate("test.twig")
+	var terr *stickgen.TemplateError
+	if !errors.As(err, &terr) {
+		t.Fatalf("expected a TemplateError, got %v", err)
+	}
+	// Columns count characters, and the include is located at or after its
+	// opening delimiter.
+	if terr.Line != 2 || terr.Column < 3 {
+		t.Errorf("expected an error at line 2, column 3 or later, got %d:%d", terr.Line, terr.Column)
+	}
+}
+
+func TestGenerateAllErrors(t *testing.T) {
+	g := stickgen.NewGenerator("views", &stick.MemoryLoader{Templates: brokenTemplates})
+	_, err := g.GenerateAll("index.twig", "broken.twig", "users.twig")
+	var errs stickgen.Errors
+	if !errors.As(err, &errs) {
+		t.Fatalf("expected Errors, got %v", err)
+	}
+	if len(errs) != 2 {
+		t.Fatalf("expected 2 errors, got %d: %v", len(errs), errs)
+	}
+	for i, name := range []string{"broken.twig", "users.twig"} {
+		var terr *stickgen.TemplateError
+		if !errors.As(errs[i], &terr) || terr.Name != name {
+			t.Errorf("expected an error in %s, got %v", name, errs[i])
+		}
+	}
+	if _, err := g.GenerateAll("index.twig"); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func TestGenerateGlobErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stickgen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	root := filepath.Join(dir, "templates")
+	writeTree(t, root, brokenTemplates)
+	out := filepath.Join(dir, "views")
+	g := stickgen.NewGenerator("views", stick.NewFilesystemLoader(root))
+	g.Jobs = 2
+	files, err := g.GenerateGlob(root, out, nil, nil)
+	if len(files) != 0 {
+		t.Errorf("expected no files to be written, got %v", files)
+	}
+	var errs stickgen.Errors
+	if !errors.As(err, &errs) {
+		t.Fatalf("expected Errors, got %v", err)
+	}
+	names := map[string]bool{}
+	for _, err := range errs {
+		var terr *stickgen.TemplateError
+		if errors.As(err, &terr) {
+			names[terr.Name] = true
+		}
+	}
+	// Errors in blocks are located in the template defining the block.
+	for _, name := range []string{"broken.twig", "users.twig", "page.twig"} {
+		if !names[name] {
+			t.Errorf("expected an error in %s, got %v", name, errs)
+		}
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Errorf("expected nothing to be written, got %v", err)
+	}
+}
@@ -0,0 +1,96 @@
+package stickgen
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/tyler-sommer/stick"
+	"github.com/tyler-sommer/stick/parse"
+)
+
+// A Filter generates Go code for a Twig filter at generation time.
+//
+// Filters registered with a Generator are compiled inline and take
+// precedence over any filter of the same name registered on the stick.Env.
+type Filter struct {
+	// Imports lists any packages required by the generated code.
+	Imports []string
+
+	// Emit returns a Go expression that applies the filter. val is a Go
+	// expression for the value being filtered and args contains a Go
+	// expression for each additional argument.
+	Emit func(val string, args ...string) string
+
+	// Eval optionally applies the filter at generation time. It is used in
+	// place of Emit when the value and all arguments are constant.
+	Eval func(val stick.Value, args ...stick.Value) stick.Value
+
+	// Params optionally names each additional argument, allowing them to
+	// be given as named arguments.
+	Params []string
+}
+
+// builtinFilters returns the filters available to every Generator.
+func builtinFilters() map[string]Filter {
+	return map[string]Filter{
+		"raw": {
+			Emit: func(val string, args ...string) string {
+				return val
+			},
+		},
+		"spaceless": {
+			Imports: []string{runtimeImport},
+			Emit: func(val string, args ...string) string {
+				return fmt.Sprintf("stickgen.Spaceless(%s)", val)
+			},
+			Eval: func(val stick.Value, args ...stick.Value) stick.Value {
+				return Spaceless(val)
+			},
+		},
+	}
+}
+
+// NewSnippetFilter creates a Filter from a snippet of Go code.
+//
+// The snippet is a format string as accepted by fmt.Sprintf. It receives the
+// value being filtered followed by any additional arguments, for example:
+//
+//	NewSnippetFilter("strings.ToUpper(stick.CoerceString(%s))", "strings")
+func NewSnippetFilter(snippet string, imports ...string) Filter {
+	return Filter{
+		Imports: imports,
+		Emit: func(val string, args ...string) string {
+			params := []interface{}{val}
+			for _, arg := range args {
+				params = append(params, arg)
+			}
+			return fmt.Sprintf(snippet, params...)
+		},
+	}
+}
+
+func (g *Generator) walkFilter(filter Filter, expr *parse.FuncExpr) (evaluatedExpr, error) {
+	if len(expr.Args) == 0 {
+		return emptyExpr, fmt.Errorf("stickgen: filter %s expects a value", expr.Name)
+	}
+	if filter.Eval != nil {
+		if vals, ok := g.constants(expr.Args); ok {
+			if lit, ok := goLiteral(filter.Eval(vals[0], vals[1:]...)); ok {
+				return newLiteral(lit), nil
+			}
+		}
+	}
+	body, args, err := g.walkArgs(expr.Args, append([]string{""}, filter.Params...))
+	if err != nil {
+		return emptyExpr, err
+	}
+	for _, name := range filter.Imports {
+		g.addImport(name)
+	}
+	return evaluatedExpr{
+		body:          strings.TrimSuffix(body, "\n"),
+		resultantName: filter.Emit(args[0], args[1:]...),
+		isFunction:    body != "",
+		hasError:      false,
+	}, nil
+}
@@ -0,0 +1,107 @@
+package stickgen_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/tyler-sommer/stick"
+	"github.com/veonik/go-stickgen"
+)
+
+func TestFilter(t *testing.T) {
+	filters := func(g *stickgen.Generator) {
+		g.Filters["upper"] = stickgen.NewSnippetFilter("strings.ToUpper(stick.CoerceString(%s))", "strings")
+		g.Filters["repeat"] = stickgen.Filter{
+			Imports: []string{"strings"},
+			Emit: func(val string, args ...string) string {
+				return "strings.Repeat(stick.CoerceString(" + val + "), int(stick.CoerceNumber(" + args[0] + ")))"
+			},
+			Eval: func(val stick.Value, args ...stick.Value) stick.Value {
+				return strings.Repeat(stick.CoerceString(val), int(stick.CoerceNumber(args[0])))
+			},
+			Params: []string{"times"},
+		}
+	}
+	testRender(t, []renderTest{
+		{
+			name:      "snippet",
+			templates: map[string]string{"test.twig": `{{ name|upper }}`},
+			options:   filters,
+			ctx:       `map[string]stick.Value{"name": "Bob"}`,
+			want:      "BOB",
+		},
+		{
+			name:      "arguments",
+			templates: map[string]string{"test.twig": `{{ name|repeat(n) }}`},
+			options:   filters,
+			ctx:       `map[string]stick.Value{"name": "ab", "n": 3}`,
+			want:      "ababab",
+		},
+		{
+			name:      "named arguments",
+			templates: map[string]string{"test.twig": `{{ name|repeat(times=2) }}`},
+			options:   filters,
+			ctx:       `map[string]stick.Value{"name": "ab"}`,
+			want:      "abab",
+		},
+		{
+			name:      "evaluated",
+			templates: map[string]string{"test.twig": `{{ 'ab'|repeat(2)|upper }}`},
+			options:   filters,
+			want:      "ABAB",
+		},
+		{
+			name:      "precedence over env",
+			templates: map[string]string{"test.twig": `{{ name|upper }}`},
+			options:   filters,
+			ctx:       `map[string]stick.Value{"name": "Bob"}`,
+			setup: `env.Filters["upper"] = func(ctx stick.Context, val stick.Value, args ...stick.Value) stick.Value {
+		return "env"
+	}`,
+			want: "BOB",
+		},
+		{
+			name:      "env",
+			templates: map[string]string{"test.twig": `{{ name|lower }}`},
+			ctx:       `map[string]stick.Value{"name": "Bob"}`,
+			setup: `env.Filters["lower"] = func(ctx stick.Context, val stick.Value, args ...stick.Value) stick.Value {
+		return strings.ToLower(stick.CoerceString(val))
+	}`,
+			imports: []string{"strings"},
+			want:    "bob",
+		},
+		{
+			name:      "raw",
+			templates: map[string]string{"test.twig": `{% autoescape 'html' %}{{ html }}{{ html|raw }}{% endautoescape %}`},
+			ctx:       `map[string]stick.Value{"html": "<br>"}`,
+			want:      "&lt;br&gt;<br>",
+		},
+		{
+			name:      "unknown argument",
+			templates: map[string]string{"test.twig": `{{ name|repeat(count=2) }}`},
+			options:   filters,
+			err:       "unknown argument count",
+		},
+	})
+}
+
+func TestSpacelessFilter(t *testing.T) {
+	testRender(t, []renderTest{
+		{
+			name:      "literal",
+			templates: map[string]string{"test.twig": `{{ ' <div>  <b> x </b> </div> '|spaceless }}`},
+			want:      "<div><b> x </b></div>",
+		},
+		{
+			name:      "variable",
+			templates: map[string]string{"test.twig": `{{ html|spaceless }}`},
+			ctx:       `map[string]stick.Value{"html": "<ul>\n\t<li>a</li>\n\t<li>b</li>\n</ul>"}`,
+			want:      "<ul><li>a</li><li>b</li></ul>",
+		},
+		{
+			name:      "text only",
+			templates: map[string]string{"test.twig": `{{ ' a  b '|spaceless }}`},
+			want:      "a  b",
+		},
+	})
+}
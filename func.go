@@ -0,0 +1,312 @@
+package stickgen
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/tyler-sommer/stick"
+	"github.com/tyler-sommer/stick/parse"
+)
+
+// walkBuiltinFunc generates a call to a runtime helper implementing one of
+// Twig's builtin functions. At least min arguments are required, and any
+// optional arguments that are not given are passed as nil.
+func (g *Generator) walkBuiltinFunc(expr *parse.FuncExpr, helper string, min int) (evaluatedExpr, error) {
+	params := signatures["Functions"][expr.Name]
+	body, args, err := g.walkArgs(expr.Args, params)
+	if err != nil {
+		return emptyExpr, err
+	}
+	if len(args) < min {
+		return emptyExpr, fmt.Errorf("stickgen: %s expects at least %d arguments", expr.Name, min)
+	}
+	for len(args) < len(params) {
+		args = append(args, "nil")
+	}
+	g.addImport(runtimeImport)
+	return evaluatedExpr{
+		body:          strings.TrimSuffix(body, "\n"),
+		resultantName: fmt.Sprintf("stickgen.%s(%s)", helper, strings.Join(args, ", ")),
+		isFunction:    body != "",
+		hasError:      false,
+	}, nil
+}
+
+// walkDumpFunc generates a dump of each argument, or of the entire context if
+// there are none. Nothing is generated unless debugging is enabled.
+func (g *Generator) walkDumpFunc(expr *parse.FuncExpr) (evaluatedExpr, error) {
+	if !g.Debug {
+		g.log().Debug("skipping dump since Debug is disabled", "template", g.name)
+		return newLiteral(`""`), nil
+	}
+	if len(expr.Args) == 0 {
+		g.addImport(runtimeImport)
+		return newLiteral(fmt.Sprintf("stickgen.Dump(%s)", g.contextVars())), nil
+	}
+	return g.walkBuiltinFunc(expr, "Dump", 0)
+}
+
+// walkAttributeFunc generates a dynamic attribute lookup. The attribute
+// name and any method arguments are evaluated at runtime.
+func (g *Generator) walkAttributeFunc(expr *parse.FuncExpr) (evaluatedExpr, error) {
+	body, args, err := g.walkArgs(expr.Args, signatures["Functions"]["attribute"])
+	if err != nil {
+		return emptyExpr, err
+	}
+	if len(args) < 2 {
+		return emptyExpr, fmt.Errorf("stickgen: attribute expects at least 2 arguments")
+	}
+	for len(args) < 3 {
+		args = append(args, "nil")
+	}
+	g.addImport(runtimeImport)
+	val := g.temp("val")
+	return evaluatedExpr{
+		body:          body + fmt.Sprintf("%s, err := stickgen.Attribute(%s)", val, strings.Join(args, ", ")),
+		resultantName: val,
+		isFunction:    true,
+		hasError:      true,
+	}, nil
+}
+
+// walkConstantFunc resolves a constant using the values given to the
+// Generator.
+func (g *Generator) walkConstantFunc(expr *parse.FuncExpr) (evaluatedExpr, error) {
+	args, err := resolveArgs(expr.Args, signatures["Functions"]["constant"])
+	if err != nil {
+		return emptyExpr, err
+	}
+	if len(args) != 1 || args[0] == nil {
+		return emptyExpr, fmt.Errorf("stickgen: constant expects the name of a constant")
+	}
+	lit, err := g.constantLiteral(args[0])
+	if err != nil {
+		return emptyExpr, err
+	}
+	return newLiteral(lit), nil
+}
+
+// constantLiteral returns a Go literal for the value of the constant named by
+// e, which must be evaluated at generation time.
+func (g *Generator) constantLiteral(e parse.Expr) (string, error) {
+	name, ok := g.constant(e)
+	if !ok {
+		return "", fmt.Errorf("stickgen: constant names must be evaluated at generation time")
+	}
+	v, ok := g.Constants[stick.CoerceString(name)]
+	if !ok {
+		return "", fmt.Errorf("stickgen: undefined constant %s", stick.CoerceString(name))
+	}
+	lit, ok := goLiteral(v)
+	if !ok {
+		return "", fmt.Errorf("stickgen: unsupported value for constant %s: %T", stick.CoerceString(name), v)
+	}
+	if _, ok := v.(float64); ok {
+		// Preserve the type of the constant, which matters when it is
+		// compared strictly.
+		lit = "float64(" + lit + ")"
+	}
+	return lit, nil
+}
+
+// walkSourceFunc inlines the contents of a template, or loads them at
+// runtime if RuntimeSources is enabled.
+func (g *Generator) walkSourceFunc(expr *parse.FuncExpr) (evaluatedExpr, error) {
+	if g.RuntimeSources {
+		body, args, err := g.walkArgs(expr.Args, signatures["Functions"]["source"])
+		if err != nil {
+			return emptyExpr, err
+		}
+		if len(args) == 0 {
+			return emptyExpr, fmt.Errorf("stickgen: source expects a template name")
+		}
+		for len(args) < 2 {
+			args = append(args, "nil")
+		}
+		g.addImport(runtimeImport)
+		val := g.temp("val")
+		return evaluatedExpr{
+			body:          body + fmt.Sprintf("%s, err := stickgen.Source(env, %s)", val, strings.Join(args, ", ")),
+			resultantName: val,
+			isFunction:    true,
+			hasError:      true,
+			safe:          true,
+			isString:      true,
+		}, nil
+	}
+	args, err := resolveArgs(expr.Args, signatures["Functions"]["source"])
+	if err != nil {
+		return emptyExpr, err
+	}
+	if len(args) == 0 || len(args) > 2 || args[0] == nil {
+		return emptyExpr, fmt.Errorf("stickgen: source expects a template name")
+	}
+	nameVal, ok := g.constant(args[0])
+	if !ok {
+		return emptyExpr, fmt.Errorf("stickgen: unable to evaluate source reference")
+	}
+	var ignoreMissing stick.Value = false
+	if len(args) == 2 && args[1] != nil {
+		if ignoreMissing, ok = g.constant(args[1]); !ok {
+			return emptyExpr, fmt.Errorf("stickgen: unable to evaluate source reference")
+		}
+	}
+	name := stick.CoerceString(nameVal)
+	contents := ""
+	tpl, err := g.loader.Load(name)
+	switch {
+	case err == nil:
+		var b []byte
+		if b, err = ioutil.ReadAll(tpl.Contents()); err != nil {
+			return emptyExpr, err
+		}
+		g.digests[name] = sha256.Sum256(b)
+		contents = string(b)
+	case stick.CoerceBool(ignoreMissing):
+		g.log().Info("skipping missing source", "template", g.name, "source", name)
+	default:
+		return emptyExpr, err
+	}
+	lit := strconv.Quote(contents)
+	return evaluatedExpr{body: lit, resultantName: lit, safe: true}, nil
+}
+
+// walkCycleFunc generates a lookup of the value at the given position,
+// modulo the number of values. Lookups in array literals are generated
+// inline.
+func (g *Generator) walkCycleFunc(expr *parse.FuncExpr) (evaluatedExpr, error) {
+	args, err := resolveArgs(expr.Args, signatures["Functions"]["cycle"])
+	if err != nil {
+		return emptyExpr, err
+	}
+	if len(args) != 2 || args[0] == nil || args[1] == nil {
+		return emptyExpr, fmt.Errorf("stickgen: cycle expects values and a position")
+	}
+	arr, ok := args[0].(*parse.ArrayExpr)
+	if !ok || len(arr.Elements) == 0 {
+		return g.walkBuiltinFunc(expr, "Cycle", 2)
+	}
+	vals, err := g.walkExpr(arr)
+	if err != nil {
+		return emptyExpr, err
+	}
+	pos, err := g.walkExpr(args[1])
+	if err != nil {
+		return emptyExpr, err
+	}
+	var body []string
+	for _, v := range []evaluatedExpr{vals, pos} {
+		if v.isFunction {
+			body = append(body, g.handleErr(v))
+		}
+	}
+	// Negative positions count back from the end, as with Cycle.
+	n := len(arr.Elements)
+	return evaluatedExpr{
+		body:          strings.Join(body, "\n"+g.indent()),
+		resultantName: fmt.Sprintf("%s[(int(stick.CoerceNumber(%s))%%%d+%d)%%%d]", vals.resultantName, pos.resultantName, n, n, n),
+		isFunction:    len(body) > 0,
+		hasError:      false,
+	}, nil
+}
+
+// countedRange returns the bounds of a range with literal integer bounds,
+// which can be iterated over without building a slice.
+func (g *Generator) countedRange(e parse.Expr) (start, step, count int, ok bool) {
+	var args []parse.Expr
+	switch expr := e.(type) {
+	case *parse.GroupExpr:
+		return g.countedRange(expr.X)
+	case *parse.BinaryExpr:
+		if expr.Op != parse.OpBinaryRange {
+			return 0, 0, 0, false
+		}
+		args = []parse.Expr{expr.Left, expr.Right}
+	case *parse.FuncExpr:
+		if !g.isBuiltinFunc(expr, "range") {
+			return 0, 0, 0, false
+		}
+		args = expr.Args
+	default:
+		return 0, 0, 0, false
+	}
+	if len(args) < 2 || len(args) > 3 {
+		return 0, 0, 0, false
+	}
+	vals, ok := g.constants(args)
+	if !ok {
+		return 0, 0, 0, false
+	}
+	bounds := make([]int, len(vals))
+	for i, v := range vals {
+		f, ok := v.(float64)
+		if !ok || f != math.Trunc(f) || math.IsInf(f, 0) {
+			return 0, 0, 0, false
+		}
+		bounds[i] = int(f)
+	}
+	step = 1
+	if len(bounds) > 2 {
+		step = bounds[2]
+		if step < 0 {
+			step = -step
+		}
+	}
+	if step == 0 {
+		return 0, 0, 0, false
+	}
+	start, end := bounds[0], bounds[1]
+	if start > end {
+		return start, -step, (start-end)/step + 1, true
+	}
+	return start, step, (end-start)/step + 1, true
+}
+
+// isBuiltinFunc reports whether e calls the named builtin function, rather
+// than a macro of the same name.
+func (g *Generator) isBuiltinFunc(e parse.Expr, name string) bool {
+	fn, ok := stripGroup(e).(*parse.FuncExpr)
+	if !ok || fn.Name != name {
+		return false
+	}
+	b, ok := g.namespaces[g.name][name]
+	return !ok || b.macro == ""
+}
+
+// dateComparison returns a Go expression comparing the operands of expr as
+// times, reporting false if expr is not a comparison.
+func (g *Generator) dateComparison(expr *parse.BinaryExpr, left, right string) (string, bool) {
+	if !g.isBuiltinFunc(expr.Left, "date") {
+		left = fmt.Sprintf("stickgen.Date(%s, nil)", left)
+	}
+	if !g.isBuiltinFunc(expr.Right, "date") {
+		right = fmt.Sprintf("stickgen.Date(%s, nil)", right)
+	}
+	switch expr.Op {
+	case parse.OpBinaryEqual:
+		return fmt.Sprintf("%s.Equal(%s)", left, right), true
+	case parse.OpBinaryNotEqual:
+		return fmt.Sprintf("!%s.Equal(%s)", left, right), true
+	case parse.OpBinaryGreaterThan:
+		return fmt.Sprintf("%s.After(%s)", left, right), true
+	case parse.OpBinaryLessThan:
+		return fmt.Sprintf("%s.Before(%s)", left, right), true
+	case parse.OpBinaryGreaterEqual:
+		return fmt.Sprintf("!%s.Before(%s)", left, right), true
+	case parse.OpBinaryLessEqual:
+		return fmt.Sprintf("!%s.After(%s)", left, right), true
+	}
+	return "", false
+}
+
+// stripGroup returns the expression within any parentheses.
+func stripGroup(e parse.Expr) parse.Expr {
+	if group, ok := e.(*parse.GroupExpr); ok {
+		return stripGroup(group.X)
+	}
+	return e
+}
@@ -0,0 +1,360 @@
+package stickgen_test
+
+import (
+	"testing"
+
+	"github.com/tyler-sommer/stick"
+	"github.com/veonik/go-stickgen"
+)
+
+func TestSource(t *testing.T) {
+	runtimeSources := func(g *stickgen.Generator) {
+		g.RuntimeSources = true
+	}
+	loader := `env.Loader = &stick.MemoryLoader{Templates: map[string]string{"x.txt": "<b>{{ x }}</b>"}}`
+	testRender(t, []renderTest{
+		{
+			name:      "inline",
+			templates: map[string]string{"test.twig": `{{ source('x.txt') }}`, "x.txt": "<b>{{ x }}</b>"},
+			want:      "<b>{{ x }}</b>",
+		},
+		{
+			name:      "missing",
+			templates: map[string]string{"test.twig": `{{ source('missing.txt') }}`},
+			err:       "missing.txt",
+		},
+		{
+			name:      "ignore missing",
+			templates: map[string]string{"test.twig": `[{{ source('missing.txt', true) }}]`},
+			want:      "[]",
+		},
+		{
+			name:      "named ignore missing",
+			templates: map[string]string{"test.twig": `[{{ source('missing.txt', ignore_missing=true) }}]`},
+			want:      "[]",
+		},
+		{
+			name:      "named name",
+			templates: map[string]string{"test.twig": `{{ source(name='x.txt') }}`, "x.txt": "x"},
+			want:      "x",
+		},
+		{
+			name:      "no name",
+			templates: map[string]string{"test.twig": `{{ source(ignore_missing=true) }}`},
+			err:       "source expects a template name",
+		},
+		{
+			name:      "unknown argument",
+			templates: map[string]string{"test.twig": `{{ source('x.txt', strict=true) }}`},
+			err:       "unknown argument strict",
+		},
+		{
+			name:      "dynamic name",
+			templates: map[string]string{"test.twig": `{{ source(name) }}`},
+			err:       "unable to evaluate source reference",
+		},
+		{
+			name:      "runtime",
+			templates: map[string]string{"test.twig": `{{ source(name) }}`},
+			options:   runtimeSources,
+			ctx:       `map[string]stick.Value{"name": "x.txt"}`,
+			setup:     loader,
+			want:      "<b>{{ x }}</b>",
+		},
+		{
+			name:      "runtime named ignore missing",
+			templates: map[string]string{"test.twig": `[{{ source('missing.txt', ignore_missing=true) }}]`},
+			options:   runtimeSources,
+			setup:     loader,
+			want:      "[]",
+		},
+		{
+			name:      "runtime missing",
+			templates: map[string]string{"test.twig": `{{ source('missing.txt') }}`},
+			options:   runtimeSources,
+			setup:     loader,
+			runErr:    "missing.txt",
+		},
+	})
+}
+
+func TestFuncArgs(t *testing.T) {
+	join := `env.Functions["join"] = func(ctx stick.Context, args ...stick.Value) stick.Value {
+		s := fmt.Sprint(len(args), ":")
+		for _, arg := range args {
+			s += stick.CoerceString(arg) + ","
+		}
+		return s
+	}`
+	testRender(t, []renderTest{
+		{
+			name:      "no arguments",
+			templates: map[string]string{"test.twig": `{{ join() }}`},
+			setup:     join,
+			want:      "0:",
+		},
+		{
+			name:      "one argument",
+			templates: map[string]string{"test.twig": `{{ join(a) }}`},
+			ctx:       `map[string]stick.Value{"a": 1}`,
+			setup:     join,
+			want:      "1:1,",
+		},
+		{
+			name:      "many arguments",
+			templates: map[string]string{"test.twig": `{{ join(a, 'b', 3, a + 1) }}`},
+			ctx:       `map[string]stick.Value{"a": 1}`,
+			setup:     join,
+			want:      "4:1,b,3,2,",
+		},
+		{
+			name:      "nested calls",
+			templates: map[string]string{"test.twig": `{{ join(join(), join(1, 2)) }}`},
+			setup:     join,
+			want:      "2:0:,2:1,2,,",
+		},
+		{
+			name:      "local variables",
+			templates: map[string]string{"test.twig": `{% set x = 'x' %}{% for i in [1, 2] %}{{ join(x, i) }}{% endfor %}`},
+			setup:     join,
+			want:      "2:x,1,2:x,2,",
+		},
+		{
+			name:      "argument error",
+			templates: map[string]string{"test.twig": `{{ join(1, user.missing) }}`},
+			ctx:       `map[string]stick.Value{"user": map[string]stick.Value{}}`,
+			setup:     join,
+			runErr:    "missing",
+		},
+		{
+			name:      "undefined function",
+			templates: map[string]string{"test.twig": `[{{ nope(1, 2) }}]`},
+			want:      "[]",
+		},
+	})
+}
+
+func TestFuncContext(t *testing.T) {
+	funcs := `env.Functions["where"] = func(ctx stick.Context, args ...stick.Value) stick.Value {
+		v, _ := ctx.Scope().Get(stick.CoerceString(args[0]))
+		return fmt.Sprintf("%s:%v:%t", ctx.Name(), v, ctx.Env() == env)
+	}
+	env.Filters["where"] = func(ctx stick.Context, val stick.Value, args ...stick.Value) stick.Value {
+		v, _ := ctx.Scope().Get(stick.CoerceString(val))
+		return fmt.Sprintf("%s:%v", ctx.Name(), v)
+	}
+	env.Tests["local"] = func(ctx stick.Context, val stick.Value, args ...stick.Value) bool {
+		_, ok := ctx.Scope().Get(stick.CoerceString(val))
+		return ok
+	}`
+	testRender(t, []renderTest{
+		{
+			name:      "function",
+			templates: map[string]string{"test.twig": `{{ where('name') }}`},
+			ctx:       `map[string]stick.Value{"name": "Bob"}`,
+			setup:     funcs,
+			want:      "test.twig:Bob:true",
+		},
+		{
+			name:      "filter",
+			templates: map[string]string{"test.twig": `{{ 'name'|where }}`},
+			ctx:       `map[string]stick.Value{"name": "Bob"}`,
+			setup:     funcs,
+			want:      "test.twig:Bob",
+		},
+		{
+			name:      "test",
+			templates: map[string]string{"test.twig": `{% set x = 1 %}{% if 'x' is local %}x{% endif %}{% if 'y' is local %}y{% endif %}`},
+			setup:     funcs,
+			want:      "x",
+		},
+		{
+			name:      "local variables",
+			templates: map[string]string{"test.twig": `{% set x = 'local' %}{{ where('x') }}`},
+			setup:     funcs,
+			want:      "test.twig:local:true",
+		},
+		{
+			name:      "loop",
+			templates: map[string]string{"test.twig": `{% for i in ['a', 'b'] %}{{ where('i') }},{% if 'loop' is local %}loop{% endif %};{% endfor %}`},
+			setup:     funcs,
+			want:      "test.twig:a:true,loop;test.twig:b:true,loop;",
+		},
+		{
+			name: "included template",
+			templates: map[string]string{
+				"item.twig": `{{ where('name') }}`,
+				"test.twig": `{% include 'item.twig' %}`,
+			},
+			ctx:   `map[string]stick.Value{"name": "Bob"}`,
+			setup: funcs,
+			want:  "item.twig:Bob:true",
+		},
+		{
+			name: "block",
+			templates: map[string]string{
+				"layout.twig": `{% block b %}{% endblock %}`,
+				"test.twig":   `{% extends 'layout.twig' %}{% block b %}{{ where('name') }}{% endblock %}`,
+			},
+			ctx:   `map[string]stick.Value{"name": "Bob"}`,
+			setup: funcs,
+			want:  "test.twig:Bob:true",
+		},
+	})
+}
+
+func TestRange(t *testing.T) {
+	loop := func(r string) map[string]string {
+		return map[string]string{"test.twig": `{% for i in ` + r + ` %}{{ i }},{% endfor %}`}
+	}
+	testRender(t, []renderTest{
+		{name: "literal", templates: loop(`range(0, 10, 2)`), want: "0,2,4,6,8,10,"},
+		{name: "operator", templates: loop(`1..3`), want: "1,2,3,"},
+		{name: "descending", templates: loop(`range(3, 1)`), want: "3,2,1,"},
+		{name: "descending step", templates: loop(`range(10, 0, -5)`), want: "10,5,0,"},
+		{name: "variables", templates: loop(`range(low, high, step)`), ctx: `map[string]stick.Value{"low": 1, "high": 7, "step": 3}`, want: "1,4,7,"},
+		{name: "zero step", templates: loop(`range(1, high, step)`), ctx: `map[string]stick.Value{"high": 3, "step": 0}`, want: "1,2,3,"},
+		{name: "characters", templates: loop(`range('a', 'e', 2)`), want: "a,c,e,"},
+		{name: "fractional character step", templates: loop(`range('a', 'e', 0.5)`), want: "a,b,c,d,e,"},
+		{name: "character variables", templates: loop(`range(low, 'c')`), ctx: `map[string]stick.Value{"low": "a"}`, want: "a,b,c,"},
+		{name: "loop variable", templates: map[string]string{"test.twig": `{% for i in range(1, n) %}{{ loop.index }}/{{ loop.length }},{% endfor %}`}, ctx: `map[string]stick.Value{"n": 2}`, want: "1/2,2/2,"},
+		{name: "in expression", templates: map[string]string{"test.twig": `{% set r = range(1, 3) %}{% for i in r %}{{ i }}{% endfor %}{{ r|length }}`}, setup: `env.Filters["length"] = func(ctx stick.Context, val stick.Value, args ...stick.Value) stick.Value {
+		return len(val.([]stick.Value))
+	}`, want: "1233"},
+		{name: "counter as loop variable", templates: map[string]string{"test.twig": `{% for n in 1..3 %}{{ n }}{% if not loop.last %},{% endif %}{% endfor %}`}, want: "1,2,3"},
+		{name: "counter as local", templates: map[string]string{"test.twig": `{% set n = 5 %}{% for i in 1..3 %}{{ n }}{{ loop.index0 }},{% endfor %}`}, want: "50,51,52,"},
+		{name: "infinite bound", templates: loop(`range(0, high)`), ctx: `map[string]stick.Value{"high": math.Inf(1)}`, imports: []string{"math"}, want: ""},
+		{name: "infinite literal bound", templates: loop(`range(0, 1/0)`), want: ""},
+		{name: "NaN step", templates: loop(`range(0, 3, step)`), ctx: `map[string]stick.Value{"step": math.NaN()}`, imports: []string{"math"}, want: ""},
+		{name: "step below float spacing", templates: map[string]string{"test.twig": `{% for i in range(low, high, 0.5) %}{% if loop.last %}{{ loop.length }}{% endif %}{% endfor %}`}, ctx: `map[string]stick.Value{"low": 1e17, "high": 1e17 + 16}`, want: "33"},
+		{name: "too few arguments", templates: loop(`range(1)`), err: "range expects at least 2 arguments"},
+	})
+}
+
+func TestMinMax(t *testing.T) {
+	testRender(t, []renderTest{
+		{name: "arguments", templates: map[string]string{"test.twig": `{{ min(2, 1, 3) }}{{ max(2, 1, 3) }}`}, want: "13"},
+		{name: "array", templates: map[string]string{"test.twig": `{{ min([4, 2, 8]) }}{{ max([4, 2, 8]) }}`}, want: "28"},
+		{name: "variable", templates: map[string]string{"test.twig": `{{ min(items) }}{{ max(items) }}`}, ctx: `map[string]stick.Value{"items": []stick.Value{5, 3.5, 9}}`, want: "3.59"},
+		{name: "map", templates: map[string]string{"test.twig": `{{ max(m) }}`}, ctx: `map[string]stick.Value{"m": map[string]stick.Value{"a": 1, "b": 7}}`, want: "7"},
+		{name: "strings", templates: map[string]string{"test.twig": `{{ min('b', 'a', 'c') }}{{ max('b', 'a', 'c') }}`}, want: "ac"},
+		{name: "numeric strings", templates: map[string]string{"test.twig": `{{ max('10', '9') }}`}, want: "10"},
+		{name: "single value", templates: map[string]string{"test.twig": `{{ max(n) }}`}, ctx: `map[string]stick.Value{"n": 4}`, want: "4"},
+		{name: "no arguments", templates: map[string]string{"test.twig": `{{ max() }}`}, err: "max expects at least 1 arguments"},
+	})
+}
+
+func TestRandom(t *testing.T) {
+	// Each random value is checked to be within bounds many times over.
+	check := func(set, cond string) map[string]string {
+		return map[string]string{"test.twig": `{% for i in 1..50 %}{% set r = ` + set + ` %}{% if not (` + cond + `) %}{{ r }} out of bounds{% endif %}{% endfor %}ok`}
+	}
+	testRender(t, []renderTest{
+		{name: "array", templates: check(`random(['a', 'b', 'c'])`, `r == 'a' or r == 'b' or r == 'c'`), want: "ok"},
+		{name: "variable", templates: check(`random(items)`, `r == 1 or r == 2`), ctx: `map[string]stick.Value{"items": []stick.Value{1, 2}}`, want: "ok"},
+		{name: "string", templates: check(`random('xy')`, `r == 'x' or r == 'y'`), want: "ok"},
+		{name: "max", templates: check(`random(5)`, `r >= 0 and r <= 5`), want: "ok"},
+		{name: "min and max", templates: check(`random(3, 4)`, `r >= 3 and r <= 4`), want: "ok"},
+		{name: "named max", templates: check(`random(values=-2, max=-1)`, `r >= -2 and r <= -1`), want: "ok"},
+		{name: "no arguments", templates: check(`random()`, `r >= 0`), want: "ok"},
+		{name: "single item", templates: map[string]string{"test.twig": `{{ random(['x']) }}{{ random('y') }}{{ random(3, 3) }}`}, want: "xy3"},
+		{name: "empty", templates: map[string]string{"test.twig": `[{{ random('') }}]{% if random([]) is null %}null{% endif %}`}, want: "[]null"},
+	})
+}
+
+func TestDate(t *testing.T) {
+	cmp := func(expr string) map[string]string {
+		return map[string]string{"test.twig": `{% if ` + expr + ` %}yes{% else %}no{% endif %}`}
+	}
+	testRender(t, []renderTest{
+		{name: "after", templates: cmp(`date('now') > date(user.expires)`), ctx: `map[string]stick.Value{"user": map[string]stick.Value{"expires": "2000-01-01"}}`, want: "yes"},
+		{name: "before", templates: cmp(`date() < date('-1 day')`), want: "no"},
+		{name: "relative", templates: cmp(`date('+2 days') > date('tomorrow')`), want: "yes"},
+		{name: "equal", templates: cmp(`date('2020-01-02 03:04:05', 'UTC') == date('2020-01-02T03:04:05Z')`), want: "yes"},
+		{name: "not equal", templates: cmp(`date('2020-01-02') != date('2020-01-03')`), want: "yes"},
+		{name: "compared with value", templates: cmp(`date('2020-01-02') <= when`), ctx: `map[string]stick.Value{"when": "2020-01-02"}`, want: "yes"},
+		{name: "time value", templates: cmp(`date(t) >= date('2020-01-01', 'UTC')`), ctx: `map[string]stick.Value{"t": time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}`, imports: []string{"time"}, want: "yes"},
+		{name: "timezone", templates: map[string]string{"test.twig": `{{ date('2020-01-02 03:04:05', 'UTC') }}`}, want: "2020-01-02 03:04:05 +0000 UTC"},
+		{name: "location", templates: map[string]string{"test.twig": `{{ date(0, tz) }}`}, ctx: `map[string]stick.Value{"tz": time.FixedZone("X", 3600)}`, imports: []string{"time"}, want: "1970-01-01 01:00:00 +0100 X"},
+		{name: "named timezone", templates: map[string]string{"test.twig": `{{ date(timezone='UTC', date='2020-01-02') }}`}, want: "2020-01-02 00:00:00 +0000 UTC"},
+		{name: "invalid", templates: map[string]string{"test.twig": `{{ date('not a date') }}`}, want: "0001-01-01 00:00:00 +0000 UTC"},
+	})
+}
+
+func TestDump(t *testing.T) {
+	debug := func(g *stickgen.Generator) {
+		g.Debug = true
+	}
+	testRender(t, []renderTest{
+		{name: "values", templates: map[string]string{"test.twig": `{{ dump(1, 'a', user) }}`}, options: debug, ctx: `map[string]stick.Value{"user": map[string]stick.Value{"id": 1}}`, want: "1\n\"a\"\nmap[string]stick.Value{\"id\":1}\n"},
+		{name: "context", templates: map[string]string{"test.twig": `{% set x = 2 %}{{ dump() }}`}, options: debug, ctx: `map[string]stick.Value{"a": 1}`, want: "map[string]stick.Value{\"a\":1, \"x\":2}\n"},
+		{name: "disabled", templates: map[string]string{"test.twig": `a{{ dump(user.missing) }}b`}, ctx: `map[string]stick.Value{"user": nil}`, want: "ab"},
+		{name: "disabled in expression", templates: map[string]string{"test.twig": `{% set d = dump(1) %}[{{ d }}]`}, want: "[]"},
+		{name: "escaped", templates: map[string]string{"test.twig": `{% autoescape 'html' %}{{ dump('<') }}{% endautoescape %}`}, options: debug, want: "&#34;&lt;&#34;\n"},
+	})
+}
+
+func TestAttributeFunc(t *testing.T) {
+	decls := `type greeter struct {
+	Name string
+}
+
+func (g greeter) Greet(greeting, punct stick.Value) string {
+	return stick.CoerceString(greeting) + ", " + g.Name + stick.CoerceString(punct)
+}`
+	ctx := `map[string]stick.Value{"g": greeter{"Ann"}, "m": map[string]stick.Value{"a": 1, "b": 2}, "key": "b"}`
+	testRender(t, []renderTest{
+		{name: "literal", templates: map[string]string{"test.twig": `{{ attribute(m, 'a') }}`}, ctx: ctx, decls: decls, want: "1"},
+		{name: "dynamic", templates: map[string]string{"test.twig": `{{ attribute(m, key) }}`}, ctx: ctx, decls: decls, want: "2"},
+		{name: "field", templates: map[string]string{"test.twig": `{{ attribute(g, 'Na' ~ 'me') }}`}, ctx: ctx, decls: decls, want: "Ann"},
+		{name: "method", templates: map[string]string{"test.twig": `{{ attribute(g, 'Greet', ['Hi', '!']) }}`}, ctx: ctx, decls: decls, want: "Hi, Ann!"},
+		{name: "named", templates: map[string]string{"test.twig": `{{ attribute(object=g, attribute='Greet', arguments=['Hey', '?']) }}`}, ctx: ctx, decls: decls, want: "Hey, Ann?"},
+		{name: "loop", templates: map[string]string{"test.twig": `{% for k in ['a', 'b'] %}{{ attribute(m, k) }}{% endfor %}`}, ctx: ctx, decls: decls, want: "12"},
+		{name: "missing", templates: map[string]string{"test.twig": `{{ attribute(m, 'c') }}`}, ctx: ctx, decls: decls, runErr: "c"},
+		{name: "too few arguments", templates: map[string]string{"test.twig": `{{ attribute(m) }}`}, err: "attribute expects at least 2 arguments"},
+	})
+}
+
+func TestConstantFunc(t *testing.T) {
+	constants := func(g *stickgen.Generator) {
+		g.Constants = map[string]stick.Value{
+			"Status::ACTIVE": "active",
+			"MAX":            float64(3),
+			"DEBUG":          false,
+			"LAYOUT":         "layout.twig",
+			"CHANNEL":        make(chan int),
+		}
+	}
+	testRender(t, []renderTest{
+		{name: "string", templates: map[string]string{"test.twig": `{{ constant('Status::ACTIVE') }}`}, options: constants, want: "active"},
+		{name: "number", templates: map[string]string{"test.twig": `{% for i in 1..constant('MAX') %}{{ i }}{% endfor %}`}, options: constants, want: "123"},
+		{name: "comparison", templates: map[string]string{"test.twig": `{% if status == constant('Status::ACTIVE') %}yes{% endif %}{% if constant('DEBUG') %}debug{% endif %}`}, options: constants, ctx: `map[string]stick.Value{"status": "active"}`, want: "yes"},
+		{name: "named", templates: map[string]string{"test.twig": `{{ constant(constant='MAX') }}`}, options: constants, want: "3"},
+		{
+			name: "template name",
+			templates: map[string]string{
+				"layout.twig": `layout:{% block b %}{% endblock %}`,
+				"test.twig":   `{% extends constant('LAYOUT') %}{% block b %}child{% endblock %}`,
+			},
+			options: constants,
+			want:    "layout:child",
+		},
+		{name: "undefined", templates: map[string]string{"test.twig": `{{ constant('NOPE') }}`}, options: constants, err: "undefined constant NOPE"},
+		{name: "dynamic name", templates: map[string]string{"test.twig": `{{ constant(name) }}`}, options: constants, err: "constant names must be evaluated at generation time"},
+		{name: "unsupported value", templates: map[string]string{"test.twig": `{{ constant('CHANNEL') }}`}, options: constants, err: "unsupported value for constant CHANNEL"},
+		{name: "object", templates: map[string]string{"test.twig": `{{ constant('MAX', obj) }}`}, options: constants, err: "constant expects the name of a constant"},
+		{name: "no arguments", templates: map[string]string{"test.twig": `{{ constant() }}`}, options: constants, err: "constant expects the name of a constant"},
+	})
+}
+
+func TestCycle(t *testing.T) {
+	testRender(t, []renderTest{
+		{name: "loop", templates: map[string]string{"test.twig": `{% for i in 1..5 %}{{ cycle(['odd', 'even'], loop.index0) }},{% endfor %}`}, want: "odd,even,odd,even,odd,"},
+		{name: "variable", templates: map[string]string{"test.twig": `{% for i in 0..3 %}{{ cycle(values, i) }}{% endfor %}`}, ctx: `map[string]stick.Value{"values": []stick.Value{"a", "b", "c"}}`, want: "abca"},
+		{name: "expressions", templates: map[string]string{"test.twig": `{{ cycle([x, x ~ x], n + 1) }}`}, ctx: `map[string]stick.Value{"x": "a", "n": 2}`, want: "aa"},
+		{name: "negative position", templates: map[string]string{"test.twig": `{{ cycle(['a', 'b', 'c'], -1) }}{{ cycle(values, -1) }}`}, ctx: `map[string]stick.Value{"values": []stick.Value{"a", "b", "c"}}`, want: "cc"},
+		{name: "named", templates: map[string]string{"test.twig": `{{ cycle(position=3, values=['a', 'b']) }}`}, want: "b"},
+		{name: "empty", templates: map[string]string{"test.twig": `{% if cycle([], 1) is null %}null{% endif %}`}, want: "null"},
+		{name: "missing position", templates: map[string]string{"test.twig": `{{ cycle(['a']) }}`}, err: "cycle expects values and a position"},
+	})
+}
@@ -0,0 +1,128 @@
+package stickgen
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// FindTemplates returns the names of the templates found in root whose
+// names match at least one of the include patterns and none of the exclude
+// patterns. Templates are named by their slash-separated path relative to
+// root, and are returned in the order filepath.Walk finds them.
+//
+// Patterns are matched against template names using the syntax of
+// path.Match, except that a "**" element matches any number of directories,
+// so that "**/_*.twig" matches "_form.twig" and "admin/users/_row.twig". If
+// no include patterns are given, every template with the ".twig" extension
+// is included.
+func FindTemplates(root string, include, exclude []string) ([]string, error) {
+	if len(include) == 0 {
+		include = extensionPatterns([]string{templateExt})
+	}
+	for _, p := range append(append([]string{}, include...), exclude...) {
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, err
+		}
+	}
+	var names []string
+	err := filepath.Walk(root, func(file string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(root, file)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if matchAny(include, name) && !matchAny(exclude, name) {
+			names = append(names, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// FindTemplates is like the FindTemplates function, except that if no
+// include patterns are given, every template with one of the Generator's
+// Extensions is included.
+func (g *Generator) FindTemplates(root string, include, exclude []string) ([]string, error) {
+	if len(include) == 0 {
+		include = extensionPatterns(g.extensions())
+	}
+	return FindTemplates(root, include, exclude)
+}
+
+// IsTemplate reports whether the given name has one of the Generator's
+// Extensions.
+func (g *Generator) IsTemplate(name string) bool {
+	return g.extension(name) != ""
+}
+
+// extensions returns the Generator's Extensions, or the default extension
+// if there are none.
+func (g *Generator) extensions() []string {
+	if len(g.Extensions) == 0 {
+		return []string{templateExt}
+	}
+	return g.Extensions
+}
+
+// extension returns the longest of the Generator's Extensions that name
+// has, or an empty string if it has none.
+func (g *Generator) extension(name string) string {
+	ext := ""
+	for _, e := range g.extensions() {
+		if strings.HasSuffix(name, e) && len(e) > len(ext) {
+			ext = e
+		}
+	}
+	return ext
+}
+
+// extensionPatterns returns patterns matching templates with any of the
+// given extensions.
+func extensionPatterns(exts []string) []string {
+	patterns := make([]string, len(exts))
+	for i, ext := range exts {
+		patterns[i] = "**/*" + ext
+	}
+	return patterns
+}
+
+// matchAny reports whether name matches any of the given patterns.
+func matchAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if matchGlob(strings.Split(p, "/"), strings.Split(name, "/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchGlob reports whether the elements of a name match the elements of a
+// pattern, where a "**" element matches zero or more elements of the name.
+func matchGlob(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchGlob(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
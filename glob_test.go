@@ -0,0 +1,309 @@
+package stickgen_test
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/tyler-sommer/stick"
+	"github.com/veonik/go-stickgen"
+)
+
+func TestFindTemplates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stickgen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeTree(t, dir, map[string]string{
+		"index.twig":            ``,
+		"_form.twig":            ``,
+		"page.html.twig":        ``,
+		"page.tpl":              ``,
+		"README.md":             ``,
+		"admin/index.twig":      ``,
+		"admin/users/list.twig": ``,
+		"admin/users/_row.twig": ``,
+	})
+	tests := []struct {
+		name    string
+		include []string
+		exclude []string
+		want    []string
+		err     string
+	}{
+		{
+			name: "default",
+			want: []string{"_form.twig", "admin/index.twig", "admin/users/_row.twig", "admin/users/list.twig", "index.twig", "page.html.twig"},
+		},
+		{
+			name:    "top level",
+			include: []string{"*.twig"},
+			want:    []string{"_form.twig", "index.twig", "page.html.twig"},
+		},
+		{
+			name:    "directory",
+			include: []string{"admin/*"},
+			want:    []string{"admin/index.twig"},
+		},
+		{
+			name:    "any directory",
+			include: []string{"admin/**/*.twig"},
+			want:    []string{"admin/index.twig", "admin/users/_row.twig", "admin/users/list.twig"},
+		},
+		{
+			name:    "exclude partials",
+			exclude: []string{"**/_*.twig"},
+			want:    []string{"admin/index.twig", "admin/users/list.twig", "index.twig", "page.html.twig"},
+		},
+		{
+			name:    "include and exclude",
+			include: []string{"**/*.twig", "*.tpl"},
+			exclude: []string{"admin/**"},
+			want:    []string{"_form.twig", "index.twig", "page.html.twig", "page.tpl"},
+		},
+		{
+			name:    "no match",
+			include: []string{"*.html"},
+			want:    []string{},
+		},
+		{
+			name:    "invalid include",
+			include: []string{"[a-"},
+			err:     "syntax error in pattern",
+		},
+		{
+			name:    "invalid exclude",
+			exclude: []string{"admin/[a-"},
+			err:     "syntax error in pattern",
+		},
+	}
+	for _, tt := range tests {
+		names, err := stickgen.FindTemplates(dir, tt.include, tt.exclude)
+		checkErr(t, tt.name, "finding templates", err, tt.err)
+		if err != nil {
+			continue
+		}
+		got := append([]string{}, names...)
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+	names, err := stickgen.FindTemplates(dir, nil, []string{"admin/**"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"_form.twig", "index.twig", "page.html.twig"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("expected %v, got %v", want, names)
+	}
+
+	g := stickgen.NewGenerator("views", nil)
+	g.Extensions = []string{".html.twig", ".tpl"}
+	names, err = g.FindTemplates(dir, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"page.html.twig", "page.tpl"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("expected templates with extensions %v, got %v", want, names)
+	}
+	names, err = g.FindTemplates(dir, []string{"admin/*.twig"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"admin/index.twig"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("expected include patterns to override extensions, got %v", names)
+	}
+}
+
+func TestGenerateGlob(t *testing.T) {
+	templates := map[string]string{
+		"layout.twig":      `[{% block content %}{% endblock %}]`,
+		"_form.twig":       `<form>{{ action }}</form>`,
+		"index.twig":       `{% extends 'layout.twig' %}{% block content %}{% include '_form.twig' with {action: 'save'} %}{% endblock %}`,
+		"about.twig":       `{% extends 'layout.twig' %}{% block content %}About{% endblock %}`,
+		"admin/index.twig": `admin`,
+	}
+	tests := []struct {
+		name    string
+		include []string
+		exclude []string
+		files   []string
+		funcs   []string
+		absent  []string
+		err     string
+	}{
+		{
+			name:    "exclude partials and layouts",
+			include: []string{"*.twig"},
+			exclude: []string{"_*.twig", "layout.twig"},
+			files:   []string{"about_twig.go", "index_twig.go"},
+			funcs:   []string{"func TemplateIndexTwig(", "func TemplateAboutTwig(", "<form>"},
+			absent:  []string{"func TemplateFormTwig(", "func TemplateLayoutTwig(", "func TemplateAdminIndexTwig("},
+		},
+		{
+			name:    "single template",
+			include: []string{"index.twig"},
+			files:   []string{"index_twig.go"},
+			funcs:   []string{"func TemplateIndexTwig("},
+			absent:  []string{"func TemplateAboutTwig("},
+		},
+		{
+			name:    "nothing matched",
+			include: []string{"*.html"},
+			files:   []string{},
+		},
+		{
+			name:    "invalid pattern",
+			include: []string{"[a-"},
+			err:     "syntax error in pattern",
+		},
+		{
+			name:    "any directory",
+			include: []string{"**/index.twig"},
+			files:   []string{"admin_index_twig.go", "index_twig.go"},
+			funcs:   []string{"func TemplateIndexTwig(", "func TemplateAdminIndexTwig("},
+		},
+	}
+	// The generated packages import this package, so they are built in a
+	// directory within it.
+	dir, err := ioutil.TempDir(".", "_stickgen_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	root := filepath.Join(dir, "templates")
+	writeTree(t, root, templates)
+	var pkgs []string
+	for i, tt := range tests {
+		pkg := "views" + string(rune('a'+i))
+		out := filepath.Join(dir, pkg)
+		g := stickgen.NewGenerator(pkg, stick.NewFilesystemLoader(root))
+		written, err := g.GenerateGlob(root, out, tt.include, tt.exclude)
+		checkErr(t, tt.name, "generating", err, tt.err)
+		if err != nil {
+			continue
+		}
+		if got := relPaths(t, out, written); !reflect.DeepEqual(got, tt.files) {
+			t.Errorf("%s: expected files %v, got %v", tt.name, tt.files, got)
+		}
+		var src string
+		for _, file := range written {
+			b, err := ioutil.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			src += string(b)
+		}
+		for _, s := range tt.funcs {
+			if !strings.Contains(src, s) {
+				t.Errorf("%s: expected code containing %q, got:\n%s", tt.name, s, src)
+			}
+		}
+		for _, s := range tt.absent {
+			if strings.Contains(src, s) {
+				t.Errorf("%s: expected code not containing %q, got:\n%s", tt.name, s, src)
+			}
+		}
+		if len(written) > 0 {
+			pkgs = append(pkgs, "./"+pkg)
+		}
+	}
+	if _, err := exec.LookPath("go"); err != nil || testing.Short() {
+		return
+	}
+	cmd := exec.Command("go", append([]string{"build"}, pkgs...)...)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Errorf("unable to build generated packages:\n%s", out)
+	}
+}
+
+func TestIsTemplate(t *testing.T) {
+	tests := []struct {
+		extensions []string
+		name       string
+		want       bool
+	}{
+		{nil, "index.twig", true},
+		{nil, "admin/index.twig", true},
+		{nil, "index.tpl", false},
+		{nil, "twig", false},
+		{[]string{".tpl"}, "index.tpl", true},
+		{[]string{".tpl"}, "index.twig", false},
+		{[]string{".html.twig", ".txt.twig"}, "page.html.twig", true},
+		{[]string{".html.twig", ".txt.twig"}, "page.twig", false},
+	}
+	for _, tt := range tests {
+		g := stickgen.NewGenerator("views", nil)
+		g.Extensions = tt.extensions
+		if got := g.IsTemplate(tt.name); got != tt.want {
+			t.Errorf("%v: expected IsTemplate(%q) %v, got %v", tt.extensions, tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestStripExtensions(t *testing.T) {
+	strip := func(exts ...string) func(g *stickgen.Generator) {
+		return func(g *stickgen.Generator) {
+			g.Extensions = exts
+			g.StripExtensions = true
+		}
+	}
+	testRender(t, []renderTest{
+		{
+			name:      "default extension",
+			templates: map[string]string{"test.twig": `test`},
+			options:   strip(),
+			call:      `err := TemplateTest(env, output, ctx)`,
+			want:      "test",
+		},
+		{
+			name:      "longest extension",
+			templates: map[string]string{"page.html.twig": `page`},
+			tpl:       "page.html.twig",
+			options:   strip(".twig", ".html.twig"),
+			call:      `err := TemplatePage(env, output, ctx)`,
+			want:      "page",
+		},
+		{
+			name:      "other extension",
+			templates: map[string]string{"page.html.twig": `page`},
+			tpl:       "page.html.twig",
+			options:   strip(".tpl"),
+			call:      `err := TemplatePageHtmlTwig(env, output, ctx)`,
+			want:      "page",
+		},
+		{
+			name: "blocks and macros",
+			templates: map[string]string{
+				"layout.tpl": `[{% block content %}{% endblock %}]`,
+				"macros.tpl": `{% macro hello(name) %}Hello, {{ name }}{% endmacro %}`,
+				"test.tpl":   `{% extends 'layout.tpl' %}{% import 'macros.tpl' as m %}{% block content %}{{ m.hello('Ann') }}{% endblock %}`,
+			},
+			tpl:     "test.tpl",
+			options: strip(".tpl"),
+			call: `err := TemplateTest(env, output, ctx)
+	_ = macroMacrosHello`,
+			want: "[Hello, Ann]",
+		},
+		{
+			name:      "colliding names",
+			templates: map[string]string{"test.twig": `a`, "test.tpl": `b`},
+			all:       []string{"test.twig", "test.tpl"},
+			options:   strip(".twig", ".tpl"),
+			err:       "test.twig and test.tpl would both be generated as TemplateTest",
+		},
+		{
+			name:      "invalid extension",
+			templates: map[string]string{"test.twig": `a`},
+			options:   strip("twig"),
+			err:       `invalid template extension: "twig"`,
+		},
+	})
+}
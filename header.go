@@ -0,0 +1,189 @@
+package stickgen
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// Version is the version of stickgen.
+const Version = "0.1.0"
+
+// HeaderData describes a generated file. It is passed to the GeneratedBy
+// template.
+type HeaderData struct {
+	// Version is the version of stickgen that generated the file.
+	Version string
+	// Templates contains the names of the templates generated in the file.
+	Templates []string
+	// Hash is the hex-encoded SHA-256 hash of the sources of the templates
+	// and of any templates they depend on.
+	Hash string
+	// Options describes the options that differ from their defaults, as
+	// recorded in the file.
+	Options string
+	// Time is when the file was generated, or the zero Time if Reproducible
+	// is set.
+	Time time.Time
+}
+
+// header returns the comments and build constraint that precede the package
+// clause of each generated file.
+func (g *Generator) header() (string, error) {
+	var b strings.Builder
+	if g.Header != "" {
+		b.WriteString(commentLines(g.Header) + "\n")
+	}
+	if g.BuildConstraint != "" {
+		b.WriteString("//go:build " + g.BuildConstraint + "\n\n")
+	}
+	if g.GeneratedBy == "" {
+		b.WriteString("// Code generated by stickgen. DO NOT EDIT.\n")
+		b.WriteString(g.stamp())
+		return b.String(), nil
+	}
+	tpl, err := texttemplate.New("header").Parse(g.GeneratedBy)
+	if err != nil {
+		return "", fmt.Errorf("stickgen: invalid GeneratedBy template: %s", err)
+	}
+	data := HeaderData{Version: Version, Hash: g.sourceHash(), Options: g.options()}
+	if !g.Reproducible {
+		data.Time = time.Now()
+	}
+	for _, t := range g.templates {
+		data.Templates = append(data.Templates, t.name)
+	}
+	line := &bytes.Buffer{}
+	if err := tpl.Execute(line, data); err != nil {
+		return "", fmt.Errorf("stickgen: unable to execute GeneratedBy template: %s", err)
+	}
+	b.WriteString(commentLines(line.String()))
+	b.WriteString(g.stamp())
+	return b.String(), nil
+}
+
+// stamp returns the directives recording the sources of a generated file,
+// the version and options of stickgen and the command that generated it.
+// The sources are read by IsStale.
+func (g *Generator) stamp() string {
+	if len(g.digests) == 0 {
+		return ""
+	}
+	var names []string
+	for name := range g.digests {
+		names = append(names, strconv.Quote(name))
+	}
+	sort.Strings(names)
+	s := stampSources + " " + strings.Join(names, " ") + "\n" +
+		stampHash + " " + g.sourceHash() + "\n" +
+		stampVersion + " " + Version + "\n"
+	if opts := g.options(); opts != "" {
+		s += stampOptions + " " + opts + "\n"
+	}
+	if g.Command != "" {
+		s += stampCommand + " " + strings.Join(strings.Fields(g.Command), " ") + "\n"
+	}
+	return s
+}
+
+var errorPolicyNames = map[ErrorPolicy]string{
+	ErrorsPanic:       "panic",
+	ErrorsPlaceholder: "placeholder",
+	ErrorsHandler:     "handler",
+}
+
+var commentLevelNames = map[CommentLevel]string{
+	CommentsMinimal: "minimal",
+	CommentsOff:     "off",
+}
+
+// options returns a description of the options that affect the generated
+// code and differ from their defaults, such as "Autoescape=html Context".
+func (g *Generator) options() string {
+	var opts []string
+	flag := func(name string, set bool) {
+		if set {
+			opts = append(opts, name)
+		}
+	}
+	value := func(name, v string) {
+		if v == "" {
+			return
+		}
+		if strings.ContainsAny(v, " \t\"=") {
+			v = strconv.Quote(v)
+		}
+		opts = append(opts, name+"="+v)
+	}
+	value("Autoescape", g.Autoescape)
+	flag("Context", g.Context)
+	flag("Debug", g.Debug)
+	flag("DynamicIncludes", g.DynamicIncludes)
+	value("ErrorPolicy", errorPolicyNames[g.ErrorPolicy])
+	value("ErrorPlaceholder", g.ErrorPlaceholder)
+	flag("IgnoreErrors", g.IgnoreErrors)
+	flag("Registry", g.Registry)
+	flag("StringFuncs", g.StringFuncs)
+	flag("StringTemplates", g.StringTemplates)
+	flag("RuntimeSources", g.RuntimeSources)
+	flag("Types", g.Types)
+	flag("Unexported", g.Unexported)
+	value("Receiver", g.Receiver)
+	if _, ok := g.Naming.(DefaultNaming); !ok && g.Naming != nil {
+		value("Naming", fmt.Sprintf("%T", g.Naming))
+	}
+	if len(g.Extensions) > 0 {
+		value("Extensions", strings.Join(g.Extensions, ","))
+	}
+	flag("StripExtensions", g.StripExtensions)
+	if _, ok := g.FileNaming.(DefaultFileNaming); !ok && g.FileNaming != nil {
+		value("FileNaming", fmt.Sprintf("%T%+v", g.FileNaming, g.FileNaming))
+	}
+	value("Comments", commentLevelNames[g.Comments])
+	flag("LineDirectives", g.LineDirectives)
+	flag("NoFormat", g.NoFormat)
+	value("StickImport", g.StickImport)
+	value("RuntimeImport", g.RuntimeImport)
+	return strings.Join(opts, " ")
+}
+
+// commentLines returns the given text as a series of line comments.
+func commentLines(text string) string {
+	lines := strings.Split(strings.TrimRight(text, "\n"), "\n")
+	for i, l := range lines {
+		lines[i] = strings.TrimRight("// "+l, " ")
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// sourceHash returns the hex-encoded hash of the sources of every template
+// loaded, or an empty string if none have been.
+func (g *Generator) sourceHash() string {
+	if len(g.digests) == 0 {
+		return ""
+	}
+	return hashSources(g.digests)
+}
+
+// hashSources returns the hex-encoded hash of the given template names and
+// the digests of their sources.
+func hashSources(digests map[string][sha256.Size]byte) string {
+	var names []string
+	for name := range digests {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	h := sha256.New()
+	for _, name := range names {
+		sum := digests[name]
+		h.Write([]byte(name + "\x00"))
+		h.Write(sum[:])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
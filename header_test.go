@@ -0,0 +1,129 @@
+package stickgen_test
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/tyler-sommer/stick"
+	"github.com/veonik/go-stickgen"
+)
+
+func TestHeader(t *testing.T) {
+	tests := []struct {
+		name     string
+		options  func(g *stickgen.Generator)
+		prefix   string
+		contains string
+		err      string
+	}{
+		{
+			name:   "default",
+			prefix: "// Code generated by stickgen. DO NOT EDIT.\n//stickgen:sources \"test.twig\"\n//stickgen:hash ",
+		},
+		{
+			name:    "header",
+			options: func(g *stickgen.Generator) { g.Header = "Copyright 2024 Example\n\nLicensed under MIT.\n" },
+			prefix:  "// Copyright 2024 Example\n//\n// Licensed under MIT.\n\n// Code generated by stickgen. DO NOT EDIT.\n",
+		},
+		{
+			name:    "build constraint",
+			options: func(g *stickgen.Generator) { g.BuildConstraint = "!dev" },
+			prefix:  "//go:build !dev\n\n// Code generated by stickgen. DO NOT EDIT.\n",
+		},
+		{
+			name: "header and build constraint",
+			options: func(g *stickgen.Generator) {
+				g.Header = "License"
+				g.BuildConstraint = "linux && !dev"
+			},
+			prefix: "// License\n\n//go:build linux && !dev\n\n// Code generated",
+		},
+		{
+			name: "generated by",
+			options: func(g *stickgen.Generator) {
+				g.GeneratedBy = "Code generated by stickgen {{.Version}} from {{range .Templates}}{{.}}{{end}}. DO NOT EDIT."
+			},
+			prefix: "// Code generated by stickgen " + stickgen.Version + " from test.twig. DO NOT EDIT.\n//stickgen:sources",
+		},
+		{
+			name:     "command",
+			options:  func(g *stickgen.Generator) { g.Command = "stickgen  -o views.go\ttest.twig" },
+			contains: "\n//stickgen:command stickgen -o views.go test.twig\n",
+		},
+		{
+			name:     "version",
+			contains: "\n//stickgen:version " + stickgen.Version + "\n",
+		},
+		{
+			name: "options",
+			options: func(g *stickgen.Generator) {
+				g.Autoescape = "html"
+				g.Context = true
+				g.ErrorPolicy = stickgen.ErrorsPlaceholder
+				g.ErrorPlaceholder = "n/a"
+			},
+			contains: "\n//stickgen:options Autoescape=html Context ErrorPolicy=placeholder ErrorPlaceholder=n/a\n",
+		},
+		{
+			name: "time",
+			options: func(g *stickgen.Generator) {
+				g.GeneratedBy = "Generated {{if .Time.IsZero}}reproducibly{{else}}at a time{{end}}."
+			},
+			prefix: "// Generated at a time.\n",
+		},
+		{
+			name: "reproducible",
+			options: func(g *stickgen.Generator) {
+				g.Reproducible = true
+				g.Debug = true
+				g.GeneratedBy = "Generated {{if .Time.IsZero}}reproducibly{{else}}at a time{{end}} with {{.Options}}."
+			},
+			prefix: "// Generated reproducibly with Debug.\n",
+		},
+		{
+			name:    "invalid generated by",
+			options: func(g *stickgen.Generator) { g.GeneratedBy = "{{" },
+			err:     "invalid GeneratedBy template",
+		},
+		{
+			name:    "failing generated by",
+			options: func(g *stickgen.Generator) { g.GeneratedBy = "{{.Nope}}" },
+			err:     "unable to execute GeneratedBy template",
+		},
+	}
+	// Go tools recognize generated files by a line matching this pattern.
+	generated := regexp.MustCompile(`(?m)^// Code generated .* DO NOT EDIT\.$`)
+	for _, tt := range tests {
+		g := stickgen.NewGenerator("views", &stick.MemoryLoader{Templates: map[string]string{"test.twig": `a`}})
+		if tt.options != nil {
+			tt.options(g)
+		}
+		src, err := g.Generate("test.twig")
+		checkErr(t, tt.name, "generating", err, tt.err)
+		if err != nil {
+			continue
+		}
+		if !strings.HasPrefix(src, tt.prefix) {
+			t.Errorf("%s: expected code beginning with %q, got:\n%s", tt.name, tt.prefix, src)
+		}
+		if !strings.Contains(src, tt.contains) {
+			t.Errorf("%s: expected code containing %q, got:\n%s", tt.name, tt.contains, src)
+		}
+		if g.GeneratedBy == "" && !generated.MatchString(src) {
+			t.Errorf("%s: expected code marked as generated, got:\n%s", tt.name, src)
+		}
+	}
+	testRender(t, []renderTest{
+		{
+			name:      "compiles",
+			templates: map[string]string{"test.twig": `a`},
+			options: func(g *stickgen.Generator) {
+				g.Header = "License"
+				g.BuildConstraint = "!stickgen_test"
+				g.GeneratedBy = "Generated by {{.Version}}."
+			},
+			want: "a",
+		},
+	})
+}
@@ -0,0 +1,267 @@
+package stickgen
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/tyler-sommer/stick"
+	"github.com/tyler-sommer/stick/parse"
+)
+
+// An include describes a template included using the include tag or the
+// include function.
+type include struct {
+	pos           parse.Pos
+	tpl           parse.Expr
+	with          parse.Expr
+	only          bool
+	ignoreMissing bool
+}
+
+// walkInclude generates an included template inline. Variables given using
+// the with keyword are added to the context of the included template, which
+// contains only those variables if the only keyword is given.
+//
+// A list of templates may be given, in which case the first template that
+// exists is included. If the include tag has the ignore missing keywords,
+// nothing is generated when no template exists.
+func (g *Generator) walkInclude(node *parse.IncludeNode) error {
+	tpl, ignoreMissing := g.ignoreMissing(node.Tpl)
+	return g.include(include{pos: node.Pos, tpl: tpl, with: node.With, only: node.Only, ignoreMissing: ignoreMissing})
+}
+
+func (g *Generator) include(inc include) error {
+	if g.isBuiltinFunc(inc.tpl, "template_from_string") {
+		if !g.StringTemplates {
+			return errors.New("stickgen: template_from_string is not enabled")
+		}
+		return g.walkDynamicInclude(inc)
+	}
+	name, ok := g.templateName(inc.tpl)
+	if !ok {
+		if g.DynamicIncludes {
+			return g.walkDynamicInclude(inc)
+		}
+		return errors.New("Unable to evaluate include reference")
+	}
+	if inc.ignoreMissing {
+		if _, err := g.loader.Load(name); err != nil {
+			g.log().Info("skipping missing include", "template", g.name, "include", name)
+			g.out.WriteString(g.comment(inc.pos))
+			g.out.WriteString(fmt.Sprintf(`%s// %s does not exist, ignoring
+`, g.indent(), name))
+			return nil
+		}
+	}
+	if g.including(name) {
+		// A template that includes itself can only be rendered at runtime.
+		if g.DynamicIncludes {
+			return g.walkDynamicInclude(inc)
+		}
+		return fmt.Errorf("stickgen: %s is included recursively; enable DynamicIncludes to render it at runtime", name)
+	}
+	if inc.with == nil && !inc.only {
+		// Variables set by the included template are local to it.
+		g.out.WriteString(fmt.Sprintf(`%s{
+`, g.indent()))
+		g.tabs++
+		prevArgs := g.args
+		g.args = make(map[string]string, len(prevArgs))
+		for k, v := range prevArgs {
+			g.args[k] = v
+		}
+		g.isolate()
+		err := g.generate(name)
+		g.args = prevArgs
+		if err != nil {
+			return err
+		}
+		g.tabs--
+		g.out.WriteString(fmt.Sprintf(`%s}
+`, g.indent()))
+		return nil
+	}
+	g.out.WriteString(g.comment(inc.pos))
+	return g.withContext(inc.with, inc.only, func() error {
+		g.isolate()
+		return g.generate(name)
+	})
+}
+
+// including reports whether the named template is currently being generated.
+func (g *Generator) including(name string) bool {
+	for _, n := range g.stack {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// isolate redeclares each local variable in scope within the current block,
+// so that assignments made by an included template are not visible to the
+// including template.
+func (g *Generator) isolate() {
+	var names []string
+	for name := range g.args {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		ident := g.args[name]
+		g.out.WriteString(fmt.Sprintf(`%s%s := %s
+%s_ = %s
+`, g.indent(), ident, ident, g.indent(), ident))
+	}
+}
+
+// walkIncludeFunc renders an included template into a buffer, as with the
+// include function.
+func (g *Generator) walkIncludeFunc(expr *parse.FuncExpr) (evaluatedExpr, error) {
+	args, err := resolveArgs(expr.Args, signatures["Functions"]["include"])
+	if err != nil {
+		return emptyExpr, err
+	}
+	if len(args) == 0 || args[0] == nil {
+		return emptyExpr, fmt.Errorf("stickgen: include expects a template name")
+	}
+	inc := include{pos: expr.Start(), tpl: args[0]}
+	if len(args) > 1 {
+		inc.with = args[1]
+	}
+	withContext := true
+	for i, opt := range []*bool{&withContext, &inc.ignoreMissing} {
+		if len(args) <= i+2 || args[i+2] == nil {
+			continue
+		}
+		v, ok := g.constant(args[i+2])
+		if !ok {
+			return emptyExpr, fmt.Errorf("stickgen: include options must be evaluated at generation time")
+		}
+		*opt = stick.CoerceBool(v)
+	}
+	inc.only = !withContext
+	prev := g.out
+	g.out = &bytes.Buffer{}
+	g.tabs += 2
+	err = g.include(inc)
+	body := g.out.String()
+	g.out = prev
+	g.tabs -= 2
+	if err != nil {
+		return emptyExpr, err
+	}
+	g.addImport("bytes")
+	includeval := g.temp("includeval")
+	return evaluatedExpr{
+		body: fmt.Sprintf(`%s := &bytes.Buffer{}
+%s	{
+%s		output := io.Writer(%s)
+%s		_ = output
+%s%s	}`, includeval, g.indent(), g.indent(), includeval, g.indent(), body, g.indent()),
+		resultantName: includeval + ".String()",
+		isFunction:    true,
+		hasError:      false,
+		safe:          true,
+		isString:      true,
+	}, nil
+}
+
+// ignoreMissing reports whether the template expression of an include tag
+// was marked with the ignore missing keywords, returning the expression
+// itself.
+func (g *Generator) ignoreMissing(e parse.Expr) (parse.Expr, bool) {
+	f, ok := e.(*parse.FilterExpr)
+	if !ok {
+		return e, false
+	}
+	if d, ok := g.lookupDirective(f.Name); ok && d.tag == "include" {
+		return f.Args[0], true
+	}
+	return e, false
+}
+
+// templateName evaluates the name of a template referenced by a tag. Arrays
+// of names resolve to the first template that exists, or the last name if
+// none of them exist.
+func (g *Generator) templateName(e parse.Expr) (string, bool) {
+	if grp, ok := e.(*parse.GroupExpr); ok {
+		return g.templateName(grp.X)
+	}
+	arr, ok := e.(*parse.ArrayExpr)
+	if !ok {
+		return g.evaluate(e)
+	}
+	name := ""
+	for _, el := range arr.Elements {
+		if name, ok = g.evaluate(el); !ok {
+			return "", false
+		}
+		if _, err := g.loader.Load(name); err == nil {
+			break
+		}
+	}
+	return name, name != ""
+}
+
+// walkDynamicInclude generates code that renders an included template at
+// runtime using the stick.Env. Local variables are added to the context
+// unless the only keyword is given.
+func (g *Generator) walkDynamicInclude(inc include) error {
+	tpl, call := inc.tpl, "env.Execute(%s, output, %s)"
+	if inc.ignoreMissing {
+		g.addImport(runtimeImport)
+		call = "stickgen.ExecuteIfExists(env, %s, output, %s)"
+	}
+	if g.isBuiltinFunc(tpl, "template_from_string") {
+		// Templates created from strings are parsed at runtime.
+		args, err := resolveArgs(stripGroup(tpl).(*parse.FuncExpr).Args, signatures["Functions"]["template_from_string"])
+		if err != nil {
+			return err
+		}
+		if len(args) == 0 || args[0] == nil {
+			return errors.New("stickgen: template_from_string expects a template")
+		}
+		g.addImport(runtimeImport)
+		tpl, call = args[0], "stickgen.ExecuteString(env, %s, output, %s)"
+	}
+	v, err := g.walkExpr(tpl)
+	if err != nil {
+		return err
+	}
+	g.out.WriteString(g.comment(inc.pos))
+	g.out.WriteString(fmt.Sprintf(`%s{
+`, g.indent()))
+	g.tabs++
+	if v.isFunction {
+		g.out.WriteString(fmt.Sprintf(`%s%s
+`, g.indent(), g.handleErr(v)))
+	}
+	// The template is evaluated before the context of the included template
+	// is created.
+	tplval := g.temp("tplval")
+	g.out.WriteString(fmt.Sprintf(`%s%s := stick.CoerceString(%s)
+`, g.indent(), tplval, v.resultantName))
+	execute := func() error {
+		ctx := "ctx"
+		if !inc.only {
+			ctx = g.contextVars()
+		}
+		g.out.WriteString(g.indent() + g.checked(fmt.Sprintf(call, tplval, ctx), "err") + "\n")
+		return nil
+	}
+	if inc.with != nil || inc.only {
+		err = g.withContext(inc.with, inc.only, execute)
+	} else {
+		err = execute()
+	}
+	if err != nil {
+		return err
+	}
+	g.tabs--
+	g.out.WriteString(fmt.Sprintf(`%s}
+`, g.indent()))
+	return nil
+}
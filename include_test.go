@@ -0,0 +1,470 @@
+package stickgen_test
+
+import (
+	"testing"
+
+	"github.com/veonik/go-stickgen"
+)
+
+func TestIncludeWith(t *testing.T) {
+	item := `<{{ item }}{{ name }}>`
+	testRender(t, []renderTest{
+		{
+			name: "include",
+			templates: map[string]string{
+				"item.twig": item,
+				"test.twig": `{% include 'item.twig' %}`,
+			},
+			ctx:  `map[string]stick.Value{"item": "a", "name": "!"}`,
+			want: "<a!>",
+		},
+		{
+			name: "with",
+			templates: map[string]string{
+				"item.twig": item,
+				"test.twig": `{% include 'item.twig' with {'item': product} %}{{ item }}`,
+			},
+			ctx:  `map[string]stick.Value{"item": "a", "name": "!", "product": "p"}`,
+			want: "<p!>a",
+		},
+		{
+			name: "with expression",
+			templates: map[string]string{
+				"item.twig": item,
+				"test.twig": `{% include 'item.twig' with vars %}`,
+			},
+			ctx:  `map[string]stick.Value{"vars": map[string]stick.Value{"item": "v"}, "name": "!"}`,
+			want: "<v!>",
+		},
+		{
+			name: "local variables",
+			templates: map[string]string{
+				"item.twig": item,
+				"test.twig": `{% set name = '?' %}{% for i in [1, 2] %}{% include 'item.twig' with {'item': i} %}{% endfor %}`,
+			},
+			want: "<1?><2?>",
+		},
+		{
+			name: "shadows local variable",
+			templates: map[string]string{
+				"item.twig": item,
+				"test.twig": `{% set item = 'local' %}{% include 'item.twig' with {'item': 'with'} %}{{ item }}`,
+			},
+			ctx:  `map[string]stick.Value{"name": "!"}`,
+			want: "<with!>local",
+		},
+		{
+			name: "set in included template",
+			templates: map[string]string{
+				"item.twig": `{% set item = 'inner' %}{{ item }}`,
+				"test.twig": `{% include 'item.twig' with {'item': 'with'} %}{{ item }}`,
+			},
+			ctx:  `map[string]stick.Value{"item": "ctx"}`,
+			want: "innerctx",
+		},
+		{
+			name: "set local variable in included template",
+			templates: map[string]string{
+				"item.twig": `{% set name = 'inner' %}{{ item }}{{ name }}`,
+				"test.twig": `{% set name = 'outer' %}{% include 'item.twig' with {'item': 'with'} %}{{ name }}`,
+			},
+			want: "withinnerouter",
+		},
+		{
+			name: "invalid variables",
+			templates: map[string]string{
+				"item.twig": item,
+				"test.twig": `{% include 'item.twig' with {'item': } %}`,
+			},
+			err: "unexpected token",
+		},
+	})
+}
+
+func TestIncludeScope(t *testing.T) {
+	item := `{% set x = 'inner' %}{{ x }}`
+	testRender(t, []renderTest{
+		{
+			name: "set does not leak",
+			templates: map[string]string{
+				"item.twig": item,
+				"test.twig": `{% include 'item.twig' %}{{ x }}`,
+			},
+			ctx:  `map[string]stick.Value{"x": "ctx"}`,
+			want: "innerctx",
+		},
+		{
+			name: "local variable unchanged",
+			templates: map[string]string{
+				"item.twig": item,
+				"test.twig": `{% set x = 'outer' %}{% include 'item.twig' %}{{ x }}`,
+			},
+			want: "innerouter",
+		},
+		{
+			name: "local variable visible",
+			templates: map[string]string{
+				"item.twig": `{% set x = x ~ '!' %}{{ x }}`,
+				"test.twig": `{% set x = 'outer' %}{% include 'item.twig' %}{{ x }}`,
+			},
+			want: "outer!outer",
+		},
+	})
+}
+
+func TestRecursiveInclude(t *testing.T) {
+	self := map[string]string{"test.twig": `a{% if false %}{% include 'test.twig' %}{% endif %}`}
+	testRender(t, []renderTest{
+		{
+			name:      "self",
+			templates: self,
+			err:       "test.twig is included recursively",
+		},
+		{
+			name: "indirect",
+			templates: map[string]string{
+				"a.twig":    `{% include 'test.twig' %}`,
+				"test.twig": `{% if false %}{% include 'a.twig' %}{% endif %}`,
+			},
+			err: "test.twig is included recursively",
+		},
+		{
+			name:      "dynamic",
+			templates: self,
+			options: func(g *stickgen.Generator) {
+				g.DynamicIncludes = true
+			},
+			want: "a",
+		},
+		{
+			name: "dynamic indirect",
+			templates: map[string]string{
+				"a.twig":    `[{% include 'test.twig' %}]`,
+				"test.twig": `a{% if true %}{% include 'a.twig' %}{% endif %}`,
+			},
+			options: func(g *stickgen.Generator) {
+				g.DynamicIncludes = true
+			},
+			setup: `env.Loader = &stick.MemoryLoader{Templates: map[string]string{"test.twig": "a"}}`,
+			want:  "a[<executed test.twig>]",
+		},
+	})
+}
+
+func TestIncludeOnly(t *testing.T) {
+	item := `<{{ item }}{% if name is defined %}{{ name }}{% endif %}>`
+	testRender(t, []renderTest{
+		{
+			name: "with only",
+			templates: map[string]string{
+				"item.twig": item,
+				"test.twig": `{% include 'item.twig' with {'item': 'a'} only %}`,
+			},
+			ctx:  `map[string]stick.Value{"name": "!"}`,
+			want: "<a>",
+		},
+		{
+			name: "only",
+			templates: map[string]string{
+				"item.twig": `<{% if name is defined %}{{ name }}{% endif %}>`,
+				"test.twig": `{% include 'item.twig' only %}`,
+			},
+			ctx:  `map[string]stick.Value{"name": "!"}`,
+			want: "<>",
+		},
+		{
+			name: "hides local variables",
+			templates: map[string]string{
+				"item.twig": item,
+				"test.twig": `{% set name = '?' %}{% for i in [1] %}{% include 'item.twig' with {'item': i} only %}{% endfor %}`,
+			},
+			want: "<1>",
+		},
+		{
+			name: "hides loop",
+			templates: map[string]string{
+				"item.twig": `<{% if loop is defined %}{{ loop.index }}{% endif %}>`,
+				"test.twig": `{% for i in [1] %}{% include 'item.twig' only %}{% endfor %}`,
+			},
+			want: "<>",
+		},
+	})
+}
+
+func TestIncludeIgnoreMissing(t *testing.T) {
+	testRender(t, []renderTest{
+		{
+			name:      "missing",
+			templates: map[string]string{"test.twig": `a{% include 'optional.twig' ignore missing %}b`},
+			want:      "ab",
+		},
+		{
+			name: "exists",
+			templates: map[string]string{
+				"optional.twig": `optional`,
+				"test.twig":     `a{% include 'optional.twig' ignore missing %}b`,
+			},
+			want: "aoptionalb",
+		},
+		{
+			name: "with only",
+			templates: map[string]string{
+				"optional.twig": `{{ x }}{% if name is defined %}{{ name }}{% endif %}`,
+				"test.twig":     `{% include 'optional.twig' ignore missing with {'x': 1} only %}`,
+			},
+			ctx:  `map[string]stick.Value{"name": "!"}`,
+			want: "1",
+		},
+		{
+			name:      "missing with",
+			templates: map[string]string{"test.twig": `{% include 'optional.twig' ignore missing with {'x': 1} only %}-`},
+			want:      "-",
+		},
+		{
+			name:      "not ignored",
+			templates: map[string]string{"test.twig": `{% include 'optional.twig' %}`},
+			err:       "optional.twig",
+		},
+	})
+}
+
+func TestIncludeList(t *testing.T) {
+	testRender(t, []renderTest{
+		{
+			name: "first exists",
+			templates: map[string]string{
+				"custom/x.twig":  `custom`,
+				"default/x.twig": `default`,
+				"test.twig":      `{% include ['custom/x.twig', 'default/x.twig'] %}`,
+			},
+			want: "custom",
+		},
+		{
+			name: "fallback",
+			templates: map[string]string{
+				"default/x.twig": `default`,
+				"test.twig":      `{% include ['custom/x.twig', 'default/x.twig'] %}`,
+			},
+			want: "default",
+		},
+		{
+			name: "with",
+			templates: map[string]string{
+				"default/x.twig": `{{ x }}`,
+				"test.twig":      `{% include ['custom/x.twig', 'default/x.twig'] with {'x': 1} %}`,
+			},
+			want: "1",
+		},
+		{
+			name:      "none exist ignored",
+			templates: map[string]string{"test.twig": `a{% include ['custom/x.twig', 'default/x.twig'] ignore missing %}b`},
+			want:      "ab",
+		},
+		{
+			name:      "none exist",
+			templates: map[string]string{"test.twig": `{% include ['custom/x.twig', 'default/x.twig'] %}`},
+			err:       "default/x.twig",
+		},
+		{
+			name:      "dynamic member",
+			templates: map[string]string{"test.twig": `{% include [custom, 'default/x.twig'] %}`},
+			err:       "Unable to evaluate include reference",
+		},
+	})
+}
+
+func TestDynamicInclude(t *testing.T) {
+	dynamic := func(g *stickgen.Generator) {
+		g.DynamicIncludes = true
+	}
+	loader := `env.Loader = &stick.MemoryLoader{Templates: map[string]string{"item.twig": "item"}}`
+	testRender(t, []renderTest{
+		{
+			name:      "dynamic",
+			templates: map[string]string{"test.twig": `[{% include item.template %}]`},
+			options:   dynamic,
+			ctx:       `map[string]stick.Value{"item": map[string]stick.Value{"template": "item.twig"}}`,
+			setup:     loader,
+			want:      "[<executed item.twig>]",
+		},
+		{
+			name:      "with only",
+			templates: map[string]string{"test.twig": `{% for i in [1] %}{% include name with {'x': i} only %}{% endfor %}`},
+			options:   dynamic,
+			ctx:       `map[string]stick.Value{"name": "item.twig"}`,
+			setup:     loader,
+			want:      "<executed item.twig>",
+		},
+		{
+			name:      "function",
+			templates: map[string]string{"test.twig": `{% include pick() %}`},
+			options:   dynamic,
+			setup: loader + `
+	env.Functions["pick"] = func(ctx stick.Context, args ...stick.Value) stick.Value {
+		return "item.twig"
+	}`,
+			want: "<executed item.twig>",
+		},
+		{
+			name:      "literal names are generated",
+			templates: map[string]string{"item.twig": "generated", "test.twig": `{% include 'item.twig' %}`},
+			options:   dynamic,
+			want:      "generated",
+		},
+		{
+			name:      "missing",
+			templates: map[string]string{"test.twig": `{% include name %}`},
+			options:   dynamic,
+			ctx:       `map[string]stick.Value{"name": "missing.twig"}`,
+			setup:     loader,
+			runErr:    "template not found: missing.twig",
+		},
+		{
+			name:      "ignore missing",
+			templates: map[string]string{"test.twig": `a{% include name ignore missing %}b`},
+			options:   dynamic,
+			ctx:       `map[string]stick.Value{"name": "missing.twig"}`,
+			setup:     loader,
+			want:      "ab",
+		},
+		{
+			name:      "disabled",
+			templates: map[string]string{"test.twig": `{% include name %}`},
+			err:       "Unable to evaluate include reference",
+		},
+	})
+}
+
+func TestIncludeFunc(t *testing.T) {
+	item := `<{{ item }}{% if name is defined %}{{ name }}{% endif %}>`
+	testRender(t, []renderTest{
+		{
+			name: "include",
+			templates: map[string]string{
+				"item.twig": item,
+				"test.twig": `{{ include('item.twig') }}`,
+			},
+			ctx:  `map[string]stick.Value{"item": "a", "name": "!"}`,
+			want: "<a!>",
+		},
+		{
+			name: "variables",
+			templates: map[string]string{
+				"item.twig": item,
+				"test.twig": `{% for i in [1, 2] %}{{ include('item.twig', {item: i}) }}{% endfor %}`,
+			},
+			ctx:  `map[string]stick.Value{"name": "!"}`,
+			want: "<1!><2!>",
+		},
+		{
+			name: "without context",
+			templates: map[string]string{
+				"item.twig": item,
+				"test.twig": `{{ include('item.twig', {'item': 'f'}, with_context = false) }}`,
+			},
+			ctx:  `map[string]stick.Value{"name": "!"}`,
+			want: "<f>",
+		},
+		{
+			name: "positional options",
+			templates: map[string]string{
+				"item.twig": item,
+				"test.twig": `{{ include('item.twig', {'item': 'f'}, false) }}`,
+			},
+			ctx:  `map[string]stick.Value{"name": "!"}`,
+			want: "<f>",
+		},
+		{
+			name:      "ignore missing",
+			templates: map[string]string{"test.twig": `[{{ include('missing.twig', ignore_missing = true) }}]`},
+			want:      "[]",
+		},
+		{
+			name: "in expression",
+			templates: map[string]string{
+				"item.twig": `item`,
+				"test.twig": `{% set s = include('item.twig') ~ '!' %}{{ s }}`,
+			},
+			want: "item!",
+		},
+		{
+			name: "escaped once",
+			templates: map[string]string{
+				"item.twig": `<i>{{ s }}</i>`,
+				"test.twig": `{{ include('item.twig') }}`,
+			},
+			options: func(g *stickgen.Generator) {
+				g.Autoescape = "html"
+			},
+			ctx:  `map[string]stick.Value{"s": "<b>"}`,
+			want: "<i>&lt;b&gt;</i>",
+		},
+		{
+			name:      "missing",
+			templates: map[string]string{"test.twig": `{{ include('missing.twig') }}`},
+			err:       "missing.twig",
+		},
+		{
+			name:      "no template",
+			templates: map[string]string{"test.twig": `{{ include() }}`},
+			err:       "include expects a template name",
+		},
+		{
+			name:      "dynamic options",
+			templates: map[string]string{"test.twig": `{{ include('item.twig', {}, ctx) }}`},
+			err:       "include options must be evaluated at generation time",
+		},
+	})
+}
+
+func TestTemplateFromString(t *testing.T) {
+	enabled := func(g *stickgen.Generator) {
+		g.StringTemplates = true
+	}
+	testRender(t, []renderTest{
+		{
+			name:      "include tag",
+			templates: map[string]string{"test.twig": `[{% include template_from_string(src) %}]`},
+			options:   enabled,
+			ctx:       `map[string]stick.Value{"src": "Hello {{ name }}"}`,
+			want:      "[<executed __string_template__>]",
+		},
+		{
+			name:      "include function",
+			templates: map[string]string{"test.twig": `{{ include(template_from_string('{{ a }}'), {'a': 1}) }}`},
+			options:   enabled,
+			want:      "<executed __string_template__>",
+		},
+		{
+			name:      "named",
+			templates: map[string]string{"test.twig": `{% include template_from_string(template=src) only %}`},
+			options:   enabled,
+			ctx:       `map[string]stick.Value{"src": "a"}`,
+			want:      "<executed __string_template__>",
+		},
+		{
+			name:      "invalid",
+			templates: map[string]string{"test.twig": `{% include template_from_string(src) %}`},
+			options:   enabled,
+			ctx:       `map[string]stick.Value{"src": "{% if %}"}`,
+			runErr:    "unexpected token",
+		},
+		{
+			name:      "disabled",
+			templates: map[string]string{"test.twig": `{% include template_from_string(src) %}`},
+			err:       "template_from_string is not enabled",
+		},
+		{
+			name:      "outside include",
+			templates: map[string]string{"test.twig": `{{ template_from_string(src) }}`},
+			options:   enabled,
+			err:       "template_from_string may only be used with include",
+		},
+		{
+			name:      "no template",
+			templates: map[string]string{"test.twig": `{% include template_from_string() %}`},
+			options:   enabled,
+			err:       "template_from_string expects a template",
+		},
+	})
+}
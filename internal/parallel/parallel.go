@@ -0,0 +1,29 @@
+// Package parallel calls functions concurrently using a bounded number of
+// goroutines.
+package parallel
+
+import "sync"
+
+// For calls fn for each index from 0 to n, using up to jobs goroutines at
+// once, and waits for the calls to finish. At least one goroutine is used.
+func For(n, jobs int, fn func(i int)) {
+	if jobs < 1 {
+		jobs = 1
+	}
+	idx := make(chan int)
+	var wg sync.WaitGroup
+	for j := 0; j < jobs && j < n; j++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range idx {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		idx <- i
+	}
+	close(idx)
+	wg.Wait()
+}
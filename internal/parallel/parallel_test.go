@@ -0,0 +1,45 @@
+package parallel
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFor(t *testing.T) {
+	tests := []struct {
+		jobs, n, max int
+	}{
+		{1, 10, 1},
+		{3, 10, 3},
+		{8, 3, 8},
+		{4, 0, 4},
+		{0, 5, 1},
+	}
+	for _, tt := range tests {
+		var mu sync.Mutex
+		calls := make([]int, tt.n)
+		running, max := 0, 0
+		For(tt.n, tt.jobs, func(i int) {
+			mu.Lock()
+			calls[i]++
+			running++
+			if running > max {
+				max = running
+			}
+			mu.Unlock()
+			time.Sleep(time.Millisecond)
+			mu.Lock()
+			running--
+			mu.Unlock()
+		})
+		for i, n := range calls {
+			if n != 1 {
+				t.Errorf("%d jobs: expected %d called once, got %d", tt.jobs, i, n)
+			}
+		}
+		if max > tt.max {
+			t.Errorf("%d jobs: expected at most %d calls at once, got %d", tt.jobs, tt.max, max)
+		}
+	}
+}
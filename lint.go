@@ -0,0 +1,36 @@
+package stickgen
+
+// Lint parses and walks each of the named templates without generating
+// code, and returns an error for every construct that cannot be generated,
+// such as an unsupported tag or function. Unlike Generate, walking a
+// template continues after an error, so that each construct is reported.
+// Errors located in a template are TemplateErrors, and an error in a
+// template used by more than one of the named templates is reported once.
+func (g *Generator) Lint(names ...string) Errors {
+	if err := g.validate(); err != nil {
+		return Errors{err}
+	}
+	var problems Errors
+	seen := make(map[string]bool)
+	add := func(err error) {
+		if !seen[err.Error()] {
+			seen[err.Error()] = true
+			problems = append(problems, err)
+		}
+	}
+	for _, name := range names {
+		c := g.clone()
+		c.lint = true
+		err := c.generateTemplates([]string{name})
+		if err == nil {
+			_, err = c.output()
+		}
+		for _, err := range c.problems {
+			add(err)
+		}
+		if err != nil {
+			add(err)
+		}
+	}
+	return problems
+}
@@ -0,0 +1,58 @@
+package stickgen_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/tyler-sommer/stick"
+	"github.com/veonik/go-stickgen"
+)
+
+func TestLint(t *testing.T) {
+	templates := map[string]string{
+		"layout.twig": "[{% block content %}{% endblock %}]\n{{ constant('LAYOUT') }}",
+		"page.twig":   "{% extends 'layout.twig' %}\n{% block content %}\n  {{ constant('FOO') }}\n  {% include name %}\n{% endblock %}",
+		"other.twig":  "{% extends 'layout.twig' %}",
+		"fine.twig":   "Hello, {{ name }}",
+		"broken.twig": "{{ x",
+	}
+	// Each problem is identified by its location, without the column, and
+	// its message.
+	pageProblems := [][2]string{
+		{"page.twig:3:", "undefined constant FOO"},
+		{"page.twig:4:", "Unable to evaluate include reference"},
+		{"layout.twig:2:", "undefined constant LAYOUT"},
+	}
+	g := stickgen.NewGenerator("views", &stick.MemoryLoader{Templates: templates})
+	for _, tt := range []struct {
+		name  string
+		names []string
+		want  [][2]string
+	}{
+		{"fine", []string{"fine.twig"}, nil},
+		{"every construct", []string{"page.twig"}, pageProblems},
+		{"shared templates reported once", []string{"page.twig", "other.twig"}, pageProblems},
+		{"parse error", []string{"broken.twig", "fine.twig"}, [][2]string{{"broken.twig: ", ""}}},
+	} {
+		errs := g.Lint(tt.names...)
+		if len(errs) != len(tt.want) {
+			t.Errorf("%s: expected %d errors, got %d: %v", tt.name, len(tt.want), len(errs), errs)
+			continue
+		}
+		for _, want := range tt.want {
+			found := false
+			for _, err := range errs {
+				msg := err.Error()
+				if strings.HasPrefix(msg, "stickgen: "+want[0]) && strings.HasSuffix(msg, want[1]) {
+					found = true
+				}
+				if _, ok := err.(*stickgen.TemplateError); !ok {
+					t.Errorf("%s: expected a *TemplateError, got %T", tt.name, err)
+				}
+			}
+			if !found {
+				t.Errorf("%s: expected an error at %s%s, got %v", tt.name, want[0], want[1], errs)
+			}
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package stickgen
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/tyler-sommer/stick"
+)
+
+// A CachingLoader loads each template from another Loader only once,
+// keeping its contents in memory. It is safe for concurrent use, even if
+// the Loader it wraps is not.
+type CachingLoader struct {
+	loader    stick.Loader
+	mu        sync.Mutex
+	templates map[string]*cachedTemplate
+}
+
+// NewCachingLoader creates a CachingLoader that loads templates using the
+// given Loader.
+func NewCachingLoader(loader stick.Loader) *CachingLoader {
+	return &CachingLoader{loader: loader, templates: make(map[string]*cachedTemplate)}
+}
+
+// Load implements stick.Loader. Templates that fail to load are not cached.
+func (l *CachingLoader) Load(name string) (stick.Template, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if tpl, ok := l.templates[name]; ok {
+		return tpl, nil
+	}
+	tpl, err := l.loader.Load(name)
+	if err != nil {
+		return nil, err
+	}
+	r := tpl.Contents()
+	contents, err := ioutil.ReadAll(r)
+	if c, ok := r.(io.Closer); ok {
+		c.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+	cached := &cachedTemplate{name: tpl.Name(), contents: contents}
+	l.templates[name] = cached
+	return cached, nil
+}
+
+// A cachedTemplate is a template held in memory, loaded by a CachingLoader
+// or a FilesystemLoader.
+type cachedTemplate struct {
+	name     string
+	contents []byte
+}
+
+func (t *cachedTemplate) Name() string {
+	return t.name
+}
+
+func (t *cachedTemplate) Contents() io.Reader {
+	return bytes.NewReader(t.contents)
+}
+
+// A FilesystemLoader loads templates from the files in a root directory.
+// Templates are named by their slash-separated path relative to the root,
+// which is cleaned before it is resolved, so that "admin/../page.twig"
+// loads the same file as "page.twig". Names that are absolute, or that
+// refer to a file outside of the root, cannot be loaded.
+type FilesystemLoader struct {
+	root string
+}
+
+// NewFilesystemLoader creates a FilesystemLoader that loads templates from
+// the given root directory.
+func NewFilesystemLoader(root string) *FilesystemLoader {
+	return &FilesystemLoader{root: root}
+}
+
+// Load implements stick.Loader.
+func (l *FilesystemLoader) Load(name string) (stick.Template, error) {
+	clean := path.Clean(filepath.ToSlash(name))
+	if path.IsAbs(clean) || filepath.IsAbs(name) || filepath.VolumeName(name) != "" || clean == ".." || strings.HasPrefix(clean, "../") {
+		return nil, fmt.Errorf("stickgen: template %s is outside of %s", name, l.root)
+	}
+	// Templates are read at once, rather than returning an open file, since
+	// callers of Load need not read or close the template's contents.
+	contents, err := ioutil.ReadFile(filepath.Join(l.root, filepath.FromSlash(clean)))
+	if err != nil {
+		return nil, err
+	}
+	return &cachedTemplate{name: clean, contents: contents}, nil
+}
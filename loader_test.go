@@ -0,0 +1,162 @@
+package stickgen_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tyler-sommer/stick"
+	"github.com/veonik/go-stickgen"
+)
+
+// A countingLoader counts the templates it loads, and records whether it
+// was used concurrently, which it does not support.
+type countingLoader struct {
+	stick.Loader
+	loads      map[string]int
+	busy       int32
+	concurrent bool
+}
+
+func (l *countingLoader) Load(name string) (stick.Template, error) {
+	if !atomic.CompareAndSwapInt32(&l.busy, 0, 1) {
+		l.concurrent = true
+		return nil, errors.New("concurrent load")
+	}
+	defer atomic.StoreInt32(&l.busy, 0)
+	// Give concurrent calls a chance to overlap.
+	time.Sleep(time.Millisecond)
+	l.loads[name]++
+	return l.Loader.Load(name)
+}
+
+func TestCachingLoader(t *testing.T) {
+	l := &countingLoader{
+		Loader: &stick.MemoryLoader{Templates: map[string]string{"a.twig": "a", "b.twig": "b"}},
+		loads:  make(map[string]int),
+	}
+	cl := stickgen.NewCachingLoader(l)
+	tests := []struct {
+		name     string
+		contents string
+		err      string
+		loads    int
+	}{
+		{name: "a.twig", contents: "a", loads: 1},
+		{name: "b.twig", contents: "b", loads: 1},
+		{name: "missing.twig", err: "template not found: missing.twig", loads: 8},
+	}
+	for _, tt := range tests {
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				tpl, err := cl.Load(tt.name)
+				checkErr(t, tt.name, "loading", err, tt.err)
+				if err != nil {
+					return
+				}
+				b, err := ioutil.ReadAll(tpl.Contents())
+				if err != nil {
+					t.Error(err)
+				} else if string(b) != tt.contents || tpl.Name() != tt.name {
+					t.Errorf("%s: expected contents %q, got %s: %q", tt.name, tt.contents, tpl.Name(), b)
+				}
+			}()
+		}
+		wg.Wait()
+		if l.concurrent {
+			t.Fatalf("%s: expected the loader not to be used concurrently", tt.name)
+		}
+		// Templates that fail to load are not cached, so they are loaded
+		// again each time.
+		if l.loads[tt.name] != tt.loads {
+			t.Errorf("%s: expected %d loads, got %d", tt.name, tt.loads, l.loads[tt.name])
+		}
+	}
+}
+
+func TestFilesystemLoader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stickgen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeTree(t, dir, map[string]string{
+		"secret.twig":              "secret",
+		"templates/page.twig":      "page",
+		"templates/admin/row.twig": "row",
+	})
+	l := stickgen.NewFilesystemLoader(filepath.Join(dir, "templates"))
+	tests := []struct {
+		name     string
+		tplName  string
+		contents string
+		err      string
+	}{
+		{name: "page.twig", tplName: "page.twig", contents: "page"},
+		{name: "admin/row.twig", tplName: "admin/row.twig", contents: "row"},
+		{name: "./admin/../page.twig", tplName: "page.twig", contents: "page"},
+		{name: "admin//row.twig", tplName: "admin/row.twig", contents: "row"},
+		{name: "missing.twig", err: "no such file"},
+		{name: "../secret.twig", err: "template ../secret.twig is outside of"},
+		{name: "admin/../../secret.twig", err: "template admin/../../secret.twig is outside of"},
+		{name: "/secret.twig", err: "template /secret.twig is outside of"},
+		{name: filepath.Join(dir, "secret.twig"), err: "is outside of"},
+	}
+	for _, tt := range tests {
+		tpl, err := l.Load(tt.name)
+		checkErr(t, tt.name, "loading", err, tt.err)
+		if err != nil {
+			continue
+		}
+		b, err := ioutil.ReadAll(tpl.Contents())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != tt.contents || tpl.Name() != tt.tplName {
+			t.Errorf("%s: expected %s with contents %q, got %s: %q", tt.name, tt.tplName, tt.contents, tpl.Name(), b)
+		}
+	}
+}
+
+func TestFilesystemLoaderGenerate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stickgen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeTree(t, dir, map[string]string{
+		"secret.twig":                "secret",
+		"templates/layout.twig":      `[{% block content %}{% endblock %}]`,
+		"templates/admin/index.twig": `{% extends 'layout.twig' %}{% block content %}{% include 'admin/row.twig' %}{% endblock %}`,
+		"templates/admin/row.twig":   `row`,
+		"templates/escape.twig":      `{{ x }}{% include '../secret.twig' %}`,
+		"templates/absolute.twig":    `{{ x }}{% include '/secret.twig' %}`,
+	})
+	tests := []struct {
+		name    string
+		sources []string
+		err     string
+	}{
+		{name: "admin/index.twig", sources: []string{"admin/index.twig", "admin/row.twig", "layout.twig"}},
+		{name: "escape.twig", err: "stickgen: escape.twig:1:"},
+		{name: "absolute.twig", err: "template /secret.twig is outside of"},
+		{name: "../secret.twig", err: "stickgen: ../secret.twig: template ../secret.twig is outside of"},
+	}
+	for _, tt := range tests {
+		g := stickgen.NewGenerator("views", stickgen.NewFilesystemLoader(filepath.Join(dir, "templates")))
+		names, err := g.Dependencies(tt.name)
+		checkErr(t, tt.name, "generating", err, tt.err)
+		if err == nil && !reflect.DeepEqual(names, tt.sources) {
+			t.Errorf("%s: expected sources %v, got %v", tt.name, tt.sources, names)
+		}
+	}
+}
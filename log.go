@@ -0,0 +1,29 @@
+package stickgen
+
+// A Logger records the progress of a Generator, along with warnings and
+// constructs that are skipped when generating code. Each message is followed
+// by alternating keys and values describing it. Its methods match those of
+// *slog.Logger, so a *slog.Logger may be used as a Logger.
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+// nopLogger is a Logger that discards every message.
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, ...interface{}) {}
+func (nopLogger) Info(string, ...interface{})  {}
+func (nopLogger) Warn(string, ...interface{})  {}
+func (nopLogger) Error(string, ...interface{}) {}
+
+// log returns the Logger of the Generator, which discards messages if none
+// is set.
+func (g *Generator) log() Logger {
+	if g.Logger == nil {
+		return nopLogger{}
+	}
+	return g.Logger
+}
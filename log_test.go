@@ -0,0 +1,53 @@
+package stickgen_test
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/tyler-sommer/stick"
+	"github.com/veonik/go-stickgen"
+)
+
+// recordingLogger records each message logged, along with its level and
+// arguments.
+type recordingLogger struct {
+	mu   sync.Mutex
+	msgs []string
+}
+
+func (l *recordingLogger) record(level, msg string, args []interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.msgs = append(l.msgs, fmt.Sprintf("%s %s %v", level, msg, args))
+}
+
+func (l *recordingLogger) Debug(msg string, args ...interface{}) { l.record("DEBUG", msg, args) }
+func (l *recordingLogger) Info(msg string, args ...interface{})  { l.record("INFO", msg, args) }
+func (l *recordingLogger) Warn(msg string, args ...interface{})  { l.record("WARN", msg, args) }
+func (l *recordingLogger) Error(msg string, args ...interface{}) { l.record("ERROR", msg, args) }
+
+func TestLogger(t *testing.T) {
+	templates := map[string]string{
+		"test.twig": `{{ x }}{% include 'missing.twig' ignore missing %}{{ dump(x) }}{{ source('gone.twig', true) }}`,
+	}
+	g := stickgen.NewGenerator("views", &stick.MemoryLoader{Templates: templates})
+	if _, err := g.Generate("test.twig"); err != nil {
+		t.Fatalf("unexpected error generating without a logger: %s", err)
+	}
+	l := &recordingLogger{}
+	g.Logger = l
+	if _, err := g.Generate("test.twig"); err != nil {
+		t.Fatalf("unexpected error generating: %s", err)
+	}
+	expected := []string{
+		"DEBUG generating template [template test.twig]",
+		"INFO skipping missing include [template test.twig include missing.twig]",
+		"DEBUG skipping dump since Debug is disabled [template test.twig]",
+		"INFO skipping missing source [template test.twig source gone.twig]",
+	}
+	if !reflect.DeepEqual(l.msgs, expected) {
+		t.Errorf("expected messages %q, got %q", expected, l.msgs)
+	}
+}
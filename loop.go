@@ -0,0 +1,289 @@
+package stickgen
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/tyler-sommer/stick/parse"
+)
+
+var forPattern = regexp.MustCompile(`(?s)^(\w+(?:\s*,\s*\w+)?\s+in)\s+(.+)$`)
+
+// splitForCondition splits the arguments of a for tag into the loop itself
+// and its inline condition, if it has one.
+func splitForCondition(args string) (loop, expr, cond string, ok bool) {
+	m := forPattern.FindStringSubmatch(args)
+	if m == nil {
+		return "", "", "", false
+	}
+	var quote byte
+	depth := 0
+	rest := m[2]
+	for i := 0; i < len(rest); i++ {
+		c := rest[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '(' || c == '[' || c == '{':
+			depth++
+		case c == ')' || c == ']' || c == '}':
+			depth--
+		case depth == 0 && strings.HasPrefix(rest[i:], "if") && i > 0 && isSpace(rest[i-1]) && i+2 < len(rest) && isSpace(rest[i+2]):
+			return m[1], strings.TrimSpace(rest[:i]), strings.TrimSpace(rest[i+2:]), true
+		}
+	}
+	return "", "", "", false
+}
+
+// forCondition returns the inline condition of a for tag, if any, along with
+// the expression being iterated over.
+func (g *Generator) forCondition(node *parse.ForNode) (parse.Expr, parse.Expr, error) {
+	f, ok := node.X.(*parse.FilterExpr)
+	if !ok {
+		return node.X, nil, nil
+	}
+	d, ok := g.lookupDirective(f.Name)
+	if !ok {
+		return node.X, nil, nil
+	}
+	cond, err := parseExpr(d.args)
+	if err != nil {
+		return nil, nil, err
+	}
+	return f.Args[0], cond, nil
+}
+
+func (g *Generator) walkFor(node *parse.ForNode) error {
+	if start, step, count, ok := g.countedRange(node.X); ok {
+		// A range with literal bounds is never empty, so any else body is
+		// never rendered.
+		return g.walkCountedFor(node, start, step, count)
+	}
+	x, cond, err := g.forCondition(node)
+	if err != nil {
+		return err
+	}
+	name, err := g.walkExpr(x)
+	if err != nil {
+		return err
+	}
+	// The key and value shadow any local variables of the same name until
+	// the end of the loop.
+	prevArgs := g.args
+	g.args = make(map[string]string, len(prevArgs)+2)
+	for k, v := range prevArgs {
+		g.args[k] = v
+	}
+	key := "_"
+	if node.Key != "" {
+		key = local(node.Key)
+		g.args[node.Key] = key
+	}
+	val := local(node.Val)
+	g.args[node.Val] = val
+	g.out.WriteString(g.comment(node.Pos))
+	g.out.WriteString(fmt.Sprintf(`%s{
+`, g.indent()))
+	g.tabs++
+	// The iterable and its length are evaluated once, not for every item.
+	items := name.resultantName
+	if name.isFunction {
+		g.out.WriteString(fmt.Sprintf(`%s%s
+`, g.indent(), g.handleErr(name)))
+	} else {
+		items = g.temp("items")
+		g.out.WriteString(fmt.Sprintf(`%s%s := %s
+`, g.indent(), items, name.resultantName))
+	}
+	g.addImport(runtimeImport)
+	length := g.temp("length")
+	g.out.WriteString(fmt.Sprintf(`%s%s := stickgen.Length(%s)
+`, g.indent(), length, items))
+	hasElse := node.Else != nil && len(node.Else.All()) > 0
+	// The parameters of the closure are named uniquely so as not to collide
+	// with the key and value.
+	l := g.temp("l")
+	iterate := fmt.Sprintf(`stick.Iterate(%s, func(%s, %s stick.Value, %s stick.Loop) (%s bool, %s error) {
+`, items, key, val, l, g.temp("brk"), g.temp("err"))
+	// The else body is rendered when nothing was iterated over. The count is
+	// named uniquely so as not to shadow any local variable in the else body.
+	n := g.temp("n")
+	if cond != nil {
+		// Only items matching the condition are counted.
+		n = g.temp("matched")
+		g.out.WriteString(fmt.Sprintf(`%s%s := 0
+`, g.indent(), n))
+	}
+	check := n + " == 0"
+	switch {
+	case !g.IgnoreErrors && hasElse && cond == nil:
+		iterate = "if " + n + ", err := " + iterate
+	case !g.IgnoreErrors:
+		iterate = "if _, err := " + iterate
+	case hasElse && cond == nil:
+		iterate = "if " + n + ", _ := " + iterate
+	case hasElse:
+		iterate = "if " + iterate
+	}
+	g.out.WriteString(g.indent() + iterate)
+	g.tabs++
+	prevRet := g.ret
+	g.ret = "return false, err"
+	if cond != nil {
+		if err := g.walkForCondition(cond, l, n); err != nil {
+			return err
+		}
+	}
+	g.out.WriteString(g.checkDone())
+	parent := g.loop
+	if parent == "" {
+		parent = "nil"
+	}
+	prevLoop := g.loop
+	g.loops++
+	g.loop = fmt.Sprintf("loop%d", g.loops)
+	g.out.WriteString(fmt.Sprintf(`%s%s := stickgen.NewCountedLoop(%s, %s, %s)
+%s_ = %s
+`, g.indent(), g.loop, l, length, parent, g.indent(), g.loop))
+	err = g.walkScope(node.Body)
+	g.loops--
+	g.loop = prevLoop
+	g.ret = prevRet
+	g.args = prevArgs
+	if err != nil {
+		return err
+	}
+	g.out.WriteString(fmt.Sprintf(`%sreturn false, nil
+`, g.indent()))
+	g.tabs--
+	if !g.IgnoreErrors {
+		g.out.WriteString(fmt.Sprintf(`%s}); err != nil {
+%s	%s
+`, g.indent(), g.indent(), g.ret))
+		if hasElse {
+			g.out.WriteString(fmt.Sprintf(`%s} else if %s {
+`, g.indent(), check))
+			g.tabs++
+			if err := g.walkScope(node.Else); err != nil {
+				return err
+			}
+			g.tabs--
+		}
+		g.out.WriteString(fmt.Sprintf(`%s}
+`, g.indent()))
+	} else if hasElse {
+		g.out.WriteString(fmt.Sprintf(`%s}); %s {
+`, g.indent(), check))
+		g.tabs++
+		if err := g.walkScope(node.Else); err != nil {
+			return err
+		}
+		g.tabs--
+		g.out.WriteString(fmt.Sprintf(`%s}
+`, g.indent()))
+	} else {
+		g.out.WriteString(fmt.Sprintf(`%s})
+`, g.indent()))
+	}
+	g.tabs--
+	g.out.WriteString(fmt.Sprintf(`%s}
+`, g.indent()))
+	return nil
+}
+
+// walkCountedFor generates a native loop over count integers, beginning at
+// start and incremented by step.
+func (g *Generator) walkCountedFor(node *parse.ForNode, start, step, count int) error {
+	// The key and value shadow any local variables of the same name until
+	// the end of the loop.
+	prevArgs := g.args
+	g.args = make(map[string]string, len(prevArgs)+2)
+	for k, v := range prevArgs {
+		g.args[k] = v
+	}
+	key := "_"
+	if node.Key != "" {
+		key = local(node.Key)
+		g.args[node.Key] = key
+	}
+	val := local(node.Val)
+	g.args[node.Val] = val
+	// The counter is named uniquely so as not to shadow any local variable.
+	n := g.temp("n")
+	next := n
+	if step != 1 {
+		next = fmt.Sprintf("%s*%d", n, step)
+	}
+	if start != 0 {
+		next = fmt.Sprintf("%d+%s", start, next)
+	}
+	unused := fmt.Sprintf("_ = %s", val)
+	if key != "_" {
+		unused = fmt.Sprintf("_, _ = %s, %s", key, val)
+	}
+	g.out.WriteString(g.comment(node.Pos))
+	g.out.WriteString(fmt.Sprintf(`%sfor %s := 0; %s < %d; %s++ {
+%s	var %s, %s stick.Value = %s, %s
+%s	%s
+`, g.indent(), n, n, count, n, g.indent(), key, val, n, next, g.indent(), unused))
+	g.tabs++
+	g.out.WriteString(g.checkDone())
+	g.addImport(runtimeImport)
+	parent := g.loop
+	if parent == "" {
+		parent = "nil"
+	}
+	prevLoop := g.loop
+	g.loops++
+	g.loop = fmt.Sprintf("loop%d", g.loops)
+	g.out.WriteString(fmt.Sprintf(`%s%s := stickgen.NewCountedLoop(stick.Loop{Last: %s == %d, Index: %s + 1, Index0: %s}, %d, %s)
+%s_ = %s
+`, g.indent(), g.loop, n, count-1, n, n, count, parent, g.indent(), g.loop))
+	err := g.walkScope(node.Body)
+	g.loops--
+	g.loop = prevLoop
+	g.args = prevArgs
+	if err != nil {
+		return err
+	}
+	g.tabs--
+	g.out.WriteString(fmt.Sprintf(`%s}
+`, g.indent()))
+	return nil
+}
+
+// walkForCondition skips any items that do not match the inline condition
+// of a for tag, renumbering the loop l to count only those that do in
+// matched. As in Twig, loop.length and loop.last are not meaningful for such
+// loops.
+func (g *Generator) walkForCondition(cond parse.Expr, l, matched string) error {
+	c, err := g.walkExpr(cond)
+	if err != nil {
+		return err
+	}
+	check := "!stick.CoerceBool(" + c.resultantName + ")"
+	if c.isFunction {
+		g.out.WriteString(fmt.Sprintf(`%s%s
+`, g.indent(), c.body))
+		if c.hasError && g.IgnoreErrors {
+			check = "err != nil || " + check
+		} else if c.hasError {
+			g.out.WriteString(fmt.Sprintf(`%s%s
+`, g.indent(), g.checkErr(c)))
+		}
+	}
+	g.out.WriteString(fmt.Sprintf(`%sif %s {
+%s	return false, nil
+%s}
+%s%s.Index0, %s.Index = %s, %s+1
+%s%s++
+`, g.indent(), check, g.indent(), g.indent(), g.indent(), l, l, matched, matched, g.indent(), matched))
+	return nil
+}
@@ -0,0 +1,269 @@
+package stickgen_test
+
+import (
+	"testing"
+
+	"github.com/veonik/go-stickgen"
+)
+
+func TestForElse(t *testing.T) {
+	tpl := map[string]string{"test.twig": `{% for i in items %}{{ i }}{% else %}empty{% endfor %}`}
+	ignoreErrors := func(g *stickgen.Generator) {
+		g.IgnoreErrors = true
+	}
+	// Templates generated with IgnoreErrors do not return an error.
+	ignored := `TemplateTestTwig(env, output, ctx)
+	var err error`
+	testRender(t, []renderTest{
+		{name: "items", templates: tpl, ctx: `map[string]stick.Value{"items": []stick.Value{1, 2}}`, want: "12"},
+		{name: "empty", templates: tpl, ctx: `map[string]stick.Value{"items": []stick.Value{}}`, want: "empty"},
+		{name: "nil", templates: tpl, ctx: `map[string]stick.Value{"items": nil}`, want: "empty"},
+		{name: "empty map", templates: tpl, ctx: `map[string]stick.Value{"items": map[string]stick.Value{}}`, want: "empty"},
+		{name: "ignore errors", templates: tpl, options: ignoreErrors, ctx: `map[string]stick.Value{"items": []stick.Value{}}`, call: ignored, want: "empty"},
+		{name: "ignore errors items", templates: tpl, options: ignoreErrors, ctx: `map[string]stick.Value{"items": []stick.Value{1}}`, call: ignored, want: "1"},
+		{
+			name:      "function",
+			templates: map[string]string{"test.twig": `{% for i in items() %}{{ i }}{% else %}empty{% endfor %}`},
+			setup: `env.Functions["items"] = func(ctx stick.Context, args ...stick.Value) stick.Value {
+		return nil
+	}`,
+			want: "empty",
+		},
+		{
+			name:      "range",
+			templates: map[string]string{"test.twig": `{% for i in 1..3 %}{{ i }}{% else %}empty{% endfor %}`},
+			want:      "123",
+		},
+		{
+			name:      "nested",
+			templates: map[string]string{"test.twig": `{% for row in rows %}[{% for i in row %}{{ i }}{% else %}-{% endfor %}]{% else %}none{% endfor %}`},
+			ctx:       `map[string]stick.Value{"rows": []stick.Value{[]stick.Value{1}, []stick.Value{}}}`,
+			want:      "[1][-]",
+		},
+		{
+			name:      "else scope",
+			templates: map[string]string{"test.twig": `{% set i = 'x' %}{% for i in items %}{{ i }}{% else %}{{ i }}{% endfor %}{{ i }}`},
+			ctx:       `map[string]stick.Value{"items": []stick.Value{}}`,
+			want:      "xx",
+		},
+		{
+			name:      "restores shadowed variable",
+			templates: map[string]string{"test.twig": `{% set i = 'x' %}{% for i in [1, 2] %}{{ i }}{% endfor %}{{ i }}{% for i in 1..2 %}{{ i }}{% endfor %}{{ i }}`},
+			want:      "12x12x",
+		},
+		{
+			name:      "not iterable",
+			templates: tpl,
+			ctx:       `map[string]stick.Value{"items": 1}`,
+			runErr:    "not iterable",
+		},
+	})
+}
+
+func TestLoopVariable(t *testing.T) {
+	items := `map[string]stick.Value{"items": []stick.Value{"a", "b", "c"}}`
+	// The list function counts how often it is called.
+	counted := `calls := 0
+	env.Functions["list"] = func(ctx stick.Context, args ...stick.Value) stick.Value {
+		calls++
+		return []stick.Value{"a", "b"}
+	}`
+	calls := `err := TemplateTestTwig(env, output, ctx)
+	fmt.Fprint(output, calls)`
+	testRender(t, []renderTest{
+		{
+			name:      "index",
+			templates: map[string]string{"test.twig": `{% for i in items %}{{ loop.index }}{{ loop.index0 }},{% endfor %}`},
+			ctx:       items,
+			want:      "10,21,32,",
+		},
+		{
+			name:      "first and last",
+			templates: map[string]string{"test.twig": `{% for i in items %}{% if loop.first %}[{% endif %}{{ i }}{% if loop.last %}]{% else %},{% endif %}{% endfor %}`},
+			ctx:       items,
+			want:      "[a,b,c]",
+		},
+		{
+			name:      "length and revindex",
+			templates: map[string]string{"test.twig": `{% for i in items %}{{ loop.length }}{{ loop.revindex }}{{ loop.revindex0 }},{% endfor %}`},
+			ctx:       items,
+			want:      "332,321,310,",
+		},
+		{
+			name:      "iterable evaluated once",
+			templates: map[string]string{"test.twig": `{% for i in list() %}{{ i }}{{ loop.revindex }},{% endfor %}`},
+			setup:     counted,
+			call:      calls,
+			want:      "a2,b1,1",
+		},
+		{
+			name:      "range",
+			templates: map[string]string{"test.twig": `{% for i in 0..4 %}{% if loop.first %}first{% endif %}{{ loop.index }}/{{ loop.length }}{% if loop.last %}last{% endif %},{% endfor %}`},
+			want:      "first1/5,2/5,3/5,4/5,5/5last,",
+		},
+		{
+			name:      "map",
+			templates: map[string]string{"test.twig": `{% for k, v in m %}{{ loop.index }}{{ loop.length }}{% endfor %}`},
+			ctx:       `map[string]stick.Value{"m": map[string]stick.Value{"a": 1, "b": 2}}`,
+			want:      "1222",
+		},
+		{
+			name:      "outside loop",
+			templates: map[string]string{"test.twig": `{% for i in items %}{% endfor %}{{ loop }}`},
+			ctx:       `map[string]stick.Value{"items": []stick.Value{1}, "loop": "ctx"}`,
+			want:      "ctx",
+		},
+		{
+			name: "include",
+			templates: map[string]string{
+				"row.twig":  `{{ loop.index }}{{ i }}`,
+				"test.twig": `{% for i in items %}{% include 'row.twig' %}{% endfor %}`,
+			},
+			ctx:  items,
+			want: "1a2b3c",
+		},
+		{
+			name:      "named l",
+			templates: map[string]string{"test.twig": `{% for l in items %}{{ l }}{{ loop.index }}{% endfor %}`},
+			ctx:       items,
+			want:      "a1b2c3",
+		},
+		{
+			name:      "named err",
+			templates: map[string]string{"test.twig": `{% for brk, err in items %}{{ brk }}{{ err }}{{ loop.index }}{% endfor %}`},
+			ctx:       items,
+			want:      "0a11b22c3",
+		},
+	})
+}
+
+func TestLoopParent(t *testing.T) {
+	rows := `map[string]stick.Value{"rows": []stick.Value{[]stick.Value{"a", "b"}, []stick.Value{"c"}}}`
+	testRender(t, []renderTest{
+		{
+			name:      "parent",
+			templates: map[string]string{"test.twig": `{% for row in rows %}{% for i in row %}{{ loop.parent.index }}.{{ loop.index }}{{ i }},{% endfor %}{% endfor %}`},
+			ctx:       rows,
+			want:      "1.1a,1.2b,2.1c,",
+		},
+		{
+			name:      "restored after inner loop",
+			templates: map[string]string{"test.twig": `{% for row in rows %}{% for i in row %}{% endfor %}{{ loop.index }}{% endfor %}`},
+			ctx:       rows,
+			want:      "12",
+		},
+		{
+			name:      "grandparent",
+			templates: map[string]string{"test.twig": `{% for a in 1..2 %}{% for b in 1..1 %}{% for c in [1] %}{{ loop.parent.parent.index }}{% endfor %}{% endfor %}{% endfor %}`},
+			want:      "12",
+		},
+		{
+			name:      "parent last",
+			templates: map[string]string{"test.twig": `{% for row in rows %}{% for i in row %}{{ i }}{% if loop.last and not loop.parent.last %},{% endif %}{% endfor %}{% endfor %}`},
+			ctx:       rows,
+			want:      "ab,c",
+		},
+	})
+}
+
+func TestForCondition(t *testing.T) {
+	users := `map[string]stick.Value{"users": []stick.Value{
+		map[string]stick.Value{"name": "a", "active": true},
+		map[string]stick.Value{"name": "b", "active": false},
+		map[string]stick.Value{"name": "c", "active": true},
+	}}`
+	testRender(t, []renderTest{
+		{
+			name:      "condition",
+			templates: map[string]string{"test.twig": `{% for u in users if u.active %}{{ u.name }}{% endfor %}`},
+			ctx:       users,
+			want:      "ac",
+		},
+		{
+			name:      "index counts matches",
+			templates: map[string]string{"test.twig": `{% for u in users if u.active %}{{ loop.index }}{{ loop.index0 }}{% if loop.first %}!{% endif %},{% endfor %}`},
+			ctx:       users,
+			want:      "10!,21,",
+		},
+		{
+			name:      "else",
+			templates: map[string]string{"test.twig": `{% for u in users if u.name == 'z' %}{{ u.name }}{% else %}none{% endfor %}`},
+			ctx:       users,
+			want:      "none",
+		},
+		{
+			name:      "else with matches",
+			templates: map[string]string{"test.twig": `{% for u in users if not u.active %}{{ u.name }}{% else %}none{% endfor %}`},
+			ctx:       users,
+			want:      "b",
+		},
+		{
+			name:      "named matched",
+			templates: map[string]string{"test.twig": `{% for matched in [0, 1, 2] if matched %}{{ matched }}{{ loop.index }}{% endfor %}`},
+			want:      "1122",
+		},
+		{
+			name:      "outer n in else",
+			templates: map[string]string{"test.twig": `{% set n = 5 %}{% set matched = 6 %}{% for i in items %}{{ i }}{% else %}{{ n }}{% endfor %}{% for i in items if i %}{{ i }}{% else %}{{ matched }}{% endfor %}`},
+			ctx:       `map[string]stick.Value{"items": []stick.Value{}}`,
+			want:      "56",
+		},
+		{
+			name:      "key",
+			templates: map[string]string{"test.twig": `{% for k, v in [1, 2, 3, 4] if v is even %}{{ k }}{% endfor %}`},
+			want:      "13",
+		},
+		{
+			name:      "iterable evaluated once",
+			templates: map[string]string{"test.twig": `{% for i in list() if i != 'b' %}{{ i }}{{ loop.index }},{% endfor %}`},
+			setup: `calls := 0
+	env.Functions["list"] = func(ctx stick.Context, args ...stick.Value) stick.Value {
+		calls++
+		return []stick.Value{"a", "b", "c"}
+	}`,
+			call: `err := TemplateTestTwig(env, output, ctx)
+	fmt.Fprint(output, calls)`,
+			want: "a1,c2,1",
+		},
+		{
+			name:      "function",
+			templates: map[string]string{"test.twig": `{% for i in [1, 2, 3] if keep(i) %}{{ i }}{% endfor %}`},
+			setup: `env.Functions["keep"] = func(ctx stick.Context, args ...stick.Value) stick.Value {
+		return args[0] != 2
+	}`,
+			want: "13",
+		},
+		{
+			name:      "quoted if",
+			templates: map[string]string{"test.twig": `{% for s in ['a if b', 'c'] if s != 'c' %}{{ s }}{% endfor %}`},
+			want:      "a if b",
+		},
+		{
+			name:      "condition error",
+			templates: map[string]string{"test.twig": `{% for u in users if u.missing %}{{ u.name }}{% endfor %}`},
+			ctx:       users,
+			runErr:    "missing",
+		},
+		{
+			name:      "ignore errors",
+			templates: map[string]string{"test.twig": `{% for u in users if u.missing or u.active %}{{ u.name }}{% endfor %}`},
+			options: func(g *stickgen.Generator) {
+				g.IgnoreErrors = true
+			},
+			ctx: users,
+			call: `TemplateTestTwig(env, output, ctx)
+	var err error`,
+			want: "ac",
+		},
+		{
+			name:      "invalid condition",
+			templates: map[string]string{"test.twig": `{% for u in users if %}{% endfor %}`},
+			err:       "unexpected token",
+		},
+		{
+			name:      "top level",
+			templates: map[string]string{"test.twig": `{% for i in [1] %}{% if loop.parent is null %}none{% endif %}{% endfor %}`},
+			want:      "none",
+		},
+	})
+}
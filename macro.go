@@ -0,0 +1,165 @@
+package stickgen
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/tyler-sommer/stick/parse"
+)
+
+// A macro is a macro definition and the renderer that generates it.
+type macro struct {
+	node   *parse.MacroNode
+	render renderer
+}
+
+// A binding is a name imported into a template's macro namespace. It refers
+// either to all macros in a template, or to a single macro.
+type binding struct {
+	tplName string
+	macro   string
+}
+
+// macroName returns the name of the generated function for a macro.
+func (g *Generator) macroName(tplName, name string) string {
+	return fmt.Sprintf("macro%s%s", g.templateIdent(tplName), g.Naming.Identifier(name))
+}
+
+// addMacros registers each macro defined in the given module. Macros are
+// generated as standalone functions that accept their arguments as
+// stick.Values.
+func (g *Generator) addMacros(tplName string, module *parse.ModuleNode) {
+	for _, n := range module.All() {
+		node, ok := n.(*parse.MacroNode)
+		if !ok {
+			continue
+		}
+		g.register(g.macroName(tplName, node.Name))
+		g.macros[g.macroName(tplName, node.Name)] = macro{node, func(g *Generator, node *parse.MacroNode, tplName string) renderer {
+			return func() error {
+				prevName, prevArgs, prevTemps := g.name, g.args, g.temps
+				g.name, g.temps = tplName, 0
+				g.args = make(map[string]string)
+				defer func() {
+					g.name, g.args, g.temps = prevName, prevArgs, prevTemps
+				}()
+				params := ""
+				for _, arg := range node.Args {
+					g.args[arg] = local(arg)
+					params += ", " + g.args[arg]
+				}
+				if params != "" {
+					params += " stick.Value"
+				}
+				g.out.WriteString(fmt.Sprintf(`func %s(%senv *stick.Env, output io.Writer, ctx map[string]stick.Value%s)%s {
+`, g.macroName(tplName, node.Name), g.params(), params, g.result()))
+				if err := g.walk(node.Body); err != nil {
+					return err
+				}
+				g.out.WriteString(g.returnNil() + `}`)
+				return nil
+			}
+		}(g, node, tplName)}
+	}
+}
+
+// walkMacroAttr generates a call to a macro referenced as an attribute of a
+// template, such as _self.input().
+func (g *Generator) walkMacroAttr(tplName string, expr *parse.GetAttrExpr) (evaluatedExpr, error) {
+	attr, ok := expr.Attr.(*parse.StringExpr)
+	if !ok {
+		return emptyExpr, fmt.Errorf("stickgen: unable to evaluate macro name in %s", tplName)
+	}
+	return g.walkMacroCall(tplName, attr.Text, expr.Args)
+}
+
+// walkMacroCall generates a call to a macro defined in the given template,
+// capturing its output.
+func (g *Generator) walkMacroCall(tplName string, macro string, exprs []parse.Expr) (evaluatedExpr, error) {
+	name := g.macroName(tplName, macro)
+	m, ok := g.macros[name]
+	if !ok {
+		return emptyExpr, fmt.Errorf("stickgen: undefined macro %s in %s", macro, tplName)
+	}
+	body, args, err := g.walkArgs(exprs, append([]string{}, m.node.Args...))
+	if err != nil {
+		return emptyExpr, err
+	}
+	if body != "" {
+		body += g.indent() + "	"
+	}
+	g.addImport("bytes")
+	// Missing arguments are passed as nil and extra arguments are ignored.
+	params := ""
+	for i := range m.node.Args {
+		if i < len(args) {
+			params += ", " + args[i]
+		} else {
+			params += ", nil"
+		}
+	}
+	macroval := g.temp("macroval")
+	return evaluatedExpr{
+		body: fmt.Sprintf(`%s%s := &bytes.Buffer{}
+%s	%s`, body, macroval, g.indent(), g.checked(fmt.Sprintf("%s(%senv, %s, nil%s)", name, g.callArgs(), macroval, params), "err")),
+		resultantName: macroval + ".String()",
+		isFunction:    true,
+		hasError:      false,
+		safe:          true,
+		isString:      true,
+	}, nil
+}
+
+// walkImport makes the macros defined in another template available under
+// the given alias.
+func (g *Generator) walkImport(node *parse.ImportNode) error {
+	tplName, err := g.macroSource(node.Tpl)
+	if err != nil {
+		return err
+	}
+	g.bind(node.Alias, binding{tplName: tplName})
+	return nil
+}
+
+// walkFrom binds individual macros defined in another template.
+func (g *Generator) walkFrom(node *parse.FromNode) error {
+	tplName, err := g.macroSource(node.Tpl)
+	if err != nil {
+		return err
+	}
+	for name, alias := range node.Imports {
+		if _, ok := g.macros[g.macroName(tplName, name)]; !ok {
+			return fmt.Errorf("stickgen: undefined macro %s in %s", name, tplName)
+		}
+		g.bind(alias, binding{tplName: tplName, macro: name})
+	}
+	return nil
+}
+
+// macroSource resolves the template referenced by an import, registering
+// its macros.
+func (g *Generator) macroSource(e parse.Expr) (string, error) {
+	if name, ok := e.(*parse.NameExpr); ok && name.Name == "_self" {
+		return g.name, nil
+	}
+	tplName, ok := g.evaluate(e)
+	if !ok {
+		return "", errors.New("Unable to evaluate import reference")
+	}
+	if tplName != g.name {
+		if _, err := g.parse(tplName); err != nil {
+			return "", err
+		}
+	}
+	return tplName, nil
+}
+
+// bind adds an entry to the current template's macro namespace.
+func (g *Generator) bind(alias string, b binding) {
+	ns, ok := g.namespaces[g.name]
+	if !ok {
+		ns = make(map[string]binding)
+		g.namespaces[g.name] = ns
+	}
+	ns[alias] = b
+}
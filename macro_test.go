@@ -0,0 +1,166 @@
+package stickgen_test
+
+import "testing"
+
+func TestMacro(t *testing.T) {
+	testRender(t, []renderTest{
+		{
+			name:      "call",
+			templates: map[string]string{"test.twig": `{% macro input(name, value) %}<input name="{{ name }}" value="{{ value }}">{% endmacro %}{{ _self.input('user', 'Bob') }}`},
+			want:      `<input name="user" value="Bob">`,
+		},
+		{
+			name:      "keyword parameter",
+			templates: map[string]string{"test.twig": `{% macro input(name, value, type) %}<input type="{{ type }}" name="{{ name }}" value="{{ value }}">{% endmacro %}{{ _self.input('user', 'Bob', 'text') }}`},
+			want:      `<input type="text" name="user" value="Bob">`,
+		},
+		{
+			name:      "parameters named like generated ones",
+			templates: map[string]string{"test.twig": `{% macro m(output, env, ctx) %}{{ output }}{{ env }}{{ ctx }}{% endmacro %}{{ _self.m('a', 'b', 'c') }}`},
+			want:      "abc",
+		},
+		{
+			name:      "called twice",
+			templates: map[string]string{"test.twig": `{% macro em(s) %}<em>{{ s }}</em>{% endmacro %}{{ _self.em('a') }}{{ _self.em(name) }}`},
+			ctx:       `map[string]stick.Value{"name": "b"}`,
+			want:      "<em>a</em><em>b</em>",
+		},
+		{
+			name:      "missing argument",
+			templates: map[string]string{"test.twig": `{% macro pair(a, b) %}{{ a }}{% if b is not defined or b is null %}!{% endif %}{% endmacro %}{{ _self.pair('x') }}`},
+			want:      "x!",
+		},
+		{
+			name:      "calls macro",
+			templates: map[string]string{"test.twig": `{% macro inner(s) %}[{{ s }}]{% endmacro %}{% macro outer(s) %}{{ _self.inner(s ~ s) }}{% endmacro %}{{ _self.outer('a') }}`},
+			want:      "[aa]",
+		},
+		{
+			name:      "set in macro",
+			templates: map[string]string{"test.twig": `{% macro m() %}{% set x = 'in' %}{{ x }}{% endmacro %}{{ _self.m() }}{{ x }}`},
+			ctx:       `map[string]stick.Value{"x": "out"}`,
+			want:      "inout",
+		},
+		{
+			name:      "escaped once",
+			templates: map[string]string{"test.twig": `{% macro m(s) %}{% autoescape 'html' %}<i>{{ s }}</i>{% endautoescape %}{% endmacro %}{% autoescape 'html' %}{{ _self.m(html) }}{% endautoescape %}`},
+			ctx:       `map[string]stick.Value{"html": "<b>"}`,
+			want:      "<i>&lt;b&gt;</i>",
+		},
+		{
+			name:      "undefined",
+			templates: map[string]string{"test.twig": `{{ _self.nope() }}`},
+			err:       "undefined macro nope",
+		},
+		{
+			name:      "invalid argument",
+			templates: map[string]string{"test.twig": `{% macro m(s) %}{{ s }}{% endmacro %}{{ _self.m(1 +) }}`},
+			err:       "unexpected token",
+		},
+	})
+}
+
+func TestImport(t *testing.T) {
+	forms := `{% macro input(name) %}<input name="{{ name }}">{% endmacro %}{% macro label(s) %}<label>{{ s }}</label>{% endmacro %}`
+	testRender(t, []renderTest{
+		{
+			name: "import",
+			templates: map[string]string{
+				"forms.twig": forms,
+				"test.twig":  `{% import 'forms.twig' as forms %}{{ forms.label('Name') }}{{ forms.input('name') }}`,
+			},
+			want: `<label>Name</label><input name="name">`,
+		},
+		{
+			name:      "self",
+			templates: map[string]string{"test.twig": `{% import _self as m %}{% macro em(s) %}<em>{{ s }}</em>{% endmacro %}{{ m.em('a') }}`},
+			want:      "<em>a</em>",
+		},
+		{
+			name: "imported twice",
+			templates: map[string]string{
+				"forms.twig":  forms,
+				"layout.twig": `{% import 'forms.twig' as f %}{{ f.label('a') }}{% block body %}{% endblock %}`,
+				"test.twig":   `{% extends 'layout.twig' %}{% import 'forms.twig' as forms %}{% block body %}{{ forms.label('b') }}{% endblock %}`,
+			},
+			want: "<label>a</label><label>b</label>",
+		},
+		{
+			name: "imported macro calls its own",
+			templates: map[string]string{
+				"forms.twig": `{% macro row(s) %}<p>{{ _self.em(s) }}</p>{% endmacro %}{% macro em(s) %}<em>{{ s }}</em>{% endmacro %}`,
+				"test.twig":  `{% import 'forms.twig' as forms %}{{ forms.row('a') }}`,
+			},
+			want: "<p><em>a</em></p>",
+		},
+		{
+			name: "undefined macro",
+			templates: map[string]string{
+				"forms.twig": forms,
+				"test.twig":  `{% import 'forms.twig' as forms %}{{ forms.textarea('a') }}`,
+			},
+			err: "undefined macro textarea in forms.twig",
+		},
+		{
+			name:      "missing template",
+			templates: map[string]string{"test.twig": `{% import 'forms.twig' as forms %}`},
+			err:       "forms.twig",
+		},
+		{
+			name:      "dynamic template",
+			templates: map[string]string{"test.twig": `{% import name as forms %}`},
+			err:       "Unable to evaluate import reference",
+		},
+	})
+}
+
+func TestFromImport(t *testing.T) {
+	forms := `{% macro input(name) %}<input name="{{ name }}">{% endmacro %}{% macro textarea(name) %}<textarea name="{{ name }}"></textarea>{% endmacro %}`
+	testRender(t, []renderTest{
+		{
+			name: "import",
+			templates: map[string]string{
+				"forms.twig": forms,
+				"test.twig":  `{% from 'forms.twig' import input %}{{ input('a') }}`,
+			},
+			want: `<input name="a">`,
+		},
+		{
+			name: "alias",
+			templates: map[string]string{
+				"forms.twig": forms,
+				"test.twig":  `{% from 'forms.twig' import input, textarea as ta %}{{ input('a') }}{{ ta('b') }}`,
+			},
+			want: `<input name="a"><textarea name="b"></textarea>`,
+		},
+		{
+			name:      "self",
+			templates: map[string]string{"test.twig": `{% from _self import em %}{% macro em(s) %}<em>{{ s }}</em>{% endmacro %}{{ em('a') }}`},
+			want:      "<em>a</em>",
+		},
+		{
+			name: "shadows function",
+			templates: map[string]string{
+				"forms.twig": `{% macro upper(s) %}macro {{ s }}{% endmacro %}`,
+				"test.twig":  `{% from 'forms.twig' import upper %}{{ upper('a') }}`,
+			},
+			setup: `env.Functions["upper"] = func(ctx stick.Context, args ...stick.Value) stick.Value {
+		return "function"
+	}`,
+			want: "macro a",
+		},
+		{
+			name: "undefined macro",
+			templates: map[string]string{
+				"forms.twig": forms,
+				"test.twig":  `{% from 'forms.twig' import select %}`,
+			},
+			err: "undefined macro select in forms.twig",
+		},
+		{
+			name:      "missing template",
+			templates: map[string]string{"test.twig": `{% from 'forms.twig' import input %}`},
+			err:       "forms.twig",
+		},
+	})
+}
@@ -0,0 +1,153 @@
+package stickgen
+
+import (
+	"path"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// A NamingStrategy names the functions generated for templates, blocks and
+// macros.
+type NamingStrategy interface {
+	// Template returns the name of the function that renders the named
+	// template.
+	Template(name string) string
+
+	// Block returns the name of the function that renders the named block.
+	// scope is an identifier for the template being rendered, or for an
+	// embedded template within it.
+	Block(scope, name string) string
+
+	// Identifier converts the name of a template or macro into a Go
+	// identifier, which is used as part of the names of other generated
+	// functions.
+	Identifier(name string) string
+}
+
+// DefaultNaming is the NamingStrategy used by default. Names are converted
+// to title case with any characters that are not letters or digits removed,
+// so that "user-card.twig" is rendered by TemplateUserCardTwig.
+type DefaultNaming struct{}
+
+// Template implements NamingStrategy.
+func (DefaultNaming) Template(name string) string {
+	return "Template" + titleize(name)
+}
+
+// Block implements NamingStrategy.
+func (DefaultNaming) Block(scope, name string) string {
+	return "block" + scope + titleize(name)
+}
+
+// Identifier implements NamingStrategy.
+func (DefaultNaming) Identifier(name string) string {
+	return titleize(name)
+}
+
+// A FileNaming names the files generated for templates by GenerateDir and
+// GenerateGlob.
+type FileNaming interface {
+	// File returns the name of the file generated for the named template.
+	File(name string) string
+}
+
+var notAlnum = regexp.MustCompile(`[^a-zA-Z0-9]+`)
+
+// DefaultFileNaming is the FileNaming used by default. Names are converted
+// to lower case with any characters that are not letters or digits replaced
+// by underscores, so that "partials/user-card.twig" is generated as
+// "partials_user_card_twig.go".
+type DefaultFileNaming struct{}
+
+// File implements FileNaming.
+func (DefaultFileNaming) File(name string) string {
+	return strings.ToLower(strings.Trim(notAlnum.ReplaceAllString(name, "_"), "_")) + ".go"
+}
+
+// A FilePattern is a FileNaming that names files using a pattern, such as
+// "{{name}}_stickgen.go", in which "{{name}}" is replaced by the name of the
+// template without its extension.
+type FilePattern struct {
+	// Pattern is the name of each file, which should have the ".go"
+	// extension.
+	Pattern string
+
+	// Flatten replaces the slashes in the names of templates in
+	// subdirectories with underscores, so that "admin/users.twig" is
+	// generated as "admin_users.go" rather than in a subdirectory.
+	Flatten bool
+
+	// Lowercase converts the names of templates to lower case.
+	Lowercase bool
+}
+
+// File implements FileNaming.
+func (p FilePattern) File(name string) string {
+	name = strings.TrimSuffix(name, path.Ext(name))
+	if p.Flatten {
+		name = strings.Replace(name, "/", "_", -1)
+	}
+	if p.Lowercase {
+		name = strings.ToLower(name)
+	}
+	return strings.Replace(p.Pattern, "{{name}}", name, -1)
+}
+
+// fileName returns the name of the file generated for the named template.
+func (g *Generator) fileName(name string) string {
+	if g.FileNaming == nil {
+		return DefaultFileNaming{}.File(name)
+	}
+	return g.FileNaming.File(name)
+}
+
+// templateIdent returns the identifier for the named template used in the
+// names of other generated functions.
+func (g *Generator) templateIdent(name string) string {
+	return g.Naming.Identifier(g.identName(name))
+}
+
+// identName returns the name of a template as it is used in identifiers,
+// which is without its extension if StripExtensions is set.
+func (g *Generator) identName(name string) string {
+	if !g.StripExtensions {
+		return name
+	}
+	return strings.TrimSuffix(name, g.extension(name))
+}
+
+// templateFunc returns the name of the function that renders the named
+// template.
+func (g *Generator) templateFunc(name string) string {
+	fnName := g.Naming.Template(g.identName(name))
+	if g.Unexported {
+		return unexport(fnName)
+	}
+	return fnName
+}
+
+// templateRef returns a Go expression for the function that renders the named
+// template, which is a method value if Receiver is set.
+func (g *Generator) templateRef(name string) string {
+	if g.Receiver != "" {
+		return "(" + g.Receiver + "{})." + g.templateFunc(name)
+	}
+	return g.templateFunc(name)
+}
+
+// receiver returns the receiver of the functions that render templates, if
+// any.
+func (g *Generator) receiver() string {
+	if g.Receiver == "" {
+		return ""
+	}
+	return "(" + g.Receiver + ") "
+}
+
+// unexport returns the given identifier with its first letter in lower case.
+func unexport(name string) string {
+	r, n := utf8.DecodeRuneInString(name)
+	return string(unicode.ToLower(r)) + name[n:]
+}
@@ -0,0 +1,189 @@
+package stickgen_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/veonik/go-stickgen"
+)
+
+// prefixNaming names functions after templates with a prefix.
+type prefixNaming struct {
+	stickgen.DefaultNaming
+}
+
+func (prefixNaming) Template(name string) string {
+	return "Render" + stickgen.DefaultNaming{}.Identifier(strings.TrimSuffix(name, ".twig"))
+}
+
+func (prefixNaming) Block(scope, name string) string {
+	return "renderBlock" + scope + "_" + name
+}
+
+// upperNaming converts identifiers to upper case.
+type upperNaming struct {
+	stickgen.DefaultNaming
+}
+
+func (upperNaming) Identifier(name string) string {
+	return strings.ToUpper(stickgen.DefaultNaming{}.Identifier(name))
+}
+
+func TestNaming(t *testing.T) {
+	card := map[string]string{"partials/user-card.html.twig": `{{ name }}`}
+	layout := map[string]string{
+		"layout.twig": `[{% block content %}{% endblock %}]`,
+		"test.twig":   `{% extends 'layout.twig' %}{% block content %}{{ name }}{% endblock %}`,
+	}
+	custom := func(g *stickgen.Generator) {
+		g.Naming = prefixNaming{}
+	}
+	ctx := `map[string]stick.Value{"name": "Ann"}`
+	testRender(t, []renderTest{
+		{
+			name:      "default",
+			templates: card,
+			tpl:       "partials/user-card.html.twig",
+			ctx:       ctx,
+			call:      `err := TemplatePartialsUserCardHtmlTwig(env, output, ctx)`,
+			want:      "Ann",
+		},
+		{
+			name:      "custom",
+			templates: layout,
+			options:   custom,
+			ctx:       ctx,
+			call: `err := RenderTest(env, output, ctx)
+	_ = renderBlockTestTwig_content`,
+			want: "[Ann]",
+		},
+		{
+			name: "custom macro",
+			templates: map[string]string{
+				"test.twig": `{% macro hello(name) %}Hello, {{ name }}{% endmacro %}{{ _self.hello('Bob') }}`,
+			},
+			options: custom,
+			call:    `err := RenderTest(env, output, ctx)`,
+			want:    "Hello, Bob",
+		},
+		{
+			name: "custom block dispatcher",
+			templates: map[string]string{
+				"test.twig": `{% block content %}{{ name }}{% endblock %}|{{ block(b) }}`,
+			},
+			options: func(g *stickgen.Generator) {
+				g.Naming = upperNaming{}
+			},
+			ctx: `map[string]stick.Value{"name": "Ann", "b": "content"}`,
+			call: `err := TemplateTestTwig(env, output, ctx)
+	_ = blocksTESTTWIG`,
+			want: "Ann|Ann",
+		},
+		{
+			name:      "unexported",
+			templates: layout,
+			options: func(g *stickgen.Generator) {
+				g.Naming = prefixNaming{}
+				g.Unexported = true
+			},
+			ctx:  ctx,
+			call: `err := renderTest(env, output, ctx)`,
+			want: "[Ann]",
+		},
+	})
+}
+
+func TestReceiver(t *testing.T) {
+	receiver := func(name string) func(g *stickgen.Generator) {
+		return func(g *stickgen.Generator) {
+			g.Receiver = name
+		}
+	}
+	templates := map[string]string{
+		"layout.twig": `[{% block content %}{% endblock %}]`,
+		"macros.twig": `{% macro hello(name) %}Hello, {{ name }}{% endmacro %}`,
+		"test.twig":   `{% extends 'layout.twig' %}{% import 'macros.twig' as m %}{% block content %}{{ m.hello(name) }}{% endblock %}`,
+		"static.twig": `static`,
+	}
+	ctx := `map[string]stick.Value{"name": "Ann"}`
+	testRender(t, []renderTest{
+		{
+			name:      "unexported",
+			templates: templates,
+			options:   func(g *stickgen.Generator) { g.Unexported = true },
+			ctx:       ctx,
+			call:      `err := templateTestTwig(env, output, ctx)`,
+			want:      "[Hello, Ann]",
+		},
+		{
+			name:      "receiver",
+			templates: templates,
+			options:   receiver("Views"),
+			ctx:       ctx,
+			call:      `err := Views{}.TemplateTestTwig(env, output, ctx)`,
+			want:      "[Hello, Ann]",
+		},
+		{
+			name:      "static receiver",
+			templates: templates,
+			tpl:       "static.twig",
+			options:   receiver("Views"),
+			call:      `err := Views{}.TemplateStaticTwig(env, output, ctx)`,
+			want:      "static",
+		},
+		{
+			name:      "unexported receiver",
+			templates: templates,
+			options: func(g *stickgen.Generator) {
+				g.Receiver = "views"
+				g.Unexported = true
+			},
+			ctx:  ctx,
+			call: `err := views{}.templateTestTwig(env, output, ctx)`,
+			want: "[Hello, Ann]",
+		},
+		{
+			name:      "receiver with wrappers",
+			templates: templates,
+			all:       []string{"test.twig", "static.twig"},
+			options: func(g *stickgen.Generator) {
+				g.Receiver = "Views"
+				g.StringFuncs = true
+				g.Types = true
+			},
+			ctx: ctx,
+			call: `s, err := Views{}.TemplateTestTwigString(env, ctx)
+	fmt.Fprint(output, s)
+	if err == nil {
+		err = StaticTwigTemplate{}.Execute(env, output, ctx)
+	}`,
+			want: "[Hello, Ann]static",
+		},
+		{
+			name:      "invalid receiver",
+			templates: templates,
+			options:   receiver("my-views"),
+			err:       "invalid receiver type name: my-views",
+		},
+	})
+}
+
+func TestFileNaming(t *testing.T) {
+	tests := []struct {
+		naming stickgen.FileNaming
+		name   string
+		want   string
+	}{
+		{stickgen.DefaultFileNaming{}, "partials/user-card.twig", "partials_user_card_twig.go"},
+		{stickgen.DefaultFileNaming{}, "Index.html.twig", "index_html_twig.go"},
+		{stickgen.FilePattern{Pattern: "{{name}}_stickgen.go"}, "Index.twig", "Index_stickgen.go"},
+		{stickgen.FilePattern{Pattern: "{{name}}_stickgen.go"}, "admin/users.twig", "admin/users_stickgen.go"},
+		{stickgen.FilePattern{Pattern: "{{name}}.go", Flatten: true}, "admin/users.twig", "admin_users.go"},
+		{stickgen.FilePattern{Pattern: "gen_{{name}}.go", Flatten: true, Lowercase: true}, "Admin/Users.html.twig", "gen_admin_users.html.go"},
+	}
+	for _, tt := range tests {
+		if got := tt.naming.File(tt.name); got != tt.want {
+			t.Errorf("%T%+v: expected %s named %q, got %q", tt.naming, tt.naming, tt.name, tt.want, got)
+		}
+	}
+}
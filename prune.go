@@ -0,0 +1,65 @@
+package stickgen
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// Orphans returns the files in outDir, and in the directories of Packages,
+// that were generated by stickgen but would not be generated by GenerateGlob
+// given the same arguments, such as files generated for templates that have
+// since been deleted or renamed. Generated files are identified by the
+// sources recorded in their headers, so other files are never returned.
+func (g *Generator) Orphans(root, outDir string, include, exclude []string) ([]string, error) {
+	pkgs, err := g.findPackages(root, outDir, include, exclude)
+	if err != nil {
+		return nil, err
+	}
+	keep := make(map[string]bool)
+	for _, pkg := range pkgs {
+		for _, file := range pkg.files {
+			keep[filepath.Clean(file)] = true
+		}
+	}
+	dirs := []string{outDir}
+	for _, p := range g.Packages {
+		dirs = append(dirs, p.Out)
+	}
+	var orphans []string
+	for _, dir := range dirs {
+		files, err := filepath.Glob(filepath.Join(dir, "*.go"))
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			if keep[filepath.Clean(file)] {
+				continue
+			}
+			if _, err := Sources(file); err == nil {
+				orphans = append(orphans, file)
+			}
+		}
+	}
+	return orphans, nil
+}
+
+// Prune removes the files returned by Orphans, along with any record of
+// them in the BuildCache. The files removed are returned.
+func (g *Generator) Prune(root, outDir string, include, exclude []string) ([]string, error) {
+	orphans, err := g.Orphans(root, outDir, include, exclude)
+	if err != nil {
+		return nil, err
+	}
+	var removed []string
+	for _, file := range orphans {
+		if err := os.Remove(file); err != nil {
+			return removed, err
+		}
+		removed = append(removed, file)
+		g.log().Info("removed", "file", file)
+		if g.BuildCache != nil {
+			g.BuildCache.Remove(file)
+		}
+	}
+	return removed, nil
+}
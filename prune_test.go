@@ -0,0 +1,66 @@
+package stickgen_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/tyler-sommer/stick"
+	"github.com/veonik/go-stickgen"
+)
+
+func TestPrune(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "_stickgen_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	root := filepath.Join(dir, "templates")
+	out := filepath.Join(dir, "views")
+	writeTree(t, root, map[string]string{
+		"index.twig":       `Hello`,
+		"about.twig":       `About`,
+		"admin/users.twig": `Users`,
+	})
+	g := stickgen.NewGenerator("views", stick.NewFilesystemLoader(root))
+	g.Packages = []stickgen.Package{{Dir: "admin", Out: filepath.Join(out, "admin")}}
+	g.BuildCache = stickgen.NewBuildCache()
+	if _, err := g.GenerateDir(root, out); err != nil {
+		t.Fatalf("unexpected error generating: %s", err)
+	}
+	writeTree(t, out, map[string]string{"helpers.go": "package views\n"})
+	for _, name := range []string{"about.twig", "admin/users.twig"} {
+		if err := os.Remove(filepath.Join(root, filepath.FromSlash(name))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	orphans, err := g.Orphans(root, out, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error finding orphans: %s", err)
+	}
+	want := []string{"about_twig.go", "admin/users_twig.go"}
+	if got := relPaths(t, out, orphans); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected orphans %v, got %v", want, got)
+	}
+	if _, ok := g.BuildCache.Files[filepath.Join(out, "about_twig.go")]; !ok {
+		t.Fatalf("expected a cache entry for about_twig.go")
+	}
+	removed, err := g.Prune(root, out, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error pruning: %s", err)
+	}
+	if got := relPaths(t, out, removed); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected files %v removed, got %v", want, got)
+	}
+	for _, file := range []string{"index_twig.go", "helpers.go"} {
+		if _, err := os.Stat(filepath.Join(out, file)); err != nil {
+			t.Errorf("expected %s to be kept: %s", file, err)
+		}
+	}
+	if _, ok := g.BuildCache.Files[filepath.Join(out, "about_twig.go")]; ok {
+		t.Errorf("expected the cache entry of a removed file to be removed")
+	}
+}
@@ -0,0 +1,80 @@
+package stickgen
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// registryFile is the name of the file written by GenerateDir that declares
+// the Templates map, if Registry is enabled.
+const registryFile = "templates.go"
+
+// funcType returns the type of the functions generated for templates.
+func (g *Generator) funcType() string {
+	return fmt.Sprintf("func(%senv *stick.Env, output io.Writer, ctx map[string]stick.Value)%s", g.params(), g.result())
+}
+
+// registry returns code that registers each generated template in the
+// Templates map. Unless the map is declared in a file of its own, the
+// declaration of the map and of Execute are included.
+func (g *Generator) registry() string {
+	format := "	%s: %s,\n"
+	if g.sharedRegistry {
+		format = "	Templates[%s] = %s\n"
+	}
+	entries := ""
+	for _, tpl := range g.templates {
+		entries += fmt.Sprintf(format, strconv.Quote(tpl.name), g.templateRef(tpl.name))
+	}
+	if g.sharedRegistry {
+		return "func init() {\n" + entries + "}"
+	}
+	return g.registryDecl(entries)
+}
+
+// registryDecl returns the declaration of the Templates map, containing the
+// given entries, and of Execute.
+func (g *Generator) registryDecl(entries string) string {
+	g.addImport("fmt")
+	call := fmt.Sprintf("return tpl(%senv, output, ctx)", g.callArgs())
+	if g.IgnoreErrors {
+		call = fmt.Sprintf("tpl(%senv, output, ctx)\n	return nil", g.callArgs())
+	}
+	return fmt.Sprintf(`// Templates maps the name of each generated template to the function that
+// renders it.
+var Templates = map[string]%s{
+%s}
+
+// Execute renders the named template.
+func Execute(%sname string, env *stick.Env, output io.Writer, ctx map[string]stick.Value) error {
+	tpl, ok := Templates[name]
+	if !ok {
+		return fmt.Errorf("template %%s does not exist", name)
+	}
+	%s
+}`, g.funcType(), entries, g.params(), call)
+}
+
+// writeRegistry returns the source of a file declaring the Templates map,
+// to which the other generated files add their templates.
+func (g *Generator) writeRegistry() (string, error) {
+	decl := g.registryDecl("")
+	if g.Context {
+		g.addImport("context")
+	}
+	imports := g.importSpecs()
+	header, err := g.header()
+	if err != nil {
+		return "", err
+	}
+	return g.format(fmt.Sprintf(`%s
+package %s
+
+import (
+	%s
+)
+
+%s
+`, header, g.pkgName, strings.Join(imports, "\n	"), decl))
+}
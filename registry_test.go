@@ -0,0 +1,83 @@
+package stickgen_test
+
+import (
+	"testing"
+
+	"github.com/veonik/go-stickgen"
+)
+
+func TestRegistry(t *testing.T) {
+	registry := func(g *stickgen.Generator) {
+		g.Registry = true
+	}
+	templates := map[string]string{
+		"layout.twig":          `[{% block content %}{% endblock %}]`,
+		"index.twig":           `{% extends 'layout.twig' %}{% block content %}{{ name }}{% endblock %}`,
+		"partials/footer.twig": `footer`,
+	}
+	testRender(t, []renderTest{
+		{
+			name:      "execute",
+			templates: templates,
+			all:       []string{"index.twig", "partials/footer.twig"},
+			options:   registry,
+			ctx:       `map[string]stick.Value{"name": "Ann"}`,
+			call: `err := Execute("index.twig", env, output, ctx)
+	if err == nil {
+		err = Execute("partials/footer.twig", env, output, ctx)
+	}`,
+			want: "[Ann]footer",
+		},
+		{
+			name:      "templates",
+			templates: templates,
+			all:       []string{"index.twig", "partials/footer.twig"},
+			options:   registry,
+			call: `err := Templates["partials/footer.twig"](env, output, ctx)
+	fmt.Fprint(output, len(Templates))`,
+			want: "footer2",
+		},
+		{
+			name:      "missing",
+			templates: templates,
+			tpl:       "index.twig",
+			options:   registry,
+			call:      `err := Execute("layout.twig", env, output, ctx)`,
+			runErr:    "template layout.twig does not exist",
+		},
+		{
+			name:      "context",
+			templates: templates,
+			tpl:       "partials/footer.twig",
+			options: func(g *stickgen.Generator) {
+				g.Registry = true
+				g.Context = true
+			},
+			imports: []string{"context"},
+			call:    `err := Execute(context.Background(), "partials/footer.twig", env, output, ctx)`,
+			want:    "footer",
+		},
+		{
+			name:      "ignore errors",
+			templates: templates,
+			tpl:       "index.twig",
+			options: func(g *stickgen.Generator) {
+				g.Registry = true
+				g.IgnoreErrors = true
+			},
+			call: `err := Execute("index.twig", env, output, ctx)`,
+			want: "[<nil>]",
+		},
+		{
+			name:      "receiver",
+			templates: templates,
+			tpl:       "partials/footer.twig",
+			options: func(g *stickgen.Generator) {
+				g.Registry = true
+				g.Receiver = "Views"
+			},
+			call: `err := Execute("partials/footer.twig", env, output, ctx)`,
+			want: "footer",
+		},
+	})
+}
@@ -0,0 +1,903 @@
+package stickgen
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/tyler-sommer/stick"
+)
+
+// runtimeImport is the import path of this package, which provides helpers
+// used by generated code.
+const runtimeImport = "github.com/veonik/go-stickgen"
+
+// stickImport is the import path of the stick package.
+const stickImport = "github.com/tyler-sommer/stick"
+
+var spacelessPattern = regexp.MustCompile(`>\s+<`)
+
+// Spaceless removes whitespace between HTML tags in the given value.
+func Spaceless(val stick.Value) string {
+	return strings.TrimSpace(spacelessPattern.ReplaceAllString(stick.CoerceString(val), "><"))
+}
+
+// A SpacelessWriter removes whitespace between HTML tags as it is written to
+// the underlying writer. Leading and trailing whitespace is also removed.
+type SpacelessWriter struct {
+	w       io.Writer
+	last    byte
+	pending []byte
+}
+
+// NewSpacelessWriter returns a SpacelessWriter that writes to w.
+func NewSpacelessWriter(w io.Writer) *SpacelessWriter {
+	return &SpacelessWriter{w: w}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\f' || c == '\r'
+}
+
+// Write writes p to the underlying writer, holding back any whitespace until
+// it is known whether the whitespace separates two tags.
+func (s *SpacelessWriter) Write(p []byte) (int, error) {
+	out := make([]byte, 0, len(p))
+	for _, c := range p {
+		if isSpace(c) {
+			if s.last != 0 {
+				s.pending = append(s.pending, c)
+			}
+			continue
+		}
+		if len(s.pending) > 0 && !(s.last == '>' && c == '<') {
+			out = append(out, s.pending...)
+		}
+		s.pending = s.pending[:0]
+		s.last = c
+		out = append(out, c)
+	}
+	if _, err := s.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// NewContext returns a copy of parent with the given variables added. vars
+// may be any value that can be iterated over, such as a map.
+func NewContext(parent map[string]stick.Value, vars stick.Value) map[string]stick.Value {
+	ctx := make(map[string]stick.Value, len(parent))
+	for k, v := range parent {
+		ctx[k] = v
+	}
+	if vars != nil {
+		stick.Iterate(vars, func(k, v stick.Value, l stick.Loop) (bool, error) {
+			ctx[stick.CoerceString(k)] = v
+			return false, nil
+		})
+	}
+	return ctx
+}
+
+// NewFuncContext returns the stick.Context passed to functions, filters and
+// tests called from the named template. ctx is used as the context's scope.
+func NewFuncContext(env *stick.Env, name string, ctx map[string]stick.Value) stick.Context {
+	if ctx == nil {
+		ctx = make(map[string]stick.Value)
+	}
+	return &funcContext{env: env, name: name, scope: contextScope(ctx), meta: make(contextMetadata)}
+}
+
+type funcContext struct {
+	env   *stick.Env
+	name  string
+	scope contextScope
+	meta  contextMetadata
+}
+
+func (c *funcContext) Name() string                { return c.name }
+func (c *funcContext) Env() *stick.Env             { return c.env }
+func (c *funcContext) Scope() stick.ContextScope   { return c.scope }
+func (c *funcContext) Meta() stick.ContextMetadata { return c.meta }
+
+// WithContext returns a stick.Context that wraps c and carries ctx, which
+// functions, filters and tests may retrieve using ContextOf.
+func WithContext(c stick.Context, ctx context.Context) stick.Context {
+	return &cancelContext{c, ctx}
+}
+
+// ContextOf returns the context.Context carried by c, or context.Background
+// if c carries none.
+func ContextOf(c stick.Context) context.Context {
+	if cc, ok := c.(*cancelContext); ok {
+		return cc.ctx
+	}
+	return context.Background()
+}
+
+type cancelContext struct {
+	stick.Context
+	ctx context.Context
+}
+
+type contextScope map[string]stick.Value
+
+func (s contextScope) All() map[string]stick.Value { return s }
+
+func (s contextScope) Get(name string) (stick.Value, bool) {
+	v, ok := s[name]
+	return v, ok
+}
+
+func (s contextScope) Set(name string, v stick.Value) { s[name] = v }
+
+type contextMetadata map[string]string
+
+func (m contextMetadata) All() map[string]string { return m }
+
+func (m contextMetadata) Get(name string) (string, bool) {
+	v, ok := m[name]
+	return v, ok
+}
+
+func (m contextMetadata) Set(name string, v string) { m[name] = v }
+
+// NewLoop returns the Twig loop variable for the current iteration over val.
+// parent is the loop variable of the enclosing loop, if any.
+func NewLoop(l stick.Loop, val stick.Value, parent map[string]stick.Value) map[string]stick.Value {
+	return NewCountedLoop(l, Length(val), parent)
+}
+
+// NewCountedLoop returns the Twig loop variable for the current iteration
+// over length items. If the length is unknown, as when Length cannot measure
+// the iterable, length, revindex and revindex0 are all 0.
+func NewCountedLoop(l stick.Loop, length int, parent map[string]stick.Value) map[string]stick.Value {
+	// The parent of the outermost loop is null rather than a nil map.
+	var p stick.Value
+	if parent != nil {
+		p = parent
+	}
+	revindex, revindex0 := length-l.Index0, length-l.Index
+	if revindex0 < 0 {
+		revindex, revindex0 = 0, 0
+	}
+	return map[string]stick.Value{
+		"parent":    p,
+		"index":     l.Index,
+		"index0":    l.Index0,
+		"revindex":  revindex,
+		"revindex0": revindex0,
+		"first":     l.Index0 == 0,
+		"last":      l.Last,
+		"length":    length,
+	}
+}
+
+// Length returns the number of elements in the given value, or 0 if it
+// cannot be iterated over.
+func Length(val stick.Value) int {
+	if val == nil {
+		return 0
+	}
+	r := reflect.Indirect(reflect.ValueOf(val))
+	switch r.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return r.Len()
+	}
+	return 0
+}
+
+// Range returns the values from low to high inclusive, as with Twig's range
+// function. If both bounds are single characters, a range of characters is
+// returned. A nil or zero step is treated as 1, and a fractional step between
+// characters is rounded up. If a bound or the step is infinite or NaN, the
+// range is empty.
+func Range(low, high, step stick.Value) []stick.Value {
+	inc := math.Abs(stick.CoerceNumber(step))
+	if !isFinite(inc) {
+		return nil
+	}
+	if inc == 0 {
+		inc = 1
+	}
+	var vals []stick.Value
+	if lo, hi, ok := charBounds(low, high); ok {
+		n := int(math.Ceil(inc))
+		if lo <= hi {
+			for r := lo; r <= hi; r += rune(n) {
+				vals = append(vals, string(r))
+			}
+		} else {
+			for r := lo; r >= hi; r -= rune(n) {
+				vals = append(vals, string(r))
+			}
+		}
+		return vals
+	}
+	lo, hi := stick.CoerceNumber(low), stick.CoerceNumber(high)
+	if !isFinite(lo) || !isFinite(hi) {
+		return nil
+	}
+	if lo > hi {
+		inc = -inc
+	}
+	// The values are counted up front, since adding a step smaller than the
+	// spacing between floats may never reach the upper bound.
+	vals = make([]stick.Value, int(math.Floor((hi-lo)/inc))+1)
+	for i := range vals {
+		vals[i] = lo + float64(i)*inc
+	}
+	return vals
+}
+
+// isFinite reports whether f is neither infinite nor NaN.
+func isFinite(f float64) bool {
+	return !math.IsInf(f, 0) && !math.IsNaN(f)
+}
+
+// Min returns the lowest of the given values, as with Twig's min function. A
+// single iterable argument is treated as the list of values.
+func Min(vals ...stick.Value) stick.Value {
+	return extreme(vals, -1)
+}
+
+// Max returns the highest of the given values, as with Twig's max function.
+// A single iterable argument is treated as the list of values.
+func Max(vals ...stick.Value) stick.Value {
+	return extreme(vals, 1)
+}
+
+// extreme returns the value that compares as sign against all others.
+func extreme(vals []stick.Value, sign int) stick.Value {
+	if len(vals) == 1 && Length(vals[0]) > 0 {
+		list := vals[0]
+		vals = nil
+		stick.Iterate(list, func(_, v stick.Value, _ stick.Loop) (bool, error) {
+			vals = append(vals, v)
+			return false, nil
+		})
+	}
+	var res stick.Value
+	for i, v := range vals {
+		if i == 0 || Compare(v, res) == sign {
+			res = v
+		}
+	}
+	return res
+}
+
+// Random returns a random value, as with Twig's random function. If values
+// is a string, a random character is returned. If it is a number, a random
+// integer between 0 and the number is returned, or between the number and
+// max if max is given. If it is iterable, a random item is returned. If
+// values is nil, a random non-negative integer is returned.
+func Random(values, max stick.Value) stick.Value {
+	switch val := values.(type) {
+	case nil:
+		if max == nil {
+			return rand.Int()
+		}
+		return randomInt(0, int(stick.CoerceNumber(max)))
+	case string:
+		chars := []rune(val)
+		if len(chars) == 0 {
+			return ""
+		}
+		return string(chars[rand.Intn(len(chars))])
+	}
+	if isNumeric(values) {
+		n := int(stick.CoerceNumber(values))
+		if max != nil {
+			return randomInt(n, int(stick.CoerceNumber(max)))
+		}
+		return randomInt(0, n)
+	}
+	var items []stick.Value
+	stick.Iterate(values, func(_, v stick.Value, _ stick.Loop) (bool, error) {
+		items = append(items, v)
+		return false, nil
+	})
+	if len(items) == 0 {
+		return nil
+	}
+	return items[rand.Intn(len(items))]
+}
+
+// randomInt returns a random integer between a and b inclusive.
+func randomInt(a, b int) int {
+	if a > b {
+		a, b = b, a
+	}
+	return a + rand.Intn(b-a+1)
+}
+
+var (
+	dateLayouts = []string{
+		time.RFC3339Nano,
+		"2006-01-02 15:04:05",
+		"2006-01-02T15:04:05",
+		"2006-01-02 15:04",
+		"2006-01-02",
+		time.RFC1123Z,
+		time.RFC1123,
+		time.RFC850,
+		time.ANSIC,
+		"January 2, 2006",
+		"Jan 2, 2006",
+		"02-Jan-2006",
+	}
+	relativeDatePattern = regexp.MustCompile(`^([+-]?\d+)\s*(second|sec|minute|min|hour|day|week|month|year)s?$`)
+)
+
+// Date converts the given value to a time, as with Twig's date function.
+// The value may be a time, a Unix timestamp, or a string containing a date,
+// "now", "today", "tomorrow", "yesterday" or a relative offset such as
+// "+1 day". nil is treated as the current time. If timezone is given, as a
+// name or a *time.Location, it is used to interpret dates without a zone
+// and the result is converted to it. The zero time is returned if the value
+// cannot be converted.
+func Date(date, timezone stick.Value) time.Time {
+	loc := time.Local
+	switch tz := timezone.(type) {
+	case *time.Location:
+		loc = tz
+	case string:
+		if l, err := time.LoadLocation(tz); err == nil {
+			loc = l
+		}
+	}
+	var t time.Time
+	switch val := date.(type) {
+	case nil:
+		t = time.Now()
+	case time.Time:
+		t = val
+	case *time.Time:
+		t = *val
+	case string:
+		t = parseDate(strings.TrimSpace(val), loc)
+	default:
+		if !isNumeric(val) {
+			return time.Time{}
+		}
+		t = time.Unix(int64(stick.CoerceNumber(val)), 0)
+	}
+	if t.IsZero() {
+		return t
+	}
+	return t.In(loc)
+}
+
+// parseDate parses a date string in the given location.
+func parseDate(s string, loc *time.Location) time.Time {
+	now := time.Now().In(loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	switch strings.ToLower(s) {
+	case "", "now":
+		return now
+	case "today", "midnight":
+		return today
+	case "tomorrow":
+		return today.AddDate(0, 0, 1)
+	case "yesterday":
+		return today.AddDate(0, 0, -1)
+	}
+	if _, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Unix(int64(stick.CoerceNumber(s)), 0)
+	}
+	if m := relativeDatePattern.FindStringSubmatch(strings.ToLower(s)); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		switch m[2] {
+		case "second", "sec":
+			return now.Add(time.Duration(n) * time.Second)
+		case "minute", "min":
+			return now.Add(time.Duration(n) * time.Minute)
+		case "hour":
+			return now.Add(time.Duration(n) * time.Hour)
+		case "day":
+			return now.AddDate(0, 0, n)
+		case "week":
+			return now.AddDate(0, 0, 7*n)
+		case "month":
+			return now.AddDate(0, n, 0)
+		case "year":
+			return now.AddDate(n, 0, 0)
+		}
+	}
+	for _, layout := range dateLayouts {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// Dump returns a Go-syntax representation of each of the given values, one
+// per line, as used by Twig's dump function.
+func Dump(vals ...stick.Value) string {
+	b := &strings.Builder{}
+	for _, v := range vals {
+		fmt.Fprintf(b, "%#v\n", v)
+	}
+	return b.String()
+}
+
+// Attribute returns the named attribute of obj, as with Twig's attribute
+// function. If the attribute is a method, it is called with each of the
+// values in args.
+func Attribute(obj, attr, args stick.Value) (stick.Value, error) {
+	var params []stick.Value
+	if args != nil {
+		stick.Iterate(args, func(_, v stick.Value, _ stick.Loop) (bool, error) {
+			params = append(params, v)
+			return false, nil
+		})
+	}
+	return stick.GetAttr(obj, attr, params...)
+}
+
+// Source returns the contents of the named template, as with Twig's source
+// function. If ignoreMissing is true, an empty string is returned when the
+// template does not exist.
+func Source(env *stick.Env, name, ignoreMissing stick.Value) (string, error) {
+	tpl, err := env.Loader.Load(stick.CoerceString(name))
+	if err != nil {
+		if stick.CoerceBool(ignoreMissing) {
+			return "", nil
+		}
+		return "", err
+	}
+	b, err := ioutil.ReadAll(tpl.Contents())
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// Cycle returns the value at position in values, modulo the number of
+// values, as with Twig's cycle function.
+func Cycle(values, position stick.Value) stick.Value {
+	var items []stick.Value
+	stick.Iterate(values, func(_, v stick.Value, _ stick.Loop) (bool, error) {
+		items = append(items, v)
+		return false, nil
+	})
+	if len(items) == 0 {
+		return nil
+	}
+	i := int(stick.CoerceNumber(position)) % len(items)
+	if i < 0 {
+		i += len(items)
+	}
+	return items[i]
+}
+
+// stringTemplateName is the name given to templates created from strings.
+const stringTemplateName = "__string_template__"
+
+// ExecuteString parses and renders a template from its source, as with
+// Twig's template_from_string function. The template may include or extend
+// templates loaded by env.
+func ExecuteString(env *stick.Env, src string, output io.Writer, ctx map[string]stick.Value) error {
+	e := *env
+	e.Loader = &stringLoader{src: src, loader: env.Loader}
+	return e.Execute(stringTemplateName, output, ctx)
+}
+
+// A stringLoader loads a template created from a string, deferring to
+// another loader for any other template.
+type stringLoader struct {
+	src    string
+	loader stick.Loader
+}
+
+func (l *stringLoader) Load(name string) (stick.Template, error) {
+	if name == stringTemplateName {
+		return &stringTemplate{name: name, src: l.src}, nil
+	}
+	if l.loader == nil {
+		return nil, fmt.Errorf("stickgen: template %s not found", name)
+	}
+	return l.loader.Load(name)
+}
+
+type stringTemplate struct {
+	name string
+	src  string
+}
+
+func (t *stringTemplate) Name() string        { return t.name }
+func (t *stringTemplate) Contents() io.Reader { return strings.NewReader(t.src) }
+
+// Defined reports whether the named variable exists in ctx.
+func Defined(ctx map[string]stick.Value, name string) bool {
+	_, ok := ctx[name]
+	return ok
+}
+
+// Empty reports whether the given value is empty, as with Twig's empty test.
+// nil, false, empty strings and values of length zero are empty. Unlike in
+// Go, the number zero is not.
+func Empty(val stick.Value) bool {
+	switch v := val.(type) {
+	case nil:
+		return true
+	case bool:
+		return !v
+	case string:
+		return v == ""
+	}
+	r := reflect.Indirect(reflect.ValueOf(val))
+	switch r.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map, reflect.Chan:
+		return r.Len() == 0
+	}
+	return false
+}
+
+// Iterable reports whether the given value is a slice, array, map or
+// channel, as with Twig's iterable test.
+func Iterable(val stick.Value) bool {
+	switch val.(type) {
+	case nil, string, bool, int, float64:
+		return false
+	case []stick.Value, map[string]stick.Value:
+		return true
+	}
+	switch reflect.Indirect(reflect.ValueOf(val)).Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map, reflect.Chan:
+		return true
+	}
+	return false
+}
+
+// Same reports whether a and b are identical, as with Twig's same as test.
+// Values of different types are never the same, and no coercion is done.
+func Same(a, b stick.Value) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	t := reflect.TypeOf(a)
+	if t != reflect.TypeOf(b) {
+		return false
+	}
+	if t.Comparable() {
+		return a == b
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// Compare returns -1, 0 or 1 if a is less than, equal to or greater than b.
+// Times are compared chronologically. Other values are compared numerically
+// if both are numeric, otherwise as strings.
+func Compare(a, b stick.Value) int {
+	if x, ok := a.(time.Time); ok {
+		if y, ok := b.(time.Time); ok {
+			switch {
+			case x.Before(y):
+				return -1
+			case x.After(y):
+				return 1
+			}
+			return 0
+		}
+	}
+	if isNumeric(a) && isNumeric(b) {
+		x, y := stick.CoerceNumber(a), stick.CoerceNumber(b)
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(stick.CoerceString(a), stick.CoerceString(b))
+}
+
+// isNumeric reports whether v is a number or a numeric string.
+func isNumeric(v stick.Value) bool {
+	switch val := v.(type) {
+	case string:
+		_, err := strconv.ParseFloat(strings.TrimSpace(val), 64)
+		return err == nil
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return true
+	}
+	return false
+}
+
+// charBounds returns the bounds of a range of characters, reporting false
+// unless both values are single non-numeric characters.
+func charBounds(low, high stick.Value) (rune, rune, bool) {
+	l, ok := low.(string)
+	h, ok2 := high.(string)
+	if !ok || !ok2 || utf8.RuneCountInString(l) != 1 || utf8.RuneCountInString(h) != 1 {
+		return 0, 0, false
+	}
+	if _, err := strconv.ParseFloat(l, 64); err == nil {
+		return 0, 0, false
+	}
+	if _, err := strconv.ParseFloat(h, 64); err == nil {
+		return 0, 0, false
+	}
+	lo, _ := utf8.DecodeRuneInString(l)
+	hi, _ := utf8.DecodeRuneInString(h)
+	return lo, hi, true
+}
+
+// ExecuteIfExists renders the named template using env, as with an include
+// tag with the ignore missing keywords. Nothing is rendered if the template
+// does not exist.
+func ExecuteIfExists(env *stick.Env, name string, output io.Writer, ctx map[string]stick.Value) error {
+	if _, err := env.Loader.Load(name); err != nil {
+		return nil
+	}
+	return env.Execute(name, output, ctx)
+}
+
+func isEscapeStrategy(strategy string) bool {
+	switch strategy {
+	case "html", "js", "css", "url", "html_attr":
+		return true
+	}
+	return false
+}
+
+func isAlnum(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9'
+}
+
+// Markup is a string that has already been escaped, such as the output
+// captured by a set tag, which Escape leaves as is.
+type Markup string
+
+// String implements fmt.Stringer.
+func (m Markup) String() string {
+	return string(m)
+}
+
+// Boolean implements stick.Boolean, so that Markup is truthy like a string.
+func (m Markup) Boolean() bool {
+	return stick.CoerceBool(string(m))
+}
+
+// Number implements stick.Number, so that Markup is numeric like a string.
+func (m Markup) Number() float64 {
+	return stick.CoerceNumber(string(m))
+}
+
+// Escape escapes the given value using the named strategy. Supported
+// strategies are html, js, css, url and html_attr. Markup is not escaped.
+func Escape(val stick.Value, strategy string) string {
+	if m, ok := val.(Markup); ok {
+		return string(m)
+	}
+	s := stick.CoerceString(val)
+	switch strategy {
+	case "html":
+		return html.EscapeString(s)
+	}
+	res := &strings.Builder{}
+	for i, r := range s {
+		switch strategy {
+		case "js":
+			if isAlnum(r) || r == ',' || r == '.' || r == '_' {
+				res.WriteRune(r)
+			} else if r > 0xFFFF {
+				r -= 0x10000
+				fmt.Fprintf(res, "\\u%04X\\u%04X", 0xD800+(r>>10), 0xDC00+(r&0x3FF))
+			} else {
+				fmt.Fprintf(res, "\\u%04X", r)
+			}
+		case "css":
+			if isAlnum(r) {
+				res.WriteRune(r)
+			} else {
+				fmt.Fprintf(res, "\\%X ", r)
+			}
+		case "url":
+			if isAlnum(r) || r == '-' || r == '_' || r == '.' || r == '~' {
+				res.WriteRune(r)
+			} else {
+				// Invalid bytes are decoded as RuneError, which is wider than
+				// the byte itself, so the width is taken from the string.
+				_, n := utf8.DecodeRuneInString(s[i:])
+				for _, b := range []byte(s[i : i+n]) {
+					fmt.Fprintf(res, "%%%02X", b)
+				}
+			}
+		case "html_attr":
+			if isAlnum(r) || r == ',' || r == '.' || r == '-' || r == '_' {
+				res.WriteRune(r)
+			} else {
+				fmt.Fprintf(res, "&#x%02X;", r)
+			}
+		default:
+			return s
+		}
+	}
+	return res.String()
+}
+
+// A Translator translates the messages in trans tags.
+type Translator interface {
+	Translate(message string) string
+}
+
+// NewTransFilter returns a filter that translates values using t. Generated
+// trans tags use the "trans" filter registered with the stick.Env.
+func NewTransFilter(t Translator) stick.Filter {
+	return func(ctx stick.Context, val stick.Value, args ...stick.Value) stick.Value {
+		return t.Translate(stick.CoerceString(val))
+	}
+}
+
+var placeholderPattern = regexp.MustCompile(`%\w+%`)
+
+// Translate translates message using the "trans" filter registered with the
+// Env of ctx, if any, and replaces each %name% placeholder with its value in
+// vars. The filter is called with ctx.
+func Translate(ctx stick.Context, message string, vars map[string]stick.Value) string {
+	if trans, ok := ctx.Env().Filters["trans"]; ok {
+		message = stick.CoerceString(trans(ctx, message))
+	}
+	return placeholderPattern.ReplaceAllStringFunc(message, func(p string) string {
+		if v, ok := vars[p[1:len(p)-1]]; ok {
+			return stick.CoerceString(v)
+		}
+		return p
+	})
+}
+
+// A Cache stores the rendered output of cache tags.
+type Cache interface {
+	// Get returns the fragment stored under key, if any.
+	Get(key string) (string, bool)
+	// Set stores a fragment under key. A ttl of zero never expires.
+	Set(key, val string, ttl time.Duration)
+}
+
+// NewCacheFunc returns a function that gives generated cache tags access to
+// c. Generated cache tags use the "cache" function registered with the
+// stick.Env, and render their fragments every time if there is none.
+func NewCacheFunc(c Cache) stick.Func {
+	return func(ctx stick.Context, args ...stick.Value) stick.Value {
+		return c
+	}
+}
+
+// Cached writes the fragment stored under key and ttl in the Cache
+// registered with the Env of ctx to w. On a miss, the fragment is rendered
+// and stored for ttl seconds. Fragments that fail to render are not stored.
+func Cached(ctx stick.Context, w io.Writer, key string, ttl float64, render func(w io.Writer) error) error {
+	var c Cache
+	if fn, ok := ctx.Env().Functions["cache"]; ok {
+		c, _ = fn(ctx).(Cache)
+	}
+	if c == nil {
+		return render(w)
+	}
+	// Tags with the same key but different ttls store separate fragments.
+	key = fmt.Sprintf("%s;ttl=%g", key, ttl)
+	if val, ok := c.Get(key); ok {
+		_, err := io.WriteString(w, val)
+		return err
+	}
+	buf := &strings.Builder{}
+	if err := render(buf); err != nil {
+		return err
+	}
+	c.Set(key, buf.String(), time.Duration(ttl*float64(time.Second)))
+	_, err := io.WriteString(w, buf.String())
+	return err
+}
+
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		return &bytes.Buffer{}
+	},
+}
+
+// GetBuffer returns an empty buffer from a pool shared by generated code.
+func GetBuffer() *bytes.Buffer {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+// PutBuffer returns the given buffer to the pool. The buffer must not be used
+// afterward.
+func PutBuffer(buf *bytes.Buffer) {
+	bufferPool.Put(buf)
+}
+
+// An ErrorHandler handles an error that occurs while rendering a template
+// generated with the ErrorsHandler policy. Rendering stops if it returns an
+// error.
+type ErrorHandler func(err error) error
+
+// Handle calls h with err. If h is nil, err is returned as is.
+func (h ErrorHandler) Handle(err error) error {
+	if h == nil {
+		return err
+	}
+	return h(err)
+}
+
+// A Template is a template generated by stickgen.
+type Template interface {
+	// Name returns the name of the template.
+	Name() string
+
+	// Execute renders the template to w.
+	Execute(env *stick.Env, w io.Writer, ctx map[string]stick.Value) error
+
+	// Blocks returns the sorted names of the blocks defined in the template,
+	// including those it inherits.
+	Blocks() []string
+}
+
+// A ContextTemplate is a Template generated with the Context option. Its
+// Execute method renders the template without a deadline.
+type ContextTemplate interface {
+	Template
+
+	// ExecuteContext renders the template to w, stopping early if ctx is
+	// done.
+	ExecuteContext(ctx context.Context, env *stick.Env, w io.Writer, vars map[string]stick.Value) error
+}
+
+type memoryEntry struct {
+	val     string
+	expires time.Time
+}
+
+// A MemoryCache is a Cache that stores fragments in memory.
+type MemoryCache struct {
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+}
+
+// NewMemoryCache returns an empty MemoryCache.
+func NewMemoryCache() *MemoryCache {
+	return &MemoryCache{entries: make(map[string]memoryEntry)}
+}
+
+// Get returns the fragment stored under key, if it has not expired.
+func (c *MemoryCache) Get(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return "", false
+	}
+	if !e.expires.IsZero() && time.Now().After(e.expires) {
+		delete(c.entries, key)
+		return "", false
+	}
+	return e.val, true
+}
+
+// Set stores a fragment under key.
+func (c *MemoryCache) Set(key, val string, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := memoryEntry{val: val}
+	if ttl > 0 {
+		e.expires = time.Now().Add(ttl)
+	}
+	c.entries[key] = e
+}
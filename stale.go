@@ -0,0 +1,102 @@
+package stickgen
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/tyler-sommer/stick"
+)
+
+// Directives recorded in the header of each generated file.
+const (
+	stampSources = "//stickgen:sources"
+	stampHash    = "//stickgen:hash"
+	stampVersion = "//stickgen:version"
+	stampOptions = "//stickgen:options"
+	stampCommand = "//stickgen:command"
+)
+
+// IsStale reports whether the generated file at the given path is out of
+// date with the templates it was generated from, as loaded by the given
+// Loader. A file is stale if any of its templates has changed or can no
+// longer be loaded.
+func IsStale(generatedFile string, loader stick.Loader) (bool, error) {
+	names, hash, err := readStamp(generatedFile)
+	if err != nil {
+		return false, err
+	}
+	digests := make(map[string][sha256.Size]byte)
+	for _, name := range names {
+		tpl, err := loader.Load(name)
+		if err != nil {
+			return true, nil
+		}
+		body, err := ioutil.ReadAll(tpl.Contents())
+		if err != nil {
+			return false, err
+		}
+		digests[name] = sha256.Sum256(body)
+	}
+	return hashSources(digests) != hash, nil
+}
+
+// Sources returns the names of the templates that the generated file at
+// the given path was generated from, including any templates they depend
+// on.
+func Sources(generatedFile string) ([]string, error) {
+	names, _, err := readStamp(generatedFile)
+	return names, err
+}
+
+// readStamp returns the names of the templates a generated file was
+// generated from and the hash of their sources.
+func readStamp(path string) ([]string, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, "", err
+	}
+	defer f.Close()
+
+	var names []string
+	var hash string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := s.Text()
+		if strings.HasPrefix(line, "package ") {
+			break
+		}
+		if v, ok := stampValue(line, stampHash); ok {
+			hash = v
+		} else if v, ok := stampValue(line, stampSources); ok {
+			for v != "" {
+				q, err := strconv.QuotedPrefix(v)
+				if err != nil {
+					return nil, "", fmt.Errorf("stickgen: %s has an invalid list of sources", path)
+				}
+				name, _ := strconv.Unquote(q)
+				names = append(names, name)
+				v = strings.TrimLeft(v[len(q):], " ")
+			}
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, "", err
+	}
+	if hash == "" || len(names) == 0 {
+		return nil, "", fmt.Errorf("stickgen: %s has no source hash", path)
+	}
+	return names, hash, nil
+}
+
+// stampValue returns the value of the given directive if line contains it.
+func stampValue(line, directive string) (string, bool) {
+	if !strings.HasPrefix(line, directive+" ") {
+		return "", false
+	}
+	return strings.TrimSpace(line[len(directive)+1:]), true
+}
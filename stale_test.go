@@ -0,0 +1,87 @@
+package stickgen_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/tyler-sommer/stick"
+	"github.com/veonik/go-stickgen"
+)
+
+func TestIsStale(t *testing.T) {
+	templates := map[string]string{
+		"layout.twig":       `[{% block content %}{% endblock %}]`,
+		"part \"one\".twig": `part`,
+		"test.twig":         `{% extends 'layout.twig' %}{% block content %}{% include 'part "one".twig' %}{% endblock %}`,
+		"other.twig":        `other`,
+	}
+	dir, err := ioutil.TempDir("", "stickgen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	g := stickgen.NewGenerator("views", &stick.MemoryLoader{Templates: templates})
+	src, err := g.Generate("test.twig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	generated := filepath.Join(dir, "views.go")
+	writeTree(t, dir, map[string]string{
+		"views.go":    src,
+		"plain.go":    "// Code generated by hand.\n\npackage views\n",
+		"invalid.go":  "//stickgen:sources \"a.twig\" b.twig\n//stickgen:hash 00\n\npackage views\n",
+		"late.go":     "package views\n\n//stickgen:sources \"a.twig\"\n//stickgen:hash 00\n",
+		"no-hash.go":  "//stickgen:sources \"a.twig\"\n\npackage views\n",
+		"no-names.go": "//stickgen:hash 00\n\npackage views\n",
+	})
+	changed := func(name, contents string) map[string]string {
+		res := make(map[string]string)
+		for k, v := range templates {
+			res[k] = v
+		}
+		if contents == "" {
+			delete(res, name)
+		} else {
+			res[name] = contents
+		}
+		return res
+	}
+	tests := []struct {
+		name      string
+		file      string
+		templates map[string]string
+		stale     bool
+		err       string
+	}{
+		{name: "unchanged", file: "views.go", templates: templates},
+		{name: "unrelated template changed", file: "views.go", templates: changed("other.twig", "changed")},
+		{name: "template changed", file: "views.go", templates: changed("test.twig", "changed"), stale: true},
+		{name: "layout changed", file: "views.go", templates: changed("layout.twig", "changed"), stale: true},
+		{name: "include changed", file: "views.go", templates: changed("part \"one\".twig", "changed"), stale: true},
+		{name: "include removed", file: "views.go", templates: changed("part \"one\".twig", ""), stale: true},
+		{name: "not generated", file: "plain.go", err: "has no source hash"},
+		{name: "invalid sources", file: "invalid.go", err: "has an invalid list of sources"},
+		{name: "after package clause", file: "late.go", err: "has no source hash"},
+		{name: "no hash", file: "no-hash.go", err: "has no source hash"},
+		{name: "no sources", file: "no-names.go", err: "has no source hash"},
+		{name: "missing file", file: "missing.go", err: "missing.go"},
+	}
+	for _, tt := range tests {
+		stale, err := stickgen.IsStale(filepath.Join(dir, tt.file), &stick.MemoryLoader{Templates: tt.templates})
+		checkErr(t, tt.name, "checking", err, tt.err)
+		if err == nil && stale != tt.stale {
+			t.Errorf("%s: expected stale %v, got %v", tt.name, tt.stale, stale)
+		}
+	}
+
+	names, err := stickgen.Sources(generated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"layout.twig", "part \"one\".twig", "test.twig"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("expected sources %q, got %q", want, names)
+	}
+}
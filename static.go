@@ -0,0 +1,160 @@
+package stickgen
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tyler-sommer/stick/parse"
+)
+
+// A staticWriter accumulates the output of a template that contains only
+// text, along with the names of the blocks it defines.
+type staticWriter struct {
+	strings.Builder
+	blocks map[string]bool
+}
+
+// staticText returns the output of the named template and the sorted names
+// of its blocks if it contains only text, once the templates it extends and
+// includes are resolved.
+func (g *Generator) staticText(name string) (string, []string, bool) {
+	b := &staticWriter{blocks: make(map[string]bool)}
+	if !g.staticTemplate(b, name, nil, make(map[string]bool)) {
+		return "", nil, false
+	}
+	var blocks []string
+	for name := range b.blocks {
+		blocks = append(blocks, name)
+	}
+	sort.Strings(blocks)
+	return b.String(), blocks, true
+}
+
+// staticTemplate writes the output of the named template to b, reporting
+// false if the template does not contain only text. Blocks are replaced by
+// the given overrides, if any.
+func (g *Generator) staticTemplate(b *staticWriter, name string, overrides map[string]*parse.BlockNode, seen map[string]bool) bool {
+	if seen[name] {
+		return false
+	}
+	seen[name] = true
+	defer delete(seen, name)
+	tree, err := g.parse(name)
+	if err != nil {
+		return false
+	}
+	root := tree.Root()
+	if root.Parent == nil {
+		return g.staticNode(b, root.BodyNode, overrides, seen)
+	}
+	names, cond, err := g.extendsRef(root.Parent.Tpl)
+	if err != nil || cond != nil {
+		return false
+	}
+	// Blocks defined in more derived templates take precedence.
+	blocks := make(map[string]*parse.BlockNode)
+	for _, n := range root.BodyNode.All() {
+		switch child := n.(type) {
+		case *parse.TextNode:
+			if strings.TrimSpace(child.Data) != "" {
+				return false
+			}
+		case *parse.BlockNode:
+			blocks[child.Name] = child
+			b.blocks[child.Name] = true
+		default:
+			return false
+		}
+	}
+	for k, v := range overrides {
+		blocks[k] = v
+	}
+	return g.staticTemplate(b, names[0], blocks, seen)
+}
+
+// staticNode writes the output of the given node to b, reporting false if
+// the node does not contain only text.
+func (g *Generator) staticNode(b *staticWriter, n parse.Node, overrides map[string]*parse.BlockNode, seen map[string]bool) bool {
+	switch node := n.(type) {
+	case *parse.BodyNode:
+		for _, child := range node.All() {
+			if !g.staticNode(b, child, overrides, seen) {
+				return false
+			}
+		}
+		return true
+	case *parse.TextNode:
+		b.WriteString(node.Data)
+		return true
+	case *parse.BlockNode:
+		b.blocks[node.Name] = true
+		if o, ok := overrides[node.Name]; ok {
+			return g.staticNode(b, o.Body, overrides, seen)
+		}
+		return g.staticNode(b, node.Body, overrides, seen)
+	case *parse.IncludeNode:
+		if node.With != nil && !g.staticExpr(node.With) {
+			return false
+		}
+		tpl, ignoreMissing := g.ignoreMissing(node.Tpl)
+		name, ok := g.templateName(tpl)
+		if !ok {
+			return false
+		}
+		if ignoreMissing {
+			if _, err := g.loader.Load(name); err != nil {
+				return true
+			}
+		}
+		return g.staticTemplate(b, name, nil, seen)
+	}
+	return false
+}
+
+// staticExpr reports whether the given expression can be evaluated without
+// any effect at runtime, such as a hash containing only constants.
+func (g *Generator) staticExpr(e parse.Expr) bool {
+	switch expr := e.(type) {
+	case *parse.GroupExpr:
+		return g.staticExpr(expr.X)
+	case *parse.HashExpr:
+		for _, kv := range expr.Elements {
+			switch kv.Key.(type) {
+			case *parse.NameExpr, *parse.StringExpr, *parse.NumberExpr:
+			default:
+				if !g.staticExpr(kv.Key) {
+					return false
+				}
+			}
+			if !g.staticExpr(kv.Value) {
+				return false
+			}
+		}
+		return true
+	case *parse.ArrayExpr:
+		for _, el := range expr.Elements {
+			if !g.staticExpr(el) {
+				return false
+			}
+		}
+		return true
+	case *parse.NullExpr:
+		return true
+	}
+	_, ok := g.constant(e)
+	return ok
+}
+
+// renderStatic returns a function for a template that contains only text,
+// which writes the text as a constant.
+func (g *Generator) renderStatic(tpl template) string {
+	constName := "static" + g.templateIdent(tpl.name)
+	return fmt.Sprintf(`const %s = %s
+
+func %s%s(%senv *stick.Env, output io.Writer, ctx map[string]stick.Value)%s {
+	%s
+%s}`, constName, strconv.Quote(tpl.text), g.receiver(), g.templateFunc(tpl.name), g.params(), g.result(),
+		g.checked(fmt.Sprintf("io.WriteString(output, %s)", constName), "_, err"), g.returnNil())
+}
@@ -0,0 +1,107 @@
+package stickgen_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/tyler-sommer/stick"
+	"github.com/veonik/go-stickgen"
+)
+
+func TestStatic(t *testing.T) {
+	tests := []struct {
+		name      string
+		templates map[string]string
+		options   func(g *stickgen.Generator)
+		call      string
+		static    bool
+		want      string
+	}{
+		{
+			name:      "text",
+			templates: map[string]string{"test.twig": "<footer>\n`quoted` \"text\"\n</footer>"},
+			static:    true,
+			want:      "<footer>\n`quoted` \"text\"\n</footer>",
+		},
+		{
+			name: "extends",
+			templates: map[string]string{
+				"layout.twig": `<h1>{% block title %}Title{% endblock %}</h1>{% block body %}{% endblock %}`,
+				"test.twig":   "{% extends 'layout.twig' %}\n{% block body %}body{% endblock %}",
+			},
+			static: true,
+			want:   "<h1>Title</h1>body",
+		},
+		{
+			name: "include",
+			templates: map[string]string{
+				"part.twig": `part`,
+				"test.twig": `[{% include 'part.twig' with {'a': 1, b: ['c', null], 'd': {'e': -1}} %}{% include 'missing.twig' ignore missing %}]`,
+			},
+			static: true,
+			want:   "[part]",
+		},
+		{
+			name:      "print",
+			templates: map[string]string{"test.twig": `a{{ 'b' }}`},
+			want:      "ab",
+		},
+		{
+			name: "dynamic include",
+			templates: map[string]string{
+				"part.twig": `part`,
+				"test.twig": `{% include 'part.twig' with {'a': a} %}`,
+			},
+			want: "part",
+		},
+		{
+			name: "conditional extends",
+			templates: map[string]string{
+				"a.twig":    `a`,
+				"b.twig":    `b`,
+				"test.twig": `{% extends x ? 'a.twig' : 'b.twig' %}`,
+			},
+			want: "b",
+		},
+		{
+			name:      "ignore errors",
+			templates: map[string]string{"test.twig": `static`},
+			options:   func(g *stickgen.Generator) { g.IgnoreErrors = true },
+			call: `TemplateTestTwig(env, output, ctx)
+	var err error`,
+			static: true,
+			want:   "static",
+		},
+		{
+			name:      "context",
+			templates: map[string]string{"test.twig": `static`},
+			options:   func(g *stickgen.Generator) { g.Context = true },
+			call:      `err := TemplateTestTwig(nil, env, output, ctx)`,
+			static:    true,
+			want:      "static",
+		},
+	}
+	var renders []renderTest
+	for _, tt := range tests {
+		g := stickgen.NewGenerator("views", &stick.MemoryLoader{Templates: tt.templates})
+		if tt.options != nil {
+			tt.options(g)
+		}
+		src, err := g.Generate("test.twig")
+		if err != nil {
+			t.Errorf("%s: unexpected error generating: %s", tt.name, err)
+			continue
+		}
+		if static := strings.Contains(src, "const staticTestTwig = "); static != tt.static {
+			t.Errorf("%s: expected static %v, got:\n%s", tt.name, tt.static, src)
+		}
+		renders = append(renders, renderTest{
+			name:      tt.name,
+			templates: tt.templates,
+			options:   tt.options,
+			call:      tt.call,
+			want:      tt.want,
+		})
+	}
+	testRender(t, renders)
+}
@@ -2,11 +2,18 @@ package stickgen // import "github.com/veonik/go-stickgen"
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"errors"
 	"fmt"
+	"go/format"
+	"go/token"
+	"io"
 	"io/ioutil"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/tyler-sommer/stick"
 	"github.com/tyler-sommer/stick/parse"
@@ -18,106 +25,623 @@ func titleize(in string) string {
 	return strings.Replace(strings.Title(notWord.ReplaceAllString(in, " ")), " ", "", -1)
 }
 
-type renderer func()
+type renderer func() error
 
 type evaluatedExpr struct {
 	body          string
 	isFunction    bool
 	hasError      bool
 	resultantName string
+	// safe is true if the result is already escaped.
+	safe bool
+	// isString is true if the result is a Go string.
+	isString bool
 }
 
+// A CommentLevel controls the comments generated for each node.
+type CommentLevel int
+
+const (
+	// CommentsFull generates a comment for every node. This is the default.
+	CommentsFull CommentLevel = iota
+	// CommentsMinimal generates comments for tags, but not for text or
+	// printed values.
+	CommentsMinimal
+	// CommentsOff generates no comments.
+	CommentsOff
+)
+
+// An ErrorPolicy controls how generated code handles errors that occur while
+// evaluating expressions, such as failed attribute lookups.
+type ErrorPolicy int
+
+const (
+	// ErrorsReturn returns the error from the generated function. This is
+	// the default.
+	ErrorsReturn ErrorPolicy = iota
+	// ErrorsPanic panics with the error.
+	ErrorsPanic
+	// ErrorsPlaceholder uses the Generator's ErrorPlaceholder as the value
+	// of the expression and continues rendering.
+	ErrorsPlaceholder
+	// ErrorsHandler passes the error to an ErrorHandler, which generated
+	// functions accept as a parameter following any context.Context.
+	// Rendering stops if the handler returns an error, which is returned
+	// from the generated function.
+	ErrorsHandler
+)
+
 // A Generator handles generating Go code from Twig templates.
+//
+// Each call to a Generate method starts from a clean state, so a Generator
+// may be reused. It is safe for concurrent use as long as its options are
+// not modified and its Loader is safe for concurrent use.
 type Generator struct {
+	// Filters contains filters that are compiled inline at generation time.
+	Filters map[string]Filter
+
+	// Tests contains tests that are compiled inline at generation time.
+	Tests map[string]Test
+
+	// DynamicIncludes enables including templates whose names cannot be
+	// evaluated at generation time. Such templates are rendered at runtime
+	// using the stick.Env passed to the generated code.
+	DynamicIncludes bool
+
+	// StringTemplates enables including templates created using the
+	// template_from_string function. Such templates are parsed and rendered
+	// at runtime using the stick.Env.
+	StringTemplates bool
+
+	// RuntimeSources defers loading templates passed to the source function
+	// until runtime, when they are loaded using the stick.Env. By default,
+	// their contents are inlined at generation time.
+	RuntimeSources bool
+
+	// Constants contains the values available to the constant function,
+	// keyed by name. Constants are resolved at generation time.
+	Constants map[string]stick.Value
+
+	// Debug enables the dump function. When it is false, calls to dump
+	// produce no output.
+	Debug bool
+
+	// Autoescape is the escaping strategy, such as "html", applied to
+	// values printed outside of any autoescape tag. By default, printed
+	// values are not escaped.
+	Autoescape string
+
+	// Registry generates a Templates map containing each generated
+	// template, keyed by name, and an Execute function that renders a
+	// template by name.
+	Registry bool
+
+	// StringFuncs generates, for each template, a function that renders
+	// the template to a string, named after the template's function with a
+	// String suffix.
+	StringFuncs bool
+
+	// Types generates, for each template, a type implementing Template,
+	// named after the template with a Template suffix.
+	Types bool
+
+	// Header is a comment, such as a license notice, placed at the top of
+	// each generated file. Each line is prefixed with "// ".
+	Header string
+
+	// BuildConstraint is a build constraint expression, such as "!dev",
+	// added to each generated file as a //go:build line.
+	BuildConstraint string
+
+	// GeneratedBy is a text/template for the comment identifying each file
+	// as generated, which is executed with a HeaderData. By default, the
+	// comment is "Code generated by stickgen. DO NOT EDIT.", which matches
+	// the convention recognized by Go tools. A custom comment should match
+	// it too.
+	GeneratedBy string
+
+	// Command is the command used to generate the code, such as the
+	// stickgen command line, which is recorded in each generated file.
+	Command string
+
+	// Reproducible omits anything that depends on when the code is
+	// generated, so that the same templates and options always generate
+	// the same code. The Time passed to the GeneratedBy template is zero.
+	Reproducible bool
+
+	// StickImport overrides the import path of the stick package used by
+	// generated code, such as for a fork. The package is imported with the
+	// name stick.
+	StickImport string
+
+	// RuntimeImport overrides the import path of this package, which
+	// provides helpers used by generated code. The package is imported with
+	// the name stickgen.
+	RuntimeImport string
+
+	// Unexported generates unexported names for the functions and types
+	// that render templates, for use in a package that provides its own API.
+	Unexported bool
+
+	// Receiver generates the functions that render templates as methods of
+	// an empty struct type with the given name, which is also generated.
+	Receiver string
+
+	// Naming names the generated functions.
+	Naming NamingStrategy
+
+	// Extensions contains the extensions of templates, such as ".twig",
+	// ".html.twig" and ".tpl", which are used to find templates when no
+	// include patterns are given. By default, templates have the ".twig"
+	// extension.
+	Extensions []string
+
+	// StripExtensions removes the extensions of templates from the names
+	// passed to Naming, so that "user-card.html.twig" is rendered by
+	// TemplateUserCard rather than TemplateUserCardHtmlTwig.
+	StripExtensions bool
+
+	// FileNaming names the files generated by GenerateDir and GenerateGlob.
+	FileNaming FileNaming
+
+	// Comments controls which comments describing the location of each
+	// node in its template are generated.
+	Comments CommentLevel
+
+	// LineDirectives generates //line directives in place of comments, so
+	// that compiler errors, panics and stack traces refer to the location
+	// in the template rather than in the generated code.
+	LineDirectives bool
+
+	// NoFormat disables formatting the generated code using go/format.
+	NoFormat bool
+
+	// AllowUnformatted returns the generated code as is when it cannot be
+	// formatted, rather than returning an error. This is useful when
+	// debugging code that does not compile.
+	AllowUnformatted bool
+
+	// Jobs is the number of templates that GenerateDir and GenerateGlob
+	// generate concurrently. By default, templates are generated one at a
+	// time. Templates are loaded through a CachingLoader, so the Loader need
+	// not be safe for concurrent use.
+	Jobs int
+
+	// Packages maps directories of templates to packages of their own,
+	// which GenerateDir and GenerateGlob generate into other directories.
+	Packages []Package
+
+	// BuildCache is used by GenerateDir and GenerateGlob to skip
+	// generating templates whose sources have not changed, and is updated
+	// with the files they generate.
+	BuildCache *BuildCache
+
+	// Context generates functions that accept a context.Context as their
+	// first argument. Rendering stops with the context's error once it is
+	// done, and the context is available to functions, filters and tests
+	// using ContextOf. Context cannot be used with IgnoreErrors.
+	Context bool
+
+	// ErrorPolicy controls how generated code handles errors that occur
+	// while evaluating expressions. Errors writing to the output are always
+	// returned. ErrorPolicy cannot be used with IgnoreErrors.
+	ErrorPolicy ErrorPolicy
+
+	// ErrorPlaceholder is the value of an expression that fails when
+	// ErrorPolicy is ErrorsPlaceholder.
+	ErrorPlaceholder string
+
+	// IgnoreErrors generates functions that return nothing, as in earlier
+	// versions of stickgen. Any errors encountered while rendering, such as
+	// failed writes or attribute lookups, are ignored. By default, generated
+	// functions return the first such error.
+	IgnoreErrors bool
+
+	// Logger records the templates generated, along with warnings and
+	// constructs that are skipped, such as missing templates that are
+	// ignored. By default, nothing is logged.
+	Logger Logger
+
 	pkgName string
 	loader  stick.Loader
 	out     *bytes.Buffer
 	name    string
 	imports map[string]bool
-	blocks  map[string]renderer
-	args    map[string]bool
-	root    bool
-	stack   []string
-	tabs    int
+	blocks  map[string]block
+	// dispatchers contains each block scope whose blocks are referenced by
+	// name at runtime.
+	dispatchers map[string]bool
+	// scope prefixes the names of generated block functions. Embedded
+	// templates are generated in their own scope.
+	scope  string
+	embeds int
+	// escape is the escaping strategy applied to printed values.
+	escape string
+	// parent is the generated function for the block overridden by the
+	// block being generated, if any.
+	parent string
+	// parents contains each overridden block called using parent().
+	parents map[string]bool
+	macros  map[string]macro
+	// namespaces maps each template name to its imported macro bindings.
+	namespaces map[string]map[string]binding
+	// args maps each local variable in scope to its Go identifier.
+	args map[string]string
+	// loop is the name of the innermost loop variable, if any, and loops is
+	// the depth of the innermost for tag.
+	loop    string
+	loops   int
+	sources map[string]sourceMap
+	// texts contains the original source of each template loaded.
+	texts map[string]string
+	// at is the location of the node being generated, to which errors are
+	// attributed.
+	at location
+	// digests contains the hash of the source of each template loaded.
+	digests map[string][sha256.Size]byte
+	// directives contains tags rewritten by the preprocessor.
+	directives []directive
+	// level is the depth of the current template in the inheritance chain
+	// of the template being generated.
+	level int
+	stack []string
+	tabs  int
+	// temps is the number of temporary variables declared in the function
+	// being generated.
+	temps int
+	// ret is the statement that returns err from the function currently
+	// being generated.
+	ret string
+	// templates contains the body of the function for each template being
+	// generated.
+	templates []template
+	// emitted contains the functions that have already been generated,
+	// possibly in another file of the same package.
+	emitted map[string]bool
+	// order contains the position in which each block, macro and
+	// dispatcher was first registered, so that they are generated in a
+	// consistent order.
+	order map[string]int
+	// sharedRegistry is true if the Templates map is declared in another
+	// file of the same package.
+	sharedRegistry bool
+	// lint is true if errors generating the nodes of a body are recorded in
+	// problems, rather than stopping at the first.
+	lint     bool
+	problems Errors
+}
+
+// A template is the generated body of the function that renders a template.
+type template struct {
+	name string
+	body string
+	// static is true if the template contains only text, which is
+	// generated as a constant. blocks contains the names of its blocks.
+	static bool
+	text   string
+	blocks []string
 }
 
-// Generate parses the given template and outputs the generated code.
+// Generate parses the given template and outputs the generated code. Errors
+// located in a template are TemplateErrors.
 func (g *Generator) Generate(name string) (string, error) {
-	err := g.generate(name)
-	if err != nil {
+	b := &strings.Builder{}
+	if err := g.GenerateTo(b, name); err != nil {
 		return "", err
 	}
-	return g.output(), nil
+	return b.String(), nil
+}
+
+// GenerateTo parses the given template and writes the generated code to w.
+// Nothing is written if the template cannot be generated.
+func (g *Generator) GenerateTo(w io.Writer, name string) error {
+	return g.clone().generateAll(w, []string{name})
+}
+
+// GenerateAll parses each of the given templates and outputs the generated
+// code for all of them as a single file, with a function for each template.
+// Functions used by more than one template, such as macros, are generated
+// only once.
+//
+// If any of the templates cannot be generated, the rest are still generated
+// so that the error, an Errors, reports each of them. Errors located in a
+// template are TemplateErrors.
+func (g *Generator) GenerateAll(names ...string) (string, error) {
+	b := &strings.Builder{}
+	if err := g.clone().generateAll(b, names); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+func (g *Generator) generateAll(w io.Writer, names []string) error {
+	if err := g.generateTemplates(names); err != nil {
+		return err
+	}
+	src, err := g.output()
+	if err != nil {
+		return err
+	}
+	src, err = g.format(src)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, src)
+	return err
+}
+
+// generateTemplates generates the function body for each of the named
+// templates. Any blocks and macros they use are generated by output.
+func (g *Generator) generateTemplates(names []string) error {
+	if err := g.validate(); err != nil {
+		return err
+	}
+	fnNames := make(map[string]string)
+	var errs Errors
+	for _, name := range names {
+		fnName := g.templateFunc(name)
+		if prev, ok := fnNames[fnName]; ok {
+			errs = append(errs, fmt.Errorf("stickgen: %s and %s would both be generated as %s", prev, name, fnName))
+			continue
+		}
+		fnNames[fnName] = name
+		if err := g.generateRoot(name); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	switch {
+	case len(errs) == 0:
+		return nil
+	case len(names) == 1:
+		return errs[0]
+	}
+	return errs
+}
+
+// validate reports any invalid combination of options.
+func (g *Generator) validate() error {
+	if g.Context && g.IgnoreErrors {
+		return errors.New("stickgen: Context cannot be used with IgnoreErrors")
+	}
+	if g.ErrorPolicy != ErrorsReturn && g.IgnoreErrors {
+		return errors.New("stickgen: ErrorPolicy cannot be used with IgnoreErrors")
+	}
+	if g.Receiver != "" && !token.IsIdentifier(g.Receiver) {
+		return fmt.Errorf("stickgen: invalid receiver type name: %s", g.Receiver)
+	}
+	if g.Autoescape != "" && !isEscapeStrategy(g.Autoescape) {
+		return fmt.Errorf("stickgen: unsupported autoescape strategy: %s", g.Autoescape)
+	}
+	for _, ext := range g.Extensions {
+		if len(ext) < 2 || ext[0] != '.' || strings.ContainsAny(ext, "/*?[") {
+			return fmt.Errorf("stickgen: invalid template extension: %q", ext)
+		}
+	}
+	return nil
+}
+
+// generateRoot generates the body of the function that renders the named
+// template.
+func (g *Generator) generateRoot(name string) error {
+	g.out.Reset()
+	g.stack = g.stack[:0]
+	g.args = make(map[string]string)
+	g.loop, g.loops, g.embeds, g.temps = "", 0, 0, 0
+	g.escape = g.Autoescape
+	g.at = location{}
+	g.log().Debug("generating template", "template", name)
+	if text, blocks, ok := g.staticText(name); ok {
+		g.templates = append(g.templates, template{name: name, static: true, text: text, blocks: blocks})
+		return nil
+	}
+	if err := g.generate(name); err != nil {
+		return g.templateError(name, err)
+	}
+	g.templates = append(g.templates, template{name: name, body: g.out.String()})
+	return nil
 }
 
 // NewGenerator creates a new code generator using the given Loader.
 func NewGenerator(pkgName string, loader stick.Loader) *Generator {
 	g := &Generator{
-		pkgName: pkgName,
-		loader:  loader,
-		name:    "",
-		out:     &bytes.Buffer{},
-		imports: map[string]bool{
-			"github.com/tyler-sommer/stick": true,
-			"io": true,
-		},
-		blocks: make(map[string]renderer),
-		args:   make(map[string]bool),
-		root:   true,
-		stack:  make([]string, 0),
-		tabs:   1,
+		Filters:    builtinFilters(),
+		Tests:      make(map[string]Test),
+		Naming:     DefaultNaming{},
+		FileNaming: DefaultFileNaming{},
+		pkgName:    pkgName,
+		loader:     loader,
 	}
-
+	g.reset()
 	return g
 }
 
+// reset discards any state left from generating code. The Generator's
+// options are kept.
+func (g *Generator) reset() {
+	g.name = ""
+	g.out = &bytes.Buffer{}
+	g.imports = map[string]bool{
+		stickImport: true,
+		"io":        true,
+	}
+	g.blocks = make(map[string]block)
+	g.dispatchers = make(map[string]bool)
+	g.scope, g.embeds, g.escape, g.parent = "", 0, "", ""
+	g.parents = make(map[string]bool)
+	g.macros = make(map[string]macro)
+	g.namespaces = make(map[string]map[string]binding)
+	g.args = make(map[string]string)
+	g.loop, g.loops = "", 0
+	g.sources = make(map[string]sourceMap)
+	g.texts = make(map[string]string)
+	g.at = location{}
+	g.problems = nil
+	g.digests = make(map[string][sha256.Size]byte)
+	g.directives = nil
+	g.level = 0
+	g.stack = make([]string, 0)
+	g.tabs = 1
+	g.ret = "return err"
+	g.templates = nil
+	g.emitted = make(map[string]bool)
+	g.order = make(map[string]int)
+}
+
+// clone returns a new Generator with the same options as g.
+func (g *Generator) clone() *Generator {
+	c := *g
+	c.reset()
+	return &c
+}
+
 func (g *Generator) indent() string {
 	return strings.Repeat("	", g.tabs)
 }
 
 func (g *Generator) generate(name string) error {
-	tpl, err := g.loader.Load(name)
-	if err != nil {
-		return err
-	}
-
-	body, err := ioutil.ReadAll(tpl.Contents())
-	if err != nil {
-		return err
-	}
-	tree, err := parse.Parse(string(body))
+	tree, err := g.parse(name)
 	if err != nil {
 		return err
 	}
 	g.name = name
 	g.stack = append(g.stack, name)
-	g.root = len(g.stack) == 1
-	if !g.root {
+	if len(g.stack) > 1 {
 		defer func() {
 			g.stack = g.stack[:len(g.stack)-1]
 			g.name = g.stack[len(g.stack)-1]
-			g.root = len(g.stack) == 1
 		}()
 	}
 	return g.walk(tree.Root())
 }
 
-func (g *Generator) output() string {
-	body := g.out.String()
+// parse loads and parses the named template, registering any macros it
+// defines.
+func (g *Generator) parse(name string) (*parse.Tree, error) {
+	tpl, err := g.loader.Load(name)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := ioutil.ReadAll(tpl.Contents())
+	if err != nil {
+		return nil, err
+	}
+	g.digests[name] = sha256.Sum256(body)
+	g.texts[name] = string(body)
+	src, sm := g.preprocess(string(body))
+	g.sources[name] = sm
+	tree, err := parse.Parse(src)
+	if err != nil {
+		return nil, &TemplateError{Name: name, Err: err}
+	}
+	g.addMacros(name, tree.Root())
+	return tree, nil
+}
+
+// output returns the unformatted source of a file containing the generated
+// templates, along with the blocks and macros they use.
+func (g *Generator) output() (string, error) {
 	funcs := make([]string, 0)
-	for _, block := range g.blocks {
+	// Rendering a function may register more functions, so render until
+	// none remain.
+	rendered := make(map[string]bool)
+	for more := true; more; {
+		more = false
+		var names []string
+		for name := range g.blocks {
+			names = append(names, name)
+		}
+		for _, name := range g.inOrder(names) {
+			block := g.blocks[name]
+			// Overridden blocks are only generated if called using parent().
+			if rendered[name] || name != g.blockFuncName(block.scope, block.name) && !g.parents[name] {
+				continue
+			}
+			rendered[name] = true
+			more = true
+			if g.emitted[name] {
+				continue
+			}
+			g.emitted[name] = true
+			g.out.Reset()
+			g.at = location{}
+			if err := g.renderBlock(name, block); err != nil {
+				return "", g.templateError(block.tplName, err)
+			}
+			funcs = append(funcs, g.out.String())
+		}
+		names = names[:0]
+		for name := range g.macros {
+			names = append(names, name)
+		}
+		for _, name := range g.inOrder(names) {
+			macro := g.macros[name]
+			if rendered[name] {
+				continue
+			}
+			rendered[name] = true
+			more = true
+			if g.emitted[name] {
+				continue
+			}
+			g.emitted[name] = true
+			g.out.Reset()
+			g.at = location{}
+			if err := macro.render(); err != nil {
+				return "", g.templateError("", err)
+			}
+			funcs = append(funcs, g.out.String())
+		}
+	}
+	var scopes []string
+	for scope := range g.dispatchers {
+		scopes = append(scopes, scope)
+	}
+	sort.Slice(scopes, func(i, j int) bool {
+		return g.order[g.dispatcherName(scopes[i])] < g.order[g.dispatcherName(scopes[j])]
+	})
+	for _, scope := range scopes {
+		if g.emitted[g.dispatcherName(scope)] {
+			continue
+		}
+		g.emitted[g.dispatcherName(scope)] = true
 		g.out.Reset()
-		block()
+		g.renderDispatcher(scope)
 		funcs = append(funcs, g.out.String())
 	}
-	imports := make([]string, 0)
-	for v, _ := range g.imports {
-		imports = append(imports, fmt.Sprintf(`"%s"`, v))
+	var tpls []string
+	if g.Receiver != "" && !g.emitted[g.Receiver] {
+		g.emitted[g.Receiver] = true
+		tpls = append(tpls, fmt.Sprintf(`// %s renders the generated templates.
+type %s struct{}`, g.Receiver, g.Receiver))
+	}
+	for _, tpl := range g.templates {
+		if tpl.static {
+			tpls = append(tpls, g.renderStatic(tpl))
+		} else {
+			tpls = append(tpls, fmt.Sprintf(`func %s%s(%senv *stick.Env, output io.Writer, ctx map[string]stick.Value)%s {
+%s%s}`, g.receiver(), g.templateFunc(tpl.name), g.params(), g.result(), tpl.body, g.returnNil()))
+		}
+		if g.StringFuncs {
+			tpls = append(tpls, g.stringFunc(tpl.name))
+		}
+		if g.Types {
+			tpls = append(tpls, g.templateType(tpl))
+		}
+	}
+	if g.Registry {
+		tpls = append(tpls, g.registry())
+	}
+	if g.Context {
+		g.addImport("context")
+	}
+	imports := g.importSpecs()
+	header, err := g.header()
+	if err != nil {
+		return "", err
 	}
 
-	return fmt.Sprintf(`// Code generated by stickgen.
-// DO NOT EDIT!
-
+	src := fmt.Sprintf(`%s
 package %s
 
 import (
@@ -126,9 +650,366 @@ import (
 
 %s
 
-func Template%s(env *stick.Env, output io.Writer, ctx map[string]stick.Value) {
-%s}
-`, g.pkgName, strings.Join(imports, "\n	"), strings.Join(funcs, "\n"), titleize(g.name), body)
+%s
+`, header, g.pkgName, strings.Join(imports, "\n	"), strings.Join(funcs, "\n"), strings.Join(tpls, "\n\n"))
+	return src, nil
+}
+
+// stringFunc returns a function that renders the named template to a string.
+func (g *Generator) stringFunc(name string) string {
+	g.addImport(runtimeImport)
+	call := fmt.Sprintf("%s(%senv, buf, ctx)", g.templateRef(name), g.callArgs())
+	if !g.IgnoreErrors {
+		call = fmt.Sprintf(`if err := %s; err != nil {
+		return "", err
+	}`, call)
+	}
+	return fmt.Sprintf(`func %s%sString(%senv *stick.Env, ctx map[string]stick.Value) (string, error) {
+	buf := stickgen.GetBuffer()
+	defer stickgen.PutBuffer(buf)
+	%s
+	return buf.String(), nil
+}`, g.receiver(), g.templateFunc(name), g.params(), call)
+}
+
+// templateType returns a type implementing Template for the given template.
+func (g *Generator) templateType(tpl template) string {
+	g.addImport(runtimeImport)
+	name := tpl.name
+	typeName := g.templateIdent(name) + "Template"
+	if g.Unexported {
+		typeName = unexport(typeName)
+	}
+	fnName := g.templateRef(name)
+	args := ""
+	if g.Context {
+		args += "context.Background(), "
+	}
+	if g.ErrorPolicy == ErrorsHandler {
+		args += "nil, "
+	}
+	call := fmt.Sprintf("return %s(%senv, output, ctx)", fnName, args)
+	if g.IgnoreErrors {
+		call = fmt.Sprintf("%s(env, output, ctx)\n	return nil", fnName)
+	}
+	iface := "Template"
+	if g.Context {
+		iface = "ContextTemplate"
+	}
+	names := tpl.blocks
+	if !tpl.static {
+		names = g.blockNames(g.templateIdent(name))
+	}
+	var blocks []string
+	for _, b := range names {
+		blocks = append(blocks, strconv.Quote(b))
+	}
+	src := fmt.Sprintf(`type %s struct{}
+
+var _ stickgen.%s = %s{}
+
+func (%s) Name() string {
+	return %s
+}
+
+func (%s) Execute(env *stick.Env, output io.Writer, ctx map[string]stick.Value) error {
+	%s
+}
+
+func (%s) Blocks() []string {
+	return []string{%s}
+}`, typeName, iface, typeName, typeName, strconv.Quote(name), typeName, call, typeName, strings.Join(blocks, ", "))
+	if g.Context {
+		args = "goctx, "
+		if g.ErrorPolicy == ErrorsHandler {
+			args += "nil, "
+		}
+		src += fmt.Sprintf(`
+
+func (%s) ExecuteContext(goctx context.Context, env *stick.Env, output io.Writer, ctx map[string]stick.Value) error {
+	return %s(%senv, output, ctx)
+}`, typeName, fnName, args)
+	}
+	return src
+}
+
+// format formats the generated source as gofmt would.
+func (g *Generator) format(src string) (string, error) {
+	if g.NoFormat {
+		return src, nil
+	}
+	b, err := format.Source([]byte(src))
+	if err != nil {
+		if g.AllowUnformatted {
+			g.log().Warn("unable to format generated code", "error", err)
+			return src, nil
+		}
+		return "", fmt.Errorf("stickgen: unable to format generated code: %s", err)
+	}
+	return string(b), nil
+}
+
+// params returns the parameters that precede the stick.Env in generated
+// functions.
+func (g *Generator) params() string {
+	params := ""
+	if g.Context {
+		params += "goctx context.Context, "
+	}
+	if g.ErrorPolicy == ErrorsHandler {
+		g.addImport(runtimeImport)
+		params += "gohandler stickgen.ErrorHandler, "
+	}
+	return params
+}
+
+// callArgs returns the arguments that precede the stick.Env in calls to
+// generated functions.
+func (g *Generator) callArgs() string {
+	args := ""
+	if g.Context {
+		args += "goctx, "
+	}
+	if g.ErrorPolicy == ErrorsHandler {
+		args += "gohandler, "
+	}
+	return args
+}
+
+// checkDone returns a statement that returns the context's error from the
+// generated function once it is done, or nothing if Context is disabled.
+func (g *Generator) checkDone() string {
+	if !g.Context {
+		return ""
+	}
+	return fmt.Sprintf(`%s%s
+`, g.indent(), g.checked("goctx.Err()", "err"))
+}
+
+// result returns the result type of generated functions.
+func (g *Generator) result() string {
+	if g.IgnoreErrors {
+		return ""
+	}
+	return " error"
+}
+
+// returnNil returns the statement that ends a generated function.
+func (g *Generator) returnNil() string {
+	if g.IgnoreErrors {
+		return ""
+	}
+	return "	return nil\n"
+}
+
+// checked returns a statement that calls fn, assigning its results to vars.
+// If err is non-nil, it is returned from the generated function unless
+// errors are ignored.
+func (g *Generator) checked(fn, vars string) string {
+	if g.IgnoreErrors {
+		return fn
+	}
+	return fmt.Sprintf(`if %s := %s; err != nil {
+%s	%s
+%s}`, vars, fn, g.indent(), g.ret, g.indent())
+}
+
+// checkErr returns a statement that handles err, produced by evaluating the
+// given expression, according to the ErrorPolicy.
+func (g *Generator) checkErr(v evaluatedExpr) string {
+	handle := g.ret
+	switch g.ErrorPolicy {
+	case ErrorsPanic:
+		handle = "panic(err)"
+	case ErrorsPlaceholder:
+		handle = fmt.Sprintf("%s = %s", v.resultantName, strconv.Quote(g.ErrorPlaceholder))
+	case ErrorsHandler:
+		handle = fmt.Sprintf(`if err := gohandler.Handle(err); err != nil {
+%s		%s
+%s	}`, g.indent(), g.ret, g.indent())
+	}
+	return fmt.Sprintf(`if err != nil {
+%s	%s
+%s}`, g.indent(), handle, g.indent())
+}
+
+// handleErr returns the body of an evaluated expression that is used by
+// another expression, handling any error it produces.
+func (g *Generator) handleErr(v evaluatedExpr) string {
+	if !v.hasError {
+		return v.body
+	}
+	if g.IgnoreErrors {
+		return v.body + "\n" + g.indent() + "_ = err"
+	}
+	return v.body + "\n" + g.indent() + g.checkErr(v)
+}
+
+// walkBody walks each node in the given body. Consecutive nodes whose output
+// is known at generation time are written at once.
+func (g *Generator) walkBody(node *parse.BodyNode) error {
+	nodes := node.All()
+	for i := 0; i < len(nodes); i++ {
+		text, ok := g.staticOutput(nodes[i])
+		if !ok {
+			if err := g.walk(nodes[i]); err != nil && g.lint {
+				g.problems = append(g.problems, g.templateError(g.name, err))
+			} else if err != nil {
+				return err
+			}
+			continue
+		}
+		start := nodes[i].Start()
+		for i+1 < len(nodes) {
+			next, ok := g.staticOutput(nodes[i+1])
+			if !ok {
+				break
+			}
+			text += next
+			i++
+		}
+		g.out.WriteString(g.contentComment(start))
+		g.writeString(textLiteral(text))
+	}
+	return nil
+}
+
+// staticOutput returns the output of the given node if it is text or prints
+// a string literal.
+func (g *Generator) staticOutput(n parse.Node) (string, bool) {
+	switch node := n.(type) {
+	case *parse.TextNode:
+		return node.Data, true
+	case *parse.PrintNode:
+		lit, ok := node.X.(*parse.StringExpr)
+		if !ok {
+			return "", false
+		}
+		// The literal is generated as a Go string, so it is interpreted
+		// the same way here.
+		s, err := strconv.Unquote(`"` + lit.Text + `"`)
+		if err != nil {
+			return "", false
+		}
+		if g.escape != "" {
+			s = Escape(s, g.escape)
+		}
+		return s, true
+	}
+	return "", false
+}
+
+// textLiteral returns a Go literal for the given text. Raw string literals
+// are used where possible, as they are easier to read. Any backquotes are
+// quoted separately, and text that cannot appear in a raw string literal at
+// all, such as carriage returns or invalid UTF-8, is quoted entirely.
+func textLiteral(text string) string {
+	if !utf8.ValidString(text) || strings.ContainsAny(text, "\r\x00\ufeff") {
+		return strconv.Quote(text)
+	}
+	if !strings.Contains(text, "`") {
+		return "`" + text + "`"
+	}
+	var lits []string
+	for i, part := range strings.Split(text, "`") {
+		if i > 0 {
+			lits = append(lits, "\"`\"")
+		}
+		if part != "" {
+			lits = append(lits, "`"+part+"`")
+		}
+	}
+	return strings.Join(lits, " + ")
+}
+
+// writeExpr generates a write of the result of the given expression to the
+// output.
+func (g *Generator) writeExpr(v evaluatedExpr) {
+	if v.isString {
+		g.writeString(v.resultantName)
+		return
+	}
+	g.write(v.resultantName)
+}
+
+// writeString generates a write of the given Go string expression to the
+// output, which avoids the formatting done by write.
+func (g *Generator) writeString(expr string) {
+	g.out.WriteString(fmt.Sprintf(`%s%s
+`, g.indent(), g.checked(fmt.Sprintf("io.WriteString(output, %s)", expr), "_, err")))
+}
+
+// write generates a write of the given Go expression to the output, which is
+// formatted using fmt.Fprint.
+func (g *Generator) write(expr string) {
+	g.addImport("fmt")
+	g.out.WriteString(fmt.Sprintf(`%s%s
+`, g.indent(), g.checked(fmt.Sprintf("fmt.Fprint(output, %s)", expr), "_, err")))
+}
+
+// comment returns a comment describing the location of a node in the
+// current template.
+func (g *Generator) comment(p parse.Pos) string {
+	if g.Comments == CommentsOff {
+		return ""
+	}
+	if g.LineDirectives {
+		// Line directives must begin at the start of the line.
+		return fmt.Sprintf(`//line %s:%d
+`, g.name, p.Line)
+	}
+	return fmt.Sprintf(`%s// line %d, offset %d in %s
+`, g.indent(), p.Line, g.sources[g.name].offset(p.Offset), g.name)
+}
+
+// contentComment returns a comment describing the location of a text or
+// print node, which is omitted unless all comments are enabled.
+func (g *Generator) contentComment(p parse.Pos) string {
+	if g.Comments != CommentsFull {
+		return ""
+	}
+	return g.comment(p)
+}
+
+// register records the given function name as registered, unless it already
+// has been.
+func (g *Generator) register(name string) {
+	if _, ok := g.order[name]; !ok {
+		g.order[name] = len(g.order)
+	}
+}
+
+// inOrder sorts the given function names in the order they were registered.
+func (g *Generator) inOrder(names []string) []string {
+	sort.Slice(names, func(i, j int) bool {
+		return g.order[names[i]] < g.order[names[j]]
+	})
+	return names
+}
+
+// importSpecs returns the sorted import declarations of the generated code.
+func (g *Generator) importSpecs() []string {
+	imports := make([]string, 0, len(g.imports))
+	for v := range g.imports {
+		spec := strconv.Quote(v)
+		switch {
+		case v == stickImport && g.StickImport != "":
+			spec = "stick " + strconv.Quote(g.StickImport)
+		case v == runtimeImport && g.RuntimeImport != "":
+			spec = "stickgen " + strconv.Quote(g.RuntimeImport)
+		}
+		imports = append(imports, spec)
+	}
+	sort.Strings(imports)
+	return imports
+}
+
+// temp returns a new name for a temporary variable in the function being
+// generated, beginning with the given prefix.
+func (g *Generator) temp(prefix string) string {
+	name := prefix + strconv.Itoa(g.temps)
+	g.temps++
+	return name
 }
 
 func (g *Generator) addImport(name string) {
@@ -138,168 +1019,354 @@ func (g *Generator) addImport(name string) {
 }
 
 func (g *Generator) walk(n parse.Node) error {
+	g.at = location{g.name, n.Start()}
 	switch node := n.(type) {
 	case *parse.ModuleNode:
 		if node.Parent != nil {
-			if name, ok := g.evaluate(node.Parent.Tpl); ok {
-				err := g.generate(name)
-				if err != nil {
-					return err
-				}
-			} else {
-				// TODO: Handle more than just string literals
-				return errors.New("Unable to evaluate extends reference")
-			}
+			return g.walkChild(node)
 		}
 		return g.walk(node.BodyNode)
 	case *parse.BodyNode:
-		for _, child := range node.All() {
-			err := g.walk(child)
-			if err != nil {
-				return err
-			}
-		}
+		return g.walkBody(node)
 	case *parse.IncludeNode:
-		if name, ok := g.evaluate(node.Tpl); ok {
-			err := g.generate(name)
-			if err != nil {
-				return err
-			}
-		} else {
-			// TODO: Handle more than just string literals
-			return errors.New("Unable to evaluate include reference")
-		}
+		return g.walkInclude(node)
 	case *parse.TextNode:
-		g.addImport("fmt")
-		g.out.WriteString(fmt.Sprintf(`%s// line %d, offset %d in %s
-%sfmt.Fprint(output, %s)
-`, g.indent(), node.Line, node.Offset, g.name, g.indent(), fmt.Sprintf("`%s`", node.Data)))
+		g.out.WriteString(g.contentComment(node.Pos))
+		g.writeString(textLiteral(node.Data))
 	case *parse.PrintNode:
+		if !g.Debug && g.isBuiltinFunc(node.X, "dump") {
+			return nil
+		}
 		v, err := g.walkExpr(node.X)
 		if err != nil {
 			return err
 		}
-		g.addImport("fmt")
-		g.out.WriteString(fmt.Sprintf(`%s// line %d, offset %d in %s
-`, g.indent(), node.Line, node.Offset, g.name))
+		if f, ok := node.X.(*parse.FilterExpr); g.escape != "" && !v.safe && (!ok || f.Name != "raw") {
+			g.addImport(runtimeImport)
+			v.resultantName = fmt.Sprintf("stickgen.Escape(%s, %s)", v.resultantName, strconv.Quote(g.escape))
+			v.isString = true
+		}
+		g.out.WriteString(g.contentComment(node.Pos))
 		if v.isFunction {
-			// TODO: The goggles, they do nothing!
 			g.out.WriteString(fmt.Sprintf(`%s{
 `, g.indent()))
 			g.tabs++
 			g.out.WriteString(fmt.Sprintf(`%s%s
 `, g.indent(), v.body))
-			if v.hasError {
+			if v.hasError && g.IgnoreErrors {
 				g.out.WriteString(fmt.Sprintf(`%sif err == nil {
 `, g.indent()))
 				g.tabs++
-				g.out.WriteString(fmt.Sprintf(`%sfmt.Fprint(output, %s)
-`, g.indent(), v.resultantName))
+				g.writeExpr(v)
 				g.tabs--
 				g.out.WriteString(fmt.Sprintf(`%s}
 `, g.indent()))
 			} else {
-				g.out.WriteString(fmt.Sprintf(`%sfmt.Fprint(output, %s)
-`, g.indent(), v.resultantName))
+				if v.hasError {
+					g.out.WriteString(fmt.Sprintf(`%s%s
+`, g.indent(), g.checkErr(v)))
+				}
+				g.writeExpr(v)
 			}
 			g.tabs--
 			g.out.WriteString(fmt.Sprintf(`%s}
 `, g.indent()))
 		} else {
-			g.out.WriteString(fmt.Sprintf(`%sfmt.Fprint(output, %s)
-`, g.indent(), v.resultantName))
+			g.writeExpr(v)
 		}
 
 	case *parse.BlockNode:
-		g.addImport("fmt")
-		g.blocks[node.Name] = func(g *Generator, node *parse.BlockNode, rootName string) renderer {
-			// TODO: Wow, I don't know about all this.
-			return func() {
-				g.out.WriteString(fmt.Sprintf(`func block%s%s(env *stick.Env, output io.Writer, ctx map[string]stick.Value) {
-`, titleize(rootName), titleize(node.Name)))
-				g.walk(node.Body)
-				g.out.WriteString(`}`)
-			}
-		}(g, node, g.stack[0])
-		if !g.root {
-			g.out.WriteString(fmt.Sprintf(`%s// line %d, offset %d in %s
-%sblock%s%s(env, output, ctx)
-`, g.indent(), node.Line, node.Offset, g.name, g.indent(), titleize(g.stack[0]), titleize(node.Name)))
-		}
+		name := g.addBlock(node.Name, node)
+		g.out.WriteString(g.comment(node.Pos))
+		g.out.WriteString(fmt.Sprintf(`%s%s
+`, g.indent(), g.checked(fmt.Sprintf("%s(%senv, output, %s)", name, g.callArgs(), g.contextVars()), "err")))
+	case *parse.EmbedNode:
+		return g.walkEmbed(node)
+	case *parse.UseNode:
+		return g.walkUse(node)
 	case *parse.ForNode:
-		name, err := g.walkExpr(node.X)
+		return g.walkFor(node)
+	case *parse.IfNode:
+		return g.walkIf(node)
+	case *parse.FilterNode:
+		if d, ok := g.directive(node); ok {
+			return g.walkDirective(d, node)
+		}
+		return g.walkApply(strings.Join(node.Filters, "|"), node)
+	case *parse.ImportNode:
+		return g.walkImport(node)
+	case *parse.FromNode:
+		return g.walkFrom(node)
+	case *parse.DoNode:
+		v, err := g.walkExpr(node.X)
 		if err != nil {
 			return err
 		}
-		key := "_"
-		if node.Key != "" {
-			key = node.Key
-			g.args[key] = true
+		if !v.isFunction {
+			// Expressions without function calls have no side effects.
+			return nil
 		}
-		val := node.Val
-		g.args[val] = true
-		g.out.WriteString(fmt.Sprintf(`%s// line %d, offset %d in %s
-%sstick.Iterate(%s, func(%s, %s stick.Value, loop stick.Loop) (brk bool, err error) {
-`, g.indent(), node.Line, node.Offset, g.name, g.indent(), name.resultantName, key, val))
-		g.tabs++
-		g.walk(node.Body)
-		delete(g.args, val)
-		delete(g.args, key)
-		g.out.WriteString(fmt.Sprintf(`%sreturn false, nil
-`, g.indent()))
-		g.tabs--
-		g.out.WriteString(fmt.Sprintf(`%s})
-`, g.indent()))
-	case *parse.IfNode:
-		cond, err := g.walkExpr(node.Cond)
+		g.out.WriteString(g.comment(node.Pos))
+		g.out.WriteString(fmt.Sprintf(`%s{
+%s	%s
+%s	_ = %s
+%s}
+`, g.indent(), g.indent(), g.handleErr(v), g.indent(), v.resultantName, g.indent()))
+	case *parse.SetNode:
+		v, err := g.walkExpr(node.X)
 		if err != nil {
 			return err
 		}
-		g.out.WriteString(fmt.Sprintf(`%s// line %d, offset %d in %s
-`, g.indent(), node.Line, node.Offset, g.name))
-		var errCheck string = ""
-		if cond.isFunction {
+		g.out.WriteString(g.comment(node.Pos))
+		name := g.declare(node.Name)
+		if v.isFunction {
 			g.out.WriteString(fmt.Sprintf(`%s{
 %s	%s
+`, g.indent(), g.indent(), v.body))
+			if v.hasError && !g.IgnoreErrors {
+				g.out.WriteString(fmt.Sprintf(`%s	%s
+%s	%s = %s
+`, g.indent(), g.checkErr(v), g.indent(), name, v.resultantName))
+			} else if v.hasError {
+				g.out.WriteString(fmt.Sprintf(`%s	if err == nil {
+%s		%s = %s
+%s	}
+`, g.indent(), g.indent(), name, v.resultantName, g.indent()))
+			} else {
+				g.out.WriteString(fmt.Sprintf(`%s	%s = %s
+`, g.indent(), name, v.resultantName))
+			}
+			g.out.WriteString(fmt.Sprintf(`%s}
+`, g.indent()))
+		} else {
+			g.out.WriteString(fmt.Sprintf(`%s%s = %s
+`, g.indent(), name, v.resultantName))
+		}
+	}
+	return nil
+}
+
+func (g *Generator) walkIf(node *parse.IfNode) error {
+	cond, err := g.walkExpr(node.Cond)
+	if err != nil {
+		return err
+	}
+	return g.walkIfCond(node, cond)
+}
+
+// walkIfCond generates an if tag whose condition has already been walked.
+func (g *Generator) walkIfCond(node *parse.IfNode, cond evaluatedExpr) error {
+	g.out.WriteString(g.comment(node.Pos))
+	var errCheck string = ""
+	if cond.isFunction {
+		g.out.WriteString(fmt.Sprintf(`%s{
+%s	%s
 `, g.indent(), g.indent(), cond.body))
-			g.tabs++
-			defer func() {
-				g.tabs--
-				g.out.WriteString(fmt.Sprintf(`%s}
+		g.tabs++
+		defer func() {
+			g.tabs--
+			g.out.WriteString(fmt.Sprintf(`%s}
 `, g.indent()))
-			}()
-			if cond.hasError {
-				errCheck = "err == nil && "
-			}
+		}()
+		if cond.hasError && g.IgnoreErrors {
+			errCheck = "err == nil && "
+		} else if cond.hasError {
+			g.out.WriteString(fmt.Sprintf(`%s%s
+`, g.indent(), g.checkErr(cond)))
 		}
-		g.out.WriteString(fmt.Sprintf(`%sif %sstick.CoerceBool(%s) {
+	}
+	g.out.WriteString(fmt.Sprintf(`%sif %sstick.CoerceBool(%s) {
 `, g.indent(), errCheck, cond.resultantName))
-		g.tabs++
-		if err := g.walk(node.Body); err != nil {
-			panic(err)
+	g.tabs++
+	if err := g.walkScope(node.Body); err != nil {
+		return err
+	}
+	g.tabs--
+	els := node.Else
+	for els != nil && len(els.All()) > 0 {
+		elseif, ok := elseIf(els)
+		if !ok {
+			g.out.WriteString(fmt.Sprintf(`%s} else {
+`, g.indent()))
+			g.tabs++
+			if err := g.walkScope(els); err != nil {
+				return err
+			}
+			g.tabs--
+			break
 		}
+		// The condition is walked once, at the depth of the nested if it
+		// becomes if it cannot be chained.
+		g.tabs++
+		cond, err := g.walkExpr(elseif.Cond)
 		g.tabs--
-		if len(node.Else.All()) > 0 {
+		if err != nil {
+			return err
+		}
+		if !g.chainable(cond) {
 			g.out.WriteString(fmt.Sprintf(`%s} else {
 `, g.indent()))
 			g.tabs++
-			if err := g.walk(node.Else); err != nil {
-				panic(err)
+			args := make(map[string]string, len(g.args))
+			for k, v := range g.args {
+				args[k] = v
+			}
+			err := g.walkIfCond(elseif, cond)
+			g.args = args
+			if err != nil {
+				return err
 			}
 			g.tabs--
+			break
 		}
-		g.out.WriteString(fmt.Sprintf(`%s}
-`, g.indent()))
+		init := ""
+		errCheck := ""
+		if cond.isFunction {
+			init = cond.body + "; "
+			if cond.hasError {
+				errCheck = "err == nil && "
+			}
+		}
+		g.out.WriteString(fmt.Sprintf(`%s} else if %s%sstick.CoerceBool(%s) {
+`, g.indent(), init, errCheck, cond.resultantName))
+		g.tabs++
+		g.out.WriteString(g.comment(elseif.Pos))
+		if err := g.walkScope(elseif.Body); err != nil {
+			return err
+		}
+		g.tabs--
+		els = elseif.Else
 	}
+	g.out.WriteString(fmt.Sprintf(`%s}
+`, g.indent()))
 	return nil
 }
 
+// elseIf returns the if tag making up the given else body, if any, which is
+// the case for elseif branches.
+func elseIf(els *parse.BodyNode) (*parse.IfNode, bool) {
+	nodes := els.All()
+	if len(nodes) != 1 {
+		return nil, false
+	}
+	node, ok := nodes[0].(*parse.IfNode)
+	return node, ok
+}
+
+// chainable reports whether an elseif branch with the given condition can be
+// generated as an else if. Conditions that require more than one statement
+// to evaluate, or whose errors must be returned, are left nested in an else
+// body.
+func (g *Generator) chainable(cond evaluatedExpr) bool {
+	return !strings.Contains(cond.body, "\n") && !(cond.hasError && !g.IgnoreErrors)
+}
+
+// local returns the Go identifier for the local variable with the given
+// name. Identifiers are prefixed so that they never collide with Go keywords
+// or the parameters and temporary variables of generated functions.
+func local(name string) string {
+	return "v_" + name
+}
+
+// declare declares a local variable with the given name, unless it is
+// already in scope, and returns its Go identifier.
+func (g *Generator) declare(name string) string {
+	if ident, ok := g.args[name]; ok {
+		return ident
+	}
+	ident := local(name)
+	g.out.WriteString(fmt.Sprintf(`%svar %s stick.Value
+%s_ = %s
+`, g.indent(), ident, g.indent(), ident))
+	g.args[name] = ident
+	return ident
+}
+
+// walkScope walks the given node in a nested scope. Local variables
+// declared within the scope are forgotten once it has been walked.
+func (g *Generator) walkScope(n parse.Node) error {
+	args := make(map[string]string, len(g.args))
+	for k, v := range g.args {
+		args[k] = v
+	}
+	defer func() {
+		g.args = args
+	}()
+	return g.walk(n)
+}
+
 func (g *Generator) evaluate(e parse.Expr) (string, bool) {
+	v, ok := g.constant(e)
+	if !ok {
+		return "", false
+	}
+	name, ok := v.(string)
+	return name, ok
+}
+
+// constant attempts to evaluate the given expression at generation time.
+func (g *Generator) constant(e parse.Expr) (stick.Value, bool) {
 	switch expr := e.(type) {
 	case *parse.StringExpr:
 		return expr.Text, true
-	default:
-		panic(e)
+	case *parse.NumberExpr:
+		if v, err := strconv.ParseFloat(expr.Value, 64); err == nil {
+			return v, true
+		}
+	case *parse.BoolExpr:
+		return expr.Value, true
+	case *parse.GroupExpr:
+		return g.constant(expr.X)
+	case *parse.UnaryExpr:
+		v, ok := g.constant(expr.X)
+		if f, isNum := v.(float64); ok && isNum {
+			switch expr.Op {
+			case parse.OpUnaryNegative:
+				return -f, true
+			case parse.OpUnaryPositive:
+				return f, true
+			}
+		}
+	case *parse.FuncExpr:
+		if g.isBuiltinFunc(expr, "constant") {
+			args, err := resolveArgs(expr.Args, signatures["Functions"]["constant"])
+			if err != nil || len(args) != 1 {
+				return nil, false
+			}
+			if name, ok := args[0].(*parse.StringExpr); ok {
+				v, ok := g.Constants[name.Text]
+				return v, ok
+			}
+		}
+	}
+	return nil, false
+}
+
+// constants evaluates each of the given expressions at generation time,
+// reporting false if any of them is not constant.
+func (g *Generator) constants(exprs []parse.Expr) ([]stick.Value, bool) {
+	vals := make([]stick.Value, len(exprs))
+	for i, e := range exprs {
+		v, ok := g.constant(e)
+		if !ok {
+			return nil, false
+		}
+		vals[i] = v
+	}
+	return vals, true
+}
+
+// goLiteral returns the Go source representation of a constant value.
+func goLiteral(v stick.Value) (string, bool) {
+	switch val := v.(type) {
+	case string:
+		return strconv.Quote(val), true
+	case float64:
+		return strconv.FormatFloat(val, 'g', -1, 64), true
+	case int:
+		return strconv.Itoa(val), true
+	case bool:
+		return strconv.FormatBool(val), true
 	}
 	return "", false
 }
@@ -313,17 +1380,31 @@ var emptyExpr = evaluatedExpr{body: "", resultantName: "", isFunction: false, ha
 func (g *Generator) walkExpr(e parse.Expr) (evaluatedExpr, error) {
 	switch expr := e.(type) {
 	case *parse.NameExpr:
-		if _, ok := g.args[expr.Name]; ok {
-			return newLiteral(expr.Name), nil
+		if ident, ok := g.args[expr.Name]; ok {
+			return newLiteral(ident), nil
+		}
+		if expr.Name == "loop" && g.loop != "" {
+			return newLiteral(g.loop), nil
 		}
 		return newLiteral("ctx[\"" + expr.Name + "\"]"), nil
 	case *parse.StringExpr:
-		return newLiteral(`"` + expr.Text + `"`), nil
+		lit := newLiteral(`"` + expr.Text + `"`)
+		lit.isString = true
+		return lit, nil
 	case *parse.NumberExpr:
 		return newLiteral(expr.Value), nil
+	case *parse.BoolExpr:
+		return newLiteral(strconv.FormatBool(expr.Value)), nil
+	case *parse.NullExpr:
+		return newLiteral("nil"), nil
 	case *parse.GetAttrExpr:
-		if len(expr.Args) > 0 {
-			return emptyExpr, errors.New("Method calls are currently unsupported.")
+		if cont, ok := expr.Cont.(*parse.NameExpr); ok {
+			if cont.Name == "_self" {
+				return g.walkMacroAttr(g.name, expr)
+			}
+			if b, ok := g.namespaces[g.name][cont.Name]; ok && b.macro == "" {
+				return g.walkMacroAttr(b.tplName, expr)
+			}
 		}
 		attr, err := g.walkExpr(expr.Attr)
 		if err != nil {
@@ -333,13 +1414,103 @@ func (g *Generator) walkExpr(e parse.Expr) (evaluatedExpr, error) {
 		if err != nil {
 			return emptyExpr, err
 		}
-		return evaluatedExpr{body: `val, err := stick.GetAttr(` + name.resultantName + `, ` + attr.resultantName + `)`, resultantName: "val", isFunction: true, hasError: true}, nil
+		// Method arguments are passed along to stick.GetAttr, which invokes
+		// the method using reflection.
+		argBody, args, err := g.walkArgs(expr.Args, []string{})
+		if err != nil {
+			return emptyExpr, err
+		}
+		getAttr := attr.resultantName
+		if len(args) > 0 {
+			getAttr += ", " + strings.Join(args, ", ")
+		}
+		val := g.temp("val")
+		body := val + `, err := stick.GetAttr(` + name.resultantName + `, ` + getAttr + `)`
+		if name.isFunction {
+			if name.hasError {
+				// Chained attributes are only looked up if the container was.
+				body = name.body + `
+var ` + val + ` stick.Value
+if err == nil {
+	` + val + `, err = stick.GetAttr(` + name.resultantName + `, ` + getAttr + `)
+}`
+			} else {
+				body = name.body + "\n" + body
+			}
+		}
+		if argBody != "" {
+			body = strings.TrimSuffix(argBody, "\n") + "\n" + body
+		}
+		return evaluatedExpr{body: body, resultantName: val, isFunction: true, hasError: true}, nil
 	case *parse.TestExpr:
-		return g.walkFuncExpr(expr.FuncExpr, "Tests")
+		return g.walkTest(expr)
+	case *parse.UnaryExpr:
+		x, err := g.walkExpr(expr.X)
+		if err != nil {
+			return emptyExpr, err
+		}
+		switch expr.Op {
+		case parse.OpUnaryNot:
+			x.resultantName = fmt.Sprintf("!stick.CoerceBool(%s)", x.resultantName)
+		case parse.OpUnaryNegative:
+			x.resultantName = fmt.Sprintf("-stick.CoerceNumber(%s)", x.resultantName)
+		case parse.OpUnaryPositive:
+			x.resultantName = fmt.Sprintf("stick.CoerceNumber(%s)", x.resultantName)
+		default:
+			return emptyExpr, fmt.Errorf("stickgen: unsupported unary operator: %s", expr.Op)
+		}
+		if !x.isFunction {
+			x.body = x.resultantName
+		}
+		x.safe = false
+		return x, nil
 	case *parse.FilterExpr:
+		if filter, ok := g.Filters[expr.Name]; ok {
+			return g.walkFilter(filter, expr.FuncExpr)
+		}
 		return g.walkFuncExpr(expr.FuncExpr, "Filters")
 	case *parse.FuncExpr:
+		if name, _ := namedArg(expr); name != "" {
+			return emptyExpr, fmt.Errorf("stickgen: unexpected named argument %s", name)
+		}
+		if b, ok := g.namespaces[g.name][expr.Name]; ok && b.macro != "" {
+			return g.walkMacroCall(b.tplName, b.macro, expr.Args)
+		}
+		switch expr.Name {
+		case "block":
+			return g.walkBlockFunc(expr)
+		case "parent":
+			return g.walkParentFunc()
+		case "range":
+			return g.walkBuiltinFunc(expr, "Range", 2)
+		case "min":
+			return g.walkBuiltinFunc(expr, "Min", 1)
+		case "max":
+			return g.walkBuiltinFunc(expr, "Max", 1)
+		case "random":
+			return g.walkBuiltinFunc(expr, "Random", 0)
+		case "date":
+			return g.walkBuiltinFunc(expr, "Date", 0)
+		case "dump":
+			return g.walkDumpFunc(expr)
+		case "attribute":
+			return g.walkAttributeFunc(expr)
+		case "constant":
+			return g.walkConstantFunc(expr)
+		case "source":
+			return g.walkSourceFunc(expr)
+		case "include":
+			return g.walkIncludeFunc(expr)
+		case "cycle":
+			return g.walkCycleFunc(expr)
+		case "template_from_string":
+			return emptyExpr, errors.New("stickgen: template_from_string may only be used with include")
+		}
 		return g.walkFuncExpr(expr, "Functions")
+	case *parse.HashExpr:
+		return g.walkHashExpr(expr)
+	case *parse.ArrayExpr:
+		return g.walkArrayExpr(expr)
 	case *parse.GroupExpr:
 		exp, err := g.walkExpr(expr.X)
 		if err != nil {
@@ -357,21 +1528,24 @@ func (g *Generator) walkExpr(e parse.Expr) (evaluatedExpr, error) {
 			return emptyExpr, err
 		}
 		if left.isFunction {
-			// TODO: Handle error
-			pre = pre + strings.Replace(left.body, "err", "_ ", 1)
+			pre = pre + g.handleErr(left)
 		}
 		if right.isFunction {
 			if pre != "" {
 				pre = pre + "\n" + g.indent()
 			}
-			// TODO: Handle error
-			pre = pre + strings.Replace(strings.Replace(left.body, "err", "_ ", 1), right.resultantName, "right", 1)
-			right.resultantName = "right"
+			pre = pre + g.handleErr(right)
 		}
 		res := evaluatedExpr{
-			body:          pre,
-			isFunction:    left.isFunction || right.isFunction,
-			hasError:      false,
+			body:       pre,
+			isFunction: left.isFunction || right.isFunction,
+			hasError:   false,
+		}
+		if g.isBuiltinFunc(expr.Left, "date") || g.isBuiltinFunc(expr.Right, "date") {
+			if cmp, ok := g.dateComparison(expr, left.resultantName, right.resultantName); ok {
+				res.resultantName = cmp
+				return res, nil
+			}
 		}
 		switch expr.Op {
 		case parse.OpBinaryEqual:
@@ -386,6 +1560,26 @@ func (g *Generator) walkExpr(e parse.Expr) (evaluatedExpr, error) {
 			res.resultantName = fmt.Sprintf(`stick.CoerceNumber(%s) >= stick.CoerceNumber(%s)`, left.resultantName, right.resultantName)
 		case parse.OpBinaryLessEqual:
 			res.resultantName = fmt.Sprintf(`stick.CoerceNumber(%s) <= stick.CoerceNumber(%s)`, left.resultantName, right.resultantName)
+		case parse.OpBinaryAdd:
+			res.resultantName = fmt.Sprintf(`stick.CoerceNumber(%s) + stick.CoerceNumber(%s)`, left.resultantName, right.resultantName)
+		case parse.OpBinarySubtract:
+			res.resultantName = fmt.Sprintf(`stick.CoerceNumber(%s) - stick.CoerceNumber(%s)`, left.resultantName, right.resultantName)
+		case parse.OpBinaryMultiply:
+			res.resultantName = fmt.Sprintf(`stick.CoerceNumber(%s) * stick.CoerceNumber(%s)`, left.resultantName, right.resultantName)
+		case parse.OpBinaryDivide:
+			res.resultantName = fmt.Sprintf(`stick.CoerceNumber(%s) / stick.CoerceNumber(%s)`, left.resultantName, right.resultantName)
+		case parse.OpBinaryModulo:
+			g.addImport("math")
+			res.resultantName = fmt.Sprintf(`math.Mod(stick.CoerceNumber(%s), stick.CoerceNumber(%s))`, left.resultantName, right.resultantName)
+		case parse.OpBinaryRange:
+			g.addImport(runtimeImport)
+			res.resultantName = fmt.Sprintf(`stickgen.Range(%s, %s, nil)`, left.resultantName, right.resultantName)
+		case parse.OpBinaryAnd:
+			res.resultantName = fmt.Sprintf(`stick.CoerceBool(%s) && stick.CoerceBool(%s)`, left.resultantName, right.resultantName)
+		case parse.OpBinaryOr:
+			res.resultantName = fmt.Sprintf(`stick.CoerceBool(%s) || stick.CoerceBool(%s)`, left.resultantName, right.resultantName)
+		case parse.OpBinaryConcat:
+			res.resultantName = fmt.Sprintf(`stick.CoerceString(%s) + stick.CoerceString(%s)`, left.resultantName, right.resultantName)
 		default:
 			return emptyExpr, fmt.Errorf("stickgen: unsupported binary operator: %s", expr.Op)
 		}
@@ -394,35 +1588,96 @@ func (g *Generator) walkExpr(e parse.Expr) (evaluatedExpr, error) {
 	return emptyExpr, fmt.Errorf("stickgen: unsupported expr: %T", e)
 }
 
-func (g *Generator) walkFuncExpr(expr *parse.FuncExpr, mapName string) (evaluatedExpr, error) {
-	argN := len(expr.Args)
-	var args []evaluatedExpr
-	// slice of interface{} so that it can be passed into fmt.Sprintf()
-	var argNames []interface{}
-	var argBody []byte
-	for _, arg := range expr.Args {
-		val, err := g.walkExpr(arg)
+func (g *Generator) walkHashExpr(expr *parse.HashExpr) (evaluatedExpr, error) {
+	var body []string
+	var elems []string
+	for _, kv := range expr.Elements {
+		var key string
+		switch k := kv.Key.(type) {
+		case *parse.NameExpr:
+			key = strconv.Quote(k.Name)
+		case *parse.StringExpr:
+			key = strconv.Quote(k.Text)
+		case *parse.NumberExpr:
+			key = strconv.Quote(k.Value)
+		default:
+			v, err := g.walkExpr(kv.Key)
+			if err != nil {
+				return emptyExpr, err
+			}
+			if v.isFunction {
+				body = append(body, g.handleErr(v))
+			}
+			key = fmt.Sprintf("stick.CoerceString(%s)", v.resultantName)
+		}
+		v, err := g.walkExpr(kv.Value)
 		if err != nil {
 			return emptyExpr, err
 		}
-		args = append(args, val)
-		argNames = append(argNames, val.resultantName)
-		if val.isFunction {
-			// TODO: Handle error
-			argBody = append(argBody, strings.Replace(val.body, "err", "_", 1)+"\n"...)
+		if v.isFunction {
+			body = append(body, g.handleErr(v))
 		}
+		elems = append(elems, key+": "+v.resultantName)
+	}
+	return evaluatedExpr{
+		body:          strings.Join(body, "\n"+g.indent()),
+		resultantName: "map[string]stick.Value{" + strings.Join(elems, ", ") + "}",
+		isFunction:    len(body) > 0,
+		hasError:      false,
+	}, nil
+}
+
+func (g *Generator) walkArrayExpr(expr *parse.ArrayExpr) (evaluatedExpr, error) {
+	body, elems, err := g.walkArgs(expr.Elements, []string{})
+	if err != nil {
+		return emptyExpr, err
+	}
+	return evaluatedExpr{
+		body:          strings.TrimSuffix(body, "\n"),
+		resultantName: "[]stick.Value{" + strings.Join(elems, ", ") + "}",
+		isFunction:    body != "",
+		hasError:      false,
+	}, nil
+}
+
+func (g *Generator) walkFuncExpr(expr *parse.FuncExpr, mapName string) (evaluatedExpr, error) {
+	params, ok := signatures[mapName][expr.Name]
+	if ok && mapName != "Functions" {
+		// The filtered or tested value is always passed first.
+		params = append([]string{""}, params...)
+	}
+	argBody, names, err := g.walkArgs(expr.Args, params)
+	if err != nil {
+		return emptyExpr, err
 	}
-	p := strings.TrimSuffix(strings.Repeat("%s, ", argN), ",")
-	pf := fmt.Sprintf(p, argNames...)
-	// TODO: nil stick.Context is passed into the function!
+	g.addImport(runtimeImport)
+	if argBody != "" {
+		argBody += g.indent() + "	"
+	}
+	args := ""
+	if len(names) > 0 {
+		args = ", " + strings.Join(names, ", ")
+	}
+	fnctx := g.funcContext()
+	fnval := g.temp("fnval")
 	return evaluatedExpr{
-		body: fmt.Sprintf(`%s
-%s	var fnval stick.Value = ""
-%s	if fn, ok := env.%s["%s"]; ok {
-%s		fnval = fn(nil, %s)
-%s	}`, string(argBody), g.indent(), g.indent(), mapName, expr.Name, g.indent(), pf, g.indent()),
-		resultantName: "fnval",
+		body: fmt.Sprintf(`%svar %s stick.Value = ""
+%s	if fn, ok := env.%s[%s]; ok {
+%s		%s = fn(%s%s)
+%s	}`, argBody, fnval, g.indent(), mapName, strconv.Quote(expr.Name), g.indent(), fnval, fnctx, args, g.indent()),
+		resultantName: fnval,
 		isFunction:    true,
 		hasError:      false,
 	}, nil
-}
\ No newline at end of file
+}
+
+// funcContext returns a Go expression for the stick.Context passed to
+// functions, filters and tests called from the current template.
+func (g *Generator) funcContext() string {
+	g.addImport(runtimeImport)
+	fnctx := fmt.Sprintf("stickgen.NewFuncContext(env, %s, %s)", strconv.Quote(g.name), g.contextVars())
+	if g.Context {
+		fnctx = fmt.Sprintf("stickgen.WithContext(%s, goctx)", fnctx)
+	}
+	return fnctx
+}
@@ -0,0 +1,1609 @@
+package stickgen_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/format"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/tyler-sommer/stick"
+	"github.com/veonik/go-stickgen"
+)
+
+// A renderTest describes a template that is generated, compiled and
+// rendered.
+type renderTest struct {
+	name string
+	// templates contains the templates available to the Generator, keyed by
+	// name. Unless tpl is given, test.twig is generated. If all is given,
+	// each of the templates it lists is generated into one file.
+	templates map[string]string
+	tpl       string
+	all       []string
+	// options configures the Generator, if set.
+	options func(g *stickgen.Generator)
+	// ctx is a Go expression for the context the template is rendered
+	// with. By default, the context is empty.
+	ctx string
+	// setup contains Go statements run before the template is rendered,
+	// with env and ctx in scope, decls contains any declarations they
+	// need, and imports contains the packages they use.
+	setup   string
+	decls   string
+	imports []string
+	// call is a Go statement rendering the template to output and
+	// assigning err. By default, the generated function is called as
+	// TemplateTestTwig(env, output, ctx).
+	call string
+	want string
+	// err is the error expected when generating the template, and runErr
+	// the error expected when rendering it.
+	err    string
+	runErr string
+}
+
+// testRender generates each template, compiles the generated code into a
+// program and checks the output of running it. Programs are built at once,
+// so that the tests do not each pay the cost of invoking the go command.
+func testRender(t *testing.T, tests []renderTest) {
+	t.Helper()
+	var built []renderTest
+	var srcs []string
+	for _, tt := range tests {
+		src, err := generateTest(tt)
+		if tt.err != "" || err != nil {
+			checkErr(t, tt.name, "generating", err, tt.err)
+			continue
+		}
+		built = append(built, tt)
+		srcs = append(srcs, src)
+	}
+	if len(built) == 0 {
+		return
+	}
+	if testing.Short() {
+		t.Skip("skipping compiling generated code in short mode")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("skipping compiling generated code without the go command")
+	}
+	// Generated code imports this package, so the programs are built in a
+	// directory within it. The leading underscore hides it from "./...".
+	dir, err := ioutil.TempDir(".", "_stickgen_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if dir, err = filepath.Abs(dir); err != nil {
+		t.Fatal(err)
+	}
+	var pkgs []string
+	for i, tt := range built {
+		pkg := fmt.Sprintf("case%d", i)
+		if err := os.Mkdir(filepath.Join(dir, pkg), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, pkg, "views.go"), []byte(srcs[i]), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, pkg, "main.go"), []byte(mainProgram(tt)), 0644); err != nil {
+			t.Fatal(err)
+		}
+		pkgs = append(pkgs, "./"+pkg)
+	}
+	bin := filepath.Join(dir, "bin") + string(filepath.Separator)
+	failed := make(map[string]string)
+	if out, err := goBuild(dir, bin, pkgs...); err != nil {
+		if len(pkgs) == 1 {
+			failed[pkgs[0]] = out
+		} else {
+			// Build each program alone to find out which are broken.
+			for _, pkg := range pkgs {
+				if out, err := goBuild(dir, bin, pkg); err != nil {
+					failed[pkg] = out
+				}
+			}
+		}
+	}
+	for i, tt := range built {
+		if out, ok := failed[pkgs[i]]; ok {
+			t.Errorf("%s: unable to compile generated code:\n%s\n%s", tt.name, out, srcs[i])
+			continue
+		}
+		cmd := exec.Command(filepath.Join(bin, fmt.Sprintf("case%d", i)))
+		var stderr strings.Builder
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			msg := strings.TrimSpace(stderr.String())
+			if msg == "" {
+				msg = err.Error()
+			}
+			if tt.runErr == "" {
+				t.Errorf("%s: unexpected error rendering: %s\n%s", tt.name, msg, srcs[i])
+			} else if !strings.Contains(msg, tt.runErr) {
+				t.Errorf("%s: expected error rendering containing %q, got %q", tt.name, tt.runErr, msg)
+			}
+			continue
+		}
+		if tt.runErr != "" {
+			t.Errorf("%s: expected error rendering containing %q, got none", tt.name, tt.runErr)
+		} else if string(out) != tt.want {
+			t.Errorf("%s: expected output %q, got %q\n%s", tt.name, tt.want, out, srcs[i])
+		}
+	}
+}
+
+// generateTest generates the code for a test.
+func generateTest(tt renderTest) (string, error) {
+	g := stickgen.NewGenerator("main", &stick.MemoryLoader{Templates: tt.templates})
+	if tt.options != nil {
+		tt.options(g)
+	}
+	if len(tt.all) > 0 {
+		return g.GenerateAll(tt.all...)
+	}
+	name := tt.tpl
+	if name == "" {
+		name = "test.twig"
+	}
+	return g.Generate(name)
+}
+
+// checkErr reports whether err is the expected error, containing want, or
+// no error if want is empty.
+func checkErr(t *testing.T, name, doing string, err error, want string) {
+	t.Helper()
+	switch {
+	case want == "" && err != nil:
+		t.Errorf("%s: unexpected error %s: %s", name, doing, err)
+	case want != "" && err == nil:
+		t.Errorf("%s: expected error %s containing %q, got none", name, doing, want)
+	case want != "" && !strings.Contains(err.Error(), want):
+		t.Errorf("%s: expected error %s containing %q, got %q", name, doing, want, err)
+	}
+}
+
+// mainProgram returns the source of a program rendering a test's template
+// to standard output.
+func mainProgram(tt renderTest) string {
+	ctx := tt.ctx
+	if ctx == "" {
+		ctx = "map[string]stick.Value{}"
+	}
+	call := tt.call
+	if call == "" {
+		call = "err := TemplateTestTwig(env, output, ctx)"
+	}
+	imports := ""
+	for _, pkg := range tt.imports {
+		imports += fmt.Sprintf("\t%q\n", pkg)
+	}
+	return fmt.Sprintf(`package main
+
+import (
+	"fmt"
+	"os"
+%s
+	"github.com/tyler-sommer/stick"
+)
+
+func main() {
+	env := stick.New(nil)
+	ctx := %s
+	output := os.Stdout
+	%s
+	%s
+	if err != nil {
+		fmt.Fprint(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+%s
+`, imports, ctx, tt.setup, call, tt.decls)
+}
+
+// A sourceTest describes a template whose generated code is checked for
+// the presence or absence of snippets.
+type sourceTest struct {
+	name      string
+	templates map[string]string
+	options   func(g *stickgen.Generator)
+	contains  []string
+	absent    []string
+}
+
+// testSource generates test.twig for each test and checks the code.
+func testSource(t *testing.T, tests []sourceTest) {
+	t.Helper()
+	for _, tt := range tests {
+		src, err := generateTest(renderTest{templates: tt.templates, options: tt.options})
+		if err != nil {
+			t.Errorf("%s: unexpected error generating: %s", tt.name, err)
+			continue
+		}
+		for _, s := range tt.contains {
+			if !strings.Contains(src, s) {
+				t.Errorf("%s: expected code containing %q, got:\n%s", tt.name, s, src)
+			}
+		}
+		for _, s := range tt.absent {
+			if strings.Contains(src, s) {
+				t.Errorf("%s: expected code not containing %q, got:\n%s", tt.name, s, src)
+			}
+		}
+	}
+}
+
+// goBuild builds the given packages in dir, writing the programs to bin.
+func goBuild(dir, bin string, pkgs ...string) (string, error) {
+	cmd := exec.Command("go", append([]string{"build", "-o", bin}, pkgs...)...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	return string(out), err
+}
+
+func TestSet(t *testing.T) {
+	testRender(t, []renderTest{
+		{
+			name:      "literal",
+			templates: map[string]string{"test.twig": `{% set x = 'a' %}{{ x }}`},
+			want:      "a",
+		},
+		{
+			name:      "expression",
+			templates: map[string]string{"test.twig": `{% set greeting = 'Hello, ' ~ name %}{{ greeting }}!`},
+			ctx:       `map[string]stick.Value{"name": "Bob"}`,
+			want:      "Hello, Bob!",
+		},
+		{
+			name:      "reassigned",
+			templates: map[string]string{"test.twig": `{% set x = 1 %}{% set x = x + 1 %}{{ x }}`},
+			want:      "2",
+		},
+		{
+			name:      "shadows context",
+			templates: map[string]string{"test.twig": `{{ x }}{% set x = 'local' %}{{ x }}`},
+			ctx:       `map[string]stick.Value{"x": "ctx"}`,
+			want:      "ctxlocal",
+		},
+		{
+			name:      "attribute",
+			templates: map[string]string{"test.twig": `{% set x = user.name %}{{ x }}`},
+			ctx:       `map[string]stick.Value{"user": map[string]stick.Value{"name": "Ann"}}`,
+			want:      "Ann",
+		},
+		{
+			name:      "function",
+			templates: map[string]string{"test.twig": `{% set x = upper('a') %}{{ x }}`},
+			setup: `env.Functions["upper"] = func(ctx stick.Context, args ...stick.Value) stick.Value {
+		return strings.ToUpper(stick.CoerceString(args[0]))
+	}`,
+			imports: []string{"strings"},
+			want:    "A",
+		},
+		{
+			name:      "keywords",
+			templates: map[string]string{"test.twig": `{% set type = 1 %}{% set func = upper('f') %}{{ type }}{{ func }}`},
+			setup: `env.Functions["upper"] = func(ctx stick.Context, args ...stick.Value) stick.Value {
+		return strings.ToUpper(stick.CoerceString(args[0]))
+	}`,
+			imports: []string{"strings"},
+			want:    "1F",
+		},
+		{
+			name:      "named like generated variables",
+			templates: map[string]string{"test.twig": `{% set output = 'o' %}{% set env = 'e' %}{% set ctx = 'c' %}{{ output }}{{ env }}{{ ctx }}{{ name }}`},
+			ctx:       `map[string]stick.Value{"name": "n"}`,
+			want:      "oecn",
+		},
+		{
+			name:      "invalid",
+			templates: map[string]string{"test.twig": `{% set x = %}`},
+			err:       "unexpected token",
+		},
+	})
+}
+
+func TestDo(t *testing.T) {
+	log := `var logged []string
+	env.Functions["log"] = func(ctx stick.Context, args ...stick.Value) stick.Value {
+		logged = append(logged, stick.CoerceString(args[0]))
+		return "unused"
+	}`
+	call := `err := TemplateTestTwig(env, output, ctx)
+	fmt.Fprint(output, logged)`
+	testRender(t, []renderTest{
+		{
+			name:      "function",
+			templates: map[string]string{"test.twig": `a{% do log(user.id) %}b`},
+			ctx:       `map[string]stick.Value{"user": map[string]stick.Value{"id": 1}}`,
+			setup:     log,
+			call:      call,
+			want:      "ab[1]",
+		},
+		{
+			name:      "loop",
+			templates: map[string]string{"test.twig": `{% for i in [1, 2] %}{% do log(i) %}{% endfor %}`},
+			setup:     log,
+			call:      call,
+			want:      "[1 2]",
+		},
+		{
+			name:      "without side effects",
+			templates: map[string]string{"test.twig": `{% do 1 + 2 %}{% do 'a' ~ name %}`},
+			ctx:       `map[string]stick.Value{"name": "b"}`,
+			setup:     log,
+			call:      call,
+			want:      "[]",
+		},
+		{
+			name:      "error",
+			templates: map[string]string{"test.twig": `{% do log(user.id) %}`},
+			ctx:       `map[string]stick.Value{"user": map[string]stick.Value{}}`,
+			setup:     log,
+			call:      call,
+			runErr:    "id",
+		},
+		{
+			name:      "invalid",
+			templates: map[string]string{"test.twig": `{% do %}`},
+			err:       "unexpected token",
+		},
+	})
+}
+
+func TestIf(t *testing.T) {
+	chain := map[string]string{"test.twig": `{% if a %}A{% elseif b %}B{% elseif c %}C{% else %}D{% endif %}`}
+	funcs := `env.Functions["eq"] = func(ctx stick.Context, args ...stick.Value) stick.Value {
+		return args[0] == args[1]
+	}`
+	testRender(t, []renderTest{
+		{name: "if", templates: chain, ctx: `map[string]stick.Value{"a": true, "b": true}`, want: "A"},
+		{name: "first elseif", templates: chain, ctx: `map[string]stick.Value{"b": true, "c": true}`, want: "B"},
+		{name: "second elseif", templates: chain, ctx: `map[string]stick.Value{"c": true}`, want: "C"},
+		{name: "else", templates: chain, want: "D"},
+		{
+			name:      "function conditions",
+			templates: map[string]string{"test.twig": `{% for x in [1, 2, 3, 4] %}{% if eq(x, 1) %}a{% elseif eq(x, 2) %}b{% elseif eq(x, 3) %}c{% else %}d{% endif %}{% endfor %}`},
+			setup:     funcs,
+			want:      "abcd",
+		},
+		{
+			name:      "attribute conditions",
+			templates: map[string]string{"test.twig": `{% if user.guest %}guest{% elseif user.admin %}admin{% else %}user{% endif %}`},
+			ctx:       `map[string]stick.Value{"user": map[string]stick.Value{"guest": false, "admin": true}}`,
+			want:      "admin",
+		},
+		{
+			name:      "set in elseif",
+			templates: map[string]string{"test.twig": `{% if a %}{% elseif eq(1, 1) %}{% set x = 'b' %}{{ x }}{% endif %}{{ x }}`},
+			ctx:       `map[string]stick.Value{"x": "-"}`,
+			setup:     funcs,
+			want:      "b-",
+		},
+	})
+}
+
+// TestElseIfWalkedOnce checks that the condition of each elseif branch is
+// only walked once, since walking allocates names in the generated code.
+func TestElseIfWalkedOnce(t *testing.T) {
+	g := stickgen.NewGenerator("views", &stick.MemoryLoader{Templates: map[string]string{
+		"test.twig": `{% if f() %}a{% elseif f() %}b{% elseif user.admin %}c{% elseif f() %}d{% endif %}`,
+	}})
+	src, err := g.Generate("test.twig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Temporary names are numbered in the order they are allocated, so
+	// conditions walked more than once leave gaps in the numbering.
+	used := make(map[string]bool)
+	for _, m := range tempPattern.FindAllStringSubmatch(src, -1) {
+		used[m[1]] = true
+	}
+	if len(used) != 4 {
+		t.Errorf("expected 4 temporary names, got %d", len(used))
+	}
+	for i := 0; i < len(used); i++ {
+		if !used[strconv.Itoa(i)] {
+			t.Errorf("expected temporary names to be numbered consecutively, missing %d:\n%s", i, src)
+		}
+	}
+}
+
+// tempPattern matches temporary names in generated code.
+var tempPattern = regexp.MustCompile(`\b[a-z]*val(\d+)\b`)
+
+func TestMethodCall(t *testing.T) {
+	decls := `type user struct {
+	First, Last string
+}
+
+func (u *user) FullName() string {
+	return u.First + " " + u.Last
+}
+
+func (u *user) Greet(greeting stick.Value) string {
+	return stick.CoerceString(greeting) + ", " + u.First
+}
+
+func (u *user) Self() *user {
+	return u
+}`
+	ctx := `map[string]stick.Value{"user": &user{"Ann", "Lee"}, "hi": "Hello"}`
+	testRender(t, []renderTest{
+		{
+			name:      "no arguments",
+			templates: map[string]string{"test.twig": `{{ user.FullName() }}`},
+			ctx:       ctx,
+			decls:     decls,
+			want:      "Ann Lee",
+		},
+		{
+			name:      "argument",
+			templates: map[string]string{"test.twig": `{{ user.Greet(hi) }}|{{ user.Greet('Hey') }}`},
+			ctx:       ctx,
+			decls:     decls,
+			want:      "Hello, Ann|Hey, Ann",
+		},
+		{
+			name:      "chained",
+			templates: map[string]string{"test.twig": `{{ user.Self().Self().First }}`},
+			ctx:       ctx,
+			decls:     decls,
+			want:      "Ann",
+		},
+		{
+			name:      "function argument",
+			templates: map[string]string{"test.twig": `{{ user.Greet(upper(hi)) }}`},
+			ctx:       ctx,
+			decls:     decls,
+			setup: `env.Functions["upper"] = func(ctx stick.Context, args ...stick.Value) stick.Value {
+		return strings.ToUpper(stick.CoerceString(args[0]))
+	}`,
+			imports: []string{"strings"},
+			want:    "HELLO, Ann",
+		},
+		{
+			name:      "in condition",
+			templates: map[string]string{"test.twig": `{% if user.FullName() == 'Ann Lee' %}yes{% endif %}`},
+			ctx:       ctx,
+			decls:     decls,
+			want:      "yes",
+		},
+		{
+			name:      "undefined method",
+			templates: map[string]string{"test.twig": `{{ user.Nope() }}`},
+			ctx:       ctx,
+			decls:     decls,
+			runErr:    "Nope",
+		},
+		{
+			name:      "named argument",
+			templates: map[string]string{"test.twig": `{{ user.Greet(greeting='Hi') }}`},
+			err:       "unknown argument greeting",
+		},
+	})
+}
+
+func TestErrors(t *testing.T) {
+	ignoreErrors := func(g *stickgen.Generator) {
+		g.IgnoreErrors = true
+	}
+	// Templates generated with IgnoreErrors do not return an error.
+	ignored := `TemplateTestTwig(env, output, ctx)
+	var err error`
+	write := `err := TemplateTestTwig(env, failingWriter{}, ctx)
+	_ = output`
+	failing := `type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("write failed")
+}`
+	testRender(t, []renderTest{
+		{
+			name:      "attribute",
+			templates: map[string]string{"test.twig": `a{{ user.name }}b`},
+			ctx:       `map[string]stick.Value{"user": map[string]stick.Value{}}`,
+			runErr:    "missing key name",
+		},
+		{
+			name:      "iterate",
+			templates: map[string]string{"test.twig": `{% for i in 5 %}{{ i }}{% endfor %}`},
+			runErr:    "not iterable",
+		},
+		{
+			name:      "write",
+			templates: map[string]string{"test.twig": `a{{ name }}`},
+			decls:     failing,
+			imports:   []string{"errors"},
+			call:      write,
+			runErr:    "write failed",
+		},
+		{
+			name:      "write value",
+			templates: map[string]string{"test.twig": `{{ name }}`},
+			ctx:       `map[string]stick.Value{"name": "x"}`,
+			decls:     failing,
+			imports:   []string{"errors"},
+			call:      write,
+			runErr:    "write failed",
+		},
+		{
+			name:      "filter argument",
+			templates: map[string]string{"test.twig": `{{ name|default(user.name) }}`},
+			ctx:       `map[string]stick.Value{"user": map[string]stick.Value{}}`,
+			runErr:    "missing key name",
+		},
+		{
+			name:      "function argument",
+			templates: map[string]string{"test.twig": `{{ f(user.name) }}`},
+			ctx:       `map[string]stick.Value{"user": map[string]stick.Value{}}`,
+			runErr:    "missing key name",
+		},
+		{
+			name:      "condition",
+			templates: map[string]string{"test.twig": `{% if user.admin %}admin{% endif %}`},
+			ctx:       `map[string]stick.Value{"user": map[string]stick.Value{}}`,
+			runErr:    "missing key admin",
+		},
+		{
+			name: "block",
+			templates: map[string]string{
+				"layout.twig": `[{% block content %}{% endblock %}]`,
+				"test.twig":   `{% extends 'layout.twig' %}{% block content %}{{ user.name }}{% endblock %}`,
+			},
+			ctx:    `map[string]stick.Value{"user": map[string]stick.Value{}}`,
+			runErr: "missing key name",
+		},
+		{
+			name: "include",
+			templates: map[string]string{
+				"part.twig": `{{ user.name }}`,
+				"test.twig": `{% include 'part.twig' %}`,
+			},
+			ctx:    `map[string]stick.Value{"user": map[string]stick.Value{}}`,
+			runErr: "missing key name",
+		},
+		{
+			name:      "stops rendering",
+			templates: map[string]string{"test.twig": `a{{ user.name }}b`},
+			ctx:       `map[string]stick.Value{"user": map[string]stick.Value{}}`,
+			call: `err := TemplateTestTwig(env, output, ctx)
+	fmt.Fprintf(output, "|%v", err)
+	err = nil`,
+			want: "a|missing key name",
+		},
+		{
+			name:      "ignore errors",
+			templates: map[string]string{"test.twig": `a{{ user.name }}b{% for i in 5 %}{{ i }}{% endfor %}c`},
+			ctx:       `map[string]stick.Value{"user": map[string]stick.Value{}}`,
+			options:   ignoreErrors,
+			call:      ignored,
+			want:      "abc",
+		},
+		{
+			name:      "ignore errors write",
+			templates: map[string]string{"test.twig": `a{{ name }}`},
+			options:   ignoreErrors,
+			decls:     failing,
+			imports:   []string{"errors"},
+			call: `TemplateTestTwig(env, failingWriter{}, ctx)
+	_ = output
+	var err error`,
+		},
+	})
+}
+
+func TestContext(t *testing.T) {
+	withContext := func(g *stickgen.Generator) {
+		g.Context = true
+	}
+	call := `err := TemplateTestTwig(context.Background(), env, output, ctx)`
+	canceled := `goctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := TemplateTestTwig(goctx, env, output, ctx)`
+	testRender(t, []renderTest{
+		{
+			name:      "render",
+			templates: map[string]string{"test.twig": `Hello, {{ name }}!`},
+			options:   withContext,
+			ctx:       `map[string]stick.Value{"name": "Bob"}`,
+			imports:   []string{"context"},
+			call:      call,
+			want:      "Hello, Bob!",
+		},
+		{
+			name:      "canceled loop",
+			templates: map[string]string{"test.twig": `{% for i in [1, 2] %}{{ i }}{% endfor %}`},
+			options:   withContext,
+			imports:   []string{"context"},
+			call:      canceled,
+			runErr:    "context canceled",
+		},
+		{
+			name:      "canceled range",
+			templates: map[string]string{"test.twig": `{% for i in 1..3 %}{{ i }}{% endfor %}`},
+			options:   withContext,
+			imports:   []string{"context"},
+			call:      canceled,
+			runErr:    "context canceled",
+		},
+		{
+			name:      "canceled during loop",
+			templates: map[string]string{"test.twig": `{% for i in 1..3 %}{{ i }}{% do stop() %}{% endfor %}`},
+			options:   withContext,
+			imports:   []string{"context"},
+			setup: `goctx, cancel := context.WithCancel(context.Background())
+	env.Functions["stop"] = func(ctx stick.Context, args ...stick.Value) stick.Value {
+		cancel()
+		return nil
+	}`,
+			call: `err := TemplateTestTwig(goctx, env, output, ctx)
+	fmt.Fprintf(output, "|%v", err)
+	err = nil`,
+			want: "1|context canceled",
+		},
+		{
+			name:      "function",
+			templates: map[string]string{"test.twig": `{{ user() }}`},
+			options:   withContext,
+			imports:   []string{"context", "github.com/veonik/go-stickgen"},
+			setup: `env.Functions["user"] = func(ctx stick.Context, args ...stick.Value) stick.Value {
+		return stickgen.ContextOf(ctx).Value(userKey{})
+	}
+	goctx := context.WithValue(context.Background(), userKey{}, "Ann")`,
+			decls: `type userKey struct{}`,
+			call:  `err := TemplateTestTwig(goctx, env, output, ctx)`,
+			want:  "Ann",
+		},
+		{
+			name: "block",
+			templates: map[string]string{
+				"layout.twig": `[{% block content %}{% endblock %}]`,
+				"test.twig":   `{% extends 'layout.twig' %}{% block content %}{% for i in [1] %}{{ i }}{% endfor %}{% endblock %}`,
+			},
+			options: withContext,
+			imports: []string{"context"},
+			call:    canceled,
+			runErr:  "context canceled",
+		},
+		{
+			name: "include",
+			templates: map[string]string{
+				"part.twig": `{% for i in [1] %}{{ i }}{% endfor %}`,
+				"test.twig": `{% include 'part.twig' %}`,
+			},
+			options: withContext,
+			imports: []string{"context"},
+			call:    canceled,
+			runErr:  "context canceled",
+		},
+		{
+			name:      "ignore errors",
+			templates: map[string]string{"test.twig": `a`},
+			options: func(g *stickgen.Generator) {
+				g.Context = true
+				g.IgnoreErrors = true
+			},
+			err: "Context cannot be used with IgnoreErrors",
+		},
+	})
+}
+
+func TestFormat(t *testing.T) {
+	broken := func(g *stickgen.Generator) {
+		g.Filters["broken"] = stickgen.NewSnippetFilter("(%s")
+	}
+	tests := []struct {
+		name      string
+		tpl       string
+		options   func(g *stickgen.Generator)
+		formatted bool
+		contains  string
+		err       string
+	}{
+		{name: "text", tpl: `a`, formatted: true},
+		{name: "loop", tpl: `{% for i in x %}{% if i %}{{ i.y }}{% endif %}{% endfor %}`, formatted: true},
+		{name: "filter", tpl: `{% set x = {'a': 1} %}{{ x.a|default(2) }}`, formatted: true},
+		{
+			name:     "no format",
+			tpl:      `{% set x = {'a': 1} %}{{ x.a|default(2) }}`,
+			options:  func(g *stickgen.Generator) { g.NoFormat = true },
+			contains: ")\n\n\n",
+		},
+		{name: "invalid", tpl: `{{ x|broken }}`, options: broken, err: "unable to format generated code"},
+		{
+			name: "allow unformatted",
+			tpl:  `{{ x|broken }}`,
+			options: func(g *stickgen.Generator) {
+				broken(g)
+				g.AllowUnformatted = true
+			},
+			contains: `(ctx["x"]`,
+		},
+	}
+	for _, tt := range tests {
+		g := stickgen.NewGenerator("views", &stick.MemoryLoader{Templates: map[string]string{"test.twig": tt.tpl}})
+		if tt.options != nil {
+			tt.options(g)
+		}
+		src, err := g.Generate("test.twig")
+		checkErr(t, tt.name, "generating", err, tt.err)
+		if err != nil {
+			continue
+		}
+		if tt.formatted {
+			if b, err := format.Source([]byte(src)); err != nil || string(b) != src {
+				t.Errorf("%s: expected formatted code, got:\n%s", tt.name, src)
+			}
+		}
+		if !strings.Contains(src, tt.contains) {
+			t.Errorf("%s: expected code containing %q, got:\n%s", tt.name, tt.contains, src)
+		}
+	}
+	testRender(t, []renderTest{
+		{
+			name:      "no format renders",
+			templates: map[string]string{"test.twig": `{% for i in [1, 2] %}{% if i > 1 %}{{ i }}{% endif %}{% endfor %}`},
+			options:   func(g *stickgen.Generator) { g.NoFormat = true },
+			want:      "2",
+		},
+	})
+}
+
+func TestComments(t *testing.T) {
+	tpl := "a{% if x %}\n{{ x }}{% endif %}"
+	tests := []struct {
+		name     string
+		level    stickgen.CommentLevel
+		contains []string
+		absent   []string
+	}{
+		{
+			name:     "full",
+			level:    stickgen.CommentsFull,
+			contains: []string{"// line 1, offset 0 in test.twig", "// line 1, offset 4 in test.twig", "// line 2, offset 12 in test.twig"},
+		},
+		{
+			name:     "minimal",
+			level:    stickgen.CommentsMinimal,
+			contains: []string{"// line 1, offset 4 in test.twig"},
+			absent:   []string{"// line 1, offset 0 in test.twig", "// line 2, offset 12 in test.twig"},
+		},
+		{
+			name:   "off",
+			level:  stickgen.CommentsOff,
+			absent: []string{"// line"},
+		},
+	}
+	var renders []renderTest
+	for _, tt := range tests {
+		g := stickgen.NewGenerator("views", &stick.MemoryLoader{Templates: map[string]string{"test.twig": tpl}})
+		g.Comments = tt.level
+		src, err := g.Generate("test.twig")
+		if err != nil {
+			t.Errorf("%s: unexpected error generating: %s", tt.name, err)
+			continue
+		}
+		for _, s := range tt.contains {
+			if !strings.Contains(src, s) {
+				t.Errorf("%s: expected code containing %q, got:\n%s", tt.name, s, src)
+			}
+		}
+		for _, s := range tt.absent {
+			if strings.Contains(src, s) {
+				t.Errorf("%s: expected code not containing %q, got:\n%s", tt.name, s, src)
+			}
+		}
+		level := tt.level
+		renders = append(renders, renderTest{
+			name:      tt.name + " renders",
+			templates: map[string]string{"test.twig": tpl},
+			options:   func(g *stickgen.Generator) { g.Comments = level },
+			ctx:       `map[string]stick.Value{"x": "b"}`,
+			want:      "a\nb",
+		})
+	}
+	testRender(t, renders)
+}
+
+func TestLineDirectives(t *testing.T) {
+	lines := func(g *stickgen.Generator) {
+		g.LineDirectives = true
+		g.Filters["caller"] = stickgen.NewSnippetFilter("caller(%s)")
+	}
+	// caller reports the location it is called from, which line directives
+	// map back to the template.
+	caller := `func caller(v stick.Value) stick.Value {
+	_, file, line, _ := runtime.Caller(1)
+	return fmt.Sprintf("%s:%d", filepath.Base(file), line)
+}`
+	g := stickgen.NewGenerator("views", &stick.MemoryLoader{Templates: map[string]string{"test.twig": "a\n{% if x %}{{ x }}{% endif %}"}})
+	g.LineDirectives = true
+	src, err := g.Generate("test.twig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(src, "\n//line test.twig:2\n") {
+		t.Errorf("expected a line directive for line 2, got:\n%s", src)
+	}
+	if strings.Contains(src, "// line ") {
+		t.Errorf("expected no location comments, got:\n%s", src)
+	}
+	testRender(t, []renderTest{
+		{
+			name:      "caller",
+			templates: map[string]string{"test.twig": "a\n\n{{ x|caller }}"},
+			options:   lines,
+			decls:     caller,
+			imports:   []string{"path/filepath", "runtime"},
+			want:      "a\n\ntest.twig:3",
+		},
+		{
+			name: "included template",
+			templates: map[string]string{
+				"part.twig": "\n{{ x|caller }}",
+				"test.twig": "{% include 'part.twig' %}",
+			},
+			options: lines,
+			decls:   caller,
+			imports: []string{"path/filepath", "runtime"},
+			want:    "\npart.twig:2",
+		},
+		{
+			name: "block",
+			templates: map[string]string{
+				"layout.twig": "{% block b %}{% endblock %}",
+				"test.twig":   "{% extends 'layout.twig' %}\n{% block b %}\n\n{{ x|caller }}{% endblock %}",
+			},
+			options: lines,
+			decls:   caller,
+			imports: []string{"path/filepath", "runtime"},
+			want:    "\n\ntest.twig:4",
+		},
+	})
+}
+
+// failingWriter is an io.Writer that always fails.
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
+func TestGenerateTo(t *testing.T) {
+	loader := &stick.MemoryLoader{Templates: map[string]string{
+		"test.twig":    `{% extends 'layout.twig' %}{% block b %}{{ name }}{% endblock %}`,
+		"layout.twig":  `[{% block b %}{% endblock %}]`,
+		"invalid.twig": `{% if %}`,
+	}}
+	g := stickgen.NewGenerator("views", loader)
+	want, err := g.Generate("test.twig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		tpl  string
+		w    io.Writer
+		want string
+		err  string
+	}{
+		{name: "buffer", tpl: "test.twig", w: &bytes.Buffer{}, want: want},
+		{name: "missing", tpl: "missing.twig", w: &bytes.Buffer{}, err: "template not found: missing.twig"},
+		{name: "invalid", tpl: "invalid.twig", w: &bytes.Buffer{}, err: "unexpected token"},
+		{name: "write", tpl: "test.twig", w: failingWriter{}, err: "write failed"},
+	}
+	for _, tt := range tests {
+		err := g.GenerateTo(tt.w, tt.tpl)
+		checkErr(t, tt.name, "generating", err, tt.err)
+		if b, ok := tt.w.(*bytes.Buffer); ok && b.String() != tt.want {
+			t.Errorf("%s: expected code %q, got %q", tt.name, tt.want, b.String())
+		}
+	}
+}
+
+func TestGenerateAll(t *testing.T) {
+	templates := map[string]string{
+		"layout.twig": `[{% block content %}{% endblock %}]`,
+		"macros.twig": `{% macro hello(name) %}Hello, {{ name }}{% endmacro %}`,
+		"index.twig":  `{% extends 'layout.twig' %}{% import 'macros.twig' as m %}{% block content %}{{ m.hello('index') }}{% endblock %}`,
+		"about.twig":  `{% extends 'layout.twig' %}{% import 'macros.twig' as m %}{% block content %}{{ m.hello('about') }}{% endblock %}`,
+	}
+	call := `err := TemplateIndexTwig(env, output, ctx)
+	if err == nil {
+		err = TemplateAboutTwig(env, output, ctx)
+	}`
+	testRender(t, []renderTest{
+		{
+			name:      "templates",
+			templates: templates,
+			all:       []string{"index.twig", "about.twig"},
+			call:      call,
+			want:      "[Hello, index][Hello, about]",
+		},
+		{
+			name:      "layout",
+			templates: templates,
+			all:       []string{"index.twig", "layout.twig"},
+			call: `err := TemplateIndexTwig(env, output, ctx)
+	if err == nil {
+		err = TemplateLayoutTwig(env, output, ctx)
+	}`,
+			want: "[Hello, index][]",
+		},
+		{
+			name:      "colliding names",
+			templates: map[string]string{"a-b.twig": `a`, "a_b.twig": `b`},
+			all:       []string{"a-b.twig", "a_b.twig"},
+			err:       "a-b.twig and a_b.twig would both be generated as TemplateABTwig",
+		},
+	})
+
+	g := stickgen.NewGenerator("views", &stick.MemoryLoader{Templates: templates})
+	src, err := g.GenerateAll("index.twig", "about.twig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fn := range []string{"func macroMacrosTwigHello(", "\nimport ("} {
+		if n := strings.Count(src, fn); n != 1 {
+			t.Errorf("expected %q once, got %d times:\n%s", fn, n, src)
+		}
+	}
+}
+
+func TestDeterministic(t *testing.T) {
+	templates := map[string]string{
+		"layout.twig": `{% block z %}{% endblock %}{% block a %}{% endblock %}{% block m %}{% endblock %}`,
+		"macros.twig": `{% macro b() %}b{% endmacro %}{% macro a() %}a{% endmacro %}`,
+		"test.twig": `{% extends 'layout.twig' %}{% import 'macros.twig' as m %}
+{% block z %}{{ m.b() }}{{ x|upper }}{% endblock %}
+{% block a %}{% for i in items %}{{ i.name|default(f(i)) }}{% endfor %}{% endblock %}
+{% block m %}{{ m.a() }}{{ 1.5 }}{% endblock %}`,
+	}
+	generate := func() string {
+		g := stickgen.NewGenerator("views", &stick.MemoryLoader{Templates: templates})
+		g.Filters["upper"] = stickgen.NewSnippetFilter("strings.ToUpper(stick.CoerceString(%s))", "strings")
+		src, err := g.GenerateAll("test.twig", "layout.twig")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return src
+	}
+	want := generate()
+	for i := 0; i < 20; i++ {
+		if got := generate(); got != want {
+			t.Fatalf("expected identical code, got:\n%s\nthen:\n%s", want, got)
+		}
+	}
+	// Blocks and macros are generated in the order they are first seen.
+	var order []int
+	for _, fn := range []string{"func blockTestTwigZ(", "func blockTestTwigA(", "func blockTestTwigM(", "func macroMacrosTwigB(", "func macroMacrosTwigA("} {
+		order = append(order, strings.Index(want, fn))
+	}
+	for i, n := range order {
+		if n < 0 || i > 0 && n < order[i-1] {
+			t.Fatalf("expected functions in order of first use, got positions %v:\n%s", order, want)
+		}
+	}
+	imports := want[strings.Index(want, "import (")+len("import ("):]
+	imports = imports[:strings.Index(imports, ")")]
+	specs := strings.Fields(imports)
+	if !sort.StringsAreSorted(specs) {
+		t.Errorf("expected sorted imports, got %v", specs)
+	}
+}
+
+func TestStringFuncs(t *testing.T) {
+	stringFuncs := func(g *stickgen.Generator) {
+		g.StringFuncs = true
+	}
+	tpl := map[string]string{"test.twig": `Hello, {{ name }}!`}
+	testRender(t, []renderTest{
+		{
+			name:      "string",
+			templates: tpl,
+			options:   stringFuncs,
+			ctx:       `map[string]stick.Value{"name": "Ann"}`,
+			call: `s, err := TemplateTestTwigString(env, ctx)
+	fmt.Fprintf(output, "%q", s)`,
+			want: `"Hello, Ann!"`,
+		},
+		{
+			name:      "reused buffer",
+			templates: tpl,
+			options:   stringFuncs,
+			call: `var err error
+	for _, name := range []string{"Ann", "Bob"} {
+		var s string
+		s, err = TemplateTestTwigString(env, map[string]stick.Value{"name": name})
+		fmt.Fprint(output, s, "|")
+	}
+	_ = ctx`,
+			want: "Hello, Ann!|Hello, Bob!|",
+		},
+		{
+			name:      "error",
+			templates: map[string]string{"test.twig": `a{{ user.name }}`},
+			options:   stringFuncs,
+			ctx:       `map[string]stick.Value{"user": map[string]stick.Value{}}`,
+			call: `s, err := TemplateTestTwigString(env, ctx)
+	fmt.Fprintf(output, "%q|%v", s, err)
+	err = nil`,
+			want: `""|missing key name`,
+		},
+		{
+			name:      "ignore errors",
+			templates: map[string]string{"test.twig": `a{{ user.name }}b`},
+			options: func(g *stickgen.Generator) {
+				g.StringFuncs = true
+				g.IgnoreErrors = true
+			},
+			ctx: `map[string]stick.Value{"user": map[string]stick.Value{}}`,
+			call: `s, err := TemplateTestTwigString(env, ctx)
+	fmt.Fprint(output, s)`,
+			want: "ab",
+		},
+		{
+			name:      "context",
+			templates: tpl,
+			options: func(g *stickgen.Generator) {
+				g.StringFuncs = true
+				g.Context = true
+			},
+			imports: []string{"context"},
+			ctx:     `map[string]stick.Value{"name": "Ann"}`,
+			call: `s, err := TemplateTestTwigString(context.Background(), env, ctx)
+	fmt.Fprint(output, s)`,
+			want: "Hello, Ann!",
+		},
+		{
+			name:      "unexported",
+			templates: tpl,
+			options: func(g *stickgen.Generator) {
+				g.StringFuncs = true
+				g.Unexported = true
+			},
+			ctx: `map[string]stick.Value{"name": "Ann"}`,
+			call: `s, err := templateTestTwigString(env, ctx)
+	fmt.Fprint(output, s)`,
+			want: "Hello, Ann!",
+		},
+	})
+}
+
+func TestTypes(t *testing.T) {
+	types := func(g *stickgen.Generator) {
+		g.Types = true
+	}
+	templates := map[string]string{
+		"layout.twig": `[{% block title %}{% endblock %}{% block content %}{% endblock %}]`,
+		"test.twig":   `{% extends 'layout.twig' %}{% block content %}{{ name }}{% block inner %}{% endblock %}{% endblock %}`,
+		"static.twig": `{% block b %}static{% endblock %}`,
+	}
+	describe := `fmt.Fprint(output, tpl.Name(), tpl.Blocks(), "|")
+	err := tpl.Execute(env, output, ctx)`
+	testRender(t, []renderTest{
+		{
+			name:      "template",
+			templates: templates,
+			options:   types,
+			imports:   []string{"github.com/veonik/go-stickgen"},
+			ctx:       `map[string]stick.Value{"name": "Ann"}`,
+			call: `var tpl stickgen.Template = TestTwigTemplate{}
+	` + describe,
+			want: "test.twig[content inner title]|[Ann]",
+		},
+		{
+			name:      "static",
+			templates: templates,
+			tpl:       "static.twig",
+			options:   types,
+			imports:   []string{"github.com/veonik/go-stickgen"},
+			call: `var tpl stickgen.Template = StaticTwigTemplate{}
+	` + describe,
+			want: "static.twig[b]|static",
+		},
+		{
+			name:      "polymorphic",
+			templates: templates,
+			all:       []string{"test.twig", "static.twig"},
+			options:   types,
+			imports:   []string{"github.com/veonik/go-stickgen"},
+			call: `var err error
+	for _, tpl := range []stickgen.Template{TestTwigTemplate{}, StaticTwigTemplate{}} {
+		if err = tpl.Execute(env, output, ctx); err != nil {
+			break
+		}
+	}`,
+			want: "[<nil>]static",
+		},
+		{
+			name:      "context",
+			templates: templates,
+			options: func(g *stickgen.Generator) {
+				g.Types = true
+				g.Context = true
+			},
+			imports: []string{"context", "github.com/veonik/go-stickgen"},
+			ctx:     `map[string]stick.Value{"name": "Ann"}`,
+			call: `var tpl stickgen.ContextTemplate = TestTwigTemplate{}
+	err := tpl.Execute(env, output, ctx)
+	if err == nil {
+		err = tpl.ExecuteContext(context.Background(), env, output, ctx)
+	}`,
+			want: "[Ann][Ann]",
+		},
+		{
+			name:      "error",
+			templates: map[string]string{"test.twig": `{{ user.name }}`},
+			options:   types,
+			ctx:       `map[string]stick.Value{"user": map[string]stick.Value{}}`,
+			call:      `err := TestTwigTemplate{}.Execute(env, output, ctx)`,
+			runErr:    "missing key name",
+		},
+		{
+			name:      "ignore errors",
+			templates: map[string]string{"test.twig": `a{{ user.name }}`},
+			options: func(g *stickgen.Generator) {
+				g.Types = true
+				g.IgnoreErrors = true
+			},
+			ctx:  `map[string]stick.Value{"user": map[string]stick.Value{}}`,
+			call: `err := TestTwigTemplate{}.Execute(env, output, ctx)`,
+			want: "a",
+		},
+		{
+			name:      "unexported",
+			templates: templates,
+			options: func(g *stickgen.Generator) {
+				g.Types = true
+				g.Unexported = true
+			},
+			ctx:  `map[string]stick.Value{"name": "Ann"}`,
+			call: `err := testTwigTemplate{}.Execute(env, output, ctx)`,
+			want: "[Ann]",
+		},
+	})
+}
+
+func TestErrorPolicy(t *testing.T) {
+	policy := func(p stickgen.ErrorPolicy) func(g *stickgen.Generator) {
+		return func(g *stickgen.Generator) {
+			g.ErrorPolicy = p
+			g.ErrorPlaceholder = "?"
+		}
+	}
+	tpl := map[string]string{"test.twig": `a{{ user.name }}b{{ user.id }}c`}
+	ctx := `map[string]stick.Value{"user": map[string]stick.Value{"id": 1}}`
+	// Expressions whose errors are handled evaluate to nil.
+	handlerCall := `var handled []string
+	handler := func(err error) error {
+		handled = append(handled, err.Error())
+		return nil
+	}
+	err := TemplateTestTwig(handler, env, output, ctx)
+	fmt.Fprint(output, handled)`
+	testRender(t, []renderTest{
+		{name: "return", templates: tpl, options: policy(stickgen.ErrorsReturn), ctx: ctx, runErr: "missing key name"},
+		{
+			name:      "panic",
+			templates: tpl,
+			options:   policy(stickgen.ErrorsPanic),
+			ctx:       ctx,
+			call: `defer func() {
+		fmt.Fprint(output, "|", recover())
+	}()
+	err := TemplateTestTwig(env, output, ctx)`,
+			want: "a|missing key name",
+		},
+		{name: "placeholder", templates: tpl, options: policy(stickgen.ErrorsPlaceholder), ctx: ctx, want: "a?b1c"},
+		{
+			name:      "placeholder in condition",
+			templates: map[string]string{"test.twig": `{% if user.admin %}admin{% endif %}`},
+			options:   policy(stickgen.ErrorsPlaceholder),
+			ctx:       ctx,
+			want:      "admin",
+		},
+		{
+			name:      "placeholder in filter",
+			templates: map[string]string{"test.twig": `{{ user.name|default('x') }}`},
+			options:   policy(stickgen.ErrorsPlaceholder),
+			ctx:       ctx,
+			setup: `env.Filters["default"] = func(ctx stick.Context, val stick.Value, args ...stick.Value) stick.Value {
+		return val
+	}`,
+			want: "?",
+		},
+		{name: "handler", templates: tpl, options: policy(stickgen.ErrorsHandler), ctx: ctx, call: handlerCall, want: "a<nil>b1c[missing key name]"},
+		{
+			name:      "nil handler",
+			templates: tpl,
+			options:   policy(stickgen.ErrorsHandler),
+			ctx:       ctx,
+			call:      `err := TemplateTestTwig(nil, env, output, ctx)`,
+			runErr:    "missing key name",
+		},
+		{
+			name:      "failing handler",
+			templates: tpl,
+			options:   policy(stickgen.ErrorsHandler),
+			ctx:       ctx,
+			call: `err := TemplateTestTwig(func(err error) error {
+		return fmt.Errorf("handled: %s", err)
+	}, env, output, ctx)`,
+			runErr: "handled: missing key name",
+		},
+		{
+			name: "handler in block",
+			templates: map[string]string{
+				"layout.twig": `[{% block content %}{% endblock %}]`,
+				"test.twig":   `{% extends 'layout.twig' %}{% block content %}{{ user.name }}{% endblock %}`,
+			},
+			options: policy(stickgen.ErrorsHandler),
+			ctx:     ctx,
+			call:    handlerCall,
+			want:    "[<nil>][missing key name]",
+		},
+		{
+			name:      "handler with context",
+			templates: tpl,
+			options: func(g *stickgen.Generator) {
+				g.ErrorPolicy = stickgen.ErrorsHandler
+				g.Context = true
+			},
+			ctx:     ctx,
+			imports: []string{"context"},
+			call: `err := TemplateTestTwig(context.Background(), func(err error) error {
+		return nil
+	}, env, output, ctx)`,
+			want: "a<nil>b1c",
+		},
+		{
+			name:      "write errors",
+			templates: tpl,
+			options:   policy(stickgen.ErrorsPlaceholder),
+			ctx:       ctx,
+			decls: `type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("write failed")
+}`,
+			imports: []string{"errors"},
+			call: `err := TemplateTestTwig(env, failingWriter{}, ctx)
+	_ = output`,
+			runErr: "write failed",
+		},
+		{
+			name:      "ignore errors",
+			templates: tpl,
+			options: func(g *stickgen.Generator) {
+				g.ErrorPolicy = stickgen.ErrorsPanic
+				g.IgnoreErrors = true
+			},
+			err: "ErrorPolicy cannot be used with IgnoreErrors",
+		},
+	})
+}
+
+func TestReuse(t *testing.T) {
+	templates := map[string]string{
+		"layout.twig": `[{% block content %}{% endblock %}]`,
+		"macros.twig": `{% macro hello(name) %}Hello, {{ name }}{% endmacro %}`,
+		"a.twig":      `{% extends 'layout.twig' %}{% import 'macros.twig' as m %}{% block content %}{{ m.hello(name) }}{% endblock %}`,
+		"b.twig":      `{% for i in items %}{{ i.name }}{% endfor %}{% include 'c.twig' %}`,
+		"c.twig":      `{% set x = 1 %}{{ x }}`,
+		"bad.twig":    `{% if %}`,
+	}
+	names := []string{"a.twig", "b.twig", "c.twig", "layout.twig"}
+	fresh := make(map[string]string)
+	for _, name := range names {
+		src, err := stickgen.NewGenerator("views", &stick.MemoryLoader{Templates: templates}).Generate(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fresh[name] = src
+	}
+	g := stickgen.NewGenerator("views", &stick.MemoryLoader{Templates: templates})
+	// Each call starts from a clean state, even after a failed one.
+	for _, name := range []string{"a.twig", "bad.twig", "b.twig", "a.twig", "c.twig", "b.twig", "layout.twig"} {
+		src, err := g.Generate(name)
+		if name == "bad.twig" {
+			if err == nil {
+				t.Errorf("expected error generating %s", name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if src != fresh[name] {
+			t.Errorf("%s: expected the same code as a new Generator, got:\n%s\nwant:\n%s", name, src, fresh[name])
+		}
+	}
+	if _, err := g.GenerateAll("a.twig", "b.twig"); err != nil {
+		t.Fatal(err)
+	}
+	if src, _ := g.Generate("a.twig"); src != fresh["a.twig"] {
+		t.Errorf("expected the same code after GenerateAll, got:\n%s", src)
+	}
+	var wg sync.WaitGroup
+	errs := make(chan string, 8*len(names))
+	for i := 0; i < 8; i++ {
+		for _, name := range names {
+			wg.Add(1)
+			go func(name string) {
+				defer wg.Done()
+				src, err := g.Generate(name)
+				if err != nil {
+					errs <- err.Error()
+				} else if src != fresh[name] {
+					errs <- name + ": unexpected code generated concurrently:\n" + src
+				}
+			}(name)
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestImportPaths(t *testing.T) {
+	imports := func(g *stickgen.Generator) {
+		g.StickImport = "example.com/fork/stick"
+		g.RuntimeImport = "example.com/fork/stickgen"
+	}
+	g := stickgen.NewGenerator("views", &stick.MemoryLoader{Templates: map[string]string{
+		"test.twig": `{% for i in items %}{{ loop.index }}{{ f(i) }}{% endfor %}`,
+	}})
+	imports(g)
+	_, f, err := g.GenerateAST("test.twig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]string)
+	for _, spec := range f.Imports {
+		name := ""
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+		got[spec.Path.Value] = name
+	}
+	want := map[string]string{
+		`"example.com/fork/stick"`:    "stick",
+		`"example.com/fork/stickgen"`: "stickgen",
+		`"fmt"`:                       "",
+		`"io"`:                        "",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected imports %v, got %v", want, got)
+	}
+	// The registry is declared in a file of its own by GenerateDir.
+	root, err := ioutil.TempDir("", "stickgen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	writeTree(t, root, map[string]string{"test.twig": `a`})
+	g = stickgen.NewGenerator("views", stick.NewFilesystemLoader(root))
+	imports(g)
+	g.Registry = true
+	files, err := g.GenerateFiles(root, "views", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Errorf("expected the registry and template files, got %d files", len(files))
+	}
+	for file, src := range files {
+		if !strings.Contains(src, `stick "example.com/fork/stick"`) || strings.Contains(src, `"github.com/tyler-sommer/stick"`) {
+			t.Errorf("%s: expected the stick package to be imported from its fork, got:\n%s", file, src)
+		}
+	}
+	testRender(t, []renderTest{
+		{
+			name:      "default paths",
+			templates: map[string]string{"test.twig": `{% for i in items %}{{ loop.index }}{% endfor %}`},
+			options: func(g *stickgen.Generator) {
+				g.StickImport = "github.com/tyler-sommer/stick"
+				g.RuntimeImport = "github.com/veonik/go-stickgen"
+			},
+			ctx:  `map[string]stick.Value{"items": []stick.Value{"a", "b"}}`,
+			want: "12",
+		},
+	})
+}
+
+func TestTemporaryNames(t *testing.T) {
+	funcs := `env.Functions["join"] = func(ctx stick.Context, args ...stick.Value) stick.Value {
+		s := ""
+		for _, arg := range args {
+			s += stick.CoerceString(arg)
+		}
+		return s
+	}`
+	ctx := `map[string]stick.Value{"a": map[string]stick.Value{"b": map[string]stick.Value{"c": "x"}, "d": "y"}}`
+	templates := map[string]string{
+		"nested":    `{% if join(a.b.c, join(a.d, a.b.c)) == 'xyx' %}{{ join(a.d, a.b.c) }}{% endif %}`,
+		"sequence":  `{{ a.b.c }}{{ a.b.c }}{% set x = join(a.d) %}{% set y = join(x, a.d) %}{{ y }}`,
+		"loops":     `{% for i in [a.b, a.b] %}{% for j in [i.c, a.d] %}{{ join(i.c, j) }}{% endfor %}{% endfor %}`,
+		"hash":      `{% set h = {'k': join(a.d), (a.b.c): a.d} %}{{ h.k }}{{ h.x }}`,
+		"condition": `{% if a.b.c == 'x' and join(a.d) == 'y' or a.d == 'z' %}ok{% endif %}`,
+	}
+	want := map[string]string{
+		"nested":    "yx",
+		"sequence":  "xxyy",
+		"loops":     "xxxyxxxy",
+		"hash":      "yy",
+		"condition": "ok",
+	}
+	var tests []renderTest
+	for name, tpl := range templates {
+		tests = append(tests, renderTest{
+			name:      name,
+			templates: map[string]string{"test.twig": tpl},
+			setup:     funcs,
+			ctx:       ctx,
+			want:      want[name],
+		})
+		// Temporary names are not reused within a function, so that
+		// nested expressions do not shadow each other.
+		g := stickgen.NewGenerator("views", &stick.MemoryLoader{Templates: map[string]string{"test.twig": tpl}})
+		src, err := g.Generate("test.twig")
+		if err != nil {
+			t.Fatal(err)
+		}
+		declared := make(map[string]bool)
+		for _, m := range tempDeclPattern.FindAllStringSubmatch(src, -1) {
+			if declared[m[1]] {
+				t.Errorf("%s: expected %s to be declared once, got:\n%s", name, m[1], src)
+			}
+			declared[m[1]] = true
+		}
+		if len(declared) == 0 {
+			t.Errorf("%s: expected temporary names, got:\n%s", name, src)
+		}
+	}
+	testRender(t, tests)
+}
+
+// tempDeclPattern matches the declarations of temporary names in generated
+// code.
+var tempDeclPattern = regexp.MustCompile(`(?m)^\s*(?:var )?([a-z]*val\d+)(?:, err)?(?: stick\.Value =| :=)`)
+
+func TestWrites(t *testing.T) {
+	html := func(g *stickgen.Generator) {
+		g.Autoescape = "html"
+	}
+	testSource(t, []sourceTest{
+		{
+			name:      "text",
+			templates: map[string]string{"test.twig": `a{% if x %}b{% endif %}`},
+			contains:  []string{"io.WriteString(output, `a`)", "io.WriteString(output, `b`)"},
+			absent:    []string{"fmt"},
+		},
+		{
+			name:      "string literal",
+			templates: map[string]string{"test.twig": `{% if x %}{{ 'b' }}{% endif %}`},
+			contains:  []string{"io.WriteString(output, `b`)"},
+			absent:    []string{"fmt"},
+		},
+		{
+			name:      "escaped value",
+			templates: map[string]string{"test.twig": `{{ x }}`},
+			options:   html,
+			contains:  []string{`io.WriteString(output, stickgen.Escape(ctx["x"], "html"))`},
+			absent:    []string{"fmt"},
+		},
+		{
+			name:      "value",
+			templates: map[string]string{"test.twig": `{{ x }}`},
+			contains:  []string{`fmt.Fprint(output, ctx["x"])`},
+		},
+	})
+	testRender(t, []renderTest{
+		{name: "text", templates: map[string]string{"test.twig": `a{% if x %}b{% endif %}`}, ctx: `map[string]stick.Value{"x": true}`, want: "ab"},
+		{name: "escaped value", templates: map[string]string{"test.twig": `{{ x }}`}, options: html, ctx: `map[string]stick.Value{"x": "<b>"}`, want: "&lt;b&gt;"},
+		{name: "value", templates: map[string]string{"test.twig": `{{ x }}`}, ctx: `map[string]stick.Value{"x": 1.5}`, want: "1.5"},
+	})
+}
+
+func TestMergedWrites(t *testing.T) {
+	tests := []struct {
+		name   string
+		tpl    string
+		writes []string
+		want   string
+	}{
+		{name: "text and literals", tpl: `a{{ 'b' }}c{{ "d" }}`, writes: []string{"`abcd`"}, want: "abcd"},
+		{name: "split by values", tpl: `a{{ 'b' }}{{ x }}c{{ 'd' }}`, writes: []string{"`ab`", "`cd`"}, want: "ab1cd"},
+		{name: "split by tags", tpl: `a{% if x %}b{{ 'c' }}{% endif %}d`, writes: []string{"`a`", "`bc`", "`d`"}, want: "abcd"},
+		{name: "escaped literal", tpl: `{% autoescape 'html' %}a{{ '<' }}b{% endautoescape %}`, writes: []string{"`a&lt;b`"}, want: "a&lt;b"},
+	}
+	var renders []renderTest
+	for _, tt := range tests {
+		templates := map[string]string{"test.twig": tt.tpl}
+		src, err := generateTest(renderTest{templates: templates})
+		if err != nil {
+			t.Errorf("%s: unexpected error generating: %s", tt.name, err)
+			continue
+		}
+		var writes []string
+		for _, m := range writePattern.FindAllStringSubmatch(src, -1) {
+			writes = append(writes, m[1])
+		}
+		if !reflect.DeepEqual(writes, tt.writes) {
+			t.Errorf("%s: expected writes %q, got %q:\n%s", tt.name, tt.writes, writes, src)
+		}
+		renders = append(renders, renderTest{
+			name:      tt.name,
+			templates: templates,
+			ctx:       `map[string]stick.Value{"x": 1}`,
+			want:      tt.want,
+		})
+	}
+	testRender(t, renders)
+}
+
+// writePattern matches writes of literals in generated code.
+var writePattern = regexp.MustCompile("io\\.WriteString\\(output, (`[^`]*`|\"[^\"]*\")\\)")
+
+func TestTextLiterals(t *testing.T) {
+	texts := map[string]string{
+		"plain":           "Hello, world!\n",
+		"backquote":       "a `b` c",
+		"only backquotes": "``",
+		"edge backquotes": "`a`",
+		"carriage return": "a\r\nb",
+		"invalid utf-8":   "a\xffb",
+		"nul":             "a\x00b",
+		"byte order mark": "\ufeffa",
+		"backslashes":     `a\nb\\c "d"`,
+		"unicode":         "héllo, 世界",
+		"mixed":           "`a`\r\n\\`",
+	}
+	var tests []renderTest
+	for name, text := range texts {
+		tests = append(tests, renderTest{
+			name:      name,
+			templates: map[string]string{"test.twig": text + "{% if x %}" + text + "{% endif %}"},
+			ctx:       `map[string]stick.Value{"x": true}`,
+			want:      text + text,
+		})
+	}
+	testRender(t, tests)
+	// The templates print a value so that they are not written as constants.
+	testSource(t, []sourceTest{
+		{
+			name:      "raw where possible",
+			templates: map[string]string{"test.twig": "a\n\"b\"\\c{{ x }}"},
+			contains:  []string{"`a\n\"b\"\\c`"},
+		},
+		{
+			name:      "backquotes quoted separately",
+			templates: map[string]string{"test.twig": "a`b{{ x }}"},
+			contains:  []string{"`a`+\"`\"+`b`"},
+		},
+		{
+			name:      "quoted entirely",
+			templates: map[string]string{"test.twig": "a`\r\n{{ x }}"},
+			contains:  []string{`"a` + "`" + `\r\n"`},
+		},
+	})
+}
@@ -0,0 +1,249 @@
+package stickgen
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/tyler-sommer/stick"
+	"github.com/tyler-sommer/stick/parse"
+)
+
+// A Test generates Go code for a Twig test at generation time.
+//
+// Tests registered with a Generator are compiled inline and take precedence
+// over any test of the same name, including those built into Twig. Other
+// tests are looked up on the stick.Env at runtime.
+type Test struct {
+	// Imports lists any packages required by the generated code.
+	Imports []string
+
+	// Emit returns a Go boolean expression that applies the test. val is a
+	// Go expression for the value being tested and args contains a Go
+	// expression for each additional argument.
+	Emit func(val string, args ...string) string
+
+	// Eval optionally applies the test at generation time. It is used in
+	// place of Emit when the value and all arguments are constant.
+	Eval func(val stick.Value, args ...stick.Value) bool
+
+	// Params optionally names each additional argument, allowing them to
+	// be given as named arguments.
+	Params []string
+}
+
+// NewSnippetTest creates a Test from a snippet of Go code.
+//
+// The snippet is a format string as accepted by fmt.Sprintf. It receives the
+// value being tested followed by any additional arguments, for example:
+//
+//	NewSnippetTest("strings.HasPrefix(stick.CoerceString(%s), %s)", "strings")
+func NewSnippetTest(snippet string, imports ...string) Test {
+	return Test{
+		Imports: imports,
+		Emit: func(val string, args ...string) string {
+			params := []interface{}{val}
+			for _, arg := range args {
+				params = append(params, arg)
+			}
+			return fmt.Sprintf(snippet, params...)
+		},
+	}
+}
+
+// walkTest generates a test. Tests registered with the Generator and tests
+// built into Twig are generated inline, while any other test is looked up
+// on the stick.Env at runtime.
+func (g *Generator) walkTest(expr *parse.TestExpr) (evaluatedExpr, error) {
+	if len(expr.Args) == 0 {
+		return emptyExpr, fmt.Errorf("stickgen: test %s expects a value", expr.Name)
+	}
+	if test, ok := g.Tests[expr.Name]; ok {
+		return g.walkCustomTest(test, expr)
+	}
+	switch expr.Name {
+	case "defined":
+		return g.walkDefinedTest(expr.Args[0])
+	case "null", "none":
+		// The value is converted so that literals can be compared to nil.
+		return g.walkValueTest(expr.Args[0], "stick.Value(%s) == nil")
+	case "empty":
+		g.addImport(runtimeImport)
+		return g.walkValueTest(expr.Args[0], "stickgen.Empty(%s)")
+	case "even":
+		return g.walkValueTest(expr.Args[0], "int(stick.CoerceNumber(%s))%%2 == 0")
+	case "odd":
+		return g.walkValueTest(expr.Args[0], "int(stick.CoerceNumber(%s))%%2 != 0")
+	case "divisible by":
+		return g.walkDivisibleTest(expr)
+	case "same as":
+		return g.walkSameTest(expr)
+	case "iterable":
+		g.addImport(runtimeImport)
+		return g.walkValueTest(expr.Args[0], "stickgen.Iterable(%s)")
+	case "constant":
+		return g.walkConstantTest(expr)
+	}
+	return g.walkFuncExpr(expr.FuncExpr, "Tests")
+}
+
+// walkDefinedTest generates a check that a variable or attribute exists.
+// Unlike when the value is used, a missing attribute is not an error.
+func (g *Generator) walkDefinedTest(e parse.Expr) (evaluatedExpr, error) {
+	switch expr := stripGroup(e).(type) {
+	case *parse.NameExpr:
+		if _, ok := g.args[expr.Name]; ok || expr.Name == "loop" && g.loop != "" {
+			return newLiteral("true"), nil
+		}
+		g.addImport(runtimeImport)
+		return newLiteral(fmt.Sprintf("stickgen.Defined(ctx, %s)", strconv.Quote(expr.Name))), nil
+	case *parse.GetAttrExpr:
+		prevRet := g.ret
+		g.ret = "return false"
+		v, err := g.walkExpr(expr)
+		g.ret = prevRet
+		if err != nil {
+			return emptyExpr, err
+		}
+		if !v.hasError {
+			return newLiteral("true"), nil
+		}
+		// The lookup is wrapped in a function so that its error is not
+		// seen by the enclosing expression.
+		return newLiteral(fmt.Sprintf(`func() bool {
+%s	%s
+%s	_ = %s
+%s	return err == nil
+%s}()`, g.indent(), v.body, g.indent(), v.resultantName, g.indent(), g.indent())), nil
+	}
+	return newLiteral("true"), nil
+}
+
+// walkCustomTest generates a test registered with the Generator.
+func (g *Generator) walkCustomTest(test Test, expr *parse.TestExpr) (evaluatedExpr, error) {
+	if test.Eval != nil {
+		if vals, ok := g.constants(expr.Args); ok {
+			return newLiteral(strconv.FormatBool(test.Eval(vals[0], vals[1:]...))), nil
+		}
+	}
+	body, args, err := g.walkArgs(expr.Args, append([]string{""}, test.Params...))
+	if err != nil {
+		return emptyExpr, err
+	}
+	for _, name := range test.Imports {
+		g.addImport(name)
+	}
+	return evaluatedExpr{
+		body:          strings.TrimSuffix(body, "\n"),
+		resultantName: test.Emit(args[0], args[1:]...),
+		isFunction:    body != "",
+		hasError:      false,
+	}, nil
+}
+
+// testValue evaluates the value being tested. A value that cannot be
+// evaluated, such as a missing attribute, is treated as nil.
+func (g *Generator) testValue(e parse.Expr) (evaluatedExpr, error) {
+	prevRet := g.ret
+	g.ret = "return nil"
+	v, err := g.walkExpr(e)
+	g.ret = prevRet
+	if err != nil || !v.hasError {
+		return v, err
+	}
+	return newLiteral(fmt.Sprintf(`func() stick.Value {
+%s	%s
+%s	if err != nil {
+%s		return nil
+%s	}
+%s	return %s
+%s}()`, g.indent(), v.body, g.indent(), g.indent(), g.indent(), g.indent(), v.resultantName, g.indent())), nil
+}
+
+// walkValueTest generates a test of the given value using a format string
+// that receives the Go expression for the value.
+func (g *Generator) walkValueTest(e parse.Expr, format string) (evaluatedExpr, error) {
+	v, err := g.testValue(e)
+	if err != nil {
+		return emptyExpr, err
+	}
+	v.resultantName = fmt.Sprintf(format, v.resultantName)
+	if !v.isFunction {
+		v.body = v.resultantName
+	}
+	v.safe = false
+	return v, nil
+}
+
+// walkDivisibleTest generates a check that the value is divisible by the
+// test's argument.
+func (g *Generator) walkDivisibleTest(expr *parse.TestExpr) (evaluatedExpr, error) {
+	if len(expr.Args) != 2 {
+		return emptyExpr, fmt.Errorf("stickgen: test %s expects a divisor", expr.Name)
+	}
+	v, err := g.testValue(expr.Args[0])
+	if err != nil {
+		return emptyExpr, err
+	}
+	n, err := g.walkExpr(expr.Args[1])
+	if err != nil {
+		return emptyExpr, err
+	}
+	body := ""
+	if n.isFunction {
+		body = g.handleErr(n)
+	}
+	if v.isFunction {
+		body = strings.TrimPrefix(body+"\n"+g.indent()+v.body, "\n"+g.indent())
+	}
+	g.addImport("math")
+	return evaluatedExpr{
+		body:          body,
+		resultantName: fmt.Sprintf("math.Mod(stick.CoerceNumber(%s), stick.CoerceNumber(%s)) == 0", v.resultantName, n.resultantName),
+		isFunction:    body != "",
+		hasError:      false,
+	}, nil
+}
+
+// walkSameTest generates a strict comparison of the value and the test's
+// argument. Unlike the == operator, neither value is coerced.
+func (g *Generator) walkSameTest(expr *parse.TestExpr) (evaluatedExpr, error) {
+	if len(expr.Args) != 2 {
+		return emptyExpr, fmt.Errorf("stickgen: test %s expects a value to compare", expr.Name)
+	}
+	v, err := g.testValue(expr.Args[0])
+	if err != nil {
+		return emptyExpr, err
+	}
+	other, err := g.testValue(expr.Args[1])
+	if err != nil {
+		return emptyExpr, err
+	}
+	var body []string
+	for _, e := range []evaluatedExpr{v, other} {
+		if e.isFunction {
+			body = append(body, g.handleErr(e))
+		}
+	}
+	g.addImport(runtimeImport)
+	return evaluatedExpr{
+		body:          strings.Join(body, "\n"+g.indent()),
+		resultantName: fmt.Sprintf("stickgen.Same(%s, %s)", v.resultantName, other.resultantName),
+		isFunction:    len(body) > 0,
+		hasError:      false,
+	}, nil
+}
+
+// walkConstantTest generates a strict comparison of the value and a constant
+// given to the Generator.
+func (g *Generator) walkConstantTest(expr *parse.TestExpr) (evaluatedExpr, error) {
+	if len(expr.Args) != 2 {
+		return emptyExpr, fmt.Errorf("stickgen: test %s expects the name of a constant", expr.Name)
+	}
+	lit, err := g.constantLiteral(expr.Args[1])
+	if err != nil {
+		return emptyExpr, err
+	}
+	g.addImport(runtimeImport)
+	return g.walkValueTest(expr.Args[0], "stickgen.Same(%s, "+strings.Replace(lit, "%", "%%", -1)+")")
+}
@@ -0,0 +1,366 @@
+package stickgen_test
+
+import (
+	"testing"
+
+	"github.com/tyler-sommer/stick"
+	"github.com/veonik/go-stickgen"
+)
+
+func TestDefinedTest(t *testing.T) {
+	tpl := map[string]string{"test.twig": `{% if user.address is defined %}yes{% else %}no{% endif %}`}
+	testRender(t, []renderTest{
+		{name: "defined", templates: tpl, ctx: `map[string]stick.Value{"user": map[string]stick.Value{"address": "x"}}`, want: "yes"},
+		{name: "defined as nil", templates: tpl, ctx: `map[string]stick.Value{"user": map[string]stick.Value{"address": nil}}`, want: "yes"},
+		{name: "missing key", templates: tpl, ctx: `map[string]stick.Value{"user": map[string]stick.Value{}}`, want: "no"},
+		{name: "missing parent", templates: tpl, want: "no"},
+		{
+			name:      "variable",
+			templates: map[string]string{"test.twig": `{% if name is defined %}yes{% else %}no{% endif %}`},
+			ctx:       `map[string]stick.Value{"name": nil}`,
+			want:      "yes",
+		},
+		{
+			name:      "missing variable",
+			templates: map[string]string{"test.twig": `{% if name is defined %}yes{% else %}no{% endif %}`},
+			want:      "no",
+		},
+		{
+			name:      "local variable",
+			templates: map[string]string{"test.twig": `{% set name = 'x' %}{% if name is defined %}yes{% else %}no{% endif %}`},
+			want:      "yes",
+		},
+		{
+			name:      "negated",
+			templates: map[string]string{"test.twig": `{% if user.address is not defined %}no{% endif %}`},
+			ctx:       `map[string]stick.Value{"user": map[string]stick.Value{}}`,
+			want:      "no",
+		},
+		{
+			name:      "loop",
+			templates: map[string]string{"test.twig": `{% for i in [1] %}{% if loop is defined %}yes{% endif %}{% endfor %}`},
+			want:      "yes",
+		},
+		{
+			name:      "use is still an error",
+			templates: map[string]string{"test.twig": `{% if user.address is defined %}yes{% endif %}{{ user.address }}`},
+			ctx:       `map[string]stick.Value{"user": map[string]stick.Value{}}`,
+			runErr:    "missing key address",
+		},
+	})
+}
+
+func TestEmptyAndNullTests(t *testing.T) {
+	empty := map[string]string{"test.twig": `{% if v is empty %}yes{% else %}no{% endif %}`}
+	null := map[string]string{"test.twig": `{% if v is null %}yes{% else %}no{% endif %}`}
+	testRender(t, []renderTest{
+		{name: "empty nil", templates: empty, ctx: `map[string]stick.Value{"v": nil}`, want: "yes"},
+		{name: "empty missing", templates: empty, want: "yes"},
+		{name: "empty string", templates: empty, ctx: `map[string]stick.Value{"v": ""}`, want: "yes"},
+		{name: "empty false", templates: empty, ctx: `map[string]stick.Value{"v": false}`, want: "yes"},
+		{name: "empty slice", templates: empty, ctx: `map[string]stick.Value{"v": []stick.Value{}}`, want: "yes"},
+		{name: "empty map", templates: empty, ctx: `map[string]stick.Value{"v": map[string]stick.Value{}}`, want: "yes"},
+		{name: "zero is not empty", templates: empty, ctx: `map[string]stick.Value{"v": 0}`, want: "no"},
+		{name: "string is not empty", templates: empty, ctx: `map[string]stick.Value{"v": "0"}`, want: "no"},
+		{name: "slice is not empty", templates: empty, ctx: `map[string]stick.Value{"v": []stick.Value{1}}`, want: "no"},
+		{
+			name:      "empty literal",
+			templates: map[string]string{"test.twig": `{% if [] is empty %}yes{% endif %}{% if 'a' is not empty %}no{% endif %}`},
+			want:      "yesno",
+		},
+		{
+			name:      "empty missing attribute",
+			templates: map[string]string{"test.twig": `{% if user.name is empty %}yes{% endif %}`},
+			ctx:       `map[string]stick.Value{"user": map[string]stick.Value{}}`,
+			want:      "yes",
+		},
+		{name: "null nil", templates: null, ctx: `map[string]stick.Value{"v": nil}`, want: "yes"},
+		{name: "null missing", templates: null, want: "yes"},
+		{name: "null empty string", templates: null, ctx: `map[string]stick.Value{"v": ""}`, want: "no"},
+		{name: "null zero", templates: null, ctx: `map[string]stick.Value{"v": 0}`, want: "no"},
+		{
+			name:      "none",
+			templates: map[string]string{"test.twig": `{% if v is none %}yes{% endif %}{% if 1 is not none %}no{% endif %}`},
+			want:      "yesno",
+		},
+		{
+			name:      "null literal",
+			templates: map[string]string{"test.twig": `{% if null is null %}yes{% endif %}`},
+			want:      "yes",
+		},
+	})
+}
+
+func TestEvenAndOddTests(t *testing.T) {
+	even := map[string]string{"test.twig": `{% if v is even %}even{% else %}odd{% endif %}`}
+	odd := map[string]string{"test.twig": `{% if v is odd %}odd{% else %}even{% endif %}`}
+	testRender(t, []renderTest{
+		{name: "even", templates: even, ctx: `map[string]stick.Value{"v": 2}`, want: "even"},
+		{name: "even odd", templates: even, ctx: `map[string]stick.Value{"v": 3}`, want: "odd"},
+		{name: "even zero", templates: even, ctx: `map[string]stick.Value{"v": 0}`, want: "even"},
+		{name: "even string", templates: even, ctx: `map[string]stick.Value{"v": "4"}`, want: "even"},
+		{name: "even float", templates: even, ctx: `map[string]stick.Value{"v": 4.5}`, want: "even"},
+		{name: "odd", templates: odd, ctx: `map[string]stick.Value{"v": 3}`, want: "odd"},
+		{name: "odd even", templates: odd, ctx: `map[string]stick.Value{"v": 2}`, want: "even"},
+		{name: "odd negative", templates: odd, ctx: `map[string]stick.Value{"v": -3}`, want: "odd"},
+		{name: "odd missing", templates: odd, want: "even"},
+		{
+			name:      "literal",
+			templates: map[string]string{"test.twig": `{% if 2 is even %}a{% endif %}{% if 2 is not odd %}b{% endif %}`},
+			want:      "ab",
+		},
+		{
+			name:      "striping",
+			templates: map[string]string{"test.twig": `{% for i in items %}<tr class="{% if loop.index is odd %}odd{% else %}even{% endif %}">{% endfor %}`},
+			ctx:       `map[string]stick.Value{"items": []stick.Value{"a", "b", "c"}}`,
+			want:      `<tr class="odd"><tr class="even"><tr class="odd">`,
+		},
+	})
+}
+
+func TestDivisibleByTest(t *testing.T) {
+	tpl := map[string]string{"test.twig": `{% if v is divisible by(3) %}yes{% else %}no{% endif %}`}
+	testRender(t, []renderTest{
+		{name: "divisible", templates: tpl, ctx: `map[string]stick.Value{"v": 9}`, want: "yes"},
+		{name: "not divisible", templates: tpl, ctx: `map[string]stick.Value{"v": 10}`, want: "no"},
+		{name: "zero", templates: tpl, ctx: `map[string]stick.Value{"v": 0}`, want: "yes"},
+		{name: "string", templates: tpl, ctx: `map[string]stick.Value{"v": "6"}`, want: "yes"},
+		{
+			name:      "loop index",
+			templates: map[string]string{"test.twig": `{% for i in 1..7 %}{{ i }}{% if loop.index is divisible by(3) %},{% endif %}{% endfor %}`},
+			want:      "123,456,7",
+		},
+		{
+			name:      "variable divisor",
+			templates: map[string]string{"test.twig": `{% if v is divisible by(n) %}yes{% else %}no{% endif %}`},
+			ctx:       `map[string]stick.Value{"v": 10, "n": 5}`,
+			want:      "yes",
+		},
+		{
+			name:      "function divisor",
+			templates: map[string]string{"test.twig": `{% if v is not divisible by(n()) %}no{% endif %}`},
+			ctx:       `map[string]stick.Value{"v": 10}`,
+			setup: `env.Functions["n"] = func(ctx stick.Context, args ...stick.Value) stick.Value {
+		return 4
+	}`,
+			want: "no",
+		},
+		{
+			name:      "missing attribute divisor",
+			templates: map[string]string{"test.twig": `{% if 4 is divisible by(d.n) %}yes{% endif %}`},
+			ctx:       `map[string]stick.Value{"d": map[string]stick.Value{}}`,
+			runErr:    "missing key n",
+		},
+	})
+}
+
+func TestIterableTest(t *testing.T) {
+	tpl := map[string]string{"test.twig": `{% if v is iterable %}yes{% else %}no{% endif %}`}
+	testRender(t, []renderTest{
+		{name: "slice", templates: tpl, ctx: `map[string]stick.Value{"v": []stick.Value{}}`, want: "yes"},
+		{name: "map", templates: tpl, ctx: `map[string]stick.Value{"v": map[string]stick.Value{}}`, want: "yes"},
+		{name: "typed slice", templates: tpl, ctx: `map[string]stick.Value{"v": []string{"a"}}`, want: "yes"},
+		{name: "array", templates: tpl, ctx: `map[string]stick.Value{"v": [2]int{1, 2}}`, want: "yes"},
+		{name: "channel", templates: tpl, ctx: `map[string]stick.Value{"v": make(chan int)}`, want: "yes"},
+		{name: "pointer to slice", templates: tpl, ctx: `map[string]stick.Value{"v": &[]int{1}}`, want: "yes"},
+		{name: "string", templates: tpl, ctx: `map[string]stick.Value{"v": "abc"}`, want: "no"},
+		{name: "number", templates: tpl, ctx: `map[string]stick.Value{"v": 1}`, want: "no"},
+		{name: "struct", templates: tpl, ctx: `map[string]stick.Value{"v": struct{}{}}`, want: "no"},
+		{name: "nil", templates: tpl, ctx: `map[string]stick.Value{"v": nil}`, want: "no"},
+		{name: "missing", templates: tpl, want: "no"},
+		{
+			name:      "literal",
+			templates: map[string]string{"test.twig": `{% if [1, 2] is iterable %}a{% endif %}{% if 'a' is not iterable %}b{% endif %}`},
+			want:      "ab",
+		},
+		{
+			name:      "before looping",
+			templates: map[string]string{"test.twig": `{% if v is iterable %}{% for i in v %}{{ i }}{% endfor %}{% else %}{{ v }}{% endif %}`},
+			ctx:       `map[string]stick.Value{"v": 5}`,
+			want:      "5",
+		},
+	})
+}
+
+func TestSameAsTest(t *testing.T) {
+	tpl := map[string]string{"test.twig": `{% if a is same as(b) %}yes{% else %}no{% endif %}`}
+	testRender(t, []renderTest{
+		{name: "same", templates: tpl, ctx: `map[string]stick.Value{"a": 1, "b": 1}`, want: "yes"},
+		{name: "different types", templates: tpl, ctx: `map[string]stick.Value{"a": 1, "b": "1"}`, want: "no"},
+		{name: "int and float", templates: tpl, ctx: `map[string]stick.Value{"a": 1, "b": 1.0}`, want: "no"},
+		{name: "different values", templates: tpl, ctx: `map[string]stick.Value{"a": "x", "b": "y"}`, want: "no"},
+		{name: "nil", templates: tpl, ctx: `map[string]stick.Value{"a": nil, "b": nil}`, want: "yes"},
+		{name: "nil and false", templates: tpl, ctx: `map[string]stick.Value{"a": nil, "b": false}`, want: "no"},
+		{name: "slices", templates: tpl, ctx: `map[string]stick.Value{"a": []stick.Value{1}, "b": []stick.Value{1}}`, want: "yes"},
+		{name: "missing", templates: tpl, ctx: `map[string]stick.Value{"b": nil}`, want: "yes"},
+		{
+			name:      "differs from equals",
+			templates: map[string]string{"test.twig": `{% if a == b %}equal{% endif %}{% if a is not same as(b) %} not same{% endif %}`},
+			ctx:       `map[string]stick.Value{"a": 1, "b": "1"}`,
+			want:      "equal not same",
+		},
+		{
+			name:      "literal",
+			templates: map[string]string{"test.twig": `{% if a is same as('x') %}yes{% endif %}`},
+			ctx:       `map[string]stick.Value{"a": "x"}`,
+			want:      "yes",
+		},
+		{
+			name:      "missing attribute",
+			templates: map[string]string{"test.twig": `{% if a.b is same as(null) %}yes{% endif %}`},
+			ctx:       `map[string]stick.Value{"a": map[string]stick.Value{}}`,
+			want:      "yes",
+		},
+	})
+}
+
+func TestCustomTest(t *testing.T) {
+	prefixed := func(g *stickgen.Generator) {
+		test := stickgen.NewSnippetTest("strings.HasPrefix(stick.CoerceString(%s), stick.CoerceString(%s))", "strings")
+		test.Params = []string{"prefix"}
+		g.Tests["prefixed"] = test
+	}
+	positive := `env.Tests["positive"] = func(ctx stick.Context, val stick.Value, args ...stick.Value) bool {
+		return stick.CoerceNumber(val) > 0
+	}`
+	testRender(t, []renderTest{
+		{
+			name:      "snippet",
+			templates: map[string]string{"test.twig": `{% if v is prefixed('ab') %}yes{% endif %}{% if v is not prefixed('b') %}no{% endif %}`},
+			options:   prefixed,
+			ctx:       `map[string]stick.Value{"v": "abc"}`,
+			want:      "yesno",
+		},
+		{
+			name:      "named argument",
+			templates: map[string]string{"test.twig": `{% if v is prefixed(prefix='ab') %}yes{% endif %}`},
+			options:   prefixed,
+			ctx:       `map[string]stick.Value{"v": "abc"}`,
+			want:      "yes",
+		},
+		{
+			name:      "unknown argument",
+			templates: map[string]string{"test.twig": `{% if v is prefixed(suffix='ab') %}yes{% endif %}`},
+			options:   prefixed,
+			err:       "unknown argument suffix",
+		},
+		{
+			name:      "attribute value",
+			templates: map[string]string{"test.twig": `{% if user.name is prefixed('J') %}yes{% endif %}`},
+			options:   prefixed,
+			ctx:       `map[string]stick.Value{"user": map[string]stick.Value{"name": "Jo"}}`,
+			want:      "yes",
+		},
+		{
+			name:      "evaluated at generation time",
+			templates: map[string]string{"test.twig": `{% if 'abc' is folded('a') %}yes{% else %}no{% endif %}{% if v is folded('a') %}yes{% else %}no{% endif %}`},
+			options: func(g *stickgen.Generator) {
+				g.Tests["folded"] = stickgen.Test{
+					Emit: func(val string, args ...string) string {
+						return "false"
+					},
+					Eval: func(val stick.Value, args ...stick.Value) bool {
+						return val == "abc" && args[0] == "a"
+					},
+				}
+			},
+			ctx:  `map[string]stick.Value{"v": "abc"}`,
+			want: "yesno",
+		},
+		{
+			name:      "overrides built in test",
+			templates: map[string]string{"test.twig": `{% if 1 is even %}yes{% endif %}`},
+			options: func(g *stickgen.Generator) {
+				g.Tests["even"] = stickgen.NewSnippetTest("stick.CoerceNumber(%s) > 0")
+			},
+			want: "yes",
+		},
+		{
+			name:      "env test",
+			templates: map[string]string{"test.twig": `{% if v is positive %}yes{% else %}no{% endif %}`},
+			setup:     positive,
+			ctx:       `map[string]stick.Value{"v": 2}`,
+			want:      "yes",
+		},
+		{
+			name:      "env test negated",
+			templates: map[string]string{"test.twig": `{% if v is not positive %}no{% endif %}`},
+			setup:     positive,
+			ctx:       `map[string]stick.Value{"v": -2}`,
+			want:      "no",
+		},
+		{
+			name:      "env test arguments",
+			templates: map[string]string{"test.twig": `{% if v is between(1, 3) %}yes{% endif %}`},
+			setup: `env.Tests["between"] = func(ctx stick.Context, val stick.Value, args ...stick.Value) bool {
+		n := stick.CoerceNumber(val)
+		return n >= stick.CoerceNumber(args[0]) && n <= stick.CoerceNumber(args[1])
+	}`,
+			ctx:  `map[string]stick.Value{"v": 2}`,
+			want: "yes",
+		},
+	})
+}
+
+func TestConstantTest(t *testing.T) {
+	constants := func(g *stickgen.Generator) {
+		g.Constants = map[string]stick.Value{
+			"Order::SHIPPED": "shipped",
+			"MAX":            float64(3),
+			"COUNT":          2,
+			"CHANNEL":        make(chan int),
+		}
+	}
+	shipped := map[string]string{"test.twig": `{% if status is constant('Order::SHIPPED') %}yes{% else %}no{% endif %}`}
+	testRender(t, []renderTest{
+		{name: "same", templates: shipped, options: constants, ctx: `map[string]stick.Value{"status": "shipped"}`, want: "yes"},
+		{name: "different", templates: shipped, options: constants, ctx: `map[string]stick.Value{"status": "pending"}`, want: "no"},
+		{name: "missing", templates: shipped, options: constants, want: "no"},
+		{
+			name:      "strict",
+			templates: map[string]string{"test.twig": `{% if n is constant('MAX') %}a{% endif %}{% if n == constant('MAX') %}b{% endif %}`},
+			options:   constants,
+			ctx:       `map[string]stick.Value{"n": "3"}`,
+			want:      "b",
+		},
+		{
+			name:      "float",
+			templates: map[string]string{"test.twig": `{% if n is constant('MAX') %}yes{% endif %}`},
+			options:   constants,
+			ctx:       `map[string]stick.Value{"n": float64(3)}`,
+			want:      "yes",
+		},
+		{
+			name:      "int",
+			templates: map[string]string{"test.twig": `{% if n is constant('COUNT') %}yes{% endif %}`},
+			options:   constants,
+			ctx:       `map[string]stick.Value{"n": 2}`,
+			want:      "yes",
+		},
+		{
+			name:      "negated",
+			templates: map[string]string{"test.twig": `{% if status is not constant('Order::SHIPPED') %}no{% endif %}`},
+			options:   constants,
+			ctx:       `map[string]stick.Value{"status": "pending"}`,
+			want:      "no",
+		},
+		{
+			name:      "undefined",
+			templates: map[string]string{"test.twig": `{% if status is constant('NOPE') %}yes{% endif %}`},
+			options:   constants,
+			err:       "undefined constant NOPE",
+		},
+		{
+			name:      "dynamic name",
+			templates: map[string]string{"test.twig": `{% if status is constant(name) %}yes{% endif %}`},
+			options:   constants,
+			err:       "constant names must be evaluated at generation time",
+		},
+		{
+			name:      "unsupported value",
+			templates: map[string]string{"test.twig": `{% if status is constant('CHANNEL') %}yes{% endif %}`},
+			options:   constants,
+			err:       "unsupported value for constant CHANNEL",
+		},
+	})
+}